@@ -2,6 +2,28 @@ import { createContext, useContext, useState, useEffect } from 'react'
 
 const AuthContext = createContext()
 
+const API_BASE = 'http://localhost:8080/api/v1'
+
+// Access tokens are short-lived; they are renewed this long before expiry
+const REFRESH_MARGIN_MS = 60 * 1000
+
+function saveTokens(data) {
+  localStorage.setItem('token', data.access_token || data.token)
+  if (data.refresh_token) {
+    localStorage.setItem('refresh_token', data.refresh_token)
+  }
+  if (data.expires_in) {
+    localStorage.setItem('token_expires_at', String(Date.now() + data.expires_in * 1000))
+  }
+}
+
+function clearTokens() {
+  localStorage.removeItem('token')
+  localStorage.removeItem('refresh_token')
+  localStorage.removeItem('token_expires_at')
+  localStorage.removeItem('user')
+}
+
 export function AuthProvider({ children }) {
   const [user, setUser] = useState(null)
   const [loading, setLoading] = useState(true)
@@ -18,9 +40,47 @@ export function AuthProvider({ children }) {
     setLoading(false)
   }, [])
 
+  useEffect(() => {
+    if (!user) return
+
+    // Renew the access token with the refresh token shortly before it
+    // expires; a rejected refresh means the session was revoked
+    const refresh = async () => {
+      const refreshToken = localStorage.getItem('refresh_token')
+      const expiresAt = Number(localStorage.getItem('token_expires_at') || 0)
+      if (!refreshToken || Date.now() < expiresAt - REFRESH_MARGIN_MS) return
+
+      try {
+        const response = await fetch(`${API_BASE}/auth/refresh`, {
+          method: 'POST',
+          headers: {
+            'Content-Type': 'application/json',
+          },
+          body: JSON.stringify({ refresh_token: refreshToken }),
+        })
+        if (response.status === 401) {
+          // Another tab may have refreshed first and stored new tokens
+          if (localStorage.getItem('refresh_token') !== refreshToken) return
+          clearTokens()
+          setUser(null)
+          return
+        }
+        if (response.ok) {
+          saveTokens(await response.json())
+        }
+      } catch (error) {
+        // Offline; try again on the next tick
+      }
+    }
+
+    refresh()
+    const timer = setInterval(refresh, 30 * 1000)
+    return () => clearInterval(timer)
+  }, [user])
+
   const login = async (email, password) => {
     try {
-      const response = await fetch('http://localhost:8080/api/v1/auth/login', {
+      const response = await fetch(`${API_BASE}/auth/login`, {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
@@ -34,7 +94,7 @@ export function AuthProvider({ children }) {
 
       const data = await response.json()
       
-      localStorage.setItem('token', data.token)
+      saveTokens(data)
       localStorage.setItem('user', JSON.stringify({
         id: data.user_id,
         email: data.email
@@ -53,7 +113,7 @@ export function AuthProvider({ children }) {
 
   const register = async (email, password) => {
     try {
-      const response = await fetch('http://localhost:8080/api/v1/auth/register', {
+      const response = await fetch(`${API_BASE}/auth/register`, {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
@@ -67,7 +127,7 @@ export function AuthProvider({ children }) {
 
       const data = await response.json()
       
-      localStorage.setItem('token', data.token)
+      saveTokens(data)
       localStorage.setItem('user', JSON.stringify({
         id: data.user_id,
         email: data.email
@@ -85,8 +145,15 @@ export function AuthProvider({ children }) {
   }
 
   const logout = () => {
-    localStorage.removeItem('token')
-    localStorage.removeItem('user')
+    const token = localStorage.getItem('token')
+    if (token) {
+      // End the session on the server too, so its tokens stop working
+      fetch(`${API_BASE}/auth/logout`, {
+        method: 'POST',
+        headers: { Authorization: `Bearer ${token}` },
+      }).catch(() => {})
+    }
+    clearTokens()
     setUser(null)
   }
 
@@ -27,6 +27,20 @@ def search_similar_chunks(args):
     chunks = ai_service.search_similar_chunks(query, document_ids)
     return chunks
 
+def chunk_document(args):
+    """Extract and chunk the text of a document."""
+    file_path = args.get('file_path', '')
+    
+    result = ai_service.chunk_document(file_path)
+    return result
+
+def extract_graph(args):
+    """Extract entities and relationships from text."""
+    text = args.get('text', '')
+    
+    result = ai_service.extract_graph(text)
+    return result
+
 def generate_chat_response(args):
     """Generate chat response."""
     query = args.get('query', '')
@@ -42,20 +56,73 @@ def analyze_resume(args):
     """Analyze resume."""
     resume_path = args.get('resume_path', '')
     job_description = args.get('job_description', '')
+    resume_text = args.get('resume_text', '')
     
-    result = ai_service.analyze_resume(resume_path, job_description)
+    result = ai_service.analyze_resume(resume_path, job_description, resume_text)
     return result
 
 def generate_sql_from_natural_language(args):
     """Generate SQL from natural language."""
     natural_query = args.get('natural_query', '')
+    schema = args.get('schema', '')
     
     try:
-        sql = ai_service.generate_sql_from_natural_language(natural_query)
+        sql = ai_service.generate_sql_from_natural_language(natural_query, schema)
         return {"response": sql}
     except Exception as e:
         return {"response": "", "error": str(e)}
 
+def repair_sql(args):
+    """Repair SQL that failed to execute."""
+    natural_query = args.get('natural_query', '')
+    failed_sql = args.get('failed_sql', '')
+    error = args.get('error', '')
+    schema = args.get('schema', '')
+    
+    try:
+        sql = ai_service.repair_sql(natural_query, failed_sql, error, schema)
+        return {"response": sql}
+    except Exception as e:
+        return {"response": "", "error": str(e)}
+
+def explain_sql_result(args):
+    """Explain a SQL query and summarize its result."""
+    natural_query = args.get('natural_query', '')
+    sql = args.get('sql', '')
+    columns = args.get('columns', [])
+    rows = args.get('rows', [])
+    row_count = args.get('row_count', 0)
+    
+    result = ai_service.explain_sql_result(natural_query, sql, columns, rows, row_count)
+    return result
+
+def summarize_community(args):
+    """Summarize a knowledge graph community."""
+    entities = args.get('entities', [])
+    relations = args.get('relations', [])
+    
+    result = ai_service.summarize_community(entities, relations)
+    return result
+
+def graph_map_answer(args):
+    """Answer a question from one community summary."""
+    query = args.get('query', '')
+    summary = args.get('summary', '')
+    
+    result = ai_service.graph_map_answer(query, summary)
+    return result
+
+def graph_reduce_answer(args):
+    """Combine partial answers into a final answer."""
+    query = args.get('query', '')
+    partial_answers = args.get('partial_answers', [])
+    
+    try:
+        answer = ai_service.graph_reduce_answer(query, partial_answers)
+        return {"response": answer}
+    except Exception as e:
+        return {"response": "", "error": str(e)}
+
 def conduct_research(args):
     """Conduct research."""
     research_query = args.get('research_query', '')
@@ -84,9 +151,16 @@ def main():
     functions = {
         'process_document': process_document,
         'search_similar_chunks': search_similar_chunks,
+        'chunk_document': chunk_document,
+        'extract_graph': extract_graph,
+        'summarize_community': summarize_community,
+        'graph_map_answer': graph_map_answer,
+        'graph_reduce_answer': graph_reduce_answer,
         'generate_chat_response': generate_chat_response,  
         'analyze_resume': analyze_resume,
         'generate_sql_from_natural_language': generate_sql_from_natural_language,
+        'repair_sql': repair_sql,
+        'explain_sql_result': explain_sql_result,
         'conduct_research': conduct_research,
     }
     
@@ -96,7 +170,9 @@ def main():
     
     try:
         result = functions[method](args)
-        print(json.dumps(result))
+        # The tokens the model calls used go along with the result, for
+        # the backend to meter
+        print(json.dumps({"result": result, "usage": ai_service.usage.report()}))
     except Exception as e:
         logger.error(f"Error in {method}: {e}")
         print(json.dumps({"error": str(e)}))
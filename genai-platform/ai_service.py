@@ -11,6 +11,7 @@ sys.path.append('/home/ubuntu/genai-platform')
 from langchain.text_splitter import RecursiveCharacterTextSplitter
 from langchain_openai import OpenAIEmbeddings, ChatOpenAI
 from langchain_google_genai import GoogleGenerativeAIEmbeddings, ChatGoogleGenerativeAI
+from langchain_core.callbacks import BaseCallbackHandler
 import faiss
 import numpy as np
 import PyPDF2
@@ -20,6 +21,71 @@ from docx import Document
 logging.basicConfig(level=logging.INFO)
 logger = logging.getLogger(__name__)
 
+class UsageTracker(BaseCallbackHandler):
+    """Count the tokens of every model call, for the Go backend to meter."""
+
+    def __init__(self, model: str = ""):
+        self.model = model
+        self.calls = 0
+        self.prompt_tokens = 0
+        self.completion_tokens = 0
+
+    def on_llm_end(self, response, **kwargs):
+        self.calls += 1
+        llm_output = response.llm_output or {}
+        self.model = llm_output.get('model_name') or self.model
+
+        counted = False
+        for generations in response.generations:
+            for generation in generations:
+                usage = getattr(getattr(generation, 'message', None), 'usage_metadata', None)
+                if usage:
+                    self.prompt_tokens += usage.get('input_tokens', 0)
+                    self.completion_tokens += usage.get('output_tokens', 0)
+                    counted = True
+        if not counted:
+            token_usage = llm_output.get('token_usage') or {}
+            self.prompt_tokens += token_usage.get('prompt_tokens', 0)
+            self.completion_tokens += token_usage.get('completion_tokens', 0)
+
+    def on_embed(self, model: str, texts: List[str]):
+        """Count the tokens of texts sent to an embeddings model, which
+        reports none of its own; they are estimated when tiktoken is missing."""
+        self.calls += 1
+        self.model = model
+        try:
+            import tiktoken
+            encoding = tiktoken.get_encoding("cl100k_base")
+            self.prompt_tokens += sum(len(encoding.encode(text)) for text in texts)
+        except Exception:
+            self.prompt_tokens += sum(len(text) // 4 + 1 for text in texts)
+
+    def report(self) -> Dict[str, Any]:
+        return {
+            "model": self.model,
+            "calls": self.calls,
+            "prompt_tokens": self.prompt_tokens,
+            "completion_tokens": self.completion_tokens,
+        }
+
+class MeteredEmbeddings:
+    """Embeddings model whose every call is counted by a UsageTracker."""
+
+    def __init__(self, embeddings, model: str, usage: UsageTracker):
+        self.embeddings = embeddings
+        self.model = model
+        self.usage = usage
+
+    def embed_documents(self, texts: List[str]) -> List[List[float]]:
+        vectors = self.embeddings.embed_documents(texts)
+        self.usage.on_embed(self.model, texts)
+        return vectors
+
+    def embed_query(self, text: str) -> List[float]:
+        vector = self.embeddings.embed_query(text)
+        self.usage.on_embed(self.model, [text])
+        return vector
+
 class AIService:
     def __init__(self):
         self.openai_api_key = os.getenv('OPENAI_API_KEY', '')
@@ -27,19 +93,28 @@ class AIService:
         
         # Initialize embeddings
         if self.openai_api_key:
-            self.embeddings = OpenAIEmbeddings(openai_api_key=self.openai_api_key)
-            self.llm = ChatOpenAI(openai_api_key=self.openai_api_key, model="gpt-3.5-turbo")
+            self.usage = UsageTracker("gpt-3.5-turbo")
+            embeddings = OpenAIEmbeddings(openai_api_key=self.openai_api_key)
+            self.embeddings = MeteredEmbeddings(embeddings, embeddings.model, self.usage)
+            self.llm = ChatOpenAI(openai_api_key=self.openai_api_key, model="gpt-3.5-turbo", callbacks=[self.usage])
         elif self.gemini_api_key:
-            self.embeddings = GoogleGenerativeAIEmbeddings(
-                model="models/embedding-001",
-                google_api_key=self.gemini_api_key
+            self.usage = UsageTracker("gemini-pro")
+            self.embeddings = MeteredEmbeddings(
+                GoogleGenerativeAIEmbeddings(
+                    model="models/embedding-001",
+                    google_api_key=self.gemini_api_key
+                ),
+                "embedding-001",
+                self.usage
             )
             self.llm = ChatGoogleGenerativeAI(
                 model="gemini-pro",
-                google_api_key=self.gemini_api_key
+                google_api_key=self.gemini_api_key,
+                callbacks=[self.usage]
             )
         else:
             logger.warning("No API keys found. Using mock implementations.")
+            self.usage = UsageTracker()
             self.embeddings = None
             self.llm = None
         
@@ -133,6 +208,73 @@ class AIService:
             logger.error(f"Error processing document {file_path}: {e}")
             return False
 
+    def chunk_document(self, file_path: str) -> Dict[str, Any]:
+        """Extract the text of a document and split it into chunks."""
+        try:
+            file_ext = Path(file_path).suffix.lower()
+            if file_ext == '.pdf':
+                text = self.extract_text_from_pdf(file_path)
+            elif file_ext in ['.docx', '.doc']:
+                text = self.extract_text_from_docx(file_path)
+            elif file_ext in ['.txt', '.md']:
+                with open(file_path, 'r', encoding='utf-8', errors='ignore') as file:
+                    text = file.read()
+            else:
+                return {"error": f"Unsupported file type: {file_ext}"}
+
+            if not text.strip():
+                return {"error": f"No text extracted from {file_path}"}
+
+            return {"chunks": self.text_splitter.split_text(text)}
+
+        except Exception as e:
+            logger.error(f"Error chunking document {file_path}: {e}")
+            return {"error": f"Failed to chunk document: {str(e)}"}
+
+    def extract_graph(self, text: str) -> Dict[str, Any]:
+        """Extract entities and relationships from a chunk of text."""
+        try:
+            if not self.llm:
+                # Mock implementation: treat capitalized phrases as entities
+                # and link entities that appear in the same sentence
+                import re
+                entities = []
+                relations = []
+                for sentence in re.split(r'[.!?]\s+', text):
+                    names = re.findall(r'\b[A-Z][a-zA-Z]+(?:\s+[A-Z][a-zA-Z]+)*', sentence)
+                    for name in names:
+                        entities.append({"name": name, "type": "CONCEPT", "description": ""})
+                    for source, target in zip(names, names[1:]):
+                        relations.append({"source": source, "target": target, "type": "RELATED_TO", "description": sentence[:200]})
+                return {"entities": entities, "relations": relations}
+
+            prompt = f"""Extract a knowledge graph from the following text.
+
+Text:
+{text}
+
+Respond with a JSON object with two keys:
+- "entities": a list of objects with "name", "type" (one of PERSON, ORGANIZATION, LOCATION, EVENT, PRODUCT, DATE, CONCEPT) and a one sentence "description"
+- "relations": a list of objects with "source" and "target" (entity names from the list above), a short uppercase "type" such as WORKS_FOR or LOCATED_IN, and a one sentence "description"
+
+Only include entities and relationships stated in the text. Respond with the JSON object only."""
+
+            response = self.llm.invoke(prompt)
+            content = response.content.strip()
+            if content.startswith("```"):
+                content = content.strip("`")
+                if content.startswith("json"):
+                    content = content[4:]
+            parsed = json.loads(content)
+            return {
+                "entities": parsed.get("entities", []),
+                "relations": parsed.get("relations", [])
+            }
+
+        except Exception as e:
+            logger.error(f"Error extracting graph: {e}")
+            return {"error": f"Failed to extract graph: {str(e)}"}
+
     def search_similar_chunks(self, query: str, document_ids: List[int], k: int = 5) -> List[str]:
         """Search for similar chunks based on query."""
         try:
@@ -188,86 +330,118 @@ Please provide a helpful and accurate answer based on the context provided."""
             logger.error(f"Error generating chat response: {e}")
             return f"I apologize, but I encountered an error while processing your question: {query}"
 
-    def analyze_resume(self, resume_path: str, job_description: str = "") -> Dict[str, Any]:
-        """Analyze resume and provide feedback."""
+    RESUME_DIMENSIONS = ["skills_match", "experience_relevance", "impact", "formatting", "ats_readability"]
+
+    def analyze_resume(self, resume_path: str, job_description: str = "", resume_text: str = "") -> Dict[str, Any]:
+        """Analyze a resume against a job description with a structured rubric.
+
+        Each rubric dimension is scored from 0 to 100; the overall score is
+        weighted by the caller. When resume_text is given (already extracted,
+        and possibly redacted, by the caller) the file is not read."""
         try:
             # Extract text from resume
             file_ext = Path(resume_path).suffix.lower()
-            if file_ext == '.pdf':
+            if resume_text:
+                pass
+            elif file_ext == '.pdf':
                 resume_text = self.extract_text_from_pdf(resume_path)
             elif file_ext in ['.docx', '.doc']:
                 resume_text = self.extract_text_from_docx(resume_path)
+            elif file_ext in ['.txt', '.md']:
+                with open(resume_path, 'r', encoding='utf-8', errors='ignore') as f:
+                    resume_text = f.read()
             else:
                 return {"error": "Unsupported file format"}
 
             if not self.llm:
-                # Mock implementation
-                return {
-                    "feedback": f"Mock feedback for resume analysis. Resume contains {len(resume_text)} characters. Job description: {job_description[:100]}...",
-                    "score": 75
-                }
+                return self._mock_resume_analysis(resume_text, job_description)
 
-            # Prepare analysis prompt
-            prompt = f"""Please analyze the following resume and provide detailed feedback.
+            prompt = f"""You are an experienced recruiter reviewing a resume for a role.
 
 Resume:
 {resume_text}
 
-Job Description (if provided):
+Job Description (may be empty):
 {job_description}
 
-Please provide:
-1. Overall assessment and ATS score (0-100)
-2. Strengths and weaknesses
-3. Specific recommendations for improvement
-4. Keyword optimization suggestions
-
-Format your response as constructive feedback."""
+Respond with a JSON object with these keys:
+- "summary": two or three sentences of overall feedback
+- "dimensions": an object with the keys {", ".join(self.RESUME_DIMENSIONS)}; each value is an object with an integer "score" from 0 to 100 and a one sentence "comment"
+  - skills_match: how well the candidate's skills cover the job's requirements
+  - experience_relevance: how relevant past roles are to the job
+  - impact: whether achievements are concrete and quantified
+  - formatting: structure, consistency and length
+  - ats_readability: whether an applicant tracking system can parse it (plain layout, standard headings, no text in images or tables)
+- "strengths": a list of short strings
+- "gaps": a list of short strings describing what is missing or weak for this job
+- "missing_keywords": a list of important terms from the job description that do not appear in the resume
+- "bullet_rewrites": a list of objects with "original" (a bullet copied verbatim from the resume), "rewrite" (an improved version) and "reason"
+
+Respond with the JSON object only."""
 
-            # Generate analysis
             response = self.llm.invoke(prompt)
-            
-            # Extract score (simple heuristic)
-            score = 75  # Default score
-            response_text = response.content.lower()
-            if "excellent" in response_text or "outstanding" in response_text:
-                score = 85
-            elif "good" in response_text or "strong" in response_text:
-                score = 75
-            elif "needs improvement" in response_text or "weak" in response_text:
-                score = 60
-            
+            content = response.content.strip()
+            if content.startswith("```"):
+                content = content.strip("`")
+                if content.startswith("json"):
+                    content = content[4:]
+            parsed = json.loads(content)
             return {
-                "feedback": response.content,
-                "score": score
+                "feedback": parsed.get("summary", ""),
+                "dimensions": parsed.get("dimensions", {}),
+                "strengths": parsed.get("strengths", []),
+                "gaps": parsed.get("gaps", []),
+                "missing_keywords": parsed.get("missing_keywords", []),
+                "bullet_rewrites": parsed.get("bullet_rewrites", [])
             }
-            
+
         except Exception as e:
             logger.error(f"Error analyzing resume: {e}")
             return {"error": f"Failed to analyze resume: {str(e)}"}
 
-    def generate_sql_from_natural_language(self, natural_query: str) -> str:
-        """Generate SQL from natural language query."""
+    def _mock_resume_analysis(self, resume_text: str, job_description: str) -> Dict[str, Any]:
+        """Score a resume with simple heuristics when no model is configured."""
+        import re
+        words = lambda text: set(w.lower() for w in re.findall(r"[A-Za-z][A-Za-z+#.]{3,}", text))
+        resume_words = words(resume_text)
+        job_words = words(job_description)
+        missing = sorted(job_words - resume_words)[:15]
+        coverage = 1.0 if not job_words else len(job_words & resume_words) / len(job_words)
+
+        bullets = [line.strip(" -*\u2022\t") for line in resume_text.splitlines()
+                   if line.strip().startswith(("-", "*", "\u2022"))]
+        quantified = [b for b in bullets if re.search(r"\d", b)]
+        impact = 40 + int(60 * len(quantified) / len(bullets)) if bullets else 50
+
+        return {
+            "feedback": f"Mock feedback. The resume has {len(resume_text)} characters and covers {int(coverage * 100)}% of the job description's terms.",
+            "dimensions": {
+                "skills_match": {"score": int(40 + 60 * coverage), "comment": "Share of job description terms found in the resume."},
+                "experience_relevance": {"score": int(50 + 40 * coverage), "comment": "Estimated from term overlap."},
+                "impact": {"score": impact, "comment": f"{len(quantified)} of {len(bullets)} bullets contain numbers."},
+                "formatting": {"score": 80 if bullets else 60, "comment": "Bullets found." if bullets else "No bullet points found."},
+                "ats_readability": {"score": 85 if resume_text.strip() else 20, "comment": "Text could be extracted." if resume_text.strip() else "No text could be extracted."}
+            },
+            "strengths": ["Readable text layout"] if resume_text.strip() else [],
+            "gaps": [f"Does not mention {word}" for word in missing[:5]],
+            "missing_keywords": missing,
+            "bullet_rewrites": [
+                {"original": b, "rewrite": f"{b} (quantify the result, e.g. by how much or for how many users)", "reason": "Bullets with measurable outcomes rank higher."}
+                for b in bullets if b not in quantified
+            ][:5]
+        }
+
+    def generate_sql_from_natural_language(self, natural_query: str, schema: str) -> str:
+        """Generate SQL from natural language query against the given schema."""
         try:
             if not self.llm:
                 # Mock implementation
-                return f"-- Generated SQL for: {natural_query}\nSELECT * FROM users WHERE created_at >= NOW() - INTERVAL '30 days';"
-
-            # Database schema context
-            schema_context = """
-Database Schema:
-- users (id, email, password_hash, created_at, updated_at)
-- documents (id, user_id, filename, file_path, file_type, file_size, status, created_at)
-- chat_sessions (id, user_id, document_ids, created_at)
-- chat_messages (id, session_id, role, content, metadata, created_at)
-- research_tasks (id, user_id, query, status, result, metadata, created_at, completed_at)
-- resume_analyses (id, user_id, resume_path, job_description, feedback, score, status, created_at, completed_at)
-- sql_queries (id, user_id, natural_query, generated_sql, result_data, status, created_at)
-"""
-
-            prompt = f"""{schema_context}
-
-Convert the following natural language query to SQL:
+                return f"-- Generated SQL for: {natural_query}\nSELECT 1 AS answer;"
+
+            prompt = f"""{schema}
+
+Convert the following natural language query to a single read-only PostgreSQL SELECT statement
+that only uses the tables above:
 "{natural_query}"
 
 Please provide only the SQL query without explanations."""
@@ -280,6 +454,176 @@ Please provide only the SQL query without explanations."""
             logger.error(f"Error generating SQL: {e}")
             return f"-- Error generating SQL for: {natural_query}\n-- {str(e)}"
 
+    def repair_sql(self, natural_query: str, failed_sql: str, error: str, schema: str) -> str:
+        """Repair a generated SQL query using the database error and schema."""
+        try:
+            if not self.llm:
+                # Mock implementation
+                return failed_sql
+
+            prompt = f"""{schema}
+
+The following SQL query was generated for the request "{natural_query}" but failed to execute:
+
+{failed_sql}
+
+Database error:
+{error}
+
+Please rewrite the query so that it runs against the schema above and still answers the request.
+Please provide only the SQL query without explanations."""
+
+            # Generate repaired SQL
+            response = self.llm.invoke(prompt)
+            return response.content.strip()
+
+        except Exception as e:
+            logger.error(f"Error repairing SQL: {e}")
+            return failed_sql
+
+    def explain_sql_result(self, natural_query: str, sql: str, columns: List[str], rows: List[List[Any]], row_count: int) -> Dict[str, Any]:
+        """Explain a SQL query and summarize its result in plain English."""
+        try:
+            if not self.llm:
+                # Mock implementation
+                return {
+                    "explanation": f"Mock explanation: this query answers '{natural_query}' by reading from the database.",
+                    "summary": f"Mock summary: the query returned {row_count} rows with columns {', '.join(columns)}."
+                }
+
+            sample = "\n".join(json.dumps(row, default=str) for row in rows)
+            prompt = f"""A business user asked: "{natural_query}"
+
+It was answered with this SQL query:
+{sql}
+
+The result has the columns {json.dumps(columns)} and {row_count} rows in the preview. Sample rows:
+{sample}
+
+Respond with a JSON object with two keys:
+- "explanation": two or three sentences explaining in plain English, without SQL jargon, what the query does
+- "summary": two or three sentences summarizing what the result shows
+
+Respond with the JSON object only."""
+
+            response = self.llm.invoke(prompt)
+            content = response.content.strip()
+            if content.startswith("```"):
+                content = content.strip("`")
+                if content.startswith("json"):
+                    content = content[4:]
+            try:
+                parsed = json.loads(content)
+                return {
+                    "explanation": parsed.get("explanation", ""),
+                    "summary": parsed.get("summary", "")
+                }
+            except json.JSONDecodeError:
+                return {"explanation": content, "summary": ""}
+
+        except Exception as e:
+            logger.error(f"Error explaining SQL result: {e}")
+            return {"error": f"Failed to explain SQL result: {str(e)}"}
+
+    def summarize_community(self, entities: List[Dict[str, Any]], relations: List[Dict[str, Any]]) -> Dict[str, Any]:
+        """Summarize a community of related entities in the knowledge graph."""
+        try:
+            if not self.llm:
+                # Mock implementation
+                names = [entity.get('name', '') for entity in entities]
+                return {
+                    "title": ", ".join(names[:3]),
+                    "summary": f"Mock summary of a community of {len(entities)} entities: {', '.join(names[:10])}."
+                }
+
+            entity_lines = "\n".join(f"- {e.get('name')} ({e.get('type')}): {e.get('description', '')}" for e in entities)
+            relation_lines = "\n".join(f"- {r.get('source')} {r.get('type')} {r.get('target')}: {r.get('description', '')}" for r in relations)
+            prompt = f"""The following entities and relationships form a community in a knowledge graph extracted from documents.
+
+Entities:
+{entity_lines}
+
+Relationships:
+{relation_lines}
+
+Respond with a JSON object with two keys:
+- "title": a short title naming the main theme of the community
+- "summary": a paragraph describing the community, its key entities and how they relate
+
+Respond with the JSON object only."""
+
+            response = self.llm.invoke(prompt)
+            content = response.content.strip()
+            if content.startswith("```"):
+                content = content.strip("`")
+                if content.startswith("json"):
+                    content = content[4:]
+            try:
+                parsed = json.loads(content)
+                return {"title": parsed.get("title", ""), "summary": parsed.get("summary", "")}
+            except json.JSONDecodeError:
+                return {"title": "", "summary": content}
+
+        except Exception as e:
+            logger.error(f"Error summarizing community: {e}")
+            return {"error": f"Failed to summarize community: {str(e)}"}
+
+    def graph_map_answer(self, query: str, summary: str) -> Dict[str, Any]:
+        """Answer a question from a single community summary and rate how helpful it is."""
+        try:
+            if not self.llm:
+                # Mock implementation: score by word overlap
+                words = set(w.lower() for w in query.split() if len(w) > 3)
+                overlap = sum(1 for w in summary.lower().split() if w.strip('.,') in words)
+                return {"answer": summary, "score": min(100, overlap * 20)}
+
+            prompt = f"""Community summary:
+{summary}
+
+Question: {query}
+
+Using only the community summary, write a partial answer to the question and rate from 0 to 100 how helpful the summary is for answering it.
+Respond with a JSON object with the keys "answer" and "score". Respond with the JSON object only."""
+
+            response = self.llm.invoke(prompt)
+            content = response.content.strip()
+            if content.startswith("```"):
+                content = content.strip("`")
+                if content.startswith("json"):
+                    content = content[4:]
+            try:
+                parsed = json.loads(content)
+                return {"answer": parsed.get("answer", ""), "score": int(parsed.get("score", 0))}
+            except (json.JSONDecodeError, ValueError):
+                return {"answer": content, "score": 50}
+
+        except Exception as e:
+            logger.error(f"Error mapping graph answer: {e}")
+            return {"error": f"Failed to map graph answer: {str(e)}"}
+
+    def graph_reduce_answer(self, query: str, partial_answers: List[str]) -> str:
+        """Combine partial answers from community summaries into a final answer."""
+        try:
+            if not self.llm:
+                # Mock implementation
+                return f"Mock answer to '{query}' combined from {len(partial_answers)} community summaries:\n\n" + "\n\n".join(partial_answers)
+
+            answers = "\n\n".join(f"Partial answer {i + 1}:\n{a}" for i, a in enumerate(partial_answers))
+            prompt = f"""The following partial answers were produced from different parts of a knowledge graph, ordered from most to least relevant.
+
+{answers}
+
+Question: {query}
+
+Combine them into a single comprehensive answer to the question. Drop information that is not relevant."""
+
+            response = self.llm.invoke(prompt)
+            return response.content
+
+        except Exception as e:
+            logger.error(f"Error reducing graph answers: {e}")
+            return f"I apologize, but I encountered an error while answering: {query}"
+
     def conduct_research(self, research_query: str) -> str:
         """Conduct research on a given topic."""
         try:
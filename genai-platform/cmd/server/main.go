@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"log"
 	"net/http"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"genai-platform/internal/auth"
 	"genai-platform/internal/database"
 	"genai-platform/internal/handlers"
+	"genai-platform/internal/services"
 	"genai-platform/pkg/config"
 )
 
@@ -18,6 +20,24 @@ func main() {
 	// Load configuration
 	cfg := config.Load()
 
+	// Build the token service once, with the configured keys
+	authService, err := auth.NewService(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize auth:", err)
+	}
+
+	// Rate limits per route group, kept in memory or shared through Redis
+	rateLimits, err := auth.ParseRateLimits(cfg.RateLimits)
+	if err != nil {
+		log.Fatal("Invalid RATE_LIMITS:", err)
+	}
+	rateLimitStore, err := auth.OpenRateLimitStore(cfg.RateLimitStore, cfg.RedisURL)
+	if err != nil {
+		log.Fatal("Failed to initialize rate limit store:", err)
+	}
+	limiter := auth.NewRateLimiter(rateLimitStore, rateLimits)
+	log.Printf("Rate limits: %s", limiter.Limits())
+
 	// Initialize database
 	db, err := database.Initialize(cfg.DatabaseURL)
 	if err != nil {
@@ -25,6 +45,47 @@ func main() {
 	}
 	defer db.Close()
 
+	// Generated SQL runs on its own connection, as a role that can only
+	// read the tables of one schema
+	var queryDB *sql.DB
+	if cfg.SQLDatabaseURL != "" {
+		queryDB, err = database.OpenQueryDatabase(cfg.SQLDatabaseURL, cfg.SQLSchema)
+		if err != nil {
+			log.Fatal("Failed to initialize text-to-SQL database:", err)
+		}
+		defer queryDB.Close()
+	} else {
+		log.Printf("Text-to-SQL is disabled, SQL_DATABASE_URL is not set")
+	}
+
+	// Initialize the GraphRAG graph store
+	graphStore, err := services.OpenGraphStore(db, cfg.GraphStore, services.Neo4jConfig{
+		URL:      cfg.Neo4jURL,
+		Username: cfg.Neo4jUsername,
+		Password: cfg.Neo4jPassword,
+		Database: cfg.Neo4jDatabase,
+	})
+	if err != nil {
+		log.Fatal("Failed to initialize graph store:", err)
+	}
+	defer graphStore.Close()
+
+	// Initialize the mailer for verification, password reset and
+	// notification emails
+	mailer, err := services.NewMailer(services.MailerConfig{
+		Mailer:         cfg.Mailer,
+		From:           cfg.MailFrom,
+		SendGridAPIKey: cfg.SendGridAPIKey,
+		SMTPHost:       cfg.SMTPHost,
+		SMTPPort:       cfg.SMTPPort,
+		SMTPUsername:   cfg.SMTPUsername,
+		SMTPPassword:   cfg.SMTPPassword,
+		Dir:            cfg.MailDir,
+	})
+	if err != nil {
+		log.Fatal("Failed to initialize mailer:", err)
+	}
+
 	// Initialize router
 	r := chi.NewRouter()
 
@@ -38,42 +99,177 @@ func main() {
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
 	// Initialize handlers
-	h := handlers.New(db)
+	h := handlers.New(db, queryDB, cfg, graphStore, mailer, authService)
+
+	// Public keys of the asymmetric token keys
+	r.Get("/.well-known/jwks.json", authService.JWKS)
 
 	// Routes
 	r.Route("/api/v1", func(r chi.Router) {
-		// Public routes
-		r.Post("/auth/login", h.Login)
-		r.Post("/auth/register", h.Register)
+		// Public routes, limited per client address
+		r.Group(func(r chi.Router) {
+			r.Use(limiter.Limit(auth.RateLimitAuth))
+
+			r.Post("/auth/login", h.Login)
+			r.Post("/auth/register", h.Register)
+			r.Post("/auth/refresh", h.RefreshToken)
+			r.Post("/auth/verify-email", h.VerifyEmail)
+			r.Post("/auth/verify-email/resend", h.ResendVerification)
+			r.Post("/auth/password/forgot", h.ForgotPassword)
+			r.Post("/auth/password/reset", h.ResetPassword)
+			r.Get("/auth/methods", h.AuthMethods)
+			r.Post("/auth/oidc/start", h.StartOIDCLogin)
+			r.Post("/auth/oidc/callback", h.CompleteOIDCLogin)
+			r.Post("/auth/mfa/verify", h.VerifyMFA)
+		})
 
 		// Protected routes
 		r.Group(func(r chi.Router) {
-			r.Use(auth.JWTMiddleware)
+			r.Use(authService.Middleware)
+
+			// Session and API key routes, for signed-in users only
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireSession)
+
+				r.Post("/auth/logout", h.Logout)
+				r.Get("/auth/sessions", h.ListSessions)
+				r.Delete("/auth/sessions", h.RevokeOtherSessions)
+				r.Delete("/auth/sessions/{id}", h.RevokeSession)
+
+				r.Get("/auth/mfa", h.GetMFAStatus)
+				r.Post("/auth/mfa/enroll", h.EnrollMFA)
+				r.Post("/auth/mfa/activate", h.ActivateMFA)
+				r.Delete("/auth/mfa", h.DisableMFA)
+				r.Post("/auth/mfa/recovery-codes", h.RegenerateMFARecoveryCodes)
+
+				r.Post("/api-keys", h.CreateAPIKey)
+				r.Get("/api-keys", h.ListAPIKeys)
+				r.Delete("/api-keys/{id}", h.RevokeAPIKey)
+
+				r.Get("/usage", h.GetUsage)
+
+				r.Get("/workspaces", h.ListWorkspaces)
+				r.Post("/workspaces", h.CreateWorkspace)
+				r.Get("/workspaces/{id}", h.GetWorkspace)
+				r.Put("/workspaces/{id}", h.RenameWorkspace)
+				r.Delete("/workspaces/{id}", h.DeleteWorkspace)
+				r.Put("/workspaces/{id}/security", h.SetWorkspaceSecurity)
+				r.Get("/workspaces/{id}/members", h.ListWorkspaceMembers)
+				r.Put("/workspaces/{id}/members/{userID}", h.SetWorkspaceMemberRole)
+				r.Delete("/workspaces/{id}/members/{userID}", h.RemoveWorkspaceMember)
+				r.Get("/workspaces/{id}/invitations", h.ListWorkspaceInvitations)
+				r.Post("/workspaces/{id}/invitations", h.InviteToWorkspace)
+				r.Delete("/workspaces/{id}/invitations/{invitationID}", h.RevokeWorkspaceInvitation)
+
+				r.Get("/invitations", h.ListMyInvitations)
+				r.Post("/invitations/accept", h.AcceptInvitation)
+			})
+
+			// Administration, for users whose role allows it
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(auth.RequireSession)
+
+				r.Group(func(r chi.Router) {
+					r.Use(auth.RequirePermission(auth.PermissionAdminUsers))
+
+					r.Get("/users", h.AdminListUsers)
+					r.Get("/users/{id}", h.AdminGetUser)
+					r.Post("/users/{id}/disable", h.AdminDisableUser)
+					r.Post("/users/{id}/enable", h.AdminEnableUser)
+					r.Post("/users/{id}/password", h.AdminResetPassword)
+					r.Put("/users/{id}/role", h.AdminSetUserRole)
+					r.Delete("/users/{id}/mfa", h.AdminResetMFA)
+					r.Get("/users/{id}/jobs", h.AdminListUserJobs)
+					r.Get("/audit-events", h.AdminListAuthEvents)
+					r.Get("/usage", h.AdminGetUsage)
+					r.Put("/users/{id}/usage-quota", h.AdminSetUsageQuota)
+					r.Delete("/users/{id}/usage-quota", h.AdminResetUsageQuota)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(auth.RequirePermission(auth.PermissionAdminRoles))
+
+					r.Get("/roles", h.AdminListRoles)
+					r.Post("/roles", h.AdminCreateRole)
+					r.Put("/roles/{name}", h.AdminUpdateRole)
+					r.Delete("/roles/{name}", h.AdminDeleteRole)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(auth.RequirePermission(auth.PermissionAdminSSO))
+
+					r.Get("/sso/domains", h.AdminListSSODomains)
+					r.Put("/sso/domains/{domain}/{workspaceID}", h.AdminSetSSODomain)
+					r.Delete("/sso/domains/{domain}/{workspaceID}", h.AdminDeleteSSODomain)
+					r.Get("/sso/groups", h.AdminListSSOGroups)
+					r.Put("/sso/groups/{group}", h.AdminSetSSOGroup)
+					r.Delete("/sso/groups/{group}", h.AdminDeleteSSOGroup)
+				})
+			})
+
+			// The routes below need a role, and a role in the workspace named
+			// by X-Workspace-ID, granting the permission. API keys also need
+			// it as a scope. Model calls and uploads are rate limited per API
+			// key or user, and model calls refused once the user has used up
+			// their monthly quota.
+			can := auth.RequirePermission
+			limit := limiter.Limit
+			quota := h.RequireQuota
 
 			// PDF Chat routes
-			r.Post("/pdf/upload", h.UploadPDF)
-			r.Post("/chat/query", h.ChatQuery)
+			r.With(can(auth.ScopeChatWrite), limit(auth.RateLimitUploads)).Post("/pdf/upload", h.UploadPDF)
+			r.With(can(auth.ScopeChatRead), limit(auth.RateLimitChat), quota).Post("/chat/query", h.ChatQuery)
 
 			// Graph RAG routes
-			r.Post("/graph/upload", h.GraphUpload)
-			r.Post("/graph/query", h.GraphQuery)
+			r.With(can(auth.ScopeGraphWrite), limit(auth.RateLimitUploads), quota).Post("/graph/upload", h.GraphUpload)
+			r.With(can(auth.ScopeGraphRead)).Get("/graph/upload/{id}", h.GetGraphIngestion)
+			r.With(can(auth.ScopeGraphRead), limit(auth.RateLimitChat), quota).Post("/graph/query", h.GraphQuery)
+			r.With(can(auth.ScopeGraphRead)).Get("/graph/communities", h.ListGraphCommunities)
+			r.With(can(auth.ScopeGraphWrite), limit(auth.RateLimitResearch), quota).Post("/graph/communities/rebuild", h.RebuildGraphCommunities)
+			r.With(can(auth.ScopeGraphRead)).Get("/graph/entities", h.SearchGraphEntities)
+			r.With(can(auth.ScopeGraphRead)).Get("/graph/entities/{id}", h.GetGraphEntity)
+			r.With(can(auth.ScopeGraphRead)).Get("/graph/path", h.GetGraphPath)
+			r.With(can(auth.ScopeGraphRead)).Get("/graph/export", h.ExportGraph)
 
 			// Research Assistant routes
-			r.Post("/agent/research", h.ResearchAgent)
-			r.Get("/agent/research/{id}", h.GetResearchResult)
+			r.With(can(auth.ScopeResearchWrite), limit(auth.RateLimitResearch), quota).Post("/agent/research", h.ResearchAgent)
+			r.With(can(auth.ScopeResearchRead)).Get("/agent/research/{id}", h.GetResearchResult)
 
 			// Resume Feedback routes
-			r.Post("/resume/upload", h.ResumeUpload)
-			r.Get("/resume/feedback/{id}", h.GetResumeFeedback)
+			r.With(can(auth.ScopeResumeWrite), limit(auth.RateLimitUploads), quota).Post("/resume/upload", h.ResumeUpload)
+			r.With(can(auth.ScopeResumeRead)).Get("/resume/feedback/{id}", h.GetResumeFeedback)
+			r.With(can(auth.ScopeResumeRead)).Get("/resume/{id}/profile", h.GetResumeProfile)
+			r.With(can(auth.ScopeResumeRead)).Get("/resume/{id}/versions", h.GetResumeVersions)
+			r.With(can(auth.ScopeResumeRead)).Get("/resume/{id}/versions/compare", h.CompareResumeVersions)
+			r.With(can(auth.ScopeResumeWrite), limit(auth.RateLimitUploads), quota).Post("/resume/batch", h.ResumeBatchUpload)
+			r.With(can(auth.ScopeResumeRead)).Get("/resume/batch/{id}", h.GetResumeBatch)
+			r.With(can(auth.ScopeResumeRead)).Get("/resume/batch/{id}/export", h.ExportResumeBatch)
+
+			// PII redaction routes
+			r.With(can(auth.ScopeSettingsRead)).Get("/redaction/settings", h.GetRedactionSettings)
+			r.With(can(auth.ScopeSettingsWrite)).Put("/redaction/settings", h.UpdateRedactionSettings)
+			r.With(can(auth.ScopeSettingsWrite)).Delete("/redaction/settings", h.ResetRedactionSettings)
+			r.With(can(auth.ScopeSettingsRead)).Get("/redaction/events", h.ListRedactionEvents)
 
 			// Text-to-SQL routes
-			r.Post("/sql/query", h.SQLQuery)
+			r.With(can(auth.ScopeSQLExecute), limit(auth.RateLimitChat), quota).Post("/sql/query", h.SQLQuery)
+			r.With(can(auth.ScopeSQLRead)).Get("/sql/queries", h.ListSQLQueries)
+			r.With(can(auth.ScopeSQLRead)).Get("/sql/queries/{id}", h.GetSQLQuery)
+			r.With(can(auth.ScopeSQLExecute), limit(auth.RateLimitChat)).Get("/sql/queries/{id}/results", h.GetSQLQueryResults)
+			r.With(can(auth.ScopeSQLExecute), limit(auth.RateLimitChat)).Get("/sql/queries/{id}/export", h.ExportSQLQuery)
+			r.With(can(auth.ScopeSQLWrite)).Post("/sql/saved", h.SaveSQLQuery)
+			r.With(can(auth.ScopeSQLRead)).Get("/sql/saved", h.ListSavedSQLQueries)
+			r.With(can(auth.ScopeSQLRead)).Get("/sql/saved/{id}", h.GetSavedSQLQuery)
+			r.With(can(auth.ScopeSQLWrite)).Delete("/sql/saved/{id}", h.DeleteSavedSQLQuery)
+			r.With(can(auth.ScopeSQLExecute), limit(auth.RateLimitChat), quota).Post("/sql/saved/{id}/run", h.RunSavedSQLQuery)
+			r.With(can(auth.ScopeSQLWrite)).Post("/sql/saved/{id}/shares", h.ShareSavedSQLQuery)
+			r.With(can(auth.ScopeSQLWrite)).Delete("/sql/saved/{id}/shares/{email}", h.UnshareSavedSQLQuery)
 		})
 	})
 
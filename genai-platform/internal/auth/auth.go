@@ -2,40 +2,189 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"genai-platform/pkg/config"
+	"github.com/golang-jwt/jwt/v5"
 )
 
-// GenerateToken generates a new JWT token
-func GenerateToken(userID int, email string, secretKey string) (string, error) {
+// defaultKeyID names the key made from JWT_SECRET when JWT_KEYS is not set
+const defaultKeyID = "default"
+
+// Service signs and validates access tokens. It is built once at startup
+// from the configured keys: one key signs new tokens and every key still
+// listed verifies them, so a key can be rotated out without logging
+// everyone out.
+type Service struct {
+	signing     *SigningKey
+	keys        map[string]*SigningKey
+	ttl         time.Duration
+	revocations RevocationList
+	apiKeys     APIKeyStore
+	accounts    AccountStore
+	workspaces  WorkspaceStore
+}
+
+// NewService builds the service from JWT_KEYS, or from JWT_SECRET when no
+// keys are listed. In production it refuses the default secret and short
+// shared secrets.
+func NewService(cfg *config.Config) (*Service, error) {
+	keys, err := ParseKeys(cfg.JWTKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT_KEYS: %w", err)
+	}
+	if len(keys) == 0 {
+		keys = []*SigningKey{NewHMACKey(defaultKeyID, cfg.JWTSecret)}
+	}
+
+	ttl := cfg.AccessTokenTTL
+	if ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+	s := &Service{keys: map[string]*SigningKey{}, ttl: ttl}
+	for _, key := range keys {
+		s.keys[key.ID] = key
+		if cfg.IsProduction() && key.Method == jwt.SigningMethodHS256 {
+			secret := string(key.verifyKey.([]byte))
+			if secret == config.DefaultJWTSecret {
+				return nil, fmt.Errorf("refusing to start in production with the default JWT secret: set JWT_SECRET or JWT_KEYS")
+			}
+			if len(secret) < minSecretLength {
+				return nil, fmt.Errorf("refusing to start in production: JWT key %q is shorter than %d characters", key.ID, minSecretLength)
+			}
+		}
+	}
+
+	if cfg.JWTSigningKey != "" {
+		s.signing = s.keys[cfg.JWTSigningKey]
+		if s.signing == nil {
+			return nil, fmt.Errorf("JWT_SIGNING_KEY %q is not one of JWT_KEYS", cfg.JWTSigningKey)
+		}
+	} else {
+		s.signing = keys[0]
+	}
+	if !s.signing.CanSign() {
+		return nil, fmt.Errorf("JWT key %q has no private key and cannot sign tokens", s.signing.ID)
+	}
+
+	return s, nil
+}
+
+// AccessToken is a signed access token with the id it is revoked by
+type AccessToken struct {
+	Token     string
+	ID        string
+	ExpiresAt time.Time
+}
+
+// RevocationList tells whether an access token has been revoked before it
+// expired, e.g. by logging out
+type RevocationList interface {
+	IsRevoked(tokenID string) (bool, error)
+}
+
+// SetRevocationList makes the middleware reject revoked tokens
+func (s *Service) SetRevocationList(list RevocationList) {
+	s.revocations = list
+}
+
+// APIKeyPrefix starts every personal API key, telling keys apart from
+// access tokens
+const APIKeyPrefix = "gk_"
+
+// APIKey is who an API key acts for and what it may do
+type APIKey struct {
+	ID     int
+	UserID int
+	Scopes []string
+}
+
+// APIKeyStore looks up personal API keys. Authenticate returns nil for a
+// key that is unknown, revoked or expired.
+type APIKeyStore interface {
+	Authenticate(key, ipAddress string) (*APIKey, error)
+}
+
+// SetAPIKeyStore makes the middleware accept API keys as bearer tokens
+func (s *Service) SetAPIKeyStore(store APIKeyStore) {
+	s.apiKeys = store
+}
+
+// TTL is how long access tokens are valid
+func (s *Service) TTL() time.Duration {
+	return s.ttl
+}
+
+// GenerateToken generates a new access token for a user's session, signed
+// with the current signing key
+func (s *Service) GenerateToken(userID int, email string, sessionID int) (*AccessToken, error) {
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.ttl)
 	claims := jwt.MapClaims{
 		"user_id": userID,
 		"email":   email,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
+		"sid":     sessionID,
+		"jti":     id,
+		"iat":     now.Unix(),
+		"exp":     expiresAt.Unix(),
+	}
+
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.ID
+	signed, err := token.SignedString(s.signing.signKey)
+	if err != nil {
+		return nil, err
 	}
+	return &AccessToken{Token: signed, ID: id, ExpiresAt: expiresAt}, nil
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secretKey))
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
 
-// ValidateToken validates a JWT token
-func ValidateToken(tokenString string) (*jwt.Token, error) {
-	cfg := config.Load() // Load configuration
-	secretKey := cfg.JWTSecret
+// ValidateToken validates a token against the key its kid names. Tokens
+// without a kid, issued before keys were named, are checked against the
+// key made from JWT_SECRET.
+func (s *Service) ValidateToken(tokenString string) (*jwt.Token, error) {
 	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			kid = defaultKeyID
+		}
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		// The algorithm must be the key's own, so a public key can never
+		// be used as an HMAC secret
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, jwt.ErrSignatureInvalid
 		}
-		return []byte(secretKey), nil
+		return key.verifyKey, nil
 	})
 }
 
-// JWTMiddleware validates JWT tokens from requests
-func JWTMiddleware(next http.Handler) http.Handler {
+// Middleware validates the access token or API key of a request, rejecting
+// revoked ones and disabled accounts, and puts its user_id, session_id,
+// token_id, role, permissions and workspace in the request context. API
+// keys set scopes instead of a session.
+func (s *Service) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -50,20 +199,99 @@ func JWTMiddleware(next http.Handler) http.Handler {
 		}
 
 		tokenString := parts[1]
-		token, err := ValidateToken(tokenString)
+		if strings.HasPrefix(tokenString, APIKeyPrefix) {
+			s.serveAPIKey(w, r, next, tokenString)
+			return
+		}
+
+		token, err := s.ValidateToken(tokenString)
 		if err != nil {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			userID := int(claims["user_id"].(float64))
-			ctx := context.WithValue(r.Context(), "user_id", userID)
-			next.ServeHTTP(w, r.WithContext(ctx))
-		} else {
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok || !token.Valid {
+			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+			return
+		}
+		userID, ok := claims["user_id"].(float64)
+		if !ok {
 			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+			return
+		}
+
+		// Tokens issued before sessions existed carry no id and simply
+		// expire
+		tokenID, _ := claims["jti"].(string)
+		if tokenID != "" && s.revocations != nil {
+			revoked, err := s.revocations.IsRevoked(tokenID)
+			if err != nil {
+				http.Error(w, "Failed to check token", http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), "user_id", int(userID))
+		ctx = context.WithValue(ctx, "token_id", tokenID)
+		if sessionID, ok := claims["sid"].(float64); ok {
+			ctx = context.WithValue(ctx, "session_id", int(sessionID))
 		}
+		s.serve(w, r.WithContext(ctx), next, int(userID))
 	})
 }
 
+// serveAPIKey authenticates a request made with an API key, putting its
+// user_id, api_key_id and scopes in the request context
+func (s *Service) serveAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	if s.apiKeys == nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	ipAddress := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ipAddress = host
+	}
+	apiKey, err := s.apiKeys.Authenticate(key, ipAddress)
+	if err != nil {
+		http.Error(w, "Failed to check API key", http.StatusInternalServerError)
+		return
+	}
+	if apiKey == nil {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), "user_id", apiKey.UserID)
+	ctx = context.WithValue(ctx, "api_key_id", apiKey.ID)
+	ctx = context.WithValue(ctx, "scopes", apiKey.Scopes)
+	s.serve(w, r.WithContext(ctx), next, apiKey.UserID)
+}
+
+// JWKS serves the public keys of the RS256 and EdDSA keys, so other
+// services can verify tokens without sharing a secret
+func (s *Service) JWKS(w http.ResponseWriter, r *http.Request) {
+	keys := []JWK{}
+	// The signing key goes first; the rest follow in kid order so the
+	// document is stable between requests
+	if jwk, ok := s.signing.jwk(); ok {
+		keys = append(keys, jwk)
+	}
+	for _, kid := range sortedKeys(s.keys) {
+		if kid == s.signing.ID {
+			continue
+		}
+		if jwk, ok := s.keys[kid].jwk(); ok {
+			keys = append(keys, jwk)
+		}
+	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minSecretLength is the shortest HS256 secret accepted in production
+const minSecretLength = 32
+
+// SigningKey is one key tokens are signed or verified with, named by the
+// kid header of the tokens it signs. A retired asymmetric key may be given
+// by its public key alone, which verifies tokens but cannot sign new ones.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// CanSign reports whether new tokens can be signed with the key
+func (k *SigningKey) CanSign() bool {
+	return k.signKey != nil
+}
+
+// ParseKeys reads keys written as "kid:ALG:value", separated by commas.
+// For HS256 the value is the secret itself; for RS256 and EdDSA it is the
+// path of a PEM file holding a private key, or a public key for a key that
+// only verifies.
+func ParseKeys(spec string) ([]*SigningKey, error) {
+	var keys []*SigningKey
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.SplitN(part, ":", 3)
+		if len(fields) != 3 || fields[0] == "" || fields[2] == "" {
+			return nil, fmt.Errorf("invalid key %q: expected kid:ALG:value", redactKeySpec(part))
+		}
+		kid, alg, value := strings.TrimSpace(fields[0]), strings.ToUpper(strings.TrimSpace(fields[1])), fields[2]
+		if seen[kid] {
+			return nil, fmt.Errorf("duplicate key id %q", kid)
+		}
+		seen[kid] = true
+
+		key, err := newSigningKey(kid, alg, value)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", kid, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// redactKeySpec keeps secrets out of error messages
+func redactKeySpec(part string) string {
+	fields := strings.SplitN(part, ":", 3)
+	if len(fields) == 3 {
+		return fields[0] + ":" + fields[1] + ":..."
+	}
+	return fields[0] + ":..."
+}
+
+// NewHMACKey makes an HS256 key from a shared secret
+func NewHMACKey(kid, secret string) *SigningKey {
+	return &SigningKey{ID: kid, Method: jwt.SigningMethodHS256, signKey: []byte(secret), verifyKey: []byte(secret)}
+}
+
+func newSigningKey(kid, alg, value string) (*SigningKey, error) {
+	switch alg {
+	case "HS256":
+		return NewHMACKey(kid, value), nil
+	case "RS256", "EDDSA":
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q: expected HS256, RS256 or EdDSA", alg)
+	}
+
+	data, err := os.ReadFile(value)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM block", value)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, value)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{ID: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.signKey, key.verifyKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.verifyKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.signKey, key.verifyKey = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.verifyKey = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("%s does not hold an RSA or Ed25519 key", value)
+	}
+	if key.Method.Alg() != jwtAlg(alg) {
+		return nil, fmt.Errorf("%s holds a %s key, not %s", value, key.Method.Alg(), alg)
+	}
+	return key, nil
+}
+
+func jwtAlg(alg string) string {
+	if alg == "EDDSA" {
+		return jwt.SigningMethodEdDSA.Alg()
+	}
+	return alg
+}
+
+// JWK is a public key in JSON Web Key form
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// jwk returns the public half of an asymmetric key; shared HS256 secrets
+// are never published
+func (k *SigningKey) jwk() (JWK, bool) {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch public := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA", Kid: k.ID, Use: "sig", Alg: k.Method.Alg(),
+			N: encode(public.N.Bytes()),
+			E: encode(big.NewInt(int64(public.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Kid: k.ID, Use: "sig", Alg: k.Method.Alg(), Crv: "Ed25519", X: encode(public)}, true
+	}
+	return JWK{}, false
+}
+
+// PublicKey decodes an RSA, P-256 or Ed25519 key. It is the inverse of the
+// keys JWKS publishes, and reads the keys of identity providers.
+func (k JWK) PublicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: invalid modulus: %w", k.Kid, err)
+		}
+		e, err := decode(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("key %q: invalid exponent", k.Kid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("key %q: unsupported curve %q", k.Kid, k.Crv)
+		}
+		x, errX := decode(k.X)
+		y, errY := decode(k.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("key %q: invalid coordinates", k.Kid)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("key %q: point is not on the curve", k.Kid)
+		}
+		return key, nil
+	case "OKP":
+		x, err := decode(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("key %q: invalid Ed25519 key", k.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("key %q: unsupported key type %q", k.Kid, k.Kty)
+}
+
+func sortedKeys(keys map[string]*SigningKey) []string {
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"genai-platform/pkg/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testKeys are key files written to a test's temporary directory
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+
+	rsaPrivate, rsaPublic, ed25519Private, ed25519Public, notPEM string
+}
+
+func writeTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	marshal := func(der []byte, err error) []byte {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := &testKeys{rsa: rsaKey, ed25519: edKey}
+	k.rsaPrivate = write("rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	k.rsaPublic = write("rsa.pub", "PUBLIC KEY", marshal(x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)))
+	k.ed25519Private = write("ed25519.pem", "PRIVATE KEY", marshal(x509.MarshalPKCS8PrivateKey(edKey)))
+	k.ed25519Public = write("ed25519.pub", "PUBLIC KEY", marshal(x509.MarshalPKIXPublicKey(edKey.Public())))
+	k.notPEM = filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(k.notPEM, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestParseKeys(t *testing.T) {
+	k := writeTestKeys(t)
+
+	keys, err := ParseKeys(" current:EdDSA:" + k.ed25519Private + ", old:rs256:" + k.rsaPublic + ",, shared:HS256:s3cr:et ")
+	if err != nil {
+		t.Fatalf("ParseKeys() error = %v", err)
+	}
+	type parsed struct {
+		ID      string
+		Alg     string
+		CanSign bool
+	}
+	var got []parsed
+	for _, key := range keys {
+		got = append(got, parsed{key.ID, key.Method.Alg(), key.CanSign()})
+	}
+	want := []parsed{{"current", "EdDSA", true}, {"old", "RS256", false}, {"shared", "HS256", true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseKeys() = %+v, want %+v", got, want)
+	}
+	// The secret runs to the end, colons and all
+	if secret := string(keys[2].verifyKey.([]byte)); secret != "s3cr:et" {
+		t.Errorf("HS256 secret = %q, want %q", secret, "s3cr:et")
+	}
+
+	if keys, err := ParseKeys(""); err != nil || len(keys) != 0 {
+		t.Errorf("ParseKeys(\"\") = %v, %v, want no keys", keys, err)
+	}
+
+	for _, spec := range []string{
+		"just-a-secret",
+		":HS256:topsecret",
+		"a:HS256:",
+		"a:HS256:one,a:HS256:two",
+		"a:ES256:topsecret",
+		"a:RS256:" + filepath.Join(t.TempDir(), "missing.pem"),
+		"a:RS256:" + k.notPEM,
+		"a:RS256:" + k.ed25519Private,
+		"a:EdDSA:" + k.rsaPrivate,
+	} {
+		_, err := ParseKeys(spec)
+		if err == nil {
+			t.Errorf("ParseKeys(%q) = nil error", spec)
+			continue
+		}
+		if strings.Contains(err.Error(), "topsecret") {
+			t.Errorf("ParseKeys(%q) error %q shows the secret", spec, err)
+		}
+	}
+}
+
+func TestNewServiceKeys(t *testing.T) {
+	k := writeTestKeys(t)
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{name: "default secret in development", cfg: config.Config{JWTSecret: config.DefaultJWTSecret}},
+		{name: "default secret in production", cfg: config.Config{Environment: "production", JWTSecret: config.DefaultJWTSecret}, wantErr: true},
+		{name: "short secret in production", cfg: config.Config{Environment: "Production", JWTKeys: "a:HS256:short"}, wantErr: true},
+		{name: "asymmetric key in production", cfg: config.Config{Environment: "production", JWTKeys: "a:RS256:" + k.rsaPrivate}},
+		{name: "unknown signing key", cfg: config.Config{JWTKeys: "a:RS256:" + k.rsaPrivate, JWTSigningKey: "b"}, wantErr: true},
+		{name: "signing key without a private key", cfg: config.Config{JWTKeys: "a:RS256:" + k.rsaPublic}, wantErr: true},
+		{name: "signing key chosen by kid", cfg: config.Config{JWTKeys: "a:RS256:" + k.rsaPublic + ",b:EdDSA:" + k.ed25519Private, JWTSigningKey: "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewService(&tt.cfg)
+			if tt.wantErr && err == nil {
+				t.Error("NewService() = nil error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("NewService() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	k := writeTestKeys(t)
+	s, err := NewService(&config.Config{
+		JWTKeys:       "old:RS256:" + k.rsaPrivate + ",shared:HS256:topsecret,current:EdDSA:" + k.ed25519Private,
+		JWTSigningKey: "current",
+	})
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.JWKS(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	var document struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&document); err != nil {
+		t.Fatalf("JWKS() gave invalid JSON: %v", err)
+	}
+	if strings.Contains(w.Body.String(), "topsecret") || strings.Contains(w.Body.String(), `"d"`) {
+		t.Errorf("JWKS() published a secret: %s", w.Body.String())
+	}
+
+	// The signing key first, then the rest by kid; shared secrets are left out
+	var kids []string
+	published := map[string]interface{}{}
+	for _, jwk := range document.Keys {
+		kids = append(kids, jwk.Kid)
+		if jwk.Use != "sig" {
+			t.Errorf("key %s use = %q, want sig", jwk.Kid, jwk.Use)
+		}
+		if published[jwk.Kid], err = jwk.PublicKey(); err != nil {
+			t.Errorf("PublicKey() of %s error = %v", jwk.Kid, err)
+		}
+	}
+	if want := []string{"current", "old"}; !reflect.DeepEqual(kids, want) {
+		t.Fatalf("JWKS() kids = %v, want %v", kids, want)
+	}
+	if !reflect.DeepEqual(published["current"], k.ed25519.Public()) {
+		t.Errorf("published Ed25519 key = %v, want the configured one", published["current"])
+	}
+	if !reflect.DeepEqual(published["old"], &k.rsa.PublicKey) {
+		t.Errorf("published RSA key does not match the configured one")
+	}
+
+	// Another service verifies tokens with nothing but the published keys
+	token, err := s.GenerateToken(1, "jane@example.com", 2)
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+	parsed, err := jwt.Parse(token.Token, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return published[kid], nil
+	}, jwt.WithValidMethods([]string{"EdDSA", "RS256"}))
+	if err != nil || !parsed.Valid {
+		t.Errorf("token does not verify against the JWKS: %v", err)
+	}
+}
+
+func TestValidateTokenKeys(t *testing.T) {
+	k := writeTestKeys(t)
+	s, err := NewService(&config.Config{
+		JWTKeys:       "current:RS256:" + k.rsaPrivate + ",default:HS256:legacy-secret",
+		JWTSigningKey: "current",
+	})
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(time.Minute).Unix()})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	publicPEM, _ := os.ReadFile(k.rsaPublic)
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"signed by the current key", sign(jwt.SigningMethodRS256, "current", k.rsa), true},
+		{"without kid uses the legacy secret", sign(jwt.SigningMethodHS256, "", []byte("legacy-secret")), true},
+		{"unknown kid", sign(jwt.SigningMethodRS256, "other", k.rsa), false},
+		{"public key used as an HMAC secret", sign(jwt.SigningMethodHS256, "current", publicPEM), false},
+		{"wrong secret", sign(jwt.SigningMethodHS256, "default", []byte("guess")), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.ValidateToken(tt.token)
+			if tt.valid && err != nil {
+				t.Errorf("ValidateToken() error = %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("ValidateToken() = nil error")
+			}
+		})
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	encode := base64.RawURLEncoding.EncodeToString
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecJWK := JWK{Kty: "EC", Kid: "ec", Crv: "P-256", X: encode(ec.X.Bytes()), Y: encode(ec.Y.Bytes())}
+	if key, err := ecJWK.PublicKey(); err != nil || !ec.PublicKey.Equal(key) {
+		t.Errorf("PublicKey() of a P-256 key = %v, %v", key, err)
+	}
+
+	offCurve := ecJWK
+	offCurve.Y = encode([]byte{1})
+	for _, jwk := range []JWK{
+		offCurve,
+		{Kty: "EC", Crv: "P-384", X: ecJWK.X, Y: ecJWK.Y},
+		{Kty: "RSA", N: "AQAB", E: ""},
+		{Kty: "RSA", N: "AQAB", E: encode([]byte{1, 0, 0, 0, 1})},
+		{Kty: "RSA", N: "!!", E: "AQAB"},
+		{Kty: "OKP", Crv: "Ed25519", X: encode([]byte("short"))},
+		{Kty: "OKP", Crv: "X25519", X: encode(make([]byte, ed25519.PublicKeySize))},
+		{Kty: "oct"},
+	} {
+		if _, err := jwk.PublicKey(); err == nil {
+			t.Errorf("PublicKey(%+v) = nil error", jwk)
+		}
+	}
+}
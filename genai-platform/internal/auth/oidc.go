@@ -0,0 +1,340 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCConfig configures an OpenID Connect relying party
+type OIDCConfig struct {
+	// Issuer is the provider's issuer URL; its endpoints are discovered
+	// from Issuer + "/.well-known/openid-configuration"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// GroupsClaim names the ID token claim listing the user's groups
+	GroupsClaim string
+}
+
+// OIDCIdentity is who an ID token says signed in
+type OIDCIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Groups        []string
+}
+
+// ErrOIDCLogin is wrapped by every error caused by the provider or the
+// user rather than by this server, such as a refused code or a bad token
+var ErrOIDCLogin = errors.New("single sign-on failed")
+
+// jwksRefreshInterval keeps an unknown kid from making every login fetch
+// the provider's keys again
+const jwksRefreshInterval = time.Minute
+
+// oidcMetadata is the part of the discovery document that is used
+type oidcMetadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// OIDCClient signs users in with the authorization code flow and PKCE.
+// The provider is discovered on first use rather than at startup, so the
+// server starts while the provider is unreachable.
+type OIDCClient struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *oidcMetadata
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+// NewOIDCClient creates a client for the provider at cfg.Issuer
+func NewOIDCClient(cfg OIDCConfig) *OIDCClient {
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	return &OIDCClient{cfg: cfg, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// NewPKCEVerifier returns a random PKCE code verifier
+func NewPKCEVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PKCEChallenge is the S256 code challenge of a verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider page that signs the user in and sends
+// them back to the redirect URL with a code and state
+func (c *OIDCClient) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	metadata, err := c.discover()
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.cfg.ClientID},
+		"redirect_uri":          {c.cfg.RedirectURL},
+		"scope":                 {strings.Join(c.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the identity in the
+// verified ID token, which must carry nonce
+func (c *OIDCClient) Exchange(code, verifier, nonce string) (*OIDCIdentity, error) {
+	metadata, err := c.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {c.cfg.ClientID},
+	}
+	// client_secret_basic is the default; the secret goes in the form only
+	// for providers that accept nothing else
+	postSecret := len(metadata.TokenAuthMethods) > 0 && !contains(metadata.TokenAuthMethods, "client_secret_basic") &&
+		contains(metadata.TokenAuthMethods, "client_secret_post")
+	if postSecret && c.cfg.ClientSecret != "" {
+		form.Set("client_secret", c.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !postSecret && c.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("token response: %s: %w", resp.Status, err)
+	}
+	if body.Error != "" {
+		return nil, fmt.Errorf("%w: provider refused the code: %s", ErrOIDCLogin, strings.TrimSpace(body.Error+" "+body.ErrorDescription))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token response: %s", resp.Status)
+	}
+	if body.IDToken == "" {
+		return nil, fmt.Errorf("%w: provider returned no ID token", ErrOIDCLogin)
+	}
+
+	return c.verify(body.IDToken, nonce)
+}
+
+// verify checks the signature, issuer, audience, expiry and nonce of an ID
+// token and reads the identity from it
+func (c *OIDCClient) verify(rawIDToken, nonce string) (*OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, c.key,
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(c.cfg.Issuer),
+		jwt.WithAudience(c.cfg.ClientID),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID token: %v", ErrOIDCLogin, err)
+	}
+	if exp, err := claims.GetExpirationTime(); err != nil || exp == nil {
+		return nil, fmt.Errorf("%w: ID token has no expiry", ErrOIDCLogin)
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("%w: ID token nonce does not match", ErrOIDCLogin)
+	}
+	// A token for several audiences must name this client as the party
+	// it was issued to
+	if audience, _ := claims.GetAudience(); len(audience) > 1 {
+		if azp, _ := claims["azp"].(string); azp != c.cfg.ClientID {
+			return nil, fmt.Errorf("%w: ID token was issued to another client", ErrOIDCLogin)
+		}
+	}
+
+	identity := &OIDCIdentity{Issuer: c.cfg.Issuer}
+	identity.Subject, _ = claims.GetSubject()
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: ID token has no subject", ErrOIDCLogin)
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	// Some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	switch groups := claims[c.cfg.GroupsClaim].(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok && name != "" {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		if groups != "" {
+			identity.Groups = []string{groups}
+		}
+	}
+	return identity, nil
+}
+
+// key finds the provider key an ID token was signed with, fetching the
+// provider's keys again when the kid is unknown, as after a key rotation
+func (c *OIDCClient) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(c.keysFetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if err := c.fetchKeys(); err != nil {
+		return nil, err
+	}
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// fetchKeys reads the provider's JWKS. The caller holds c.mu.
+func (c *OIDCClient) fetchKeys() error {
+	if c.metadata == nil {
+		return fmt.Errorf("provider is not discovered")
+	}
+	c.keysFetchedAt = time.Now()
+
+	var jwks struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := c.getJSON(c.metadata.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("fetch provider keys: %w", err)
+	}
+	keys := map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			// One key of a type we can not read does not make the others
+			// unusable
+			fmt.Printf("Skipping OIDC provider key: %v\n", err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	c.keys = keys
+	return nil
+}
+
+// discover reads the provider's discovery document once
+func (c *OIDCClient) discover() (*oidcMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metadata != nil {
+		return c.metadata, nil
+	}
+
+	var metadata oidcMetadata
+	if err := c.getJSON(c.cfg.Issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, fmt.Errorf("discover OIDC provider: %w", err)
+	}
+	if strings.TrimRight(metadata.Issuer, "/") != c.cfg.Issuer {
+		return nil, fmt.Errorf("discover OIDC provider: issuer is %q, expected %q", metadata.Issuer, c.cfg.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("discover OIDC provider: discovery document lacks endpoints")
+	}
+	c.metadata = &metadata
+	if err := c.fetchKeys(); err != nil {
+		c.metadata = nil
+		return nil, err
+	}
+	return c.metadata, nil
+}
+
+func (c *OIDCClient) getJSON(u string, v interface{}) error {
+	resp, err := c.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
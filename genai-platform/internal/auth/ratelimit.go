@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route groups with their own rate limits
+const (
+	RateLimitAuth     = "auth"
+	RateLimitChat     = "chat"
+	RateLimitUploads  = "uploads"
+	RateLimitResearch = "research"
+)
+
+// RateLimitGroups lists the route groups that can be given a limit
+var RateLimitGroups = []string{RateLimitAuth, RateLimitChat, RateLimitUploads, RateLimitResearch}
+
+// RateLimit is a token bucket: Burst requests at once, refilled at Count
+// requests every Period
+type RateLimit struct {
+	Count  int
+	Period time.Duration
+	Burst  int
+}
+
+// rate is how many requests the bucket refills a second
+func (l RateLimit) rate() float64 {
+	return float64(l.Count) / l.Period.Seconds()
+}
+
+func (l RateLimit) String() string {
+	period := l.Period.String()
+	switch l.Period {
+	case time.Second:
+		period = "s"
+	case time.Minute:
+		period = "m"
+	case time.Hour:
+		period = "h"
+	case 24 * time.Hour:
+		period = "d"
+	}
+	s := fmt.Sprintf("%d/%s", l.Count, period)
+	if l.Burst != l.Count {
+		s += fmt.Sprintf(" burst %d", l.Burst)
+	}
+	return s
+}
+
+// DefaultRateLimits are the limits of groups RATE_LIMITS leaves out
+func DefaultRateLimits() map[string]RateLimit {
+	return map[string]RateLimit{
+		RateLimitAuth:     {Count: 20, Period: time.Minute, Burst: 20},
+		RateLimitChat:     {Count: 30, Period: time.Minute, Burst: 30},
+		RateLimitUploads:  {Count: 60, Period: time.Hour, Burst: 20},
+		RateLimitResearch: {Count: 20, Period: time.Hour, Burst: 5},
+	}
+}
+
+// ParseRateLimits reads limits such as "chat=60/m,uploads=100/h:10",
+// count per period (s, m, h or a duration like 10m) with an optional
+// burst, over the defaults. "off" turns a group's limit off.
+func ParseRateLimits(spec string) (map[string]RateLimit, error) {
+	limits := DefaultRateLimits()
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return limits, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		group, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		group, value = strings.TrimSpace(group), strings.TrimSpace(value)
+		if !ok || !contains(RateLimitGroups, group) {
+			return nil, fmt.Errorf("invalid rate limit %q: expected one of %s followed by =count/period", part, strings.Join(RateLimitGroups, ", "))
+		}
+		if value == "off" {
+			delete(limits, group)
+			continue
+		}
+
+		value, burstValue, hasBurst := strings.Cut(value, ":")
+		countValue, periodValue, ok := strings.Cut(value, "/")
+		count, err := strconv.Atoi(strings.TrimSpace(countValue))
+		if !ok || err != nil || count < 1 {
+			return nil, fmt.Errorf("invalid rate limit for %s: %q", group, value)
+		}
+		period, err := parseRatePeriod(strings.TrimSpace(periodValue))
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit period for %s: %w", group, err)
+		}
+		burst := count
+		if hasBurst {
+			if burst, err = strconv.Atoi(strings.TrimSpace(burstValue)); err != nil || burst < 1 {
+				return nil, fmt.Errorf("invalid rate limit burst for %s: %q", group, burstValue)
+			}
+		}
+		limits[group] = RateLimit{Count: count, Period: period, Burst: burst}
+	}
+	return limits, nil
+}
+
+func parseRatePeriod(s string) (time.Duration, error) {
+	switch s {
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	case "d":
+		return 24 * time.Hour, nil
+	}
+	period, err := time.ParseDuration(s)
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("%q is not s, m, h, d or a duration", s)
+	}
+	return period, nil
+}
+
+// RateLimitResult is what taking a request from a bucket left
+type RateLimitResult struct {
+	Allowed bool
+
+	// Remaining is how many more requests the bucket holds
+	Remaining int
+
+	// RetryAfter is how long until the next request is allowed when this
+	// one was not; Reset how long until the bucket is full again
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// RateLimitStore keeps the token buckets. Take spends a request from the
+// bucket of key, refilled for the time since it was last used.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimiter limits requests per route group. Signed-in requests are
+// counted per API key or user, the rest per client address.
+type RateLimiter struct {
+	store  RateLimitStore
+	limits map[string]RateLimit
+}
+
+func NewRateLimiter(store RateLimitStore, limits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{store: store, limits: limits}
+}
+
+// Limits describes the limit of every limited group
+func (l *RateLimiter) Limits() string {
+	groups := make([]string, 0, len(l.limits))
+	for group, limit := range l.limits {
+		groups = append(groups, group+"="+limit.String())
+	}
+	sort.Strings(groups)
+	return strings.Join(groups, ", ")
+}
+
+// Limit is middleware applying the limit of group. Requests over it get
+// 429 with Retry-After; every response says how much is left in
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset
+// (seconds). When the store fails requests are let through.
+func (l *RateLimiter) Limit(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limit, ok := l.limits[group]
+		if !ok {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, err := l.store.Take("ratelimit:"+group+":"+rateLimitKey(r), limit)
+			if err != nil {
+				log.Printf("Rate limit store failed, allowing request: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			if !result.Allowed {
+				retryAfter := ceilSeconds(result.RetryAfter)
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				http.Error(w, fmt.Sprintf("Rate limit exceeded, try again in %d seconds", retryAfter), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey names whose bucket a request takes from: its API key, its
+// user, or its client address when it is not signed in
+func rateLimitKey(r *http.Request) string {
+	if keyID, ok := r.Context().Value("api_key_id").(int); ok {
+		return "key:" + strconv.Itoa(keyID)
+	}
+	if userID, ok := r.Context().Value("user_id").(int); ok {
+		return "user:" + strconv.Itoa(userID)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// takeToken refills a bucket holding tokens last changed elapsed ago and
+// spends one request from it, returning what is left
+func takeToken(tokens float64, elapsed time.Duration, limit RateLimit) (float64, RateLimitResult) {
+	rate := limit.rate()
+	if elapsed > 0 {
+		tokens = math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*rate)
+	}
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	return tokens, bucketResult(allowed, tokens, limit)
+}
+
+// bucketResult describes a bucket left holding tokens
+func bucketResult(allowed bool, tokens float64, limit RateLimit) RateLimitResult {
+	rate := limit.rate()
+	result := RateLimitResult{Allowed: allowed, Remaining: int(tokens)}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	result.Reset = time.Duration((float64(limit.Burst) - tokens) / rate * float64(time.Second))
+	return result
+}
+
+// memoryIdleSweep is how often the memory store drops buckets that have
+// refilled, which are no different from having none
+const memoryIdleSweep = time.Minute
+
+// MemoryRateLimitStore keeps buckets in the server's memory, so each
+// server of a deployment limits on its own
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}, lastSweep: time.Now()}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > memoryIdleSweep {
+		for k, bucket := range s.buckets {
+			if now.After(bucket.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = bucket
+	}
+	tokens, result := takeToken(bucket.tokens, now.Sub(bucket.updated), limit)
+	bucket.tokens, bucket.updated, bucket.full = tokens, now, now.Add(result.Reset)
+	return result, nil
+}
+
+// Rate limit stores of RATE_LIMIT_STORE
+const (
+	RateLimitStoreMemory = "memory"
+	RateLimitStoreRedis  = "redis"
+)
+
+// OpenRateLimitStore creates the store named by backend: buckets in
+// memory, or in Redis at redisURL so every server of a deployment shares
+// them. The Redis store fails when the server cannot be reached.
+func OpenRateLimitStore(backend, redisURL string) (RateLimitStore, error) {
+	switch backend {
+	case "", RateLimitStoreMemory:
+		return NewMemoryRateLimitStore(), nil
+	case RateLimitStoreRedis:
+		return NewRedisRateLimitStore(redisURL)
+	}
+	return nil, fmt.Errorf("unsupported rate limit store: %s", backend)
+}
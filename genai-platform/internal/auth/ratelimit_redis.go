@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// redisTimeout bounds every command, so a slow Redis delays requests
+	// by at most this before they are let through
+	redisTimeout = 2 * time.Second
+
+	// redisIdleConns is how many connections are kept open between
+	// requests
+	redisIdleConns = 16
+)
+
+// redisTokenBucket refills and spends from the bucket in KEYS[1]
+// atomically, by Redis's clock so every server agrees. ARGV is the refill
+// rate per millisecond and the burst. It returns whether the request was
+// allowed and the tokens left, as a string since Redis truncates Lua
+// numbers to integers.
+const redisTokenBucket = `
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+	tokens = burst
+	updated = now
+end
+if now > updated then
+	tokens = math.min(burst, tokens + (now - updated) * rate)
+	updated = now
+end
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(updated))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1000)
+return {allowed, tostring(tokens)}
+`
+
+// redisTokenBucketSHA names the bucket script in Redis's script cache, so
+// requests send its digest rather than the whole script
+var redisTokenBucketSHA = func() string {
+	sum := sha1.Sum([]byte(redisTokenBucket))
+	return hex.EncodeToString(sum[:])
+}()
+
+// RedisRateLimitStore keeps buckets in Redis, shared by every server of a
+// deployment. It speaks just enough of the Redis protocol to run the
+// bucket script.
+type RedisRateLimitStore struct {
+	address  string
+	username string
+	password string
+	database int
+	tls      *tls.Config
+
+	idle chan *redisConn
+}
+
+// redisError is an error reply, after which the connection is still good
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedisRateLimitStore connects to the Redis server at redisURL, such as
+// "redis://:password@localhost:6379/0", or "rediss://" for TLS
+func NewRedisRateLimitStore(redisURL string) (*RedisRateLimitStore, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("invalid REDIS_URL: expected redis://[:password@]host[:port][/database]")
+	}
+
+	s := &RedisRateLimitStore{address: u.Host, idle: make(chan *redisConn, redisIdleConns)}
+	if u.Port() == "" {
+		s.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if database := strings.Trim(u.Path, "/"); database != "" {
+		if s.database, err = strconv.Atoi(database); err != nil || s.database < 0 {
+			return nil, fmt.Errorf("invalid REDIS_URL: database %q is not a number", database)
+		}
+	}
+	if u.Scheme == "rediss" {
+		s.tls = &tls.Config{ServerName: u.Hostname()}
+	}
+
+	if _, err := s.do("PING"); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	return s, nil
+}
+
+// Take runs the bucket script by its digest. Redis answers NOSCRIPT when
+// the script is not cached, as after a restart or SCRIPT FLUSH, and the
+// full script is sent once to cache it again.
+func (s *RedisRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	args := []string{"1", key, strconv.FormatFloat(limit.rate()/1000, 'g', -1, 64), strconv.Itoa(limit.Burst)}
+	reply, err := s.do(append([]string{"EVALSHA", redisTokenBucketSHA}, args...)...)
+	if e, ok := err.(redisError); ok && strings.HasPrefix(string(e), "NOSCRIPT") {
+		reply, err = s.do(append([]string{"EVAL", redisTokenBucket}, args...)...)
+	}
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return RateLimitResult{}, fmt.Errorf("redis: unexpected bucket reply %v", reply)
+	}
+	allowed, _ := values[0].(int64)
+	tokensValue, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(tokensValue, 64)
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("redis: unexpected bucket reply %v", reply)
+	}
+	return bucketResult(allowed == 1, tokens, limit), nil
+}
+
+// do runs a command on an idle connection, or a new one, and returns the
+// reply. Connections that fail are closed rather than reused.
+func (s *RedisRateLimitStore) do(args ...string) (interface{}, error) {
+	var conn *redisConn
+	select {
+	case conn = <-s.idle:
+	default:
+		var err error
+		if conn, err = s.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := conn.do(args...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case s.idle <- conn:
+	default:
+		conn.Close()
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+func (s *RedisRateLimitStore) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var netConn net.Conn
+	var err error
+	if s.tls != nil {
+		netConn, err = tls.DialWithDialer(dialer, "tcp", s.address, s.tls)
+	} else {
+		netConn, err = dialer.Dial("tcp", s.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+
+	var setup [][]string
+	switch {
+	case s.username != "" && s.password != "":
+		setup = append(setup, []string{"AUTH", s.username, s.password})
+	case s.password != "":
+		setup = append(setup, []string{"AUTH", s.password})
+	}
+	if s.database != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.database)})
+	}
+	for _, command := range setup {
+		reply, err := conn.do(command...)
+		if err == nil {
+			if e, ok := reply.(redisError); ok {
+				err = e
+			}
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %w", command[0], err)
+		}
+	}
+	return conn, nil
+}
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// do sends a command and reads its reply
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	if err := c.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.Conn, command.String()); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read reads one reply: a string, redisError, int64, nil or a slice of
+// replies
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		if buf[n] != '\r' || buf[n+1] != '\n' {
+			return nil, fmt.Errorf("redis: malformed bulk string of length %d", n)
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
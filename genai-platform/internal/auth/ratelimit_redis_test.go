@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedisRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "simple string", reply: "+PONG\r\n", want: "PONG"},
+		{name: "empty simple string", reply: "+\r\n", want: ""},
+		{name: "error", reply: "-NOSCRIPT No matching script\r\n", want: redisError("NOSCRIPT No matching script")},
+		{name: "integer", reply: ":-42\r\n", want: int64(-42)},
+		{name: "bulk string", reply: "$5\r\nhello\r\n", want: "hello"},
+		{name: "bulk string with CRLF inside", reply: "$4\r\na\r\nb\r\n", want: "a\r\nb"},
+		{name: "empty bulk string", reply: "$0\r\n\r\n", want: ""},
+		{name: "null bulk string", reply: "$-1\r\n", want: nil},
+		{name: "null array", reply: "*-1\r\n", want: nil},
+		{name: "array", reply: "*2\r\n:1\r\n$3\r\n4.5\r\n", want: []interface{}{int64(1), "4.5"}},
+		{name: "nested array", reply: "*2\r\n*1\r\n+a\r\n$-1\r\n", want: []interface{}{[]interface{}{"a"}, nil}},
+
+		{name: "missing CR", reply: "+PONG\n", wantErr: true},
+		{name: "unknown type", reply: "!oops\r\n", wantErr: true},
+		{name: "bad integer", reply: ":x\r\n", wantErr: true},
+		{name: "bad bulk length", reply: "$x\r\n", wantErr: true},
+		{name: "short bulk string", reply: "$5\r\nhi\r\n", wantErr: true},
+		{name: "bulk string without CRLF", reply: "$2\r\nhiXY", wantErr: true},
+		{name: "short array", reply: "*2\r\n:1\r\n", wantErr: true},
+		{name: "empty", reply: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &redisConn{reader: bufio.NewReader(strings.NewReader(tt.reply))}
+			got, err := conn.read()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("read(%q) = %#v, want an error", tt.reply, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read(%q) error = %v", tt.reply, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("read(%q) = %#v, want %#v", tt.reply, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeRedis answers the commands the store sends, with a script cache
+// that starts empty
+type fakeRedis struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	scripts  map[string]bool
+	commands []string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	f := &fakeRedis{listener: listener, scripts: map[string]bool{}}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(netConn net.Conn) {
+	defer netConn.Close()
+	// Commands arrive as arrays of bulk strings, which read parses too
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	for {
+		request, err := conn.read()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range request.([]interface{}) {
+			args = append(args, arg.(string))
+		}
+		fmt.Fprint(netConn, f.reply(args))
+	}
+}
+
+func (f *fakeRedis) reply(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, args[0])
+
+	bucket := "*2\r\n:1\r\n$3\r\n4.5\r\n"
+	switch args[0] {
+	case "PING":
+		return "+PONG\r\n"
+	case "EVALSHA":
+		if !f.scripts[args[1]] {
+			return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+		}
+		return bucket
+	case "EVAL":
+		sum := sha1.Sum([]byte(args[1]))
+		f.scripts[hex.EncodeToString(sum[:])] = true
+		return bucket
+	case "FLUSH":
+		f.scripts = map[string]bool{}
+		return "+OK\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func (f *fakeRedis) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	commands := f.commands
+	f.commands = nil
+	return commands
+}
+
+func TestRedisRateLimitStoreCachesScript(t *testing.T) {
+	server := newFakeRedis(t)
+	store, err := NewRedisRateLimitStore("redis://" + server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("NewRedisRateLimitStore() error = %v", err)
+	}
+	server.sent()
+
+	limit := RateLimit{Count: 60, Period: time.Minute, Burst: 10}
+	take := func(want []string) {
+		t.Helper()
+		result, err := store.Take("ratelimit:chat:user:1", limit)
+		if err != nil {
+			t.Fatalf("Take() error = %v", err)
+		}
+		if want := (RateLimitResult{Allowed: true, Remaining: 4, Reset: 5500 * time.Millisecond}); result != want {
+			t.Errorf("Take() = %+v, want %+v", result, want)
+		}
+		if sent := server.sent(); !reflect.DeepEqual(sent, want) {
+			t.Errorf("Take() sent %v, want %v", sent, want)
+		}
+	}
+
+	// The first request loads the script, later ones only send its digest
+	take([]string{"EVALSHA", "EVAL"})
+	take([]string{"EVALSHA"})
+
+	// After Redis loses its script cache the script is loaded again
+	if _, err := store.do("FLUSH"); err != nil {
+		t.Fatalf("FLUSH error = %v", err)
+	}
+	server.sent()
+	take([]string{"EVALSHA", "EVAL"})
+}
+
+func TestRedisRateLimitStoreErrors(t *testing.T) {
+	server := newFakeRedis(t)
+	store, err := NewRedisRateLimitStore("redis://" + server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("NewRedisRateLimitStore() error = %v", err)
+	}
+
+	// An error reply fails the command but leaves the connection usable
+	if _, err := store.do("BOGUS"); err == nil {
+		t.Error("do(BOGUS) = nil error, want the error reply")
+	}
+	if reply, err := store.do("PING"); err != nil || reply != "PONG" {
+		t.Errorf("do(PING) after an error reply = %v, %v", reply, err)
+	}
+
+	for _, redisURL := range []string{"http://localhost", "redis://", "redis://localhost/x", "redis://localhost/-1"} {
+		if _, err := NewRedisRateLimitStore(redisURL); err == nil {
+			t.Errorf("NewRedisRateLimitStore(%q) = nil error", redisURL)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	defaults := DefaultRateLimits()
+	with := func(group string, limit *RateLimit) map[string]RateLimit {
+		limits := DefaultRateLimits()
+		if limit == nil {
+			delete(limits, group)
+		} else {
+			limits[group] = *limit
+		}
+		return limits
+	}
+
+	tests := []struct {
+		spec    string
+		want    map[string]RateLimit
+		wantErr bool
+	}{
+		{spec: "", want: defaults},
+		{spec: "  ", want: defaults},
+		{spec: "chat=60/m", want: with(RateLimitChat, &RateLimit{Count: 60, Period: time.Minute, Burst: 60})},
+		{spec: "uploads=100/h:10", want: with(RateLimitUploads, &RateLimit{Count: 100, Period: time.Hour, Burst: 10})},
+		{spec: " auth = 5/10m ", want: with(RateLimitAuth, &RateLimit{Count: 5, Period: 10 * time.Minute, Burst: 5})},
+		{spec: "research=off", want: with(RateLimitResearch, nil)},
+
+		{spec: "bogus=1/m", wantErr: true},
+		{spec: "chat", wantErr: true},
+		{spec: "chat=60", wantErr: true},
+		{spec: "chat=0/m", wantErr: true},
+		{spec: "chat=5/week", wantErr: true},
+		{spec: "chat=5/-1m", wantErr: true},
+		{spec: "chat=5/m:0", wantErr: true},
+		{spec: "chat=5/m,", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRateLimits(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRateLimits(%q) = %v, want an error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRateLimits(%q) error = %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseRateLimits(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+
+	if combined, err := ParseRateLimits("chat=60/m,uploads=off"); err != nil || combined[RateLimitChat].Count != 60 || len(combined) != len(defaults)-1 {
+		t.Errorf("ParseRateLimits() of two groups = %v, %v", combined, err)
+	}
+}
+
+func TestRateLimitString(t *testing.T) {
+	tests := []struct {
+		limit RateLimit
+		want  string
+	}{
+		{RateLimit{Count: 20, Period: time.Minute, Burst: 20}, "20/m"},
+		{RateLimit{Count: 100, Period: time.Hour, Burst: 10}, "100/h burst 10"},
+		{RateLimit{Count: 5, Period: 10 * time.Minute, Burst: 5}, "5/10m0s"},
+	}
+	for _, tt := range tests {
+		if got := tt.limit.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+		// String is what ParseRateLimits reads back
+		parsed, err := ParseRateLimits("chat=" + tt.want)
+		if tt.limit.Burst == tt.limit.Count && (err != nil || parsed[RateLimitChat] != tt.limit) {
+			t.Errorf("ParseRateLimits(%q) = %v, %v", tt.want, parsed[RateLimitChat], err)
+		}
+	}
+}
+
+func TestTakeToken(t *testing.T) {
+	// One request a second, up to ten at once
+	limit := RateLimit{Count: 60, Period: time.Minute, Burst: 10}
+
+	tests := []struct {
+		name       string
+		tokens     float64
+		elapsed    time.Duration
+		wantTokens float64
+		want       RateLimitResult
+	}{
+		{
+			name:       "full bucket",
+			tokens:     10,
+			wantTokens: 9,
+			want:       RateLimitResult{Allowed: true, Remaining: 9, Reset: time.Second},
+		},
+		{
+			name:       "empty bucket",
+			tokens:     0.5,
+			wantTokens: 0.5,
+			want:       RateLimitResult{Remaining: 0, RetryAfter: 500 * time.Millisecond, Reset: 9500 * time.Millisecond},
+		},
+		{
+			name:       "refilled",
+			tokens:     0,
+			elapsed:    2500 * time.Millisecond,
+			wantTokens: 1.5,
+			want:       RateLimitResult{Allowed: true, Remaining: 1, Reset: 8500 * time.Millisecond},
+		},
+		{
+			name:       "refill stops at burst",
+			tokens:     5,
+			elapsed:    time.Hour,
+			wantTokens: 9,
+			want:       RateLimitResult{Allowed: true, Remaining: 9, Reset: time.Second},
+		},
+		{
+			name:       "clock going backwards does not refill",
+			tokens:     0,
+			elapsed:    -time.Hour,
+			wantTokens: 0,
+			want:       RateLimitResult{RetryAfter: time.Second, Reset: 10 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, result := takeToken(tt.tokens, tt.elapsed, limit)
+			if tokens != tt.wantTokens {
+				t.Errorf("takeToken() tokens = %v, want %v", tokens, tt.wantTokens)
+			}
+			if result != tt.want {
+				t.Errorf("takeToken() = %+v, want %+v", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Count: 1, Period: time.Hour, Burst: 2}
+
+	for i, want := range []bool{true, true, false} {
+		result, err := store.Take("a", limit)
+		if err != nil || result.Allowed != want {
+			t.Errorf("Take() #%d = %+v, %v, want allowed %v", i+1, result, err, want)
+		}
+	}
+	if result, _ := store.Take("b", limit); !result.Allowed {
+		t.Errorf("Take() of another key = %+v, want allowed", result)
+	}
+}
+
+func TestRateLimiterLimit(t *testing.T) {
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), map[string]RateLimit{
+		RateLimitAuth: {Count: 1, Period: time.Minute, Burst: 1},
+	})
+	handler := limiter.Limit(RateLimitAuth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request("192.0.2.1:1234"); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("first request = %d, remaining %q", w.Code, w.Header().Get("X-RateLimit-Remaining"))
+	}
+	w := request("192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("second request = %d, Retry-After %q, want 429 after 60", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := request("192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("request from another address = %d, want 200", w.Code)
+	}
+
+	// Groups without a limit are not counted
+	chat := limiter.Limit(RateLimitChat)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		chat.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/chat", nil))
+		if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Errorf("unlimited request = %d, X-RateLimit-Limit %q", w.Code, w.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+}
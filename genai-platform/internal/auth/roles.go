@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Built-in roles. Admins may define more roles from the same permissions.
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+)
+
+// Permissions only a role can grant. Admin routes refuse API keys, so these
+// are never scopes.
+const (
+	PermissionAdminUsers = "admin:users"
+	PermissionAdminRoles = "admin:roles"
+	PermissionAdminSSO   = "admin:sso"
+)
+
+// AdminPermissions lists the permissions beyond the scopes a role can have
+var AdminPermissions = []Permission{
+	{PermissionAdminUsers, "List, disable and enable users, reset passwords, change roles, inspect jobs and read the sign-in audit log"},
+	{PermissionAdminRoles, "Create, change and delete custom roles"},
+	{PermissionAdminSSO, "Map single sign-on email domains to workspaces and groups to roles"},
+}
+
+// ValidatePermissions checks that every permission of a role is a scope,
+// an admin permission or a wildcard of them
+func ValidatePermissions(permissions []string) error {
+	if len(permissions) == 0 {
+		return fmt.Errorf("at least one permission is required")
+	}
+	known := append(append([]Permission{}, Scopes...), AdminPermissions...)
+	for _, permission := range permissions {
+		if !knownPermission(known, permission) {
+			return fmt.Errorf("unknown permission %q", permission)
+		}
+	}
+	return nil
+}
+
+// Account is a user's role and what it permits. It is looked up on every
+// request, so a role change or a disabled account takes effect at once
+// rather than when the access token expires.
+type Account struct {
+	Role        string
+	Permissions []string
+	Disabled    bool
+}
+
+// AccountStore looks up accounts. Account returns nil for a user that no
+// longer exists.
+type AccountStore interface {
+	Account(userID int) (*Account, error)
+}
+
+// SetAccountStore makes the middleware reject disabled accounts and put
+// the role and permissions of the user in the request context
+func (s *Service) SetAccountStore(store AccountStore) {
+	s.accounts = store
+}
+
+// serve looks up the account of an authenticated request before passing
+// it on
+func (s *Service) serve(w http.ResponseWriter, r *http.Request, next http.Handler, userID int) {
+	if s.accounts == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	account, err := s.accounts.Account(userID)
+	if err != nil {
+		http.Error(w, "Failed to check account", http.StatusInternalServerError)
+		return
+	}
+	if account == nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	if account.Disabled {
+		http.Error(w, "Account is disabled", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), "role", account.Role)
+	ctx = context.WithValue(ctx, "permissions", account.Permissions)
+	if r = s.withWorkspace(w, r.WithContext(ctx), userID); r == nil {
+		return
+	}
+	next.ServeHTTP(w, r)
+}
+
+// RequirePermission lets through users whose role, and role in the
+// workspace of the request, grant permission. Requests made with an API key
+// also need the key to be granted it as a scope, so a key never does more
+// than its owner's role allows.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if required, _ := r.Context().Value("workspace_mfa_required").(bool); required {
+				http.Error(w, "This workspace requires two-factor authentication, turn it on to continue", http.StatusForbidden)
+				return
+			}
+			if permissions, ok := r.Context().Value("permissions").([]string); ok && !HasScope(permissions, permission) {
+				http.Error(w, fmt.Sprintf("Your role does not allow %s", permission), http.StatusForbidden)
+				return
+			}
+			if permissions, ok := r.Context().Value("workspace_permissions").([]string); ok && !HasScope(permissions, permission) {
+				http.Error(w, fmt.Sprintf("Your workspace role does not allow %s", permission), http.StatusForbidden)
+				return
+			}
+			if scopes, ok := r.Context().Value("scopes").([]string); ok && !HasScope(scopes, permission) {
+				http.Error(w, fmt.Sprintf("API key lacks the %s scope", permission), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Scopes an API key can be limited to. A scope names a feature and what
+// may be done with it; "chat:*" grants every scope of chat and "*" grants
+// everything.
+const (
+	ScopeChatRead       = "chat:read"
+	ScopeChatWrite      = "chat:write"
+	ScopeGraphRead      = "graph:read"
+	ScopeGraphWrite     = "graph:write"
+	ScopeResearchRead   = "research:read"
+	ScopeResearchWrite  = "research:write"
+	ScopeResumeRead     = "resume:read"
+	ScopeResumeWrite    = "resume:write"
+	ScopeSQLRead        = "sql:read"
+	ScopeSQLExecute     = "sql:execute"
+	ScopeSQLWrite       = "sql:write"
+	ScopeSettingsRead   = "settings:read"
+	ScopeSettingsWrite  = "settings:write"
+	ScopeAll            = "*"
+	scopeWildcardSuffix = ":*"
+)
+
+// Permission is a scope or role permission with what it allows
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Scopes lists every scope with what it allows, in display order
+var Scopes = []Permission{
+	{ScopeChatRead, "Ask questions about uploaded PDFs"},
+	{ScopeChatWrite, "Upload PDFs"},
+	{ScopeGraphRead, "Query, explore and export the knowledge graph"},
+	{ScopeGraphWrite, "Add documents to the knowledge graph and rebuild communities"},
+	{ScopeResearchRead, "Read research results"},
+	{ScopeResearchWrite, "Start research tasks"},
+	{ScopeResumeRead, "Read resume feedback, profiles, versions and batches"},
+	{ScopeResumeWrite, "Upload resumes and resume batches"},
+	{ScopeSQLRead, "Read query history and saved queries"},
+	{ScopeSQLExecute, "Run natural language and saved queries and page or export their results"},
+	{ScopeSQLWrite, "Save, delete and share saved queries"},
+	{ScopeSettingsRead, "Read settings and audit logs"},
+	{ScopeSettingsWrite, "Change settings"},
+}
+
+// ValidateScopes checks that every scope is known, allowing "*" and
+// "feature:*" wildcards
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !knownPermission(Scopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+func knownPermission(known []Permission, name string) bool {
+	if name == ScopeAll {
+		return true
+	}
+	for _, k := range known {
+		if name == k.Name {
+			return true
+		}
+		if strings.HasSuffix(name, scopeWildcardSuffix) && strings.HasPrefix(k.Name, strings.TrimSuffix(name, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether granted allows scope
+func HasScope(granted []string, scope string) bool {
+	feature, _, _ := strings.Cut(scope, ":")
+	for _, g := range granted {
+		if g == scope || g == ScopeAll || g == feature+scopeWildcardSuffix {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireSession refuses API keys, for routes such as key management and
+// sessions that only a signed-in user may use
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value("api_key_id").(int); ok {
+			http.Error(w, "This endpoint cannot be used with an API key", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC lists eight digit codes; six digit codes are their last six
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		if code != tt.want {
+			t.Errorf("TOTPCode() at %d = %s, want %s", tt.unix, code, tt.want)
+		}
+	}
+}
+
+func TestTOTPCodeSecretForms(t *testing.T) {
+	step := TOTPStep(time.Unix(59, 0))
+	for _, secret := range []string{strings.ToLower(rfc6238Secret), rfc6238Secret + "===="} {
+		if code, err := TOTPCode(secret, step); err != nil || code != "287082" {
+			t.Errorf("TOTPCode(%q) = %s, %v, want 287082", secret, code, err)
+		}
+	}
+	if _, err := TOTPCode("not base32!", step); err == nil {
+		t.Error("TOTPCode() of an invalid secret = nil error")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := TOTPStep(now)
+	code := func(step int64) string {
+		c, err := TOTPCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current period", code(step), step, true},
+		{"spaces are ignored", " " + code(step)[:3] + " " + code(step)[3:] + " ", step, true},
+		{"previous period within skew", code(step - 1), step - 1, true},
+		{"next period within skew", code(step + 1), step + 1, true},
+		{"two periods old", code(step - 2), 0, false},
+		{"two periods ahead", code(step + 2), 0, false},
+		{"too short", code(step)[:5], 0, false},
+		{"too long", code(step) + "0", 0, false},
+		{"empty", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := VerifyTOTP(rfc6238Secret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("VerifyTOTP(%q) = %d, %v, want %d, %v", tt.code, gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+
+	// A code verifies to the same period every time it is entered within
+	// the skew, which is what lets callers refuse it being replayed
+	first, _ := VerifyTOTP(rfc6238Secret, code(step), now)
+	later, ok := VerifyTOTP(rfc6238Secret, code(step), now.Add(TOTPPeriod))
+	if !ok || later != first {
+		t.Errorf("VerifyTOTP() a period later = %d, %v, want %d", later, ok, first)
+	}
+
+	if _, ok := VerifyTOTP("not base32!", code(step), now); ok {
+		t.Error("VerifyTOTP() with an invalid secret = ok")
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("NewTOTPSecret() error = %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("NewTOTPSecret() = %q, want 32 base32 characters", secret)
+	}
+	if _, err := TOTPCode(secret, 1); err != nil {
+		t.Errorf("TOTPCode() of a new secret error = %v", err)
+	}
+	if other, _ := NewTOTPSecret(); other == secret {
+		t.Error("NewTOTPSecret() returned the same secret twice")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("GenAI Platform", "jane+mfa@example.com", rfc6238Secret)
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("TOTPURI() = %q: %v", uri, err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" {
+		t.Errorf("TOTPURI() = %q, want otpauth://totp/", uri)
+	}
+	if want := "/GenAI%20Platform:jane%2Bmfa%40example.com"; u.EscapedPath() != want {
+		t.Errorf("TOTPURI() label = %q, want %q", u.EscapedPath(), want)
+	}
+	query := u.Query()
+	for key, want := range map[string]string{"secret": rfc6238Secret, "issuer": "GenAI Platform", "digits": "6", "period": "30", "algorithm": "SHA1"} {
+		if got := query.Get(key); got != want {
+			t.Errorf("TOTPURI() %s = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// WorkspaceHeader names the workspace a request acts in. Without it the
+// request acts in the user's personal workspace.
+const WorkspaceHeader = "X-Workspace-ID"
+
+// Membership is a user's role in a workspace and what it permits there
+type Membership struct {
+	WorkspaceID int
+	Role        string
+	Permissions []string
+
+	// MFARequired is set when the workspace requires two-factor
+	// authentication the user has not turned on. They keep the routes
+	// that turn it on, but none that need a permission.
+	MFARequired bool
+}
+
+// WorkspaceStore looks up memberships. Membership returns nil when the user
+// is not a member of the workspace; workspace 0 is the user's personal one.
+type WorkspaceStore interface {
+	Membership(userID, workspaceID int) (*Membership, error)
+}
+
+// SetWorkspaceStore makes the middleware resolve the workspace of every
+// request and put its workspace_id, workspace_role and
+// workspace_permissions in the request context
+func (s *Service) SetWorkspaceStore(store WorkspaceStore) {
+	s.workspaces = store
+}
+
+// withWorkspace resolves the workspace a request acts in, writing an error
+// response and returning nil when the user may not act in it
+func (s *Service) withWorkspace(w http.ResponseWriter, r *http.Request, userID int) *http.Request {
+	if s.workspaces == nil {
+		return r
+	}
+
+	workspaceID := 0
+	if v := r.Header.Get(WorkspaceHeader); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			http.Error(w, "Invalid "+WorkspaceHeader+" header", http.StatusBadRequest)
+			return nil
+		}
+		workspaceID = id
+	}
+
+	membership, err := s.workspaces.Membership(userID, workspaceID)
+	if err != nil {
+		http.Error(w, "Failed to check workspace", http.StatusInternalServerError)
+		return nil
+	}
+	if membership == nil {
+		http.Error(w, "Not a member of this workspace", http.StatusForbidden)
+		return nil
+	}
+
+	ctx := context.WithValue(r.Context(), "workspace_id", membership.WorkspaceID)
+	ctx = context.WithValue(ctx, "workspace_role", membership.Role)
+	ctx = context.WithValue(ctx, "workspace_permissions", membership.Permissions)
+	if membership.MFARequired {
+		ctx = context.WithValue(ctx, "workspace_mfa_required", true)
+	}
+	return r.WithContext(ctx)
+}
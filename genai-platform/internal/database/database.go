@@ -88,6 +88,397 @@ func runMigrations(db *sql.DB) error {
 			status VARCHAR(50) DEFAULT 'pending',
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`ALTER TABLE sql_queries ADD COLUMN IF NOT EXISTS attempts JSONB DEFAULT '[]'`,
+		`CREATE TABLE IF NOT EXISTS saved_sql_queries (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id),
+			name VARCHAR(255) NOT NULL,
+			natural_query TEXT,
+			sql_text TEXT NOT NULL,
+			parameters TEXT[] DEFAULT '{}',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS saved_sql_query_recipients (
+			saved_query_id INTEGER REFERENCES saved_sql_queries(id) ON DELETE CASCADE,
+			email VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (saved_query_id, email)
+		)`,
+		`ALTER TABLE sql_queries ADD COLUMN IF NOT EXISTS saved_query_id INTEGER REFERENCES saved_sql_queries(id) ON DELETE SET NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_sql_queries_user_created ON sql_queries (user_id, created_at DESC)`,
+		`ALTER TABLE sql_queries ADD COLUMN IF NOT EXISTS explanation TEXT`,
+		`ALTER TABLE sql_queries ADD COLUMN IF NOT EXISTS result_summary TEXT`,
+		`ALTER TABLE sql_queries ADD COLUMN IF NOT EXISTS visualization JSONB`,
+		`CREATE TABLE IF NOT EXISTS graph_ingestions (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id),
+			document_ids INTEGER[] DEFAULT '{}',
+			status VARCHAR(50) DEFAULT 'pending',
+			total_chunks INTEGER DEFAULT 0,
+			processed_chunks INTEGER DEFAULT 0,
+			error TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			completed_at TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS graph_chunks (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id),
+			document_id INTEGER REFERENCES documents(id) ON DELETE CASCADE,
+			chunk_index INTEGER NOT NULL,
+			content TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (document_id, chunk_index)
+		)`,
+		`CREATE TABLE IF NOT EXISTS graph_nodes (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id),
+			name VARCHAR(500) NOT NULL,
+			normalized_name VARCHAR(500) NOT NULL,
+			type VARCHAR(100) NOT NULL,
+			description TEXT DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, normalized_name, type)
+		)`,
+		`CREATE TABLE IF NOT EXISTS graph_edges (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id),
+			source_id INTEGER REFERENCES graph_nodes(id) ON DELETE CASCADE,
+			target_id INTEGER REFERENCES graph_nodes(id) ON DELETE CASCADE,
+			type VARCHAR(100) NOT NULL,
+			description TEXT DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, source_id, target_id, type)
+		)`,
+		`CREATE TABLE IF NOT EXISTS graph_mentions (
+			node_id INTEGER REFERENCES graph_nodes(id) ON DELETE CASCADE,
+			chunk_id INTEGER REFERENCES graph_chunks(id) ON DELETE CASCADE,
+			PRIMARY KEY (node_id, chunk_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS graph_edge_mentions (
+			edge_id INTEGER REFERENCES graph_edges(id) ON DELETE CASCADE,
+			chunk_id INTEGER REFERENCES graph_chunks(id) ON DELETE CASCADE,
+			PRIMARY KEY (edge_id, chunk_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_graph_mentions_chunk ON graph_mentions (chunk_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_graph_edges_source ON graph_edges (source_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_graph_edges_target ON graph_edges (target_id)`,
+		`CREATE TABLE IF NOT EXISTS graph_communities (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id),
+			level INTEGER NOT NULL DEFAULT 0,
+			node_ids INTEGER[] DEFAULT '{}',
+			title TEXT DEFAULT '',
+			summary TEXT DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE graph_communities ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES graph_communities(id) ON DELETE SET NULL`,
+		`ALTER TABLE graph_communities ADD COLUMN IF NOT EXISTS member_hash VARCHAR(64) DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS idx_graph_communities_user_level ON graph_communities (user_id, level)`,
+		`ALTER TABLE resume_analyses ADD COLUMN IF NOT EXISTS analysis JSONB`,
+		`ALTER TABLE resume_analyses ADD COLUMN IF NOT EXISTS error TEXT`,
+		`CREATE TABLE IF NOT EXISTS resume_batches (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id),
+			job_description TEXT NOT NULL,
+			weights JSONB,
+			status VARCHAR(50) DEFAULT 'pending',
+			total_resumes INTEGER DEFAULT 0,
+			processed_resumes INTEGER DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			completed_at TIMESTAMP
+		)`,
+		`ALTER TABLE resume_analyses ADD COLUMN IF NOT EXISTS batch_id INTEGER REFERENCES resume_batches(id) ON DELETE CASCADE`,
+		`ALTER TABLE resume_analyses ADD COLUMN IF NOT EXISTS filename VARCHAR(255)`,
+		`CREATE INDEX IF NOT EXISTS idx_resume_analyses_batch ON resume_analyses (batch_id)`,
+		`ALTER TABLE resume_analyses ADD COLUMN IF NOT EXISTS profile JSONB`,
+		`CREATE INDEX IF NOT EXISTS idx_resume_analyses_profile_skills ON resume_analyses USING GIN ((profile -> 'skills'))`,
+		`CREATE TABLE IF NOT EXISTS pii_redaction_settings (
+			user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			enabled BOOLEAN NOT NULL,
+			operations TEXT[] DEFAULT '{}',
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS pii_redaction_events (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			operation VARCHAR(100) NOT NULL,
+			counts JSONB NOT NULL,
+			total INTEGER NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_pii_redaction_events_user_created ON pii_redaction_events (user_id, created_at DESC)`,
+		`CREATE TABLE IF NOT EXISTS resumes (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id),
+			name VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE resume_analyses ADD COLUMN IF NOT EXISTS resume_id INTEGER REFERENCES resumes(id) ON DELETE CASCADE`,
+		`ALTER TABLE resume_analyses ADD COLUMN IF NOT EXISTS version INTEGER`,
+		`ALTER TABLE resume_analyses ADD COLUMN IF NOT EXISTS resume_text TEXT`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_resume_analyses_resume_version ON resume_analyses (resume_id, version)`,
+		`CREATE TABLE IF NOT EXISTS auth_sessions (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			user_agent TEXT DEFAULT '',
+			ip_address VARCHAR(64) DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP,
+			revoked_reason VARCHAR(50)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_auth_sessions_user ON auth_sessions (user_id)`,
+		`CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id SERIAL PRIMARY KEY,
+			session_id INTEGER REFERENCES auth_sessions(id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			access_token_id VARCHAR(64),
+			access_expires_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			used_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session ON refresh_tokens (session_id)`,
+		`CREATE TABLE IF NOT EXISTS revoked_tokens (
+			token_id VARCHAR(64) PRIMARY KEY,
+			expires_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			prefix VARCHAR(16) NOT NULL,
+			key_hash VARCHAR(64) UNIQUE NOT NULL,
+			scopes TEXT[] NOT NULL DEFAULT '{}',
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			last_used_ip VARCHAR(64),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			revoked_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys (user_id)`,
+		`CREATE TABLE IF NOT EXISTS roles (
+			name VARCHAR(50) PRIMARY KEY,
+			description TEXT DEFAULT '',
+			permissions TEXT[] NOT NULL DEFAULT '{}',
+			builtin BOOLEAN DEFAULT false,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`INSERT INTO roles (name, description, permissions, builtin) VALUES
+			('admin', 'Full access, including user and role administration', '{*}', true),
+			('member', 'Use every feature', '{chat:*,graph:*,research:*,resume:*,sql:*,settings:*}', true),
+			('viewer', 'Read results and ask questions, without uploading, running or changing anything',
+			 '{chat:read,graph:read,research:read,resume:read,sql:read,settings:read}', true)
+		 ON CONFLICT (name) DO NOTHING`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(50) NOT NULL DEFAULT 'member' REFERENCES roles(name) ON UPDATE CASCADE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_reason TEXT`,
+		`CREATE TABLE IF NOT EXISTS workspaces (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			personal BOOLEAN DEFAULT false,
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_workspaces_personal ON workspaces (created_by) WHERE personal`,
+		`CREATE TABLE IF NOT EXISTS workspace_members (
+			workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			role VARCHAR(20) NOT NULL DEFAULT 'member',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (workspace_id, user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_workspace_members_user ON workspace_members (user_id)`,
+		`CREATE TABLE IF NOT EXISTS workspace_invitations (
+			id SERIAL PRIMARY KEY,
+			workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE,
+			email VARCHAR(255) NOT NULL,
+			role VARCHAR(20) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			expires_at TIMESTAMP NOT NULL,
+			accepted_at TIMESTAMP,
+			accepted_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			revoked_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_workspace_invitations_workspace ON workspace_invitations (workspace_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_workspace_invitations_email ON workspace_invitations (lower(email))`,
+		// Every user gets a personal workspace, and what they had before
+		// workspaces moves into it
+		`INSERT INTO workspaces (name, personal, created_by)
+		 SELECT 'Personal', true, u.id FROM users u
+		 WHERE NOT EXISTS (SELECT 1 FROM workspaces w WHERE w.personal AND w.created_by = u.id)`,
+		`INSERT INTO workspace_members (workspace_id, user_id, role)
+		 SELECT id, created_by, 'owner' FROM workspaces WHERE personal AND created_by IS NOT NULL
+		 ON CONFLICT DO NOTHING`,
+		`ALTER TABLE documents ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE`,
+		`ALTER TABLE chat_sessions ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE`,
+		`ALTER TABLE research_tasks ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE`,
+		`ALTER TABLE sql_queries ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE`,
+		`ALTER TABLE saved_sql_queries ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE`,
+		`ALTER TABLE graph_ingestions ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE`,
+		`ALTER TABLE graph_chunks ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE`,
+		`ALTER TABLE graph_nodes ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE`,
+		`ALTER TABLE graph_edges ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE`,
+		`ALTER TABLE graph_communities ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE`,
+		`UPDATE documents t SET workspace_id = w.id FROM workspaces w
+		 WHERE t.workspace_id IS NULL AND w.personal AND w.created_by = t.user_id`,
+		`UPDATE chat_sessions t SET workspace_id = w.id FROM workspaces w
+		 WHERE t.workspace_id IS NULL AND w.personal AND w.created_by = t.user_id`,
+		`UPDATE research_tasks t SET workspace_id = w.id FROM workspaces w
+		 WHERE t.workspace_id IS NULL AND w.personal AND w.created_by = t.user_id`,
+		`UPDATE sql_queries t SET workspace_id = w.id FROM workspaces w
+		 WHERE t.workspace_id IS NULL AND w.personal AND w.created_by = t.user_id`,
+		`UPDATE saved_sql_queries t SET workspace_id = w.id FROM workspaces w
+		 WHERE t.workspace_id IS NULL AND w.personal AND w.created_by = t.user_id`,
+		`UPDATE graph_ingestions t SET workspace_id = w.id FROM workspaces w
+		 WHERE t.workspace_id IS NULL AND w.personal AND w.created_by = t.user_id`,
+		`UPDATE graph_chunks t SET workspace_id = w.id FROM workspaces w
+		 WHERE t.workspace_id IS NULL AND w.personal AND w.created_by = t.user_id`,
+		`UPDATE graph_nodes t SET workspace_id = w.id FROM workspaces w
+		 WHERE t.workspace_id IS NULL AND w.personal AND w.created_by = t.user_id`,
+		`UPDATE graph_edges t SET workspace_id = w.id FROM workspaces w
+		 WHERE t.workspace_id IS NULL AND w.personal AND w.created_by = t.user_id`,
+		`UPDATE graph_communities t SET workspace_id = w.id FROM workspaces w
+		 WHERE t.workspace_id IS NULL AND w.personal AND w.created_by = t.user_id`,
+		`CREATE INDEX IF NOT EXISTS idx_documents_workspace ON documents (workspace_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_research_tasks_workspace ON research_tasks (workspace_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_sql_queries_workspace_created ON sql_queries (workspace_id, created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_saved_sql_queries_workspace ON saved_sql_queries (workspace_id)`,
+		// Saved query names are unique per user within a workspace
+		`ALTER TABLE saved_sql_queries DROP CONSTRAINT IF EXISTS saved_sql_queries_user_id_name_key`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_saved_sql_queries_workspace_name ON saved_sql_queries (workspace_id, user_id, name)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_graph_nodes_workspace_key ON graph_nodes (workspace_id, normalized_name, type)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_graph_edges_workspace_key ON graph_edges (workspace_id, source_id, target_id, type)`,
+		`CREATE INDEX IF NOT EXISTS idx_graph_communities_workspace_level ON graph_communities (workspace_id, level)`,
+		// Accounts made before email verification existed count as verified,
+		// so the column is backfilled only when it is added
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+			               WHERE table_name = 'users' AND column_name = 'email_verified_at') THEN
+				ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+				UPDATE users SET email_verified_at = created_at;
+			END IF;
+		END $$`,
+		`CREATE TABLE IF NOT EXISTS email_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			purpose VARCHAR(30) NOT NULL,
+			email VARCHAR(255) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_email_tokens_user_purpose ON email_tokens (user_id, purpose)`,
+		`CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users (lower(email))`,
+		`CREATE TABLE IF NOT EXISTS user_identities (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			issuer VARCHAR(255) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			email VARCHAR(255),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_login_at TIMESTAMP,
+			UNIQUE (issuer, subject)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities (user_id)`,
+		`CREATE TABLE IF NOT EXISTS oidc_logins (
+			state_hash VARCHAR(64) PRIMARY KEY,
+			nonce VARCHAR(64) NOT NULL,
+			code_verifier VARCHAR(128) NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS sso_domain_workspaces (
+			domain VARCHAR(255) NOT NULL,
+			workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE,
+			role VARCHAR(20) NOT NULL DEFAULT 'member',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (domain, workspace_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS sso_group_roles (
+			group_name VARCHAR(255) PRIMARY KEY,
+			role VARCHAR(50) NOT NULL REFERENCES roles(name) ON UPDATE CASCADE ON DELETE CASCADE,
+			priority INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_mfa (
+			user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			totp_secret VARCHAR(64) NOT NULL,
+			enabled_at TIMESTAMP,
+			last_used_step BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			code_hash VARCHAR(64) NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user ON mfa_recovery_codes (user_id)`,
+		`CREATE TABLE IF NOT EXISTS mfa_challenges (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE workspaces ADD COLUMN IF NOT EXISTS require_mfa BOOLEAN NOT NULL DEFAULT false`,
+		`CREATE TABLE IF NOT EXISTS login_failures (
+			scope VARCHAR(20) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure_at TIMESTAMP NOT NULL,
+			locked_until TIMESTAMP,
+			PRIMARY KEY (scope, subject)
+		)`,
+		`CREATE TABLE IF NOT EXISTS auth_events (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			email VARCHAR(255) DEFAULT '',
+			event VARCHAR(50) NOT NULL,
+			detail VARCHAR(255) DEFAULT '',
+			ip_address VARCHAR(64) DEFAULT '',
+			user_agent TEXT DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_auth_events_created ON auth_events (created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_auth_events_user ON auth_events (user_id)`,
+		`CREATE TABLE IF NOT EXISTS llm_usage (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			workspace_id INTEGER REFERENCES workspaces(id) ON DELETE SET NULL,
+			feature VARCHAR(50) NOT NULL DEFAULT '',
+			operation VARCHAR(100) NOT NULL,
+			model VARCHAR(100) NOT NULL DEFAULT '',
+			prompt_tokens INTEGER NOT NULL DEFAULT 0,
+			completion_tokens INTEGER NOT NULL DEFAULT 0,
+			latency_ms INTEGER NOT NULL DEFAULT 0,
+			cost NUMERIC(12, 6) NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_llm_usage_user ON llm_usage (user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_llm_usage_workspace ON llm_usage (workspace_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_llm_usage_created ON llm_usage (created_at)`,
+		`CREATE TABLE IF NOT EXISTS usage_quotas (
+			user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			monthly_tokens BIGINT NOT NULL DEFAULT 0,
+			monthly_cost NUMERIC(12, 2) NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
 	}
 
 	for _, migration := range migrations {
@@ -98,4 +489,3 @@ func runMigrations(db *sql.DB) error {
 
 	return nil
 }
-
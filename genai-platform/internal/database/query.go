@@ -0,0 +1,147 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// privilegedRoles are the predefined roles that read or run things outside
+// any schema a query role is granted
+var privilegedRoles = []string{
+	"pg_read_all_data",
+	"pg_write_all_data",
+	"pg_read_server_files",
+	"pg_write_server_files",
+	"pg_execute_server_program",
+}
+
+// OpenQueryDatabase opens the connection generated SQL runs on and checks
+// that its role can only read the tables of schema. A role that can do
+// more is refused rather than trusted with queries written by the model.
+func OpenQueryDatabase(databaseURL, schema string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open query database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping query database: %w", err)
+	}
+
+	if err := checkQueryRole(db, schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// checkQueryRole fails unless the connected role is an ordinary role that
+// can use schema, read nothing outside it and write nothing at all
+func checkQueryRole(db *sql.DB, schema string) error {
+	var role string
+	var super, createRole, createDB, bypassRLS bool
+	err := db.QueryRow(
+		`SELECT rolname, rolsuper, rolcreaterole, rolcreatedb, rolbypassrls
+		 FROM pg_roles WHERE rolname = current_user`,
+	).Scan(&role, &super, &createRole, &createDB, &bypassRLS)
+	if err != nil {
+		return fmt.Errorf("failed to read query role: %w", err)
+	}
+	if super || createRole || createDB || bypassRLS {
+		return fmt.Errorf("query role %s must not be a superuser or have CREATEROLE, CREATEDB or BYPASSRLS", role)
+	}
+
+	var granted []string
+	rows, err := db.Query(
+		`SELECT rolname FROM pg_roles
+		 WHERE rolname = ANY($1) AND pg_has_role(current_user, oid, 'MEMBER')`,
+		pq.Array(privilegedRoles),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to read query role memberships: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		granted = append(granted, name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(granted) > 0 {
+		return fmt.Errorf("query role %s must not be a member of %s", role, strings.Join(granted, ", "))
+	}
+
+	var usable bool
+	err = db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM pg_namespace
+		 WHERE nspname = $1 AND has_schema_privilege(oid, 'USAGE'))`,
+		schema,
+	).Scan(&usable)
+	if err != nil {
+		return fmt.Errorf("failed to check query schema: %w", err)
+	}
+	if !usable {
+		return fmt.Errorf("query role %s cannot use schema %s", role, schema)
+	}
+
+	// Tables, views and foreign tables outside the schema must be out of
+	// reach, and none may be written
+	var readable, writable []string
+	rows, err = db.Query(
+		`SELECT n.nspname || '.' || c.relname,
+		        n.nspname <> $1 AND has_any_column_privilege(c.oid, 'SELECT'),
+		        has_table_privilege(c.oid, 'INSERT, UPDATE, DELETE, TRUNCATE')
+		 FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		 WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f')
+		   AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		   AND n.nspname NOT LIKE 'pg\_toast%'
+		 ORDER BY 1`,
+		schema,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to check query role privileges: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		var canRead, canWrite bool
+		if err := rows.Scan(&table, &canRead, &canWrite); err != nil {
+			return err
+		}
+		if canRead {
+			readable = append(readable, table)
+		}
+		if canWrite {
+			writable = append(writable, table)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(readable) > 0 {
+		return fmt.Errorf("query role %s can read tables outside schema %s: %s", role, schema, summarizeTables(readable))
+	}
+	if len(writable) > 0 {
+		return fmt.Errorf("query role %s can write tables: %s", role, summarizeTables(writable))
+	}
+
+	return nil
+}
+
+// summarizeTables names the first few tables of a failed privilege check
+func summarizeTables(tables []string) string {
+	const shown = 5
+	if len(tables) <= shown {
+		return strings.Join(tables, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(tables[:shown], ", "), len(tables)-shown)
+}
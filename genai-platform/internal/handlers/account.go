@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"genai-platform/internal/services"
+)
+
+// VerifyEmail confirms the user's email address with the token from a
+// verification email
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.accounts.VerifyEmail(req.Token); err == services.ErrInvalidToken {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"verified": true,
+	})
+}
+
+// ResendVerification sends a new verification email. The response is the
+// same whether or not the address has an unverified account.
+func (h *Handler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.accounts.ResendVerification(req.Email); err != nil {
+		log.Printf("Failed to resend verification email: %v", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ForgotPassword emails a password reset link. The response is the same
+// whether or not the address has an account.
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePasswordLogin(w) {
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.accounts.RequestPasswordReset(req.Email); err != nil {
+		log.Printf("Failed to send password reset email: %v", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password with the token from a password reset
+// email and signs the user out everywhere
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePasswordLogin(w) {
+		return
+	}
+
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidatePassword(req.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.accounts.ResetPassword(req.Token, req.Password); err == services.ErrInvalidToken {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"password_reset":   true,
+		"sessions_revoked": true,
+	})
+}
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"genai-platform/internal/auth"
+	"genai-platform/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// adminUserID reads the {id} of an /admin/users route
+func adminUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return userID, true
+}
+
+// AdminListUsers lists users, filtered by ?search= on the email and ?role=
+func (h *Handler) AdminListUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := pagination(w, r, 50, 200)
+	if !ok {
+		return
+	}
+	search := strings.TrimSpace(r.URL.Query().Get("search"))
+	role := r.URL.Query().Get("role")
+
+	users, total, err := h.admin.Users(search, role, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users":  users,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// AdminGetUser returns one user
+func (h *Handler) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.admin.User(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// AdminDisableUser stops a user from signing in and ends their sessions
+func (h *Handler) AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value("user_id").(int)
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+	if userID == adminID {
+		http.Error(w, "You can not disable your own account", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch err := h.admin.DisableUser(userID, strings.TrimSpace(req.Reason)); err {
+	case nil:
+	case sql.ErrNoRows:
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case services.ErrLastAdmin:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Failed to disable user", http.StatusInternalServerError)
+		return
+	}
+
+	h.AdminGetUser(w, r)
+}
+
+// AdminEnableUser lets a disabled user sign in again
+func (h *Handler) AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.admin.EnableUser(userID); err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to enable user", http.StatusInternalServerError)
+		return
+	}
+
+	h.AdminGetUser(w, r)
+}
+
+// AdminResetPassword sets a user's password and ends their sessions. Without
+// a password in the body a random one is set and returned.
+func (h *Handler) AdminResetPassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Password != "" {
+		if err := services.ValidatePassword(req.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	generated, err := h.admin.ResetPassword(userID, req.Password)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{"user_id": userID, "sessions_revoked": true}
+	if generated != "" {
+		response["password"] = generated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// AdminSetUserRole changes a user's role
+func (h *Handler) AdminSetUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		http.Error(w, "role is required", http.StatusBadRequest)
+		return
+	}
+
+	switch err := h.roles.SetUserRole(userID, req.Role); err {
+	case nil:
+	case sql.ErrNoRows:
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case services.ErrUnknownRole:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case services.ErrLastAdmin:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Failed to change role", http.StatusInternalServerError)
+		return
+	}
+
+	h.AdminGetUser(w, r)
+}
+
+// AdminListUserJobs lists any user's jobs, filtered by ?type= and ?status=
+func (h *Handler) AdminListUserJobs(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+	limit, offset, ok := pagination(w, r, 50, 200)
+	if !ok {
+		return
+	}
+
+	jobType := r.URL.Query().Get("type")
+	if jobType != "" && !slices.Contains(services.JobTypes, jobType) {
+		http.Error(w, "type must be one of "+strings.Join(services.JobTypes, ", "), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.admin.User(userID); err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+
+	jobs, total, err := h.admin.UserJobs(userID, jobType, r.URL.Query().Get("status"), limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch jobs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id": userID,
+		"jobs":    jobs,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// AdminListRoles lists the roles, with the permissions a role can be given
+func (h *Handler) AdminListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.roles.Roles()
+	if err != nil {
+		http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"roles":                 roles,
+		"available_permissions": append(append([]auth.Permission{}, auth.Scopes...), auth.AdminPermissions...),
+	})
+}
+
+type roleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// AdminCreateRole defines a custom role
+func (h *Handler) AdminCreateRole(w http.ResponseWriter, r *http.Request) {
+	var req roleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if err := services.ValidateRoleName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidatePermissions(req.Permissions); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	role, err := h.roles.CreateRole(req.Name, strings.TrimSpace(req.Description), req.Permissions)
+	if err == services.ErrRoleExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to create role", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+// AdminUpdateRole replaces the description and permissions of a custom
+// role
+func (h *Handler) AdminUpdateRole(w http.ResponseWriter, r *http.Request) {
+	var req roleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidatePermissions(req.Permissions); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	role, err := h.roles.UpdateRole(chi.URLParam(r, "name"), strings.TrimSpace(req.Description), req.Permissions)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return
+	case services.ErrBuiltinRole, services.ErrLastAdmin:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
+
+// AdminDeleteRole deletes a custom role that no user has
+func (h *Handler) AdminDeleteRole(w http.ResponseWriter, r *http.Request) {
+	switch err := h.roles.DeleteRole(chi.URLParam(r, "name")); err {
+	case nil:
+	case sql.ErrNoRows:
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return
+	case services.ErrBuiltinRole, services.ErrRoleInUse:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Failed to delete role", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"genai-platform/internal/auth"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxAPIKeyLifetimeDays caps expires_in_days
+const maxAPIKeyLifetimeDays = 3650
+
+// CreateAPIKey makes a named, scoped personal API key. The key is in the
+// response only; it cannot be shown again.
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	var req struct {
+		Name          string     `json:"name"`
+		Scopes        []string   `json:"scopes"`
+		ExpiresInDays *int       `json:"expires_in_days"`
+		ExpiresAt     *time.Time `json:"expires_at"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 255 {
+		http.Error(w, "name is required and must be at most 255 characters", http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidateScopes(req.Scopes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expiresAt := req.ExpiresAt
+	switch {
+	case req.ExpiresInDays != nil && req.ExpiresAt != nil:
+		http.Error(w, "Give expires_in_days or expires_at, not both", http.StatusBadRequest)
+		return
+	case req.ExpiresInDays != nil:
+		if *req.ExpiresInDays < 1 || *req.ExpiresInDays > maxAPIKeyLifetimeDays {
+			http.Error(w, "expires_in_days must be between 1 and 3650", http.StatusBadRequest)
+			return
+		}
+		t := time.Now().AddDate(0, 0, *req.ExpiresInDays)
+		expiresAt = &t
+	case req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()):
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	apiKey, key, err := h.apiKeys.Create(userID, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"api_key": apiKey,
+		"key":     key,
+	})
+}
+
+// ListAPIKeys lists the user's API keys, with the scopes they can be given
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	keys, err := h.apiKeys.List(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch API keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"api_keys":         keys,
+		"available_scopes": auth.Scopes,
+	})
+}
+
+// RevokeAPIKey disables one of the user's API keys at once
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	keyID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.apiKeys.Revoke(userID, keyID); err == sql.ErrNoRows {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"genai-platform/internal/services"
+)
+
+// tooManyAttempts refuses a sign-in while the email or address has to wait
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, fmt.Sprintf("Too many failed sign-in attempts, try again in %d seconds", seconds), http.StatusTooManyRequests)
+}
+
+// loginFailed counts a wrong password or code and records it. The failure
+// response says when the next attempt is accepted, and a lockout it
+// caused is recorded too.
+func (h *Handler) loginFailed(w http.ResponseWriter, r *http.Request, event string, userID int, email, detail string) {
+	ip := clientIP(r)
+	h.audit.Record(event, userID, email, detail, ip, r.UserAgent())
+
+	failure, err := h.loginAttempts.RecordFailure(email, ip)
+	if err != nil {
+		log.Printf("Failed to record failed login: %v", err)
+		return
+	}
+	if failure.AccountLocked {
+		h.audit.Record(services.AuthEventLocked, userID, email, "account", ip, r.UserAgent())
+	}
+	if failure.IPLocked {
+		h.audit.Record(services.AuthEventLocked, userID, email, "ip", ip, r.UserAgent())
+	}
+	if failure.Wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(failure.Wait.Seconds()))))
+	}
+}
+
+// loginSucceeded records a sign-in by method and forgets the failures of
+// the email
+func (h *Handler) loginSucceeded(r *http.Request, userID int, email, method string) {
+	h.audit.Record(services.AuthEventLoginSucceeded, userID, email, method, clientIP(r), r.UserAgent())
+	if err := h.loginAttempts.Reset(email); err != nil {
+		log.Printf("Failed to reset login failures of user %d: %v", userID, err)
+	}
+}
+
+// AdminListAuthEvents lists the audit log of sign-ins and lockouts,
+// filtered by ?user_id=, ?email= and ?event=
+func (h *Handler) AdminListAuthEvents(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := pagination(w, r, 50, 200)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	userID := 0
+	if v := query.Get("user_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = id
+	}
+	event := query.Get("event")
+	if event != "" && !slices.Contains(services.AuthEvents, event) {
+		http.Error(w, "event must be one of "+strings.Join(services.AuthEvents, ", "), http.StatusBadRequest)
+		return
+	}
+
+	events, total, err := h.audit.Events(userID, strings.TrimSpace(query.Get("email")), event, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch audit events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": events,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
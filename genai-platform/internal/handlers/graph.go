@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"genai-platform/internal/models"
+	"genai-platform/internal/services"
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+)
+
+// maxGraphQueryDepth caps how many hops a local search may expand
+const maxGraphQueryDepth = 3
+
+// graphFileTypes are the extensions GraphRAG can extract text from
+var graphFileTypes = map[string]bool{
+	".pdf":  true,
+	".docx": true,
+	".txt":  true,
+	".md":   true,
+}
+
+// Graph RAG handlers
+
+// GraphUpload builds the knowledge graph from documents. It accepts either
+// a multipart form with one or more "files", or a JSON body listing the
+// ids of documents that were already uploaded. Extraction runs in the
+// background; progress is read from GET /graph/upload/{id}.
+func (h *Handler) GraphUpload(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	var documents []models.Document
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		// Parse multipart form
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+
+		headers := r.MultipartForm.File["files"]
+		headers = append(headers, r.MultipartForm.File["file"]...)
+		if len(headers) == 0 {
+			http.Error(w, "No files uploaded", http.StatusBadRequest)
+			return
+		}
+
+		for _, header := range headers {
+			ext := strings.ToLower(filepath.Ext(header.Filename))
+			if !graphFileTypes[ext] {
+				http.Error(w, fmt.Sprintf("Unsupported file type: %s", header.Filename), http.StatusBadRequest)
+				return
+			}
+		}
+
+		for _, header := range headers {
+			doc, err := h.saveGraphDocument(userID, workspaceID, header)
+			if err != nil {
+				http.Error(w, "Failed to save file", http.StatusInternalServerError)
+				return
+			}
+			documents = append(documents, *doc)
+		}
+	} else {
+		var req struct {
+			DocumentIDs []int `json:"document_ids"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(req.DocumentIDs) == 0 {
+			http.Error(w, "document_ids is required", http.StatusBadRequest)
+			return
+		}
+
+		// A repeated id is ingested once, and must not make the count
+		// below look like a missing document
+		seen := map[int]bool{}
+		ids := req.DocumentIDs[:0]
+		for _, id := range req.DocumentIDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		req.DocumentIDs = ids
+
+		rows, err := h.db.Query(
+			"SELECT id, user_id, filename, file_path, file_type FROM documents WHERE workspace_id = $1 AND id = ANY($2)",
+			workspaceID, pq.Array(req.DocumentIDs),
+		)
+		if err != nil {
+			http.Error(w, "Failed to load documents", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var doc models.Document
+			if err := rows.Scan(&doc.ID, &doc.UserID, &doc.Filename, &doc.FilePath, &doc.FileType); err != nil {
+				http.Error(w, "Failed to load documents", http.StatusInternalServerError)
+				return
+			}
+			doc.WorkspaceID = workspaceID
+			documents = append(documents, doc)
+		}
+		if len(documents) != len(req.DocumentIDs) {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	documentIDs := make([]int, len(documents))
+	for i, doc := range documents {
+		documentIDs[i] = doc.ID
+	}
+
+	// Create ingestion job
+	var ingestionID int
+	if err := h.db.QueryRow(
+		"INSERT INTO graph_ingestions (user_id, workspace_id, document_ids) VALUES ($1, $2, $3) RETURNING id",
+		userID, workspaceID, pq.Array(documentIDs),
+	).Scan(&ingestionID); err != nil {
+		http.Error(w, "Failed to create graph ingestion", http.StatusInternalServerError)
+		return
+	}
+
+	// Extract entities and relations (async)
+	go h.graphService.ForUser(userID, workspaceID).Ingest(ingestionID, workspaceID, documents)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ingestion_id": ingestionID,
+		"document_ids": documentIDs,
+		"status":       "pending",
+	})
+}
+
+func (h *Handler) GetGraphIngestion(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	ingestionID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ingestion ID", http.StatusBadRequest)
+		return
+	}
+
+	var ingestion models.GraphIngestion
+	var documentIDs []int64
+	var ingestionError *string
+	if err := h.db.QueryRow(
+		`SELECT id, user_id, document_ids, status, total_chunks, processed_chunks, error, created_at, completed_at
+		 FROM graph_ingestions WHERE id = $1 AND workspace_id = $2`,
+		ingestionID, workspaceID,
+	).Scan(&ingestion.ID, &ingestion.UserID, pq.Array(&documentIDs), &ingestion.Status, &ingestion.TotalChunks,
+		&ingestion.ProcessedChunks, &ingestionError, &ingestion.CreatedAt, &ingestion.CompletedAt); err != nil {
+		http.Error(w, "Ingestion not found", http.StatusNotFound)
+		return
+	}
+	ingestion.WorkspaceID = workspaceID
+	ingestion.DocumentIDs = make([]int, len(documentIDs))
+	for i, id := range documentIDs {
+		ingestion.DocumentIDs[i] = int(id)
+	}
+	if ingestionError != nil {
+		ingestion.Error = *ingestionError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ingestion)
+}
+
+// GraphQuery answers a question from the knowledge graph. Local search
+// explores the neighborhood of entities named in the question; global
+// search map-reduces over community summaries for corpus-wide questions.
+func (h *Handler) GraphQuery(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	var req struct {
+		Query string `json:"query"`
+		Mode  string `json:"mode"`
+		Depth int    `json:"depth"`
+		Level int    `json:"level"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		http.Error(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+	if req.Depth == 0 {
+		req.Depth = 1
+	}
+	if req.Depth < 1 || req.Depth > maxGraphQueryDepth {
+		http.Error(w, fmt.Sprintf("depth must be between 1 and %d", maxGraphQueryDepth), http.StatusBadRequest)
+		return
+	}
+	if req.Level < 0 {
+		http.Error(w, "level must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	var answer *services.GraphAnswer
+	var err error
+	switch req.Mode {
+	case "", services.GraphSearchLocal:
+		answer, err = h.graphService.ForUser(userID, workspaceID).LocalSearch(workspaceID, req.Query, req.Depth)
+	case services.GraphSearchGlobal:
+		answer, err = h.graphService.ForUser(userID, workspaceID).GlobalSearch(workspaceID, req.Query, req.Level)
+	default:
+		http.Error(w, "mode must be local or global", http.StatusBadRequest)
+		return
+	}
+	if quotaExceeded(w, err) {
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to answer query", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(answer)
+}
+
+// ListGraphCommunities returns the workspace's communities with their
+// summaries.
+// Level 0 holds the broadest themes; without a level every level is listed
+// and parent_id links each community to the one containing it.
+func (h *Handler) ListGraphCommunities(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	level := -1
+	if v := r.URL.Query().Get("level"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid level", http.StatusBadRequest)
+			return
+		}
+		level = n
+	}
+
+	communities, err := h.graphService.Communities(workspaceID, level)
+	if err != nil {
+		http.Error(w, "Failed to fetch communities", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(communities)
+}
+
+// RebuildGraphCommunities re-clusters the workspace's graph now instead of
+// waiting for the next ingestion. Communities whose membership is unchanged
+// keep their summaries.
+func (h *Handler) RebuildGraphCommunities(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	refresh, err := h.graphService.ForUser(userID, workspaceID).BuildCommunities(workspaceID)
+	if quotaExceeded(w, err) {
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to build communities", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(refresh)
+}
+
+// saveGraphDocument stores an uploaded file and records it in the
+// workspace's documents so it can be reused by later ingestions
+func (h *Handler) saveGraphDocument(userID, workspaceID int, header *multipart.FileHeader) (*models.Document, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Save file
+	uploadDir := "./uploads"
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		return nil, err
+	}
+
+	filename := fmt.Sprintf("%d_%d_%s", userID, time.Now().UnixNano(), filepath.Base(header.Filename))
+	filePath := filepath.Join(uploadDir, filename)
+
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+
+	size, err := io.Copy(dst, file)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &models.Document{
+		UserID:      userID,
+		WorkspaceID: workspaceID,
+		Filename:    header.Filename,
+		FilePath:    filePath,
+		FileType:    strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), "."),
+		FileSize:    int(size),
+	}
+	if err := h.db.QueryRow(
+		`INSERT INTO documents (user_id, workspace_id, filename, file_path, file_type, file_size) 
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		userID, workspaceID, doc.Filename, doc.FilePath, doc.FileType, size,
+	).Scan(&doc.ID); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
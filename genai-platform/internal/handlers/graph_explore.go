@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"genai-platform/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultEntityPageSize = 20
+	maxEntityPageSize     = 200
+
+	// defaultGraphPathDepth and maxGraphPathDepth bound how far a shortest
+	// path search looks before reporting the entities as unconnected
+	defaultGraphPathDepth = 4
+	maxGraphPathDepth     = 8
+)
+
+// SearchGraphEntities lists the user's entities whose name contains "q",
+// optionally filtered by "type"
+func (h *Handler) SearchGraphEntities(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	limit, offset, ok := pagination(w, r, defaultEntityPageSize, maxEntityPageSize)
+	if !ok {
+		return
+	}
+
+	entities, err := h.graphService.SearchEntities(workspaceID, r.URL.Query().Get("q"), r.URL.Query().Get("type"), limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to search entities", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entities)
+}
+
+// GetGraphEntity returns an entity with its neighbors up to "depth" hops
+// away, one by default
+func (h *Handler) GetGraphEntity(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	entityID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+
+	depth, ok := intParam(w, r, "depth", 1, 1, maxGraphQueryDepth)
+	if !ok {
+		return
+	}
+
+	neighborhood, err := h.graphService.Neighborhood(workspaceID, entityID, depth)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Entity not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch entity", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(neighborhood)
+}
+
+// GetGraphPath finds a shortest path between the entities "from" and "to",
+// looking at most "max_depth" hops out
+func (h *Handler) GetGraphPath(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	fromID, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from entity ID", http.StatusBadRequest)
+		return
+	}
+	toID, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to entity ID", http.StatusBadRequest)
+		return
+	}
+	maxDepth, ok := intParam(w, r, "max_depth", defaultGraphPathDepth, 1, maxGraphPathDepth)
+	if !ok {
+		return
+	}
+
+	path, err := h.graphService.ShortestPath(workspaceID, fromID, toID, maxDepth)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Entity not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to find path", http.StatusInternalServerError)
+		return
+	}
+	if path == nil {
+		http.Error(w, fmt.Sprintf("No path within %d hops", maxDepth), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(path)
+}
+
+// ExportGraph downloads the user's whole knowledge graph as GraphML, GEXF
+// or Cytoscape JSON, chosen by "format" (GraphML by default)
+func (h *Handler) ExportGraph(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	formatName := r.URL.Query().Get("format")
+	if formatName == "" {
+		formatName = "graphml"
+	}
+	format, ok := services.LookupGraphExportFormat(formatName)
+	if !ok {
+		http.Error(w, "Unsupported export format", http.StatusBadRequest)
+		return
+	}
+
+	graph, err := h.graphService.FullGraph(workspaceID)
+	if err != nil {
+		http.Error(w, "Failed to load graph", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="knowledge_graph.%s"`, format.Extension))
+
+	if err := services.WriteGraph(formatName, w, graph); err != nil {
+		log.Printf("Failed to export graph of workspace %d: %v", workspaceID, err)
+	}
+}
+
+// intParam reads an optional integer query parameter, writing an error
+// response and returning false when it is outside [min, max]
+func intParam(w http.ResponseWriter, r *http.Request, name string, defaultValue, min, max int) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return defaultValue, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		http.Error(w, fmt.Sprintf("%s must be between %d and %d", name, min, max), http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
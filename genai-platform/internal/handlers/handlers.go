@@ -5,37 +5,151 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"golang.org/x/crypto/bcrypt"
 	"genai-platform/internal/auth"
 	"genai-platform/internal/models"
 	"genai-platform/internal/services"
+	"genai-platform/pkg/config"
 
 	"github.com/lib/pq" // Import the pq library for array handling
 )
 
 type Handler struct {
-	db          *sql.DB
-	llmService  *services.LLMService
-	fileService *services.FileService
+	db            *sql.DB
+	auth          *auth.Service
+	llmService    *services.LLMService
+	fileService   *services.FileService
+	sqlService    *services.SQLService
+	graphService  *services.GraphService
+	resumeService *services.ResumeService
+	redaction     *services.RedactionService
+	sessions      *services.SessionService
+	apiKeys       *services.APIKeyService
+	roles         *services.RoleService
+	admin         *services.AdminService
+	workspaces    *services.WorkspaceService
+	accounts      *services.AccountService
+	sso           *services.SSOService
+	mfa           *services.MFAService
+	loginAttempts *services.LoginAttemptService
+	audit         *services.AuditService
+	usage         *services.UsageService
+
+	// requireEmailVerification keeps unverified users from signing in
+	requireEmailVerification bool
+
+	// passwordLogin allows signing in with a password rather than only
+	// through the identity provider
+	passwordLogin bool
 }
 
-func New(db *sql.DB) *Handler {
+func New(db, queryDB *sql.DB, cfg *config.Config, graphStore services.GraphStore, mailer services.Mailer, authService *auth.Service) *Handler {
+	llmService := services.NewLLMService()
+	mail := services.NewMailService(mailer, cfg.AppURL)
+
+	// An unreadable redaction setting redacts everything rather than
+	// risk sending personal data the operator meant to keep back
+	redactedOperations, err := services.ParseRedactionOperations(cfg.PIIRedaction)
+	if err != nil {
+		log.Printf("Redacting all model operations, PII_REDACTION is invalid: %v", err)
+		redactedOperations = services.RedactableOperations
+	}
+	redaction := services.NewRedactionService(db, redactedOperations)
+	llmService.SetRedaction(redaction)
+
+	// Model calls are metered per user, workspace and feature. Unreadable
+	// prices fall back to the list prices rather than metering nothing.
+	modelPrices, err := services.ParseModelPrices(cfg.ModelPrices)
+	if err != nil {
+		log.Printf("Ignoring MODEL_PRICES: %v", err)
+		modelPrices = services.DefaultModelPrices()
+	}
+	usage := services.NewUsageService(db, modelPrices, services.UsageQuota{
+		MonthlyTokens: cfg.UsageMonthlyTokens,
+		MonthlyCost:   cfg.UsageMonthlyCost,
+	})
+	llmService.SetUsage(usage)
+
+	// Access tokens of revoked sessions are rejected until they expire
+	sessions := services.NewSessionService(db, authService, cfg.RefreshTokenTTL)
+	authService.SetRevocationList(sessions)
+	apiKeys := services.NewAPIKeyService(db)
+	authService.SetAPIKeyStore(apiKeys)
+	// Roles are checked on every request, so disabling an account or
+	// changing a role takes effect before its tokens expire
+	roles := services.NewRoleService(db, strings.Split(cfg.AdminEmails, ","))
+	authService.SetAccountStore(roles)
+	// Every request acts in a workspace the user is a member of
+	workspaces := services.NewWorkspaceService(db, graphStore, mail, cfg.WorkspaceInvitationTTL)
+	authService.SetWorkspaceStore(workspaces)
+
+	// Single sign-on is on when an identity provider is configured
+	var oidcClient *auth.OIDCClient
+	if cfg.OIDCIssuer != "" {
+		oidcClient = auth.NewOIDCClient(auth.OIDCConfig{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       strings.Fields(cfg.OIDCScopes),
+			GroupsClaim:  cfg.OIDCGroupsClaim,
+		})
+	}
+	sso := services.NewSSOService(db, oidcClient, roles, services.SSOOptions{
+		AllowSignup:          cfg.OIDCAllowSignup,
+		TrustUnverifiedEmail: cfg.OIDCTrustUnverifiedEmail,
+	})
+
+	rubricWeights, err := services.ParseRubricWeights(cfg.ResumeRubricWeights)
+	if err != nil {
+		log.Printf("Ignoring RESUME_RUBRIC_WEIGHTS: %v", err)
+		rubricWeights = services.DefaultRubricWeights()
+	}
+
 	return &Handler{
-		db:          db,
-		llmService:  services.NewLLMService(),
-		fileService: services.NewFileService(),
+		db:            db,
+		auth:          authService,
+		llmService:    llmService,
+		fileService:   services.NewFileService(),
+		sqlService:    services.NewSQLService(queryDB, cfg.SQLSchema, llmService, cfg.SQLMaxRepairAttempts),
+		graphService:  services.NewGraphService(db, llmService, graphStore),
+		resumeService: services.NewResumeService(db, llmService, rubricWeights, cfg.ResumeBatchConcurrency),
+		redaction:     redaction,
+		sessions:      sessions,
+		apiKeys:       apiKeys,
+		roles:         roles,
+		admin:         services.NewAdminService(db, sessions),
+		workspaces:    workspaces,
+		accounts:      services.NewAccountService(db, mail, sessions, cfg.EmailVerificationTTL, cfg.PasswordResetTTL),
+		sso:           sso,
+		mfa:           services.NewMFAService(db, cfg.MFAIssuer, cfg.MFAChallengeTTL),
+		loginAttempts: services.NewLoginAttemptService(db, services.LoginAttemptOptions{
+			FreeAttempts:   cfg.LoginFreeAttempts,
+			IPFreeAttempts: cfg.LoginIPFreeAttempts,
+			MaxLockout:     cfg.LoginLockout,
+		}),
+		audit: services.NewAuditService(db),
+		usage: usage,
+
+		requireEmailVerification: cfg.RequireEmailVerification,
+		passwordLogin:            cfg.PasswordLogin,
 	}
 }
 
 // Auth handlers
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePasswordLogin(w) {
+		return
+	}
+
 	var req struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -46,39 +160,62 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+	req.Email = services.NormalizeEmail(req.Email)
+	if err := services.ValidateEmail(req.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidatePassword(req.Password, req.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Insert user
-	var userID int
-	if err := h.db.QueryRow(
-		"INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id",
-		req.Email, string(hashedPassword),
-	).Scan(&userID); err != nil {
+	userID, err := h.accounts.Register(req.Email, req.Password)
+	if err == services.ErrEmailTaken {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
+	h.roles.PromoteConfiguredAdmin(userID, req.Email)
+	if _, err := h.workspaces.EnsurePersonal(userID); err != nil {
+		http.Error(w, "Failed to create workspace", http.StatusInternalServerError)
+		return
+	}
+
+	// Ask the user to confirm their address
+	if err := h.accounts.SendVerification(userID, req.Email); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", userID, err)
+	}
+	if h.requireEmailVerification {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"user_id":               userID,
+			"email":                 req.Email,
+			"verification_required": true,
+		})
+		return
+	}
 
-	// Generate token
-	token, err := auth.GenerateToken(userID, req.Email, "your-secret-key-change-in-production")
+	// Start a session
+	tokens, err := h.sessions.Start(userID, req.Email, r.UserAgent(), clientIP(r))
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"token":   token,
-		"user_id": userID,
-		"email":   req.Email,
-	})
+	json.NewEncoder(w).Encode(tokens)
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePasswordLogin(w) {
+		return
+	}
+
 	var req struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -89,39 +226,76 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user models.User
-	if err := h.db.QueryRow(
-		"SELECT id, email, password_hash FROM users WHERE email = $1",
-		req.Email,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash); err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	// Guesses are slowed down per email and per address, before anything
+	// about the account is looked at. Attempts on the same email or
+	// address take turns until this one is counted, so parallel guesses
+	// cannot slip past the wait.
+	email := services.NormalizeEmail(req.Email)
+	ip := clientIP(r)
+	attempt, wait, err := h.loginAttempts.Begin(email, ip)
+	if err != nil {
+		log.Printf("Failed to check login attempts: %v", err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	} else if wait > 0 {
+		h.audit.Record(services.AuthEventLoginBlocked, 0, email, "", ip, r.UserAgent())
+		tooManyAttempts(w, wait)
 		return
 	}
+	defer attempt.Done()
 
-	// Check password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+	// Emails are matched regardless of case; an exact match wins among
+	// accounts made before emails were normalized
+	var user models.User
+	var disabled, verified bool
+	err = h.db.QueryRow(
+		`SELECT id, email, password_hash, disabled_at IS NOT NULL, email_verified_at IS NOT NULL
+		 FROM users WHERE lower(email) = lower($1)
+		 ORDER BY email = $1 DESC LIMIT 1`,
+		strings.TrimSpace(req.Email),
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &disabled, &verified)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to look up user for login: %v", err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	// Check password. Unknown emails are checked against a dummy hash so
+	// they take as long as a wrong password.
+	if !services.CheckPassword(user.PasswordHash, req.Password) {
+		detail := "wrong_password"
+		if user.ID == 0 {
+			detail = "unknown_email"
+		}
+		h.loginFailed(w, r, services.AuthEventLoginFailed, user.ID, email, detail)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Generate token
-	token, err := auth.GenerateToken(user.ID, user.Email, "your-secret-key-change-in-production")
-	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+	// Only someone who knows the password learns the account is disabled
+	if disabled {
+		h.audit.Record(services.AuthEventLoginFailed, user.ID, email, "disabled", ip, r.UserAgent())
+		http.Error(w, "Account is disabled", http.StatusForbidden)
+		return
+	}
+	if h.requireEmailVerification && !verified {
+		h.audit.Record(services.AuthEventLoginFailed, user.ID, email, "unverified", ip, r.UserAgent())
+		http.Error(w, "Email address is not verified", http.StatusForbidden)
 		return
 	}
+	h.roles.PromoteConfiguredAdmin(user.ID, user.Email)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"token":   token,
-		"user_id": user.ID,
-		"email":   user.Email,
-	})
+	// Start a session, or ask for a second factor first. Failures are
+	// forgotten only once the user is fully signed in.
+	if h.startSession(w, r, user.ID, user.Email) {
+		h.loginSucceeded(r, user.ID, email, "password")
+	}
 }
 
 // PDF Chat handlers
 func (h *Handler) UploadPDF(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int)
+	workspaceID := r.Context().Value("workspace_id").(int)
 
 	// Parse multipart form
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
@@ -168,9 +342,9 @@ func (h *Handler) UploadPDF(w http.ResponseWriter, r *http.Request) {
 	// Save to database
 	var docID int
 	if err := h.db.QueryRow(
-		`INSERT INTO documents (user_id, filename, file_path, file_type, file_size) 
-		 VALUES ($1, $2, $3, $4, $5) RETURNING id`,
-		userID, header.Filename, filePath, "pdf", size,
+		`INSERT INTO documents (user_id, workspace_id, filename, file_path, file_type, file_size) 
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		userID, workspaceID, header.Filename, filePath, "pdf", size,
 	).Scan(&docID); err != nil {
 		http.Error(w, "Failed to save document info", http.StatusInternalServerError)
 		return
@@ -189,6 +363,7 @@ func (h *Handler) UploadPDF(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) ChatQuery(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int)
+	workspaceID := r.Context().Value("workspace_id").(int)
 
 	var req struct {
 		Query       string `json:"query"`
@@ -201,13 +376,38 @@ func (h *Handler) ChatQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only documents and sessions of the workspace can be used
+	var missing int
+	if err := h.db.QueryRow(
+		`SELECT COUNT(*) FROM unnest($2::int[]) d(id)
+		 WHERE NOT EXISTS (SELECT 1 FROM documents WHERE id = d.id AND workspace_id = $1)`,
+		workspaceID, pq.Array(req.DocumentIDs),
+	).Scan(&missing); err != nil {
+		http.Error(w, "Failed to check documents", http.StatusInternalServerError)
+		return
+	}
+	if missing > 0 {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return
+	}
+
 	// Create or get session
 	sessionID := req.SessionID
+	if sessionID != nil {
+		var exists bool
+		if err := h.db.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM chat_sessions WHERE id = $1 AND workspace_id = $2)",
+			*sessionID, workspaceID,
+		).Scan(&exists); err != nil || !exists {
+			http.Error(w, "Chat session not found", http.StatusNotFound)
+			return
+		}
+	}
 	if sessionID == nil {
 		var newSessionID int
 		if err := h.db.QueryRow(
-			"INSERT INTO chat_sessions (user_id, document_ids) VALUES ($1, $2) RETURNING id",
-			userID, pq.Array(req.DocumentIDs),
+			"INSERT INTO chat_sessions (user_id, workspace_id, document_ids) VALUES ($1, $2, $3) RETURNING id",
+			userID, workspaceID, pq.Array(req.DocumentIDs),
 		).Scan(&newSessionID); err != nil {
 			http.Error(w, fmt.Sprintf("Failed to create session: %v", err), http.StatusInternalServerError)
 			return
@@ -224,16 +424,22 @@ func (h *Handler) ChatQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get relevant context from documents
-	context, err := h.fileService.GetRelevantContext(req.DocumentIDs, req.Query)
-	if err != nil {
+	// Get relevant context from documents, redacted and metered like the
+	// response itself
+	llm := h.llmService.ForUser(userID).ForFeature(services.UsageFeatureChat, workspaceID)
+	context, err := llm.GetRelevantContext(req.DocumentIDs, req.Query)
+	if quotaExceeded(w, err) {
+		return
+	} else if err != nil {
 		http.Error(w, "Failed to get context", http.StatusInternalServerError)
 		return
 	}
 
 	// Generate response using LLM
-	response, err := h.llmService.GenerateResponse(req.Query, context)
-	if err != nil {
+	response, err := llm.GenerateResponse(req.Query, context)
+	if quotaExceeded(w, err) {
+		return
+	} else if err != nil {
 		http.Error(w, "Failed to generate response", http.StatusInternalServerError)
 		return
 	}
@@ -255,28 +461,10 @@ func (h *Handler) ChatQuery(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// Graph RAG handlers
-func (h *Handler) GraphUpload(w http.ResponseWriter, r *http.Request) {
-	// Placeholder for GraphRAG upload
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "success",
-		"message": "GraphRAG upload endpoint - to be implemented",
-	})
-}
-
-func (h *Handler) GraphQuery(w http.ResponseWriter, r *http.Request) {
-	// Placeholder for GraphRAG query
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "success",
-		"message": "GraphRAG query endpoint - to be implemented",
-	})
-}
-
 // Research Assistant handlers
 func (h *Handler) ResearchAgent(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int)
+	workspaceID := r.Context().Value("workspace_id").(int)
 
 	var req struct {
 		Query string `json:"query"`
@@ -290,15 +478,15 @@ func (h *Handler) ResearchAgent(w http.ResponseWriter, r *http.Request) {
 	// Create research task
 	var taskID int
 	if err := h.db.QueryRow(
-		"INSERT INTO research_tasks (user_id, query) VALUES ($1, $2) RETURNING id",
-		userID, req.Query,
+		"INSERT INTO research_tasks (user_id, workspace_id, query) VALUES ($1, $2, $3) RETURNING id",
+		userID, workspaceID, req.Query,
 	).Scan(&taskID); err != nil {
 		http.Error(w, "Failed to create research task", http.StatusInternalServerError)
 		return
 	}
 
 	// Start research process (async)
-	go h.llmService.ProcessResearchTask(taskID, req.Query, h.db)
+	go h.llmService.ForUser(userID).ForFeature(services.UsageFeatureResearch, workspaceID).ProcessResearchTask(taskID, req.Query, h.db)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -308,7 +496,7 @@ func (h *Handler) ResearchAgent(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetResearchResult(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(int)
+	workspaceID := r.Context().Value("workspace_id").(int)
 	taskIDStr := chi.URLParam(r, "id")
 	
 	taskID, err := strconv.Atoi(taskIDStr)
@@ -319,8 +507,8 @@ func (h *Handler) GetResearchResult(w http.ResponseWriter, r *http.Request) {
 
 	var task models.ResearchTask
 	if err := h.db.QueryRow(
-		"SELECT id, query, status, result, created_at, completed_at FROM research_tasks WHERE id = $1 AND user_id = $2",
-		taskID, userID,
+		"SELECT id, query, status, result, created_at, completed_at FROM research_tasks WHERE id = $1 AND workspace_id = $2",
+		taskID, workspaceID,
 	).Scan(&task.ID, &task.Query, &task.Status, &task.Result, &task.CreatedAt, &task.CompletedAt); err != nil {
 		http.Error(w, "Task not found", http.StatusNotFound)
 		return
@@ -347,8 +535,32 @@ func (h *Handler) ResumeUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	if !services.IsResumeFile(header.Filename) {
+		http.Error(w, "Unsupported file type: resumes must be PDF, DOCX or plain text", http.StatusBadRequest)
+		return
+	}
+
 	jobDescription := r.FormValue("job_description")
 
+	// Weights may be set per upload, in the same form as RESUME_RUBRIC_WEIGHTS
+	var rubricWeights services.RubricWeights
+	if spec := r.FormValue("rubric_weights"); spec != "" {
+		if rubricWeights, err = services.ParseRubricWeights(spec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// A revision names the resume it is a new version of; without one the
+	// upload starts a new resume
+	var resumeID int
+	if value := r.FormValue("resume_id"); value != "" {
+		if resumeID, err = strconv.Atoi(value); err != nil || resumeID < 1 {
+			http.Error(w, "Invalid resume ID", http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Save file
 	uploadDir := "./uploads/resumes"
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
@@ -356,7 +568,7 @@ func (h *Handler) ResumeUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filename := fmt.Sprintf("%d_%d_%s", userID, time.Now().Unix(), header.Filename)
+	filename := fmt.Sprintf("%d_%d_%s", userID, time.Now().Unix(), filepath.Base(header.Filename))
 	filePath := filepath.Join(uploadDir, filename)
 
 	dst, err := os.Create(filePath)
@@ -372,22 +584,26 @@ func (h *Handler) ResumeUpload(w http.ResponseWriter, r *http.Request) {
 		return	}
 
 	// Save to database
-	var analysisID int
-	if err := h.db.QueryRow(
-		`INSERT INTO resume_analyses (user_id, resume_path, job_description) 
-		 VALUES ($1, $2, $3) RETURNING id`,
-		userID, filePath, jobDescription,
-	).Scan(&analysisID); err != nil {
+	analysisID, resumeID, version, err := h.createResumeVersion(userID, resumeID, filePath, jobDescription, header.Filename)
+	if err == sql.ErrNoRows {
+		os.Remove(filePath)
+		http.Error(w, "Resume not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		os.Remove(filePath)
 		http.Error(w, "Failed to save analysis info", http.StatusInternalServerError)
 		return
 	}
 
 	// Process resume (async)
-	go h.llmService.ProcessResume(analysisID, filePath, jobDescription, h.db)
+	go h.resumeService.Process(analysisID, userID, r.Context().Value("workspace_id").(int), filePath, jobDescription, rubricWeights)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"analysis_id": analysisID,
+		"resume_id":   resumeID,
+		"version":     version,
 		"status":      "processing",
 	})
 }
@@ -403,15 +619,34 @@ func (h *Handler) GetResumeFeedback(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var analysis models.ResumeAnalysis
+	var feedback, analysisError sql.NullString
+	var score, resumeID, version sql.NullInt64
+	var rubricJSON []byte
 	if err := h.db.QueryRow(
-		`SELECT id, resume_path, job_description, feedback, score, status, created_at, completed_at 
+		`SELECT id, resume_path, resume_id, version, job_description, feedback, score, analysis, error, status, created_at, completed_at 
 		 FROM resume_analyses WHERE id = $1 AND user_id = $2`,
 		analysisID, userID,
-	).Scan(&analysis.ID, &analysis.ResumePath, &analysis.JobDescription, 
-		&analysis.Feedback, &analysis.Score, &analysis.Status, &analysis.CreatedAt, &analysis.CompletedAt); err != nil {
+	).Scan(&analysis.ID, &analysis.ResumePath, &resumeID, &version, &analysis.JobDescription, 
+		&feedback, &score, &rubricJSON, &analysisError, &analysis.Status, &analysis.CreatedAt, &analysis.CompletedAt); err != nil {
 		http.Error(w, "Analysis not found", http.StatusNotFound)
 		return
 	}
+	analysis.UserID = userID
+	analysis.Feedback = feedback.String
+	analysis.Score = int(score.Int64)
+	analysis.Error = analysisError.String
+	if resumeID.Valid {
+		id := int(resumeID.Int64)
+		analysis.ResumeID = &id
+		analysis.Version = int(version.Int64)
+	}
+	if rubricJSON != nil {
+		analysis.Analysis = &models.ResumeRubric{}
+		if err := json.Unmarshal(rubricJSON, analysis.Analysis); err != nil {
+			http.Error(w, "Failed to read analysis", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(analysis)
@@ -420,6 +655,9 @@ func (h *Handler) GetResumeFeedback(w http.ResponseWriter, r *http.Request) {
 // Text-to-SQL handlers
 func (h *Handler) SQLQuery(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int)
+	if !h.sqlEnabled(w) {
+		return
+	}
 
 	var req struct {
 		Query string `json:"query"`
@@ -430,44 +668,26 @@ func (h *Handler) SQLQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate SQL from natural language
-	sql, err := h.llmService.GenerateSQL(req.Query)
-	if err != nil {
-		http.Error(w, "Failed to generate SQL", http.StatusInternalServerError)
+	// Generate SQL from natural language and execute it, letting the model
+	// repair queries that fail
+	result, attempts, execErr := h.sqlService.GenerateAndExecute(userID, r.Context().Value("workspace_id").(int), req.Query)
+	// The quota can run out while a failed query is being repaired, which
+	// is not a failure of the query itself
+	if quotaExceeded(w, execErr) {
 		return
 	}
-
-	// Execute SQL (placeholder - would need proper database schema)
-	resultData := map[string]interface{}{
-		"message": "SQL execution would happen here",
-		"sql":     sql,
-	}
-
-	// Marshal resultData to JSON string
-	resultDataJSON, err := json.Marshal(resultData)
-	if err != nil {
-		http.Error(w, "Failed to marshal result data", http.StatusInternalServerError)
+	if execErr != nil && len(attempts) == 0 {
+		http.Error(w, "Failed to generate SQL", http.StatusInternalServerError)
 		return
 	}
 
-	// Save query
-	var queryID int
-	if err := h.db.QueryRow(
-		`INSERT INTO sql_queries (user_id, natural_query, generated_sql, result_data) 
-		 VALUES ($1, $2, $3, $4) RETURNING id`,
-		userID, req.Query, sql, resultDataJSON,
-	).Scan(&queryID); err != nil {
+	response, err := h.recordSQLQuery(userID, r.Context().Value("workspace_id").(int), req.Query, result, attempts, execErr, nil)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to save query: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"query_id":    queryID,
-		"sql":         sql,
-		"result_data": resultData,
-	})
+	json.NewEncoder(w).Encode(response)
 }
 
-
-
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"genai-platform/internal/services"
+)
+
+// startSession finishes the first step of signing in. Users with
+// two-factor authentication get a challenge to answer at
+// /auth/mfa/verify with a code; everyone else gets a session. It reports
+// whether a session was started.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, userID int, email string) bool {
+	enabled, err := h.mfa.Enabled(userID)
+	if err != nil {
+		log.Printf("Failed to check two-factor authentication of user %d: %v", userID, err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return false
+	}
+	if enabled {
+		token, err := h.mfa.Challenge(userID)
+		if err != nil {
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return false
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"mfa_required":    true,
+			"challenge_token": token,
+			"expires_in":      int(h.mfa.ChallengeTTL().Seconds()),
+		})
+		return false
+	}
+
+	tokens, err := h.sessions.Start(userID, email, r.UserAgent(), clientIP(r))
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+	return true
+}
+
+// mfaError writes the response for an error of a two-factor
+// authentication change
+func mfaError(w http.ResponseWriter, err error, failed string) {
+	switch err {
+	case services.ErrInvalidMFACode:
+		http.Error(w, "Invalid authentication code", http.StatusUnauthorized)
+	case services.ErrMFANotEnabled, services.ErrMFANotEnrolled:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case services.ErrMFAAlreadyEnabled, services.ErrMFARequiredByWorkspace:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("%s: %v", failed, err)
+		http.Error(w, failed, http.StatusInternalServerError)
+	}
+}
+
+// decodeMFACode reads the {"code"} body of a request that needs a code
+// from the user's authenticator app or a recovery code
+func decodeMFACode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return "", false
+	}
+	if req.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return "", false
+	}
+	return req.Code, true
+}
+
+// VerifyMFA finishes signing in with the challenge token from the first
+// step and a code from the user's authenticator app or a recovery code
+func (h *Handler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ChallengeToken == "" || req.Code == "" {
+		http.Error(w, "challenge_token and code are required", http.StatusBadRequest)
+		return
+	}
+
+	userID, email, err := h.mfa.VerifyChallenge(req.ChallengeToken, req.Code)
+	switch err {
+	case nil:
+	case services.ErrMFAChallenge:
+		http.Error(w, "Sign-in has expired, please sign in again", http.StatusUnauthorized)
+		return
+	case services.ErrInvalidMFACode:
+		// Wrong codes count like wrong passwords, so a stolen password
+		// does not buy unlimited guesses at the code
+		h.loginFailed(w, r, services.AuthEventMFAFailed, userID, services.NormalizeEmail(email), "")
+		http.Error(w, "Invalid authentication code", http.StatusUnauthorized)
+		return
+	default:
+		log.Printf("Failed to verify two-factor authentication: %v", err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := h.sessions.Start(userID, email, r.UserAgent(), clientIP(r))
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	h.loginSucceeded(r, userID, services.NormalizeEmail(email), "mfa")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// GetMFAStatus returns whether the caller has two-factor authentication
+func (h *Handler) GetMFAStatus(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	status, err := h.mfa.Status(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// EnrollMFA makes a new authenticator secret for the caller. The web app
+// shows otpauth_uri as a QR code, with secret for typing in by hand, and
+// turns two-factor authentication on with a code at /auth/mfa/activate.
+func (h *Handler) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	secret, uri, err := h.mfa.Enroll(userID)
+	if err != nil {
+		mfaError(w, err, "Failed to start enrollment")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	})
+}
+
+// ActivateMFA turns on two-factor authentication with a first code from
+// the caller's app and returns their recovery codes, shown only this once
+func (h *Handler) ActivateMFA(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	code, ok := decodeMFACode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.mfa.Activate(userID, code)
+	if err != nil {
+		mfaError(w, err, "Failed to turn on two-factor authentication")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// DisableMFA turns off the caller's two-factor authentication, which takes
+// a code
+func (h *Handler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	code, ok := decodeMFACode(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.mfa.Disable(userID, code); err != nil {
+		mfaError(w, err, "Failed to turn off two-factor authentication")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateMFARecoveryCodes replaces the caller's recovery codes, which
+// takes a code
+func (h *Handler) RegenerateMFARecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	code, ok := decodeMFACode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.mfa.RegenerateRecoveryCodes(userID, code)
+	if err != nil {
+		mfaError(w, err, "Failed to make recovery codes")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// AdminResetMFA turns off a user's two-factor authentication, for someone
+// who lost both their authenticator and recovery codes
+func (h *Handler) AdminResetMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.mfa.Reset(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to reset two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetWorkspaceSecurity turns on or off requiring two-factor authentication
+// of the members of a workspace. Members without it keep access to the
+// workspace's settings but not its data until they turn it on.
+func (h *Handler) SetWorkspaceSecurity(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	workspaceID, _, ok := h.workspaceManager(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		RequireMFA *bool `json:"require_mfa"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RequireMFA == nil {
+		http.Error(w, "require_mfa is required", http.StatusBadRequest)
+		return
+	}
+
+	// Requiring it without having it would lock the caller out
+	if *req.RequireMFA {
+		enabled, err := h.mfa.Enabled(userID)
+		if err != nil {
+			http.Error(w, "Failed to update workspace", http.StatusInternalServerError)
+			return
+		}
+		if !enabled {
+			http.Error(w, "Turn on two-factor authentication before requiring it", http.StatusConflict)
+			return
+		}
+	}
+
+	if err := h.workspaces.SetRequireMFA(workspaceID, *req.RequireMFA); err != nil {
+		workspaceError(w, err, "Workspace not found", "Failed to update workspace")
+		return
+	}
+
+	h.GetWorkspace(w, r)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"genai-platform/internal/services"
+)
+
+const (
+	defaultRedactionEventsPageSize = 50
+	maxRedactionEventsPageSize     = 200
+)
+
+// GetRedactionSettings returns which model operations have personal data
+// redacted for the caller, and the operations that can be redacted
+func (h *Handler) GetRedactionSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	setting, err := h.redaction.Setting(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch redaction settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"setting":              setting,
+		"available_operations": services.RedactableOperations,
+	})
+}
+
+// UpdateRedactionSettings saves the caller's own redaction setting, which
+// overrides the server default. Enabling with no operations redacts all.
+func (h *Handler) UpdateRedactionSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	var req struct {
+		Enabled    *bool    `json:"enabled"`
+		Operations []string `json:"operations"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Enabled == nil {
+		http.Error(w, "enabled is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := services.ValidateRedactionOperations(req.Operations); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.redaction.SaveSetting(userID, *req.Enabled, req.Operations); err != nil {
+		http.Error(w, "Failed to save redaction settings", http.StatusInternalServerError)
+		return
+	}
+
+	h.GetRedactionSettings(w, r)
+}
+
+// ResetRedactionSettings drops the caller's own setting so the server
+// default applies again
+func (h *Handler) ResetRedactionSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	if err := h.redaction.ResetSetting(userID); err != nil {
+		http.Error(w, "Failed to reset redaction settings", http.StatusInternalServerError)
+		return
+	}
+
+	h.GetRedactionSettings(w, r)
+}
+
+// ListRedactionEvents returns the audit log of the caller's redacted model
+// calls, newest first. Events hold counts per kind, never the values.
+func (h *Handler) ListRedactionEvents(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	limit, offset, ok := pagination(w, r, defaultRedactionEventsPageSize, maxRedactionEventsPageSize)
+	if !ok {
+		return
+	}
+
+	events, err := h.redaction.Events(userID, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch redaction events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": events,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
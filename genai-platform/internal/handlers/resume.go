@@ -0,0 +1,642 @@
+package handlers
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"genai-platform/internal/models"
+	"genai-platform/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// maxResumeBatchFiles caps how many resumes one batch may hold,
+	// counting those unpacked from zip archives
+	maxResumeBatchFiles = 200
+
+	// maxResumeFileSize caps the size of a single resume, including one
+	// unpacked from a zip archive
+	maxResumeFileSize = 10 << 20
+
+	// defaultShortlistSize is how many ranked resumes a batch lists unless
+	// limit is given
+	defaultShortlistSize = 10
+)
+
+// resumeFile is a resume waiting to be saved, uploaded directly or found in
+// a zip archive
+type resumeFile struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+// ResumeBatchUpload scores many resumes against one job description. The
+// multipart form carries job_description, optional rubric_weights and the
+// resumes as "resumes" files; a zip archive is unpacked and entries that
+// are not resumes are skipped. Scoring runs in the background; the ranked
+// shortlist is read from GET /resume/batch/{id}.
+func (h *Handler) ResumeBatchUpload(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	// Parse multipart form
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	jobDescription := strings.TrimSpace(r.FormValue("job_description"))
+	if jobDescription == "" {
+		http.Error(w, "job_description is required", http.StatusBadRequest)
+		return
+	}
+
+	rubricWeights := h.resumeService.Weights()
+	if spec := r.FormValue("rubric_weights"); spec != "" {
+		var err error
+		if rubricWeights, err = services.ParseRubricWeights(spec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	headers := r.MultipartForm.File["resumes"]
+	headers = append(headers, r.MultipartForm.File["resume"]...)
+	if len(headers) == 0 {
+		http.Error(w, "No resumes uploaded", http.StatusBadRequest)
+		return
+	}
+
+	var files []resumeFile
+	skipped := []string{}
+	for _, header := range headers {
+		ext := strings.ToLower(filepath.Ext(header.Filename))
+		if ext == ".zip" {
+			archive, err := header.Open()
+			if err != nil {
+				http.Error(w, "Failed to read archive", http.StatusBadRequest)
+				return
+			}
+			defer archive.Close()
+
+			entries, entriesSkipped, err := resumeArchiveFiles(archive, header)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			files = append(files, entries...)
+			skipped = append(skipped, entriesSkipped...)
+			continue
+		}
+
+		if !services.IsResumeFile(header.Filename) {
+			http.Error(w, fmt.Sprintf("Unsupported file type: %s", header.Filename), http.StatusBadRequest)
+			return
+		}
+		if header.Size > maxResumeFileSize {
+			http.Error(w, fmt.Sprintf("Resume is too large: %s", header.Filename), http.StatusBadRequest)
+			return
+		}
+		header := header
+		files = append(files, resumeFile{
+			name: header.Filename,
+			open: func() (io.ReadCloser, error) { return header.Open() },
+		})
+	}
+	if len(files) == 0 {
+		http.Error(w, "No resumes found in upload", http.StatusBadRequest)
+		return
+	}
+	if len(files) > maxResumeBatchFiles {
+		http.Error(w, fmt.Sprintf("A batch may hold at most %d resumes", maxResumeBatchFiles), http.StatusBadRequest)
+		return
+	}
+
+	// Save files
+	uploadDir := "./uploads/resumes"
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		http.Error(w, "Failed to create upload directory", http.StatusInternalServerError)
+		return
+	}
+
+	paths := make([]string, 0, len(files))
+	removeSaved := func() {
+		for _, p := range paths {
+			os.Remove(p)
+		}
+	}
+	for i, file := range files {
+		filePath := filepath.Join(uploadDir, fmt.Sprintf("%d_%d_%d_%s", userID, time.Now().UnixNano(), i, filepath.Base(file.name)))
+		if err := saveResumeFile(file, filePath); err != nil {
+			removeSaved()
+			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+			return
+		}
+		paths = append(paths, filePath)
+	}
+
+	batchID, resumes, err := h.createResumeBatch(userID, jobDescription, rubricWeights, files, paths)
+	if err != nil {
+		removeSaved()
+		http.Error(w, "Failed to create resume batch", http.StatusInternalServerError)
+		return
+	}
+
+	// Score resumes (async)
+	go h.resumeService.ProcessBatch(batchID, userID, r.Context().Value("workspace_id").(int), resumes, jobDescription, rubricWeights)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"batch_id":      batchID,
+		"total_resumes": len(resumes),
+		"skipped":       skipped,
+		"status":        "pending",
+	})
+}
+
+// resumeArchiveFiles lists the resumes inside a zip archive, returning the
+// names of entries skipped because they are not resumes
+func resumeArchiveFiles(archive multipart.File, header *multipart.FileHeader) ([]resumeFile, []string, error) {
+	zr, err := zip.NewReader(archive, header.Size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid zip archive: %s", header.Filename)
+	}
+
+	var files []resumeFile
+	skipped := []string{}
+	for _, entry := range zr.File {
+		// Entry names are only used for display; saved files are named
+		// from their base name so paths inside the archive cannot escape
+		// the upload directory
+		name := path.Base(entry.Name)
+		if entry.FileInfo().IsDir() || strings.HasPrefix(entry.Name, "__MACOSX/") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !services.IsResumeFile(name) {
+			skipped = append(skipped, entry.Name)
+			continue
+		}
+		if entry.UncompressedSize64 > maxResumeFileSize {
+			return nil, nil, fmt.Errorf("Resume is too large: %s", entry.Name)
+		}
+		entry := entry
+		files = append(files, resumeFile{
+			name: name,
+			open: func() (io.ReadCloser, error) { return entry.Open() },
+		})
+	}
+	return files, skipped, nil
+}
+
+// saveResumeFile copies a resume to filePath, refusing to write more than
+// maxResumeFileSize whatever size the upload claimed
+func saveResumeFile(file resumeFile, filePath string) error {
+	src, err := file.open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	n, err := io.Copy(dst, io.LimitReader(src, maxResumeFileSize+1))
+	if err != nil {
+		return err
+	}
+	if n > maxResumeFileSize {
+		return fmt.Errorf("resume %s is larger than %d bytes", file.name, maxResumeFileSize)
+	}
+	return nil
+}
+
+// createResumeBatch records the batch and one pending analysis per resume
+// in a single transaction
+func (h *Handler) createResumeBatch(userID int, jobDescription string, weights services.RubricWeights, files []resumeFile, paths []string) (int, []services.BatchResume, error) {
+	weightsJSON, err := json.Marshal(weights.Normalized())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	var batchID int
+	if err := tx.QueryRow(
+		`INSERT INTO resume_batches (user_id, job_description, weights, total_resumes)
+		 VALUES ($1, $2, $3, $4) RETURNING id`,
+		userID, jobDescription, weightsJSON, len(files),
+	).Scan(&batchID); err != nil {
+		return 0, nil, err
+	}
+
+	resumes := make([]services.BatchResume, len(files))
+	for i, file := range files {
+		resumes[i].Path = paths[i]
+		if err := tx.QueryRow(
+			`INSERT INTO resume_analyses (user_id, resume_path, job_description, batch_id, filename)
+			 VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+			userID, paths[i], jobDescription, batchID, file.name,
+		).Scan(&resumes[i].AnalysisID); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return batchID, resumes, tx.Commit()
+}
+
+// GetResumeBatch returns a batch's progress and its ranked shortlist: the
+// best scored resumes, each with its dimension scores and a few snippets
+// explaining the ranking. Resumes that could not be scored are listed
+// under failed.
+func (h *Handler) GetResumeBatch(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	batchID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid batch ID", http.StatusBadRequest)
+		return
+	}
+
+	limit, ok := intParam(w, r, "limit", defaultShortlistSize, 1, maxResumeBatchFiles)
+	if !ok {
+		return
+	}
+
+	batch, ok := h.resumeBatch(w, batchID, userID)
+	if !ok {
+		return
+	}
+
+	candidates, err := h.resumeService.BatchCandidates(batchID)
+	if err != nil {
+		http.Error(w, "Failed to fetch resumes", http.StatusInternalServerError)
+		return
+	}
+
+	shortlist := []models.RankedResume{}
+	failed := []models.RankedResume{}
+	for _, candidate := range candidates {
+		switch {
+		case candidate.Rank > 0 && len(shortlist) < limit:
+			shortlist = append(shortlist, candidate.RankedResume)
+		case candidate.Status == "failed":
+			failed = append(failed, candidate.RankedResume)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"batch":     batch,
+		"shortlist": shortlist,
+		"failed":    failed,
+	})
+}
+
+// ExportResumeBatch downloads every resume of a batch in rank order, one
+// row each with the dimension scores, strengths, gaps, missing keywords and
+// explanation. CSV by default; the SQL export formats are also accepted.
+func (h *Handler) ExportResumeBatch(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	batchID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid batch ID", http.StatusBadRequest)
+		return
+	}
+
+	formatName := r.URL.Query().Get("format")
+	if formatName == "" {
+		formatName = "csv"
+	}
+	format, ok := services.LookupExportFormat(formatName)
+	if !ok {
+		http.Error(w, "Unsupported export format", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.resumeBatch(w, batchID, userID); !ok {
+		return
+	}
+
+	candidates, err := h.resumeService.BatchCandidates(batchID)
+	if err != nil {
+		http.Error(w, "Failed to fetch resumes", http.StatusInternalServerError)
+		return
+	}
+
+	writer, err := services.NewResultWriter(formatName, w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	columns := []services.ResultColumn{
+		{Name: "rank", Type: "INT4"},
+		{Name: "analysis_id", Type: "INT4"},
+		{Name: "filename", Type: "TEXT"},
+		{Name: "status", Type: "TEXT"},
+		{Name: "score", Type: "INT4"},
+	}
+	for _, dimension := range services.ResumeDimensions {
+		columns = append(columns, services.ResultColumn{Name: dimension, Type: "INT4"})
+	}
+	columns = append(columns,
+		services.ResultColumn{Name: "strengths", Type: "TEXT"},
+		services.ResultColumn{Name: "gaps", Type: "TEXT"},
+		services.ResultColumn{Name: "missing_keywords", Type: "TEXT"},
+		services.ResultColumn{Name: "explanation", Type: "TEXT"},
+		services.ResultColumn{Name: "error", Type: "TEXT"},
+	)
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="resume_batch_%d.%s"`, batchID, format.Extension))
+
+	if err := writeResumeBatch(writer, columns, candidates); err != nil {
+		log.Printf("Failed to export resume batch %d: %v", batchID, err)
+	}
+}
+
+func writeResumeBatch(writer services.ResultWriter, columns []services.ResultColumn, candidates []services.BatchCandidate) error {
+	if err := writer.WriteHeader(columns); err != nil {
+		return err
+	}
+
+	for _, candidate := range candidates {
+		row := []interface{}{nil, int64(candidate.AnalysisID), candidate.Filename, candidate.Status, nil}
+		if candidate.Rank > 0 {
+			row[0] = int64(candidate.Rank)
+		}
+		if candidate.Score != nil {
+			row[4] = int64(*candidate.Score)
+		}
+		for _, dimension := range services.ResumeDimensions {
+			if score, ok := candidate.DimensionScores[dimension]; ok {
+				row = append(row, int64(score))
+			} else {
+				row = append(row, nil)
+			}
+		}
+
+		var strengths, gaps, keywords string
+		if candidate.Rubric != nil {
+			strengths = strings.Join(candidate.Rubric.Strengths, "; ")
+			gaps = strings.Join(candidate.Rubric.Gaps, "; ")
+			keywords = strings.Join(candidate.Rubric.MissingKeywords, "; ")
+		}
+		row = append(row, strengths, gaps, keywords, strings.Join(candidate.Explanation, " | "), candidate.Error)
+
+		if err := writer.WriteRow(row); err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// resumeBatch loads a batch owned by userID, writing an error response and
+// returning false otherwise
+func (h *Handler) resumeBatch(w http.ResponseWriter, batchID, userID int) (*models.ResumeBatch, bool) {
+	var batch models.ResumeBatch
+	var weightsJSON []byte
+	if err := h.db.QueryRow(
+		`SELECT id, job_description, weights, status, total_resumes, processed_resumes, created_at, completed_at
+		 FROM resume_batches WHERE id = $1 AND user_id = $2`,
+		batchID, userID,
+	).Scan(&batch.ID, &batch.JobDescription, &weightsJSON, &batch.Status, &batch.TotalResumes,
+		&batch.ProcessedResumes, &batch.CreatedAt, &batch.CompletedAt); err != nil {
+		http.Error(w, "Batch not found", http.StatusNotFound)
+		return nil, false
+	}
+	batch.UserID = userID
+	if weightsJSON != nil {
+		if err := json.Unmarshal(weightsJSON, &batch.Weights); err != nil {
+			http.Error(w, "Failed to read batch", http.StatusInternalServerError)
+			return nil, false
+		}
+	}
+
+	return &batch, true
+}
+
+// GetResumeProfile returns the contact details, work history, education,
+// skills and certifications parsed from a resume
+func (h *Handler) GetResumeProfile(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	analysisID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid analysis ID", http.StatusBadRequest)
+		return
+	}
+
+	var status string
+	var profileJSON []byte
+	if err := h.db.QueryRow(
+		"SELECT status, profile FROM resume_analyses WHERE id = $1 AND user_id = $2",
+		analysisID, userID,
+	).Scan(&status, &profileJSON); err != nil {
+		http.Error(w, "Analysis not found", http.StatusNotFound)
+		return
+	}
+
+	// The profile is parsed at the start of analysis, so it is missing for
+	// good only once the analysis has finished
+	if profileJSON == nil {
+		if status == "pending" {
+			http.Error(w, "Profile is not ready yet", http.StatusConflict)
+		} else {
+			http.Error(w, "No profile could be read from this resume", http.StatusNotFound)
+		}
+		return
+	}
+
+	var profile models.ResumeProfile
+	if err := json.Unmarshal(profileJSON, &profile); err != nil {
+		http.Error(w, "Failed to read profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"analysis_id": analysisID,
+		"profile":     profile,
+	})
+}
+
+// createResumeVersion records an uploaded resume as the next version of
+// resumeID, or as version 1 of a new resume when resumeID is 0. It returns
+// sql.ErrNoRows when resumeID is not one of the user's resumes.
+func (h *Handler) createResumeVersion(userID, resumeID int, filePath, jobDescription, filename string) (int, int, int, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer tx.Rollback()
+
+	if resumeID == 0 {
+		if err := tx.QueryRow(
+			"INSERT INTO resumes (user_id, name) VALUES ($1, $2) RETURNING id",
+			userID, filename,
+		).Scan(&resumeID); err != nil {
+			return 0, 0, 0, err
+		}
+	} else {
+		// Locking the resume keeps concurrent uploads from taking the same
+		// version number
+		if err := tx.QueryRow(
+			"SELECT id FROM resumes WHERE id = $1 AND user_id = $2 FOR UPDATE",
+			resumeID, userID,
+		).Scan(&resumeID); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	var version int
+	if err := tx.QueryRow(
+		"SELECT COALESCE(MAX(version), 0) + 1 FROM resume_analyses WHERE resume_id = $1",
+		resumeID,
+	).Scan(&version); err != nil {
+		return 0, 0, 0, err
+	}
+
+	var analysisID int
+	if err := tx.QueryRow(
+		`INSERT INTO resume_analyses (user_id, resume_path, job_description, filename, resume_id, version)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		userID, filePath, jobDescription, filename, resumeID, version,
+	).Scan(&analysisID); err != nil {
+		return 0, 0, 0, err
+	}
+
+	if _, err := tx.Exec("UPDATE resumes SET updated_at = CURRENT_TIMESTAMP WHERE id = $1", resumeID); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return analysisID, resumeID, version, tx.Commit()
+}
+
+// GetResumeVersions lists the versions of a resume, oldest first. The id
+// is the resume's, as returned by an upload, not an analysis id.
+func (h *Handler) GetResumeVersions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	resumeID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid resume ID", http.StatusBadRequest)
+		return
+	}
+
+	resume, err := h.resumeService.Resume(resumeID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Resume not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch resume", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resume)
+}
+
+// CompareResumeVersions compares two versions of a resume: a unified diff
+// of their text, how the overall and per-dimension scores moved, and which
+// suggestions made on the from version the to version addressed. to
+// defaults to the latest version and from to the one before it.
+func (h *Handler) CompareResumeVersions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	resumeID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid resume ID", http.StatusBadRequest)
+		return
+	}
+
+	resume, err := h.resumeService.Resume(resumeID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Resume not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch resume", http.StatusInternalServerError)
+		return
+	}
+	if resume.LatestVersion < 2 {
+		http.Error(w, "Resume has only one version", http.StatusBadRequest)
+		return
+	}
+
+	toVersion, ok := intParam(w, r, "to", resume.LatestVersion, 1, resume.LatestVersion)
+	if !ok {
+		return
+	}
+	fromDefault := toVersion - 1
+	if fromDefault < 1 {
+		fromDefault = 1
+	}
+	fromVersion, ok := intParam(w, r, "from", fromDefault, 1, resume.LatestVersion)
+	if !ok {
+		return
+	}
+	if fromVersion == toVersion {
+		http.Error(w, "from and to must be different versions", http.StatusBadRequest)
+		return
+	}
+
+	from, ok := h.resumeVersion(w, resumeID, fromVersion)
+	if !ok {
+		return
+	}
+	to, ok := h.resumeVersion(w, resumeID, toVersion)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(services.CompareResumeVersions(resumeID, from, to))
+}
+
+// resumeVersion loads a version for comparison, writing an error response
+// and returning false when it is missing or its text has not been read yet
+func (h *Handler) resumeVersion(w http.ResponseWriter, resumeID, version int) (*services.ResumeVersionDetail, bool) {
+	detail, err := h.resumeService.ResumeVersion(resumeID, version)
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf("Version %d not found", version), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch resume version", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !detail.Extracted {
+		if detail.Status == "pending" || detail.Status == "processing" {
+			http.Error(w, fmt.Sprintf("Version %d is still being analyzed", version), http.StatusConflict)
+		} else {
+			http.Error(w, fmt.Sprintf("No text could be read from version %d", version), http.StatusUnprocessableEntity)
+		}
+		return nil, false
+	}
+	return detail, true
+}
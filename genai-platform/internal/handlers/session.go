@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+
+	"genai-platform/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token works once; presenting one again
+// revokes its session.
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.sessions.Refresh(req.RefreshToken, r.UserAgent(), clientIP(r))
+	switch err {
+	case nil:
+	case services.ErrInvalidRefreshToken:
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	case services.ErrRefreshTokenReused:
+		http.Error(w, "Refresh token was already used; the session has been revoked", http.StatusUnauthorized)
+		return
+	default:
+		http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Logout ends the session the request's access token belongs to, or every
+// session of the user with {"all": true}
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	sessionID, _ := r.Context().Value("session_id").(int)
+
+	var req struct {
+		All bool `json:"all"`
+	}
+	// The body is optional
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.All {
+		if _, err := h.sessions.RevokeAll(userID, 0, services.RevokedLogout); err != nil {
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
+	} else if sessionID != 0 {
+		if err := h.sessions.Revoke(userID, sessionID, services.RevokedLogout); err != nil && err != sql.ErrNoRows {
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListSessions lists the devices the user is signed in on, marking the
+// one making the request as current
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	sessionID, _ := r.Context().Value("session_id").(int)
+
+	sessions, err := h.sessions.Sessions(userID, sessionID)
+	if err != nil {
+		http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": sessions,
+	})
+}
+
+// RevokeSession signs one of the user's devices out
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	sessionID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.sessions.Revoke(userID, sessionID, services.RevokedByUser); err == sql.ErrNoRows {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOtherSessions signs the user out everywhere except the device
+// making the request
+func (h *Handler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	sessionID, _ := r.Context().Value("session_id").(int)
+
+	revoked, err := h.sessions.RevokeAll(userID, sessionID, services.RevokedOtherDevices)
+	if err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"revoked": revoked,
+	})
+}
+
+// clientIP is the address the request came from, without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
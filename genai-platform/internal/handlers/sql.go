@@ -0,0 +1,706 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"genai-platform/internal/models"
+	"genai-platform/internal/services"
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultResultPageSize = 100
+	maxResultPageSize     = 1000
+
+	defaultHistoryPageSize = 50
+	maxHistoryPageSize     = 200
+)
+
+const savedSQLQueryColumns = `s.id, s.user_id, COALESCE(s.workspace_id, 0), s.name, COALESCE(s.natural_query, ''), s.sql_text,
+	s.parameters, s.created_at, s.updated_at`
+
+// recordSQLQuery stores an executed query and its attempts on the
+// sql_queries table of the workspace and builds the response returned to
+// the client
+func (h *Handler) recordSQLQuery(userID, workspaceID int, naturalQuery string, result *services.SQLResult, attempts []models.SQLAttempt, execErr error, savedQueryID *int) (map[string]interface{}, error) {
+	generatedSQL := attempts[len(attempts)-1].SQL
+	status := "completed"
+	var resultData interface{} = result
+	if execErr != nil {
+		status = "failed"
+		resultData = map[string]interface{}{
+			"error": execErr.Error(),
+		}
+	}
+
+	// Explain successful results for business users. The explanation is a
+	// nice-to-have, so a model failure only leaves it empty. Re-runs of a
+	// saved query skip it, since they promise not to call the model.
+	var explanation services.SQLExplanation
+	var visualization map[string]interface{}
+	if execErr == nil && savedQueryID == nil {
+		if e, err := h.llmService.ForUser(userID).ForFeature(services.UsageFeatureSQL, workspaceID).ExplainSQLResult(naturalQuery, generatedSQL, result); err != nil {
+			log.Printf("Failed to explain SQL result: %v", err)
+		} else {
+			explanation = *e
+		}
+	}
+	if execErr == nil {
+		visualization = services.SuggestVisualization(result)
+	}
+
+	// Marshal resultData, attempts and visualization to JSON strings
+	resultDataJSON, err := json.Marshal(resultData)
+	if err != nil {
+		return nil, err
+	}
+	attemptsJSON, err := json.Marshal(attempts)
+	if err != nil {
+		return nil, err
+	}
+	visualizationJSON, err := json.Marshal(visualization)
+	if err != nil {
+		return nil, err
+	}
+
+	var queryID int
+	if err := h.db.QueryRow(
+		`INSERT INTO sql_queries (user_id, workspace_id, natural_query, generated_sql, result_data, attempts, status,
+		                          saved_query_id, explanation, result_summary, visualization) 
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		userID, workspaceID, naturalQuery, generatedSQL, resultDataJSON, attemptsJSON, status, savedQueryID,
+		explanation.Explanation, explanation.Summary, visualizationJSON,
+	).Scan(&queryID); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"query_id":       queryID,
+		"sql":            generatedSQL,
+		"status":         status,
+		"result_data":    resultData,
+		"attempts":       attempts,
+		"explanation":    explanation.Explanation,
+		"result_summary": explanation.Summary,
+		"visualization":  visualization,
+	}, nil
+}
+
+// ListSQLQueries returns the workspace's query history, newest first,
+// optionally filtered by a search term matched against the question and the
+// SQL
+func (h *Handler) ListSQLQueries(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	limit, offset, ok := pagination(w, r, defaultHistoryPageSize, maxHistoryPageSize)
+	if !ok {
+		return
+	}
+	search := strings.TrimSpace(r.URL.Query().Get("search"))
+
+	rows, err := h.db.Query(
+		`SELECT id, user_id, natural_query, COALESCE(generated_sql, ''), status, saved_query_id, created_at
+		 FROM sql_queries
+		 WHERE workspace_id = $1
+		   AND ($2 = '' OR natural_query ILIKE '%' || $2 || '%' OR generated_sql ILIKE '%' || $2 || '%')
+		 ORDER BY created_at DESC, id DESC
+		 LIMIT $3 OFFSET $4`,
+		workspaceID, search, limit, offset,
+	)
+	if err != nil {
+		http.Error(w, "Failed to list queries", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	queries := []models.SQLQuery{}
+	for rows.Next() {
+		var q models.SQLQuery
+		var savedQueryID sql.NullInt64
+		if err := rows.Scan(&q.ID, &q.UserID, &q.NaturalQuery, &q.GeneratedSQL, &q.Status, &savedQueryID, &q.CreatedAt); err != nil {
+			http.Error(w, "Failed to list queries", http.StatusInternalServerError)
+			return
+		}
+		q.WorkspaceID = workspaceID
+		if savedQueryID.Valid {
+			id := int(savedQueryID.Int64)
+			q.SavedQueryID = &id
+		}
+		queries = append(queries, q)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"queries": queries,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// GetSQLQuery returns one history entry with its preview and attempts
+func (h *Handler) GetSQLQuery(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	queryID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid query ID", http.StatusBadRequest)
+		return
+	}
+
+	var q models.SQLQuery
+	var resultData, attempts, visualization []byte
+	var savedQueryID sql.NullInt64
+	if err := h.db.QueryRow(
+		`SELECT id, user_id, natural_query, COALESCE(generated_sql, ''), COALESCE(result_data, '{}'), COALESCE(attempts, '[]'),
+		        status, saved_query_id, COALESCE(explanation, ''), COALESCE(result_summary, ''),
+		        COALESCE(visualization, 'null'), created_at
+		 FROM sql_queries WHERE id = $1 AND workspace_id = $2`,
+		queryID, workspaceID,
+	).Scan(&q.ID, &q.UserID, &q.NaturalQuery, &q.GeneratedSQL, &resultData, &attempts, &q.Status, &savedQueryID,
+		&q.Explanation, &q.ResultSummary, &visualization, &q.CreatedAt); err != nil {
+		http.Error(w, "Query not found", http.StatusNotFound)
+		return
+	}
+	q.WorkspaceID = workspaceID
+	if savedQueryID.Valid {
+		id := int(savedQueryID.Int64)
+		q.SavedQueryID = &id
+	}
+	if err := json.Unmarshal(resultData, &q.ResultData); err != nil {
+		http.Error(w, "Failed to decode result data", http.StatusInternalServerError)
+		return
+	}
+	if err := json.Unmarshal(attempts, &q.Attempts); err != nil {
+		http.Error(w, "Failed to decode attempts", http.StatusInternalServerError)
+		return
+	}
+	if err := json.Unmarshal(visualization, &q.Visualization); err != nil {
+		http.Error(w, "Failed to decode visualization", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(q)
+}
+
+// GetSQLQueryResults returns one page of a stored query's result. The
+// first page runs the query on a server-side cursor that is held open;
+// passing the returned cursor fetches the next page from the same
+// snapshot, so pages neither repeat nor skip rows.
+func (h *Handler) GetSQLQueryResults(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	queryID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid query ID", http.StatusBadRequest)
+		return
+	}
+
+	pageSize := defaultResultPageSize
+	if v := r.URL.Query().Get("page_size"); v != "" {
+		if pageSize, err = strconv.Atoi(v); err != nil || pageSize < 1 || pageSize > maxResultPageSize {
+			http.Error(w, fmt.Sprintf("page_size must be between 1 and %d", maxResultPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	generatedSQL, ok := h.completedSQLQuery(w, queryID, workspaceID)
+	if !ok {
+		return
+	}
+
+	page, err := h.sqlService.PageResults(userID, queryID, generatedSQL, r.URL.Query().Get("cursor"), pageSize)
+	switch {
+	case err == services.ErrResultCursorExpired:
+		http.Error(w, "Result cursor has expired, start again from the first page", http.StatusGone)
+		return
+	case err == services.ErrTooManyResultCursors:
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "Too many results are being paged through, try again shortly", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to fetch results: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query_id":    queryID,
+		"page":        page.Page,
+		"page_size":   pageSize,
+		"columns":     page.Columns,
+		"rows":        page.Rows,
+		"row_count":   page.RowCount,
+		"has_more":    page.HasMore,
+		"next_cursor": page.Cursor,
+	})
+}
+
+// ExportSQLQuery streams the full result of a stored query as CSV, NDJSON,
+// XLSX or Parquet
+func (h *Handler) ExportSQLQuery(w http.ResponseWriter, r *http.Request) {
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	queryID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid query ID", http.StatusBadRequest)
+		return
+	}
+
+	formatName := r.URL.Query().Get("format")
+	if formatName == "" {
+		formatName = "csv"
+	}
+	format, ok := services.LookupExportFormat(formatName)
+	if !ok {
+		http.Error(w, "Unsupported export format", http.StatusBadRequest)
+		return
+	}
+
+	generatedSQL, ok := h.completedSQLQuery(w, queryID, workspaceID)
+	if !ok {
+		return
+	}
+
+	writer, err := services.NewResultWriter(formatName, w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="query_%d.%s"`, queryID, format.Extension))
+
+	// Headers are already sent once streaming starts, so a failure part way
+	// through can only be logged and the response cut short
+	if err := h.sqlService.Export(generatedSQL, writer); err != nil {
+		log.Printf("Failed to export SQL query %d: %v", queryID, err)
+	}
+}
+
+// sqlEnabled writes 503 and returns false when no query database is
+// configured for text-to-SQL
+func (h *Handler) sqlEnabled(w http.ResponseWriter) bool {
+	if !h.sqlService.Enabled() {
+		http.Error(w, "Text-to-SQL is not configured", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// completedSQLQuery loads the SQL of a successfully executed query of the
+// workspace, writing an error response and returning false otherwise
+func (h *Handler) completedSQLQuery(w http.ResponseWriter, queryID, workspaceID int) (string, bool) {
+	if !h.sqlEnabled(w) {
+		return "", false
+	}
+	var generatedSQL, status string
+	if err := h.db.QueryRow(
+		"SELECT generated_sql, status FROM sql_queries WHERE id = $1 AND workspace_id = $2",
+		queryID, workspaceID,
+	).Scan(&generatedSQL, &status); err != nil {
+		http.Error(w, "Query not found", http.StatusNotFound)
+		return "", false
+	}
+
+	if status != "completed" {
+		http.Error(w, "Query did not complete successfully", http.StatusConflict)
+		return "", false
+	}
+
+	return generatedSQL, true
+}
+
+// SaveSQLQuery stores SQL under a name so it can be re-run without the
+// model. The SQL comes either from a history entry or the request body,
+// must be a single query that reads and may contain :name placeholders
+// that are bound when the query is run.
+func (h *Handler) SaveSQLQuery(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	var req struct {
+		Name         string `json:"name"`
+		QueryID      *int   `json:"query_id,omitempty"`
+		SQL          string `json:"sql"`
+		NaturalQuery string `json:"natural_query"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.QueryID != nil {
+		var naturalQuery, generatedSQL string
+		if err := h.db.QueryRow(
+			"SELECT natural_query, COALESCE(generated_sql, '') FROM sql_queries WHERE id = $1 AND workspace_id = $2",
+			*req.QueryID, workspaceID,
+		).Scan(&naturalQuery, &generatedSQL); err != nil {
+			http.Error(w, "Query not found", http.StatusNotFound)
+			return
+		}
+		// An explicit SQL body wins so the caller can parameterize the
+		// generated query while saving it
+		if req.SQL == "" {
+			req.SQL = generatedSQL
+		}
+		if req.NaturalQuery == "" {
+			req.NaturalQuery = naturalQuery
+		}
+	}
+
+	if strings.TrimSpace(req.SQL) == "" {
+		http.Error(w, "Either query_id or sql is required", http.StatusBadRequest)
+		return
+	}
+	// Saved SQL is run without the model, so it has to pass the same
+	// check as generated SQL before it is stored
+	if err := services.ValidateSQL(req.SQL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	saved := models.SavedSQLQuery{
+		UserID:       userID,
+		WorkspaceID:  workspaceID,
+		Name:         req.Name,
+		NaturalQuery: req.NaturalQuery,
+		SQL:          req.SQL,
+		Parameters:   services.ParseSQLParameters(req.SQL),
+	}
+	if err := h.db.QueryRow(
+		`INSERT INTO saved_sql_queries (user_id, workspace_id, name, natural_query, sql_text, parameters)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at`,
+		userID, workspaceID, saved.Name, saved.NaturalQuery, saved.SQL, pq.Array(saved.Parameters),
+	).Scan(&saved.ID, &saved.CreatedAt, &saved.UpdatedAt); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			http.Error(w, "A saved query with this name already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to save query", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(saved)
+}
+
+// ListSavedSQLQueries returns the workspace's saved queries and the ones
+// teammates have shared with the user
+func (h *Handler) ListSavedSQLQueries(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	rows, err := h.db.Query(
+		`SELECT `+savedSQLQueryColumns+`
+		 FROM saved_sql_queries s
+		 WHERE s.workspace_id = $2
+		    OR EXISTS (SELECT 1 FROM saved_sql_query_recipients sr JOIN users u ON lower(u.email) = sr.email
+		                   WHERE sr.saved_query_id = s.id AND u.id = $1 AND u.email_verified_at IS NOT NULL)
+		 ORDER BY s.name`,
+		userID, workspaceID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to list saved queries", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	saved := []models.SavedSQLQuery{}
+	for rows.Next() {
+		var q models.SavedSQLQuery
+		if err := rows.Scan(&q.ID, &q.UserID, &q.WorkspaceID, &q.Name, &q.NaturalQuery, &q.SQL, pq.Array(&q.Parameters),
+			&q.CreatedAt, &q.UpdatedAt); err != nil {
+			http.Error(w, "Failed to list saved queries", http.StatusInternalServerError)
+			return
+		}
+		q.Shared = q.UserID != userID
+		saved = append(saved, q)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+// GetSavedSQLQuery returns a saved query; owners also see who it is shared with
+func (h *Handler) GetSavedSQLQuery(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	saved, ok := h.savedSQLQuery(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if !saved.Shared {
+		sharedWith, err := h.savedSQLQueryShares(saved.ID)
+		if err != nil {
+			http.Error(w, "Failed to load shares", http.StatusInternalServerError)
+			return
+		}
+		saved.SharedWith = sharedWith
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+func (h *Handler) DeleteSavedSQLQuery(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	savedID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid saved query ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.db.Exec("DELETE FROM saved_sql_queries WHERE id = $1 AND user_id = $2", savedID, userID)
+	if err != nil {
+		http.Error(w, "Failed to delete saved query", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Saved query not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunSavedSQLQuery binds new parameter values into a saved query and runs
+// it directly, skipping the model. The run is recorded in the history like
+// any other query so its results can be paged and exported.
+func (h *Handler) RunSavedSQLQuery(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	if !h.sqlEnabled(w) {
+		return
+	}
+
+	saved, ok := h.savedSQLQuery(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Parameters map[string]interface{} `json:"parameters"`
+	}
+	if r.ContentLength != 0 {
+		// Numbers are kept as written so large ids bind exactly
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	query, err := services.BindSQLParameters(saved.SQL, req.Parameters)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, attempt, execErr := h.sqlService.Run(query)
+
+	naturalQuery := saved.NaturalQuery
+	if naturalQuery == "" {
+		naturalQuery = saved.Name
+	}
+	response, err := h.recordSQLQuery(userID, r.Context().Value("workspace_id").(int), naturalQuery, result, []models.SQLAttempt{attempt}, execErr, &saved.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save query: %v", err), http.StatusInternalServerError)
+		return
+	}
+	response["saved_query_id"] = saved.ID
+	response["parameters"] = req.Parameters
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ShareSavedSQLQuery lets the owner of a saved query share it with a
+// teammate by email, who need not be in the workspace or even have an
+// account yet. Whoever verifies that address can view and run it but not
+// change it. The response is the same whether or not anyone has an account
+// with the address, so sharing cannot be used to find out who does.
+func (h *Handler) ShareSavedSQLQuery(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	saved, ok := h.savedSQLQuery(w, r, userID)
+	if !ok {
+		return
+	}
+	if saved.Shared {
+		http.Error(w, "Only the owner can share a saved query", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Email = services.NormalizeEmail(req.Email)
+	if err := services.ValidateEmail(req.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ownEmail string
+	if err := h.db.QueryRow("SELECT email FROM users WHERE id = $1", userID).Scan(&ownEmail); err != nil {
+		http.Error(w, "Failed to share saved query", http.StatusInternalServerError)
+		return
+	}
+	if services.NormalizeEmail(ownEmail) == req.Email {
+		http.Error(w, "Cannot share a query with yourself", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.db.Exec(
+		`INSERT INTO saved_sql_query_recipients (saved_query_id, email) VALUES ($1, $2)
+		 ON CONFLICT DO NOTHING`,
+		saved.ID, req.Email,
+	); err != nil {
+		http.Error(w, "Failed to share saved query", http.StatusInternalServerError)
+		return
+	}
+
+	sharedWith, err := h.savedSQLQueryShares(saved.ID)
+	if err != nil {
+		http.Error(w, "Failed to load shares", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"saved_query_id": saved.ID,
+		"shared_with":    sharedWith,
+	})
+}
+
+func (h *Handler) UnshareSavedSQLQuery(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	saved, ok := h.savedSQLQuery(w, r, userID)
+	if !ok {
+		return
+	}
+	if saved.Shared {
+		http.Error(w, "Only the owner can change sharing", http.StatusForbidden)
+		return
+	}
+
+	email, err := url.PathUnescape(chi.URLParam(r, "email"))
+	if err != nil {
+		http.Error(w, "Invalid email", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.db.Exec(
+		"DELETE FROM saved_sql_query_recipients WHERE saved_query_id = $1 AND email = $2",
+		saved.ID, services.NormalizeEmail(email),
+	); err != nil {
+		http.Error(w, "Failed to unshare saved query", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// savedSQLQuery loads the saved query named by the {id} URL parameter if it
+// belongs to the workspace of the request or has been shared with userID,
+// writing an error response and returning false otherwise. Only its owner
+// sees it as not Shared.
+func (h *Handler) savedSQLQuery(w http.ResponseWriter, r *http.Request, userID int) (*models.SavedSQLQuery, bool) {
+	workspaceID := r.Context().Value("workspace_id").(int)
+
+	savedID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid saved query ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var q models.SavedSQLQuery
+	if err := h.db.QueryRow(
+		`SELECT `+savedSQLQueryColumns+`
+		 FROM saved_sql_queries s
+		 WHERE s.id = $1
+		   AND (s.workspace_id = $3
+		        OR EXISTS (SELECT 1 FROM saved_sql_query_recipients sr JOIN users u ON lower(u.email) = sr.email
+		                   WHERE sr.saved_query_id = s.id AND u.id = $2 AND u.email_verified_at IS NOT NULL))`,
+		savedID, userID, workspaceID,
+	).Scan(&q.ID, &q.UserID, &q.WorkspaceID, &q.Name, &q.NaturalQuery, &q.SQL, pq.Array(&q.Parameters),
+		&q.CreatedAt, &q.UpdatedAt); err != nil {
+		http.Error(w, "Saved query not found", http.StatusNotFound)
+		return nil, false
+	}
+	q.Shared = q.UserID != userID
+
+	return &q, true
+}
+
+// savedSQLQueryShares lists the addresses a saved query is shared with,
+// whether or not they belong to an account
+func (h *Handler) savedSQLQueryShares(savedID int) ([]string, error) {
+	rows, err := h.db.Query(
+		`SELECT email FROM saved_sql_query_recipients
+		 WHERE saved_query_id = $1
+		 ORDER BY email`,
+		savedID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []string{}
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		shares = append(shares, email)
+	}
+	return shares, rows.Err()
+}
+
+// pagination reads limit and offset query parameters, writing an error
+// response and returning false when they are invalid
+func pagination(w http.ResponseWriter, r *http.Request, defaultLimit, maxLimit int) (int, int, bool) {
+	limit, offset := defaultLimit, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxLimit), http.StatusBadRequest)
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
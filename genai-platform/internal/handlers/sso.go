@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"genai-platform/internal/auth"
+	"genai-platform/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// AuthMethods tells the web app which ways of signing in are available
+func (h *Handler) AuthMethods(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"password": h.passwordLogin,
+		"oidc":     h.sso.Enabled(),
+	})
+}
+
+// requirePasswordLogin refuses password sign-in, registration and resets
+// when everyone signs in through the identity provider
+func (h *Handler) requirePasswordLogin(w http.ResponseWriter) bool {
+	if !h.passwordLogin {
+		http.Error(w, "Password sign-in is disabled, sign in with single sign-on", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// StartOIDCLogin begins single sign-on. The web app sends the user to the
+// returned authorization_url and keeps state to compare with the one the
+// provider sends back.
+func (h *Handler) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := h.sso.Begin()
+	if err == services.ErrSSODisabled {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Failed to start single sign-on: %v", err)
+		http.Error(w, "Failed to start single sign-on", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"authorization_url": authURL,
+		"state":             state,
+	})
+}
+
+// CompleteOIDCLogin finishes single sign-on with the code and state the
+// provider sent the user back with, and starts a session or, for users
+// with two-factor authentication, a challenge
+func (h *Handler) CompleteOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Code == "" || req.State == "" {
+		http.Error(w, "code and state are required", http.StatusBadRequest)
+		return
+	}
+
+	login, err := h.sso.Complete(req.Code, req.State)
+	switch {
+	case err == nil:
+	case err == services.ErrSSODisabled:
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	case err == services.ErrSSOState, errors.Is(err, auth.ErrOIDCLogin):
+		log.Printf("Single sign-on refused: %v", err)
+		http.Error(w, "Single sign-on failed, please try again", http.StatusUnauthorized)
+		return
+	case err == services.ErrSSONoEmail, err == services.ErrSSOEmailUnverified, err == services.ErrSSOSignupDisabled:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	default:
+		log.Printf("Failed to complete single sign-on: %v", err)
+		http.Error(w, "Failed to complete single sign-on", http.StatusInternalServerError)
+		return
+	}
+
+	if login.Disabled {
+		http.Error(w, "Account is disabled", http.StatusForbidden)
+		return
+	}
+	h.roles.PromoteConfiguredAdmin(login.UserID, login.Email)
+	if login.Created {
+		if _, err := h.workspaces.EnsurePersonal(login.UserID); err != nil {
+			http.Error(w, "Failed to create workspace", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if h.startSession(w, r, login.UserID, login.Email) {
+		h.loginSucceeded(r, login.UserID, services.NormalizeEmail(login.Email), "oidc")
+	}
+}
+
+// AdminListSSODomains lists the email domains mapped to workspaces
+func (h *Handler) AdminListSSODomains(w http.ResponseWriter, r *http.Request) {
+	mappings, err := h.sso.DomainMappings()
+	if err != nil {
+		http.Error(w, "Failed to list domain mappings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"domains": mappings,
+	})
+}
+
+// ssoDomainMapping reads the {domain} and {workspaceID} of an
+// /admin/sso/domains route
+func ssoDomainMapping(w http.ResponseWriter, r *http.Request) (string, int, bool) {
+	workspaceID, err := strconv.Atoi(chi.URLParam(r, "workspaceID"))
+	if err != nil {
+		http.Error(w, "Invalid workspace ID", http.StatusBadRequest)
+		return "", 0, false
+	}
+	return strings.ToLower(chi.URLParam(r, "domain")), workspaceID, true
+}
+
+// AdminSetSSODomain adds people signing in with an email of the domain to
+// the workspace, with the given workspace role
+func (h *Handler) AdminSetSSODomain(w http.ResponseWriter, r *http.Request) {
+	domain, workspaceID, ok := ssoDomainMapping(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = services.WorkspaceRoleMember
+	}
+	if err := services.ValidateEmailDomain(domain); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mapping, err := h.sso.SetDomainMapping(domain, workspaceID, req.Role)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	case services.ErrUnknownWorkspaceRole, services.ErrPersonalWorkspace:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, "Failed to save domain mapping", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mapping)
+}
+
+// AdminDeleteSSODomain stops adding people of a domain to a workspace.
+// Members already added stay.
+func (h *Handler) AdminDeleteSSODomain(w http.ResponseWriter, r *http.Request) {
+	domain, workspaceID, ok := ssoDomainMapping(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.sso.DeleteDomainMapping(domain, workspaceID); err == sql.ErrNoRows {
+		http.Error(w, "Domain mapping not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to delete domain mapping", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminListSSOGroups lists the identity provider groups mapped to roles
+func (h *Handler) AdminListSSOGroups(w http.ResponseWriter, r *http.Request) {
+	mappings, err := h.sso.GroupMappings()
+	if err != nil {
+		http.Error(w, "Failed to list group mappings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"groups": mappings,
+	})
+}
+
+// ssoGroup reads the {group} of an /admin/sso/groups route. Group names
+// such as "/engineering" are sent with their slashes escaped.
+func ssoGroup(w http.ResponseWriter, r *http.Request) (string, bool) {
+	group, err := url.PathUnescape(chi.URLParam(r, "group"))
+	if err != nil || group == "" {
+		http.Error(w, "Invalid group", http.StatusBadRequest)
+		return "", false
+	}
+	return group, true
+}
+
+// AdminSetSSOGroup gives people in an identity provider group a role when
+// they sign in
+func (h *Handler) AdminSetSSOGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := ssoGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role     string `json:"role"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		http.Error(w, "role is required", http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidateSSOGroup(group); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mapping, err := h.sso.SetGroupMapping(group, req.Role, req.Priority)
+	if err == services.ErrUnknownRole {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to save group mapping", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mapping)
+}
+
+// AdminDeleteSSOGroup stops mapping a group to a role. Users keep the role
+// they have.
+func (h *Handler) AdminDeleteSSOGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := ssoGroup(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.sso.DeleteGroupMapping(group); err == sql.ErrNoRows {
+		http.Error(w, "Group mapping not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to delete group mapping", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"genai-platform/internal/services"
+)
+
+// maxUsageDays caps the period of a usage report
+const maxUsageDays = 366
+
+// RequireQuota is middleware refusing requests that call the model once
+// the user has used up a monthly quota. When the quota cannot be checked
+// the request is let through.
+func (h *Handler) RequireQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("user_id").(int)
+		if err := h.usage.CheckQuota(userID); err != nil {
+			if quotaExceeded(w, err) {
+				return
+			}
+			log.Printf("Failed to check usage quota of user %d, allowing request: %v", userID, err)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// quotaExceeded writes 429 with Retry-After, the time until the quota
+// resets, when err is a *services.QuotaExceededError
+func quotaExceeded(w http.ResponseWriter, err error) bool {
+	var quotaErr *services.QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+	retryAfter := int(math.Ceil(time.Until(quotaErr.ResetsAt).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	used := fmt.Sprintf("%.0f of %.0f tokens", quotaErr.Used, quotaErr.Limit)
+	if quotaErr.Quota == services.QuotaCost {
+		used = fmt.Sprintf("$%.2f of $%.2f", quotaErr.Used, quotaErr.Limit)
+	}
+	http.Error(w, fmt.Sprintf("Monthly %s quota exceeded: %s used this month, resets on %s",
+		quotaErr.Quota, used, quotaErr.ResetsAt.Format("2006-01-02")), http.StatusTooManyRequests)
+	return true
+}
+
+// usagePeriod reads the ?from= and ?to= dates (YYYY-MM-DD, UTC) of a usage
+// report, both included. The period defaults to this month until today.
+func usagePeriod(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	query := r.URL.Query()
+	from := services.MonthStart()
+	if v := query.Get("from"); v != "" {
+		date, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "from must be a date such as 2006-01-02", http.StatusBadRequest)
+			return time.Time{}, time.Time{}, false
+		}
+		from = date
+	}
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if v := query.Get("to"); v != "" {
+		date, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "to must be a date such as 2006-01-02", http.StatusBadRequest)
+			return time.Time{}, time.Time{}, false
+		}
+		to = date
+	}
+
+	to = to.AddDate(0, 0, 1)
+	if !from.Before(to) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+	if to.Sub(from) > maxUsageDays*24*time.Hour {
+		http.Error(w, "Usage can be reported for at most "+strconv.Itoa(maxUsageDays)+" days at a time", http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// queryID reads an optional positive ID query parameter, 0 when absent
+func queryID(w http.ResponseWriter, r *http.Request, name, invalid string) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, true
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil || id < 1 {
+		http.Error(w, invalid, http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// GetUsage reports the caller's model usage by day and feature, from
+// ?from= to ?to= (this month by default), optionally only that of the
+// workspace ?workspace_id=, along with their monthly quota
+func (h *Handler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	from, to, ok := usagePeriod(w, r)
+	if !ok {
+		return
+	}
+	workspaceID, ok := queryID(w, r, "workspace_id", "Invalid workspace ID")
+	if !ok {
+		return
+	}
+
+	report, err := h.usage.Report(services.UsageFilter{UserID: userID, WorkspaceID: workspaceID, From: from, To: to})
+	if err != nil {
+		log.Printf("Failed to report usage of user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch usage", http.StatusInternalServerError)
+		return
+	}
+	quota, err := h.usage.QuotaStatus(userID)
+	if err != nil {
+		log.Printf("Failed to fetch usage quota of user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"usage": report,
+		"quota": quota,
+	})
+}
+
+// AdminGetUsage reports everyone's model usage by day, feature and user,
+// filtered by ?user_id= and ?workspace_id= and from ?from= to ?to=
+func (h *Handler) AdminGetUsage(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := usagePeriod(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := queryID(w, r, "user_id", "Invalid user ID")
+	if !ok {
+		return
+	}
+	workspaceID, ok := queryID(w, r, "workspace_id", "Invalid workspace ID")
+	if !ok {
+		return
+	}
+
+	report, err := h.usage.Report(services.UsageFilter{UserID: userID, WorkspaceID: workspaceID, From: from, To: to})
+	if err != nil {
+		log.Printf("Failed to report usage: %v", err)
+		http.Error(w, "Failed to fetch usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// AdminSetUsageQuota gives a user a monthly quota of their own in place
+// of the default; zero leaves a measure unlimited
+func (h *Handler) AdminSetUsageQuota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req services.UsageQuota
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.MonthlyTokens < 0 || req.MonthlyCost < 0 {
+		http.Error(w, "monthly_tokens and monthly_cost must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	h.setUsageQuota(w, userID, &req)
+}
+
+// AdminResetUsageQuota gives a user the default monthly quota again
+func (h *Handler) AdminResetUsageQuota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := adminUserID(w, r)
+	if !ok {
+		return
+	}
+
+	h.setUsageQuota(w, userID, nil)
+}
+
+// setUsageQuota changes a user's quota and returns what they used of the
+// new one this month
+func (h *Handler) setUsageQuota(w http.ResponseWriter, userID int, quota *services.UsageQuota) {
+	err := h.usage.SetQuota(userID, quota)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Failed to set usage quota of user %d: %v", userID, err)
+		http.Error(w, "Failed to update usage quota", http.StatusInternalServerError)
+		return
+	}
+
+	status, err := h.usage.QuotaStatus(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch usage quota", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"genai-platform/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// workspaceMember reads the {id} of a /workspaces route and the caller's
+// role in that workspace, writing an error response and returning false
+// when they are not a member
+func (h *Handler) workspaceMember(w http.ResponseWriter, r *http.Request) (int, string, bool) {
+	userID := r.Context().Value("user_id").(int)
+
+	workspaceID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid workspace ID", http.StatusBadRequest)
+		return 0, "", false
+	}
+
+	membership, err := h.workspaces.Membership(userID, workspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch workspace", http.StatusInternalServerError)
+		return 0, "", false
+	}
+	if membership == nil {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return 0, "", false
+	}
+	return workspaceID, membership.Role, true
+}
+
+// workspaceManager is workspaceMember for routes only owners and admins of
+// the workspace may use
+func (h *Handler) workspaceManager(w http.ResponseWriter, r *http.Request) (int, string, bool) {
+	workspaceID, role, ok := h.workspaceMember(w, r)
+	if !ok {
+		return 0, "", false
+	}
+	if !services.CanManageWorkspace(role) {
+		http.Error(w, "Only workspace owners and admins can do this", http.StatusForbidden)
+		return 0, "", false
+	}
+	return workspaceID, role, true
+}
+
+// workspaceError writes the response for an error of a workspace change
+func workspaceError(w http.ResponseWriter, err error, notFound, failed string) {
+	switch err {
+	case sql.ErrNoRows:
+		http.Error(w, notFound, http.StatusNotFound)
+	case services.ErrUnknownWorkspaceRole, services.ErrAlreadyMember:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case services.ErrOwnerOnly:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case services.ErrPersonalWorkspace, services.ErrLastOwner:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, failed, http.StatusInternalServerError)
+	}
+}
+
+// ListWorkspaces lists the workspaces the user is a member of
+func (h *Handler) ListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	workspaces, err := h.workspaces.List(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch workspaces", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"workspaces": workspaces,
+	})
+}
+
+// CreateWorkspace creates a shared workspace owned by the user
+func (h *Handler) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if err := services.ValidateWorkspaceName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	workspace, err := h.workspaces.Create(userID, req.Name)
+	if err != nil {
+		http.Error(w, "Failed to create workspace", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(workspace)
+}
+
+// GetWorkspace returns a workspace with the user's role in it
+func (h *Handler) GetWorkspace(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	workspaceID, _, ok := h.workspaceMember(w, r)
+	if !ok {
+		return
+	}
+
+	workspace, err := h.workspaces.Get(workspaceID, userID)
+	if err != nil {
+		workspaceError(w, err, "Workspace not found", "Failed to fetch workspace")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspace)
+}
+
+// RenameWorkspace changes the name of a workspace
+func (h *Handler) RenameWorkspace(w http.ResponseWriter, r *http.Request) {
+	workspaceID, _, ok := h.workspaceManager(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if err := services.ValidateWorkspaceName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.workspaces.Rename(workspaceID, req.Name); err != nil {
+		workspaceError(w, err, "Workspace not found", "Failed to rename workspace")
+		return
+	}
+
+	h.GetWorkspace(w, r)
+}
+
+// DeleteWorkspace deletes a shared workspace and everything in it. Only
+// its owners may.
+func (h *Handler) DeleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	workspaceID, role, ok := h.workspaceMember(w, r)
+	if !ok {
+		return
+	}
+	if role != services.WorkspaceRoleOwner {
+		http.Error(w, "Only workspace owners can delete a workspace", http.StatusForbidden)
+		return
+	}
+
+	if err := h.workspaces.Delete(workspaceID); err != nil {
+		workspaceError(w, err, "Workspace not found", "Failed to delete workspace")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWorkspaceMembers lists the members of a workspace
+func (h *Handler) ListWorkspaceMembers(w http.ResponseWriter, r *http.Request) {
+	workspaceID, _, ok := h.workspaceMember(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := h.workspaces.Members(workspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch members", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"workspace_id": workspaceID,
+		"members":      members,
+	})
+}
+
+// SetWorkspaceMemberRole changes the role of a member
+func (h *Handler) SetWorkspaceMemberRole(w http.ResponseWriter, r *http.Request) {
+	workspaceID, role, ok := h.workspaceManager(w, r)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.workspaces.SetMemberRole(workspaceID, memberID, req.Role, role); err != nil {
+		workspaceError(w, err, "Member not found", "Failed to change role")
+		return
+	}
+
+	h.ListWorkspaceMembers(w, r)
+}
+
+// RemoveWorkspaceMember takes a user out of a workspace. Owners and admins
+// may remove others; every member may leave.
+func (h *Handler) RemoveWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	workspaceID, role, ok := h.workspaceMember(w, r)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if memberID != userID && !services.CanManageWorkspace(role) {
+		http.Error(w, "Only workspace owners and admins can do this", http.StatusForbidden)
+		return
+	}
+
+	if err := h.workspaces.RemoveMember(workspaceID, memberID, role); err != nil {
+		workspaceError(w, err, "Member not found", "Failed to remove member")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InviteToWorkspace invites an email address into a workspace. The token
+// in the response accepts the invitation and is only shown once.
+func (h *Handler) InviteToWorkspace(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+	workspaceID, role, ok := h.workspaceManager(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if !strings.Contains(req.Email, "@") {
+		http.Error(w, "A valid email is required", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = services.WorkspaceRoleMember
+	}
+
+	invitation, token, err := h.workspaces.Invite(workspaceID, userID, req.Email, req.Role, role)
+	if err != nil {
+		workspaceError(w, err, "Workspace not found", "Failed to create invitation")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"invitation": invitation,
+		"token":      token,
+	})
+}
+
+// ListWorkspaceInvitations lists the pending invitations of a workspace
+func (h *Handler) ListWorkspaceInvitations(w http.ResponseWriter, r *http.Request) {
+	workspaceID, _, ok := h.workspaceManager(w, r)
+	if !ok {
+		return
+	}
+
+	invitations, err := h.workspaces.Invitations(workspaceID)
+	if err != nil {
+		http.Error(w, "Failed to fetch invitations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"workspace_id": workspaceID,
+		"invitations":  invitations,
+	})
+}
+
+// RevokeWorkspaceInvitation cancels a pending invitation
+func (h *Handler) RevokeWorkspaceInvitation(w http.ResponseWriter, r *http.Request) {
+	workspaceID, _, ok := h.workspaceManager(w, r)
+	if !ok {
+		return
+	}
+	invitationID, err := strconv.Atoi(chi.URLParam(r, "invitationID"))
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.workspaces.RevokeInvitation(workspaceID, invitationID); err != nil {
+		workspaceError(w, err, "Invitation not found", "Failed to revoke invitation")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListMyInvitations lists the pending invitations sent to the user's email
+func (h *Handler) ListMyInvitations(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	invitations, err := h.workspaces.PendingInvitations(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch invitations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"invitations": invitations,
+	})
+}
+
+// AcceptInvitation makes the user a member of the workspace an invitation
+// token was sent for
+func (h *Handler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(int)
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	workspace, err := h.workspaces.AcceptInvitation(userID, req.Token)
+	switch err {
+	case nil:
+	case services.ErrInvalidInvitation:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case services.ErrInvitationEmail:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	default:
+		http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspace)
+}
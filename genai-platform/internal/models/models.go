@@ -12,20 +12,184 @@ type User struct {
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
 
-type Document struct {
+// AuthSession is one signed-in device. Its refresh tokens form a family:
+// each refresh replaces the token used, and reusing a replaced token
+// revokes the whole session.
+type AuthSession struct {
+	ID         int       `json:"id" db:"id"`
+	UserID     int       `json:"user_id" db:"user_id"`
+	UserAgent  string    `json:"user_agent" db:"user_agent"`
+	IPAddress  string    `json:"ip_address" db:"ip_address"`
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// AuthEvent is an audit record of a sign-in attempt or lockout. UserID is
+// nil for attempts on emails without an account.
+type AuthEvent struct {
+	ID        int       `json:"id" db:"id"`
+	UserID    *int      `json:"user_id" db:"user_id"`
+	Email     string    `json:"email" db:"email"`
+	Event     string    `json:"event" db:"event"`
+	Detail    string    `json:"detail,omitempty" db:"detail"`
+	IPAddress string    `json:"ip_address" db:"ip_address"`
+	UserAgent string    `json:"user_agent" db:"user_agent"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// AuthTokens is what signing in or refreshing returns. Token repeats
+// AccessToken for clients written before refresh tokens.
+type AuthTokens struct {
+	Token        string `json:"token"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	SessionID    int    `json:"session_id"`
+	UserID       int    `json:"user_id"`
+	Email        string `json:"email"`
+}
+
+// APIKey is a personal key for scripts and CI. Only Prefix, the start of
+// the key, is kept in the clear so the user can tell keys apart.
+type APIKey struct {
+	ID         int        `json:"id" db:"id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip,omitempty" db:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Role names a set of permissions. Built-in roles can not be changed or
+// deleted.
+type Role struct {
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	Permissions []string  `json:"permissions" db:"permissions"`
+	Builtin     bool      `json:"builtin" db:"builtin"`
+	Users       int       `json:"users"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// AdminUser is a user as administrators see it
+type AdminUser struct {
+	ID             int        `json:"id" db:"id"`
+	Email          string     `json:"email" db:"email"`
+	Role           string     `json:"role" db:"role"`
+	EmailVerified  bool       `json:"email_verified"`
+	MFAEnabled     bool       `json:"mfa_enabled"`
+	Disabled       bool       `json:"disabled"`
+	DisabledAt     *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
+	DisabledReason string     `json:"disabled_reason,omitempty" db:"disabled_reason"`
+	ActiveSessions int        `json:"active_sessions"`
+	LastActiveAt   *time.Time `json:"last_active_at" db:"last_active_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+// UserJob is one piece of background or recorded work of a user: a
+// research task, resume analysis or batch, graph ingestion or SQL query
+type UserJob struct {
+	Type        string     `json:"type"`
+	ID          int        `json:"id"`
+	Status      string     `json:"status"`
+	Summary     string     `json:"summary"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// Workspace is a group of users sharing documents, chats, research, SQL
+// queries and a knowledge graph. Every user has a personal workspace that
+// can not be left or deleted.
+type Workspace struct {
 	ID        int       `json:"id" db:"id"`
-	UserID    int       `json:"user_id" db:"user_id"`
-	Filename  string    `json:"filename" db:"filename"`
-	FilePath  string    `json:"file_path" db:"file_path"`
-	FileType  string    `json:"file_type" db:"file_type"`
-	FileSize  int       `json:"file_size" db:"file_size"`
-	Status    string    `json:"status" db:"status"`
+	Name      string    `json:"name" db:"name"`
+	Personal  bool      `json:"personal" db:"personal"`
+	CreatedBy int       `json:"created_by" db:"created_by"`
+	Role      string    `json:"role"`
+	Members   int       `json:"members"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+
+	// RequireMFA keeps members without two-factor authentication out
+	RequireMFA bool `json:"require_mfa" db:"require_mfa"`
+}
+
+// WorkspaceMember is a user's membership of a workspace
+type WorkspaceMember struct {
+	UserID     int       `json:"user_id" db:"user_id"`
+	Email      string    `json:"email" db:"email"`
+	Role       string    `json:"role" db:"role"`
+	MFAEnabled bool      `json:"mfa_enabled"`
+	JoinedAt   time.Time `json:"joined_at" db:"created_at"`
+}
+
+// WorkspaceInvitation invites an email address into a workspace with a
+// role. The token that accepts it is only shown when it is created.
+type WorkspaceInvitation struct {
+	ID            int        `json:"id" db:"id"`
+	WorkspaceID   int        `json:"workspace_id" db:"workspace_id"`
+	WorkspaceName string     `json:"workspace_name,omitempty"`
+	Email         string     `json:"email" db:"email"`
+	Role          string     `json:"role" db:"role"`
+	InvitedBy     int        `json:"invited_by" db:"invited_by"`
+	ExpiresAt     time.Time  `json:"expires_at" db:"expires_at"`
+	AcceptedAt    *time.Time `json:"accepted_at,omitempty" db:"accepted_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+}
+
+// SSODomainMapping adds people signing in with single sign-on to a
+// workspace by the domain of their email address
+type SSODomainMapping struct {
+	Domain        string    `json:"domain" db:"domain"`
+	WorkspaceID   int       `json:"workspace_id" db:"workspace_id"`
+	WorkspaceName string    `json:"workspace_name"`
+	Role          string    `json:"role" db:"role"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// SSOGroupMapping gives people in an identity provider group a role. When
+// several of a user's groups are mapped, the highest priority wins.
+type SSOGroupMapping struct {
+	Group     string    `json:"group" db:"group_name"`
+	Role      string    `json:"role" db:"role"`
+	Priority  int       `json:"priority" db:"priority"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// MFAStatus is whether a user has two-factor authentication and which of
+// their workspaces require it
+type MFAStatus struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+	RequiredBy        []string   `json:"required_by"`
+}
+
+type Document struct {
+	ID          int       `json:"id" db:"id"`
+	UserID      int       `json:"user_id" db:"user_id"`
+	WorkspaceID int       `json:"workspace_id" db:"workspace_id"`
+	Filename    string    `json:"filename" db:"filename"`
+	FilePath    string    `json:"file_path" db:"file_path"`
+	FileType    string    `json:"file_type" db:"file_type"`
+	FileSize    int       `json:"file_size" db:"file_size"`
+	Status      string    `json:"status" db:"status"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
 type ChatSession struct {
 	ID          int       `json:"id" db:"id"`
 	UserID      int       `json:"user_id" db:"user_id"`
+	WorkspaceID int       `json:"workspace_id" db:"workspace_id"`
 	DocumentIDs []int     `json:"document_ids" db:"document_ids"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
@@ -42,6 +206,7 @@ type ChatMessage struct {
 type ResearchTask struct {
 	ID          int                    `json:"id" db:"id"`
 	UserID      int                    `json:"user_id" db:"user_id"`
+	WorkspaceID int                    `json:"workspace_id" db:"workspace_id"`
 	Query       string                 `json:"query" db:"query"`
 	Status      string                 `json:"status" db:"status"`
 	Result      string                 `json:"result" db:"result"`
@@ -51,24 +216,325 @@ type ResearchTask struct {
 }
 
 type ResumeAnalysis struct {
-	ID             int        `json:"id" db:"id"`
-	UserID         int        `json:"user_id" db:"user_id"`
-	ResumePath     string     `json:"resume_path" db:"resume_path"`
-	JobDescription string     `json:"job_description" db:"job_description"`
-	Feedback       string     `json:"feedback" db:"feedback"`
-	Score          int        `json:"score" db:"score"`
-	Status         string     `json:"status" db:"status"`
-	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
-	CompletedAt    *time.Time `json:"completed_at" db:"completed_at"`
+	ID             int           `json:"id" db:"id"`
+	UserID         int           `json:"user_id" db:"user_id"`
+	ResumePath     string        `json:"resume_path" db:"resume_path"`
+	ResumeID       *int          `json:"resume_id,omitempty" db:"resume_id"`
+	Version        int           `json:"version,omitempty" db:"version"`
+	JobDescription string        `json:"job_description" db:"job_description"`
+	Feedback       string        `json:"feedback" db:"feedback"`
+	Score          int           `json:"score" db:"score"`
+	Analysis       *ResumeRubric `json:"analysis,omitempty" db:"analysis"`
+	Error          string        `json:"error,omitempty" db:"error"`
+	Status         string        `json:"status" db:"status"`
+	CreatedAt      time.Time     `json:"created_at" db:"created_at"`
+	CompletedAt    *time.Time    `json:"completed_at" db:"completed_at"`
+}
+
+// ResumeRubric is the structured result of a resume analysis. Score on the
+// analysis is the average of the dimension scores weighted by Weights.
+type ResumeRubric struct {
+	Dimensions      map[string]ResumeDimension `json:"dimensions"`
+	Weights         map[string]float64         `json:"weights"`
+	Strengths       []string                   `json:"strengths"`
+	Gaps            []string                   `json:"gaps"`
+	MissingKeywords []string                   `json:"missing_keywords"`
+	BulletRewrites  []ResumeBulletRewrite      `json:"bullet_rewrites"`
+}
+
+type ResumeDimension struct {
+	Score   int    `json:"score"`
+	Comment string `json:"comment"`
+}
+
+type ResumeBulletRewrite struct {
+	Original string `json:"original"`
+	Rewrite  string `json:"rewrite"`
+	Reason   string `json:"reason"`
+}
+
+// ResumeProfile is the structured content of a resume, parsed from its
+// text without the model. Dates are "YYYY-MM", or "YYYY" when the resume
+// gives no month.
+type ResumeProfile struct {
+	Contact               ResumeContact         `json:"contact"`
+	Summary               string                `json:"summary,omitempty"`
+	WorkHistory           []ResumeWorkEntry     `json:"work_history"`
+	Education             []ResumeEducation     `json:"education"`
+	Skills                []string              `json:"skills"`
+	Certifications        []ResumeCertification `json:"certifications"`
+	TotalExperienceMonths int                   `json:"total_experience_months"`
+}
+
+type ResumeContact struct {
+	Name     string   `json:"name,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Phone    string   `json:"phone,omitempty"`
+	Location string   `json:"location,omitempty"`
+	Links    []string `json:"links,omitempty"`
+}
+
+type ResumeWorkEntry struct {
+	Title      string   `json:"title"`
+	Company    string   `json:"company,omitempty"`
+	StartDate  string   `json:"start_date,omitempty"`
+	EndDate    string   `json:"end_date,omitempty"`
+	Current    bool     `json:"current"`
+	Highlights []string `json:"highlights"`
+}
+
+type ResumeEducation struct {
+	Institution string `json:"institution,omitempty"`
+	Degree      string `json:"degree,omitempty"`
+	StartDate   string `json:"start_date,omitempty"`
+	EndDate     string `json:"end_date,omitempty"`
+}
+
+type ResumeCertification struct {
+	Name string `json:"name"`
+	Date string `json:"date,omitempty"`
+}
+
+// ResumeBatch scores many resumes against one job description
+type ResumeBatch struct {
+	ID               int                `json:"id" db:"id"`
+	UserID           int                `json:"user_id" db:"user_id"`
+	JobDescription   string             `json:"job_description" db:"job_description"`
+	Weights          map[string]float64 `json:"weights" db:"weights"`
+	Status           string             `json:"status" db:"status"`
+	TotalResumes     int                `json:"total_resumes" db:"total_resumes"`
+	ProcessedResumes int                `json:"processed_resumes" db:"processed_resumes"`
+	CreatedAt        time.Time          `json:"created_at" db:"created_at"`
+	CompletedAt      *time.Time         `json:"completed_at" db:"completed_at"`
+}
+
+// RankedResume is one candidate of a batch. Rank is set once the resume
+// has been scored; failed and pending resumes are listed after the ranked
+// ones.
+type RankedResume struct {
+	Rank            int            `json:"rank,omitempty"`
+	AnalysisID      int            `json:"analysis_id"`
+	Filename        string         `json:"filename"`
+	Status          string         `json:"status"`
+	Score           *int           `json:"score"`
+	DimensionScores map[string]int `json:"dimension_scores,omitempty"`
+	Explanation     []string       `json:"explanation,omitempty"`
+	MissingKeywords []string       `json:"missing_keywords,omitempty"`
+	Error           string         `json:"error,omitempty"`
+}
+
+// Resume groups the analyses of successive versions of one resume, each
+// upload of a revision adding a version
+type Resume struct {
+	ID            int             `json:"id" db:"id"`
+	UserID        int             `json:"user_id" db:"user_id"`
+	Name          string          `json:"name" db:"name"`
+	LatestVersion int             `json:"latest_version"`
+	Versions      []ResumeVersion `json:"versions"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// ResumeVersion is one uploaded revision of a resume and its analysis
+type ResumeVersion struct {
+	Version    int       `json:"version" db:"version"`
+	AnalysisID int       `json:"analysis_id" db:"id"`
+	Filename   string    `json:"filename" db:"filename"`
+	Status     string    `json:"status" db:"status"`
+	Score      *int      `json:"score" db:"score"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// ResumeComparison tells what changed between two versions of a resume:
+// the text, the scores and which suggestions made on the earlier version
+// were taken up
+type ResumeComparison struct {
+	ResumeID     int                        `json:"resume_id"`
+	From         ResumeVersion              `json:"from"`
+	To           ResumeVersion              `json:"to"`
+	Diff         string                     `json:"diff"`
+	DiffTooLarge bool                       `json:"diff_too_large,omitempty"`
+	LinesAdded   int                        `json:"lines_added"`
+	LinesRemoved int                        `json:"lines_removed"`
+	ScoreChange  *int                       `json:"score_change"`
+	Dimensions   map[string]DimensionChange `json:"dimensions"`
+	Suggestions  []ResumeSuggestion         `json:"suggestions"`
+	Addressed    int                        `json:"addressed"`
+}
+
+// DimensionChange is the move of one rubric dimension between versions.
+// From or To is nil when that version was not scored on the dimension.
+type DimensionChange struct {
+	From   *int `json:"from"`
+	To     *int `json:"to"`
+	Change *int `json:"change"`
+}
+
+// ResumeSuggestion is a suggestion from the earlier version's analysis: a
+// missing keyword, a gap or a bullet rewrite. Status is "addressed",
+// "partially_addressed" or "open".
+type ResumeSuggestion struct {
+	Kind       string `json:"kind"`
+	Suggestion string `json:"suggestion"`
+	Status     string `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// RedactionSetting says which model calls have personal data redacted for
+// a tenant. Source is "tenant" when the tenant saved it and "default" when
+// the server default applies.
+type RedactionSetting struct {
+	Enabled    bool       `json:"enabled"`
+	Operations []string   `json:"operations"`
+	Source     string     `json:"source"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// RedactionEvent is an audit record of one redacted model call. Counts has
+// the number of values replaced per kind, e.g. {"EMAIL": 1, "NAME": 3}.
+type RedactionEvent struct {
+	ID        int            `json:"id" db:"id"`
+	UserID    int            `json:"user_id" db:"user_id"`
+	Operation string         `json:"operation" db:"operation"`
+	Counts    map[string]int `json:"counts" db:"counts"`
+	Total     int            `json:"total" db:"total"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
 }
 
 type SQLQuery struct {
-	ID           int                    `json:"id" db:"id"`
-	UserID       int                    `json:"user_id" db:"user_id"`
-	NaturalQuery string                 `json:"natural_query" db:"natural_query"`
-	GeneratedSQL string                 `json:"generated_sql" db:"generated_sql"`
-	ResultData   map[string]interface{} `json:"result_data" db:"result_data"`
-	Status       string                 `json:"status" db:"status"`
-	CreatedAt    time.Time              `json:"created_at" db:"created_at"`
+	ID            int                    `json:"id" db:"id"`
+	UserID        int                    `json:"user_id" db:"user_id"`
+	WorkspaceID   int                    `json:"workspace_id" db:"workspace_id"`
+	NaturalQuery  string                 `json:"natural_query" db:"natural_query"`
+	GeneratedSQL  string                 `json:"generated_sql" db:"generated_sql"`
+	ResultData    map[string]interface{} `json:"result_data" db:"result_data"`
+	Attempts      []SQLAttempt           `json:"attempts" db:"attempts"`
+	SavedQueryID  *int                   `json:"saved_query_id,omitempty" db:"saved_query_id"`
+	Explanation   string                 `json:"explanation" db:"explanation"`
+	ResultSummary string                 `json:"result_summary" db:"result_summary"`
+	Visualization map[string]interface{} `json:"visualization" db:"visualization"`
+	Status        string                 `json:"status" db:"status"`
+	CreatedAt     time.Time              `json:"created_at" db:"created_at"`
+}
+
+type SQLAttempt struct {
+	Attempt    int    `json:"attempt"`
+	SQL        string `json:"sql"`
+	Error      string `json:"error,omitempty"`
+	ErrorCode  string `json:"error_code,omitempty"`
+	RowCount   int    `json:"row_count"`
+	DurationMS int64  `json:"duration_ms"`
 }
 
+type SavedSQLQuery struct {
+	ID           int       `json:"id" db:"id"`
+	UserID       int       `json:"user_id" db:"user_id"`
+	WorkspaceID  int       `json:"workspace_id" db:"workspace_id"`
+	Name         string    `json:"name" db:"name"`
+	NaturalQuery string    `json:"natural_query" db:"natural_query"`
+	SQL          string    `json:"sql" db:"sql_text"`
+	Parameters   []string  `json:"parameters" db:"parameters"`
+	Shared       bool      `json:"shared"`
+	SharedWith   []string  `json:"shared_with,omitempty"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type GraphIngestion struct {
+	ID              int        `json:"id" db:"id"`
+	UserID          int        `json:"user_id" db:"user_id"`
+	WorkspaceID     int        `json:"workspace_id" db:"workspace_id"`
+	DocumentIDs     []int      `json:"document_ids" db:"document_ids"`
+	Status          string     `json:"status" db:"status"`
+	TotalChunks     int        `json:"total_chunks" db:"total_chunks"`
+	ProcessedChunks int        `json:"processed_chunks" db:"processed_chunks"`
+	Error           string     `json:"error,omitempty" db:"error"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	CompletedAt     *time.Time `json:"completed_at" db:"completed_at"`
+}
+
+type GraphNode struct {
+	ID             int    `json:"id" db:"id"`
+	WorkspaceID    int    `json:"workspace_id" db:"workspace_id"`
+	Name           string `json:"name" db:"name"`
+	NormalizedName string `json:"normalized_name" db:"normalized_name"`
+	Type           string `json:"type" db:"type"`
+	Description    string `json:"description" db:"description"`
+}
+
+type GraphEdge struct {
+	ID          int    `json:"id" db:"id"`
+	WorkspaceID int    `json:"workspace_id" db:"workspace_id"`
+	SourceID    int    `json:"source_id" db:"source_id"`
+	TargetID    int    `json:"target_id" db:"target_id"`
+	Type        string `json:"type" db:"type"`
+	Description string `json:"description" db:"description"`
+	Weight      int    `json:"weight"`
+}
+
+type GraphChunk struct {
+	ID         int    `json:"id" db:"id"`
+	DocumentID int    `json:"document_id" db:"document_id"`
+	ChunkIndex int    `json:"chunk_index" db:"chunk_index"`
+	Content    string `json:"content" db:"content"`
+}
+
+type GraphCommunity struct {
+	ID          int       `json:"id" db:"id"`
+	WorkspaceID int       `json:"workspace_id" db:"workspace_id"`
+	Level       int       `json:"level" db:"level"`
+	ParentID    *int      `json:"parent_id,omitempty" db:"parent_id"`
+	NodeIDs     []int     `json:"node_ids" db:"node_ids"`
+	Title       string    `json:"title" db:"title"`
+	Summary     string    `json:"summary" db:"summary"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// UsageTotals sums the model calls of a period. Cost is an estimate in US
+// dollars from the models' list prices.
+type UsageTotals struct {
+	Calls            int64   `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+	AvgLatencyMs     int64   `json:"avg_latency_ms"`
+}
+
+type UsageDay struct {
+	Date string `json:"date"`
+	UsageTotals
+}
+
+type UsageFeature struct {
+	Feature string `json:"feature"`
+	UsageTotals
+}
+
+// UsageUser is the usage of one user, nil for calls of deleted users
+type UsageUser struct {
+	UserID *int   `json:"user_id"`
+	Email  string `json:"email"`
+	UsageTotals
+}
+
+// UsageReport breaks down the model calls from From up to To (UTC) by day
+// and feature, and by user in reports of everyone
+type UsageReport struct {
+	From      time.Time      `json:"from"`
+	To        time.Time      `json:"to"`
+	Totals    UsageTotals    `json:"totals"`
+	ByDay     []UsageDay     `json:"by_day"`
+	ByFeature []UsageFeature `json:"by_feature"`
+	ByUser    []UsageUser    `json:"by_user,omitempty"`
+}
+
+// UsageQuotaStatus is a user's monthly quota, zero for unlimited, and what
+// they used of it this month
+type UsageQuotaStatus struct {
+	MonthlyTokens int64     `json:"monthly_tokens"`
+	MonthlyCost   float64   `json:"monthly_cost"`
+	UsedTokens    int64     `json:"used_tokens"`
+	UsedCost      float64   `json:"used_cost"`
+	ResetsAt      time.Time `json:"resets_at"`
+}
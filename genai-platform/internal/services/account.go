@@ -0,0 +1,397 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// MinPasswordLength is the shortest password accepted
+	MinPasswordLength = 8
+
+	// maxPasswordLength is the most bcrypt looks at; longer passwords
+	// would be silently truncated
+	maxPasswordLength = 72
+
+	// passphraseLength is the length from which a password needs no mix of
+	// kinds of character
+	passphraseLength = 16
+)
+
+// commonPasswords are passwords at the top of every breach list that the
+// rest of the policy lets through, usually once capitalized. They are
+// compared ignoring case.
+var commonPasswords = map[string]bool{
+	"password1": true, "password12": true, "password123": true, "password1!": true, "password123!": true,
+	"passw0rd": true, "passw0rd!": true, "p@ssw0rd": true, "p@ssword1": true, "p@ssw0rd1": true,
+	"p@ssw0rd123": true, "welcome1": true, "welcome123": true, "welcome1!": true, "qwerty123": true,
+	"qwerty123!": true, "qwerty1234": true, "1q2w3e4r5t": true, "1qaz2wsx3edc": true, "zaq12wsx": true,
+	"letmein1": true, "letmein123": true, "changeme1": true, "changeme123": true, "admin123": true,
+	"admin1234": true, "admin123!": true, "iloveyou1": true, "iloveyou123": true, "abc12345": true,
+	"abcd1234": true, "monkey123": true, "dragon123": true, "football1": true, "baseball1": true,
+	"trustno1!": true, "sunshine1": true, "princess1": true, "summer2024": true, "winter2024": true,
+	"summer2025": true, "winter2025": true, "spring2025": true, "autumn2025": true, "summer2026": true,
+	"winter2026": true, "spring2026": true, "autumn2026": true, "correcthorsebatterystaple": true,
+}
+
+// dummyPasswordHash is what a sign-in with an email that has no password
+// is checked against, so it takes as long as one with a wrong password
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("no account has this password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// CheckPassword reports whether password matches a password hash. An
+// empty hash, of an unknown email or an account without a password, takes
+// as long to check as any other and never matches.
+func CheckPassword(passwordHash, password string) bool {
+	if passwordHash == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil
+}
+
+// Purposes of email_tokens
+const (
+	tokenVerifyEmail   = "verify_email"
+	tokenPasswordReset = "password_reset"
+)
+
+var (
+	// ErrEmailTaken is returned when registering an email that already has
+	// an account
+	ErrEmailTaken = errors.New("email is already registered")
+
+	// ErrInvalidToken is returned for an unknown, used or expired
+	// verification or password reset token
+	ErrInvalidToken = errors.New("link is invalid or has expired")
+)
+
+// NormalizeEmail trims and lowercases an email address so one mailbox has
+// one account
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ValidateEmail checks that email is a bare address such as
+// "jane@example.com"
+func ValidateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return fmt.Errorf("a valid email address is required")
+	}
+	if at := strings.LastIndex(email, "@"); !strings.Contains(email[at+1:], ".") {
+		return fmt.Errorf("a valid email address is required")
+	}
+	if len(email) > 255 {
+		return fmt.Errorf("email must be at most 255 characters")
+	}
+	return nil
+}
+
+// ValidatePassword checks a new password against the strength policy: a
+// length bcrypt handles, three kinds of character out of lowercase,
+// uppercase, digits and symbols unless it is a passphrase of at least
+// passphraseLength, and nothing easily guessed such as a common password
+// or one of personal, the user's email for instance.
+func ValidatePassword(password string, personal ...string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	distinct := map[rune]bool{}
+	for _, r := range password {
+		distinct[r] = true
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	kinds := 0
+	for _, has := range []bool{lower, upper, digit, symbol} {
+		if has {
+			kinds++
+		}
+	}
+	if kinds < 3 && utf8.RuneCountInString(password) < passphraseLength {
+		return fmt.Errorf("password must mix three of lowercase letters, uppercase letters, digits and symbols, or be at least %d characters", passphraseLength)
+	}
+	if len(distinct) < 5 {
+		return fmt.Errorf("password repeats too few different characters")
+	}
+
+	folded := strings.ToLower(password)
+	if commonPasswords[folded] {
+		return fmt.Errorf("password is too common")
+	}
+	for _, word := range personal {
+		// An email counts by its name, which people put in passwords
+		if at := strings.LastIndex(word, "@"); at > 0 {
+			word = word[:at]
+		}
+		if word = strings.ToLower(word); len(word) >= 4 && strings.Contains(folded, word) {
+			return fmt.Errorf("password must not contain your email address")
+		}
+	}
+	return nil
+}
+
+// AccountService registers users, verifies their email addresses and
+// resets forgotten passwords. Verification and reset links carry random
+// tokens that are stored hashed, work once and expire.
+type AccountService struct {
+	db        *sql.DB
+	mail      *MailService
+	sessions  *SessionService
+	verifyTTL time.Duration
+	resetTTL  time.Duration
+}
+
+func NewAccountService(db *sql.DB, mail *MailService, sessions *SessionService, verifyTTL, resetTTL time.Duration) *AccountService {
+	return &AccountService{db: db, mail: mail, sessions: sessions, verifyTTL: verifyTTL, resetTTL: resetTTL}
+}
+
+// Register creates a user with a normalized, validated email and returns
+// its id. It returns ErrEmailTaken when the email has an account.
+func (s *AccountService) Register(email, password string) (int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int
+	err = s.db.QueryRow(
+		`INSERT INTO users (email, password_hash)
+		 SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM users WHERE lower(email) = $1)
+		 RETURNING id`,
+		email, string(hashedPassword),
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrEmailTaken
+	}
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return 0, ErrEmailTaken
+	}
+	return userID, err
+}
+
+// SendVerification emails a user a link confirming their address
+func (s *AccountService) SendVerification(userID int, email string) error {
+	token, err := s.issueToken(userID, tokenVerifyEmail, email, s.verifyTTL)
+	if err != nil {
+		return err
+	}
+	s.mail.Notify(email, EmailVerifyAddress, map[string]interface{}{
+		"Link":      s.mail.Link("/verify-email", token),
+		"ExpiresIn": humanDuration(s.verifyTTL),
+	})
+	return nil
+}
+
+// ResendVerification sends a new verification link when email belongs to
+// an account that is not verified yet. It says nothing about whether it
+// did, so it can not be used to find out who has an account.
+func (s *AccountService) ResendVerification(email string) error {
+	var userID int
+	err := s.db.QueryRow(
+		`SELECT id FROM users
+		 WHERE lower(email) = $1 AND email_verified_at IS NULL AND disabled_at IS NULL`,
+		NormalizeEmail(email),
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.SendVerification(userID, NormalizeEmail(email))
+}
+
+// VerifyEmail marks the address a verification token was sent to as
+// verified. It returns ErrInvalidToken for a bad token, or one sent to an
+// address the user has since changed.
+func (s *AccountService) VerifyEmail(token string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userID, email, err := consumeToken(tx, token, tokenVerifyEmail)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(
+		`UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		 WHERE id = $1 AND lower(email) = lower($2)`,
+		userID, email,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidToken
+	}
+	return tx.Commit()
+}
+
+// RequestPasswordReset emails a password reset link when email belongs to
+// an enabled account. Like ResendVerification it never reveals whether it
+// did.
+func (s *AccountService) RequestPasswordReset(email string) error {
+	var userID int
+	var address string
+	err := s.db.QueryRow(
+		"SELECT id, email FROM users WHERE lower(email) = $1 AND disabled_at IS NULL",
+		NormalizeEmail(email),
+	).Scan(&userID, &address)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := s.issueToken(userID, tokenPasswordReset, address, s.resetTTL)
+	if err != nil {
+		return err
+	}
+	s.mail.Notify(address, EmailPasswordReset, map[string]interface{}{
+		"Link":      s.mail.Link("/reset-password", token),
+		"ExpiresIn": humanDuration(s.resetTTL),
+	})
+	return nil
+}
+
+// ResetPassword sets a new password with a reset token and signs the user
+// out everywhere. Receiving the link proves the address, so it is marked
+// verified too. It returns ErrInvalidToken for a bad token.
+func (s *AccountService) ResetPassword(token, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userID, email, err := consumeToken(tx, token, tokenPasswordReset)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(
+		`UPDATE users SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		 WHERE id = $2 AND lower(email) = lower($3) AND disabled_at IS NULL`,
+		string(hashedPassword), userID, email,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidToken
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if _, err := s.sessions.RevokeAll(userID, 0, RevokedPasswordReset); err != nil {
+		fmt.Printf("Failed to revoke sessions of user %d after password reset: %v\n", userID, err)
+	}
+	s.mail.Notify(email, EmailPasswordChanged, nil)
+	return nil
+}
+
+// issueToken stores a new token for purpose, retiring the user's earlier
+// ones so only the latest link works
+func (s *AccountService) issueToken(userID int, purpose, email string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"UPDATE email_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL",
+		userID, purpose,
+	); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO email_tokens (user_id, purpose, email, token_hash, expires_at)
+		 VALUES ($1, $2, $3, $4, NOW() + $5 * INTERVAL '1 second')`,
+		userID, purpose, email, hashToken(token), int64(ttl.Seconds()),
+	); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("DELETE FROM email_tokens WHERE expires_at < NOW() - INTERVAL '7 days'"); err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// consumeToken uses up a token, returning the user and address it was
+// issued for
+func consumeToken(tx *sql.Tx, token, purpose string) (int, string, error) {
+	var userID int
+	var email string
+	err := tx.QueryRow(
+		`UPDATE email_tokens SET used_at = NOW()
+		 WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		 RETURNING user_id, email`,
+		hashToken(token), purpose,
+	).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrInvalidToken
+	}
+	return userID, email, err
+}
+
+// humanDuration formats a link lifetime for an email, e.g. "2 days"
+func humanDuration(d time.Duration) string {
+	unit, n := "minute", int(d/time.Minute)
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		unit, n = "day", int(d/(24*time.Hour))
+	case d >= time.Hour && d%time.Hour == 0:
+		unit, n = "hour", int(d/time.Hour)
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
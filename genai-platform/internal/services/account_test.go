@@ -0,0 +1,44 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		personal []string
+		ok       bool
+	}{
+		{name: "three kinds", password: "Tr0ub4dor&3", ok: true},
+		{name: "passphrase of one kind", password: "correct horse battery staple", ok: true},
+		{name: "letters beyond ASCII", password: "ÄÖÜäöü12", ok: true},
+		{name: "short personal words are ignored", password: "Bobcat#2024x", personal: []string{"bob@example.com"}, ok: true},
+		{name: "longest bcrypt handles", password: "Aa1!" + strings.Repeat("x", 68), ok: true},
+
+		{name: "too short", password: "Ab1!xyz"},
+		{name: "short counted in characters, not bytes", password: "äöüßÄÖ1"},
+		{name: "too long for bcrypt", password: "Aa1!" + strings.Repeat("x", 69)},
+		{name: "one kind", password: "alllowercase"},
+		{name: "two kinds", password: "lowercase123"},
+		{name: "too few different characters", password: "Aa1Aa1Aa1Aa1"},
+		{name: "common", password: "Password123!"},
+		{name: "common in any case", password: "P@SSW0RD123"},
+		{name: "email name", password: "Jane.Doe2024!", personal: []string{"jane.doe@example.com"}},
+		{name: "personal word in any case", password: "xWORKSPACEx1", personal: []string{"Workspace"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePassword(tt.password, tt.personal...)
+			if tt.ok && err != nil {
+				t.Errorf("ValidatePassword(%q) = %v, want nil", tt.password, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("ValidatePassword(%q) = nil, want an error", tt.password)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+
+	"genai-platform/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Reasons sessions are revoked by an administrator, recorded on
+// auth_sessions
+const (
+	RevokedAccountDisabled = "account_disabled"
+	RevokedPasswordReset   = "password_reset"
+)
+
+// Job types listed by UserJobs
+var JobTypes = []string{"research", "resume_analysis", "resume_batch", "graph_ingestion", "sql_query"}
+
+// AdminService is what administrators do to other users' accounts
+type AdminService struct {
+	db       *sql.DB
+	sessions *SessionService
+}
+
+func NewAdminService(db *sql.DB, sessions *SessionService) *AdminService {
+	return &AdminService{db: db, sessions: sessions}
+}
+
+const adminUserColumns = `u.id, u.email, u.role, u.email_verified_at IS NOT NULL,
+	EXISTS (SELECT 1 FROM user_mfa f WHERE f.user_id = u.id AND f.enabled_at IS NOT NULL), u.disabled_at, COALESCE(u.disabled_reason, ''), u.created_at,
+	(SELECT COUNT(*) FROM auth_sessions s WHERE s.user_id = u.id AND s.revoked_at IS NULL AND s.expires_at > NOW()),
+	(SELECT MAX(s.last_used_at) FROM auth_sessions s WHERE s.user_id = u.id)`
+
+func scanAdminUser(row interface{ Scan(...interface{}) error }) (*models.AdminUser, error) {
+	var user models.AdminUser
+	if err := row.Scan(&user.ID, &user.Email, &user.Role, &user.EmailVerified, &user.MFAEnabled, &user.DisabledAt, &user.DisabledReason,
+		&user.CreatedAt, &user.ActiveSessions, &user.LastActiveAt); err != nil {
+		return nil, err
+	}
+	user.Disabled = user.DisabledAt != nil
+	return &user, nil
+}
+
+// Users lists users, newest first, optionally only those whose email
+// contains search or who have role, with the total number matching
+func (s *AdminService) Users(search, role string, limit, offset int) ([]models.AdminUser, int, error) {
+	const filter = `($1::text = '' OR u.email ILIKE '%' || $1::text || '%') AND ($2::text = '' OR u.role = $2::text)`
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM users u WHERE "+filter, search, role).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(
+		`SELECT `+adminUserColumns+` FROM users u WHERE `+filter+`
+		 ORDER BY u.created_at DESC, u.id DESC LIMIT $3 OFFSET $4`,
+		search, role, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []models.AdminUser{}
+	for rows.Next() {
+		user, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *user)
+	}
+	return users, total, rows.Err()
+}
+
+// User returns one user, or sql.ErrNoRows
+func (s *AdminService) User(userID int) (*models.AdminUser, error) {
+	return scanAdminUser(s.db.QueryRow("SELECT "+adminUserColumns+" FROM users u WHERE u.id = $1", userID))
+}
+
+// DisableUser stops a user from signing in and signs them out everywhere.
+// Their API keys stop working too, as the auth middleware refuses disabled
+// accounts. It returns sql.ErrNoRows for an unknown user and ErrLastAdmin
+// for the last active administrator.
+func (s *AdminService) DisableUser(userID int, reason string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE users SET disabled_at = COALESCE(disabled_at, NOW()), disabled_reason = $1, updated_at = NOW()
+		 WHERE id = $2`,
+		reason, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := requireActiveAdmin(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if _, err := s.sessions.RevokeAll(userID, 0, RevokedAccountDisabled); err != nil {
+		fmt.Printf("Failed to revoke sessions of disabled user %d: %v\n", userID, err)
+	}
+	return nil
+}
+
+// EnableUser lets a disabled user sign in again. It returns sql.ErrNoRows
+// for an unknown user.
+func (s *AdminService) EnableUser(userID int) error {
+	result, err := s.db.Exec(
+		"UPDATE users SET disabled_at = NULL, disabled_reason = NULL, updated_at = NOW() WHERE id = $1",
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ResetPassword sets a user's password and signs them out everywhere. An
+// empty password sets a random one, which is returned so the administrator
+// can pass it on. It returns sql.ErrNoRows for an unknown user.
+func (s *AdminService) ResetPassword(userID int, password string) (string, error) {
+	generated := ""
+	if password == "" {
+		b := make([]byte, 12)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		generated = base64.RawURLEncoding.EncodeToString(b)
+		password = generated
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	result, err := s.db.Exec(
+		"UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2",
+		string(hashedPassword), userID,
+	)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	if _, err := s.sessions.RevokeAll(userID, 0, RevokedPasswordReset); err != nil {
+		fmt.Printf("Failed to revoke sessions of user %d after password reset: %v\n", userID, err)
+	}
+	return generated, nil
+}
+
+// UserJobs lists a user's jobs of every type, newest first, optionally
+// only those of one type or status, with the total number matching
+func (s *AdminService) UserJobs(userID int, jobType, status string, limit, offset int) ([]models.UserJob, int, error) {
+	const jobs = `
+		SELECT 'research' AS type, id, COALESCE(status, '') AS status, query AS summary, '' AS error,
+		       created_at, completed_at
+		FROM research_tasks WHERE user_id = $1
+		UNION ALL
+		SELECT 'resume_analysis', id, COALESCE(status, ''), COALESCE(filename, ''), '', created_at, completed_at
+		FROM resume_analyses WHERE user_id = $1 AND batch_id IS NULL
+		UNION ALL
+		SELECT 'resume_batch', id, COALESCE(status, ''),
+		       processed_resumes || ' of ' || total_resumes || ' resumes', '', created_at, completed_at
+		FROM resume_batches WHERE user_id = $1
+		UNION ALL
+		SELECT 'graph_ingestion', id, COALESCE(status, ''),
+		       COALESCE(array_length(document_ids, 1), 0) || ' documents', COALESCE(error, ''), created_at, completed_at
+		FROM graph_ingestions WHERE user_id = $1
+		UNION ALL
+		SELECT 'sql_query', id, COALESCE(status, ''), natural_query, '', created_at, NULL
+		FROM sql_queries WHERE user_id = $1`
+	const filter = `($2::text = '' OR type = $2::text) AND ($3::text = '' OR status = $3::text)`
+
+	var total int
+	if err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM ("+jobs+") j WHERE "+filter,
+		userID, jobType, status,
+	).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(
+		`SELECT type, id, status, summary, error, created_at, completed_at
+		 FROM (`+jobs+`) j WHERE `+filter+`
+		 ORDER BY created_at DESC, type, id DESC LIMIT $4 OFFSET $5`,
+		userID, jobType, status, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result := []models.UserJob{}
+	for rows.Next() {
+		var job models.UserJob
+		if err := rows.Scan(&job.Type, &job.ID, &job.Status, &job.Summary, &job.Error,
+			&job.CreatedAt, &job.CompletedAt); err != nil {
+			return nil, 0, err
+		}
+		result = append(result, job)
+	}
+	return result, total, rows.Err()
+}
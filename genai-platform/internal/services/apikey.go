@@ -0,0 +1,136 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"time"
+
+	"genai-platform/internal/auth"
+	"genai-platform/internal/models"
+	"github.com/lib/pq"
+)
+
+const (
+	// apiKeyPrefixLength is how much of a key is kept in the clear, the
+	// "gk_" marker included
+	apiKeyPrefixLength = 11
+
+	// apiKeyUsageInterval limits how often last-used tracking writes to
+	// the database for a busy key
+	apiKeyUsageInterval = time.Minute
+)
+
+// APIKeyService manages personal API keys. Keys are stored as SHA-256
+// hashes; the key itself is shown once, when it is created.
+type APIKeyService struct {
+	db *sql.DB
+}
+
+func NewAPIKeyService(db *sql.DB) *APIKeyService {
+	return &APIKeyService{db: db}
+}
+
+// Create makes a key for a user, returning it with the secret key. A nil
+// expiresAt makes a key that never expires.
+func (s *APIKeyService) Create(userID int, name string, scopes []string, expiresAt *time.Time) (*models.APIKey, string, error) {
+	if err := auth.ValidateScopes(scopes); err != nil {
+		return nil, "", err
+	}
+	scopes = cleanList(scopes)
+	sort.Strings(scopes)
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	key := auth.APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	apiKey := &models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    key[:apiKeyPrefixLength],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.QueryRow(
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
+		userID, name, apiKey.Prefix, hashToken(key), pq.Array(scopes), expiresAt,
+	).Scan(&apiKey.ID, &apiKey.CreatedAt); err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+// List returns a user's keys that have not been revoked, newest first.
+// Expired keys are listed until revoked so the user sees why a job broke.
+func (s *APIKeyService) List(userID int) ([]models.APIKey, error) {
+	rows, err := s.db.Query(
+		`SELECT id, name, prefix, scopes, expires_at, last_used_at, COALESCE(last_used_ip, ''), created_at
+		 FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL
+		 ORDER BY created_at DESC, id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key := models.APIKey{UserID: userID}
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.ExpiresAt,
+			&key.LastUsedAt, &key.LastUsedIP, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke disables one of a user's keys at once. It returns sql.ErrNoRows
+// when the key is not the user's or is already revoked.
+func (s *APIKeyService) Revoke(userID, keyID int) error {
+	result, err := s.db.Exec(
+		"UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		keyID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Authenticate looks up a key presented as a bearer token and records its
+// use. It returns nil for keys that are unknown, revoked or expired.
+func (s *APIKeyService) Authenticate(key, ipAddress string) (*auth.APIKey, error) {
+	apiKey := &auth.APIKey{}
+	err := s.db.QueryRow(
+		`SELECT id, user_id, scopes FROM api_keys
+		 WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())`,
+		hashToken(key),
+	).Scan(&apiKey.ID, &apiKey.UserID, pq.Array(&apiKey.Scopes))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.Exec(
+		`UPDATE api_keys SET last_used_at = NOW(), last_used_ip = $1
+		 WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < NOW() - $3 * INTERVAL '1 second' OR last_used_ip <> $1)`,
+		ipAddress, apiKey.ID, int64(apiKeyUsageInterval.Seconds()),
+	); err != nil {
+		fmt.Printf("Failed to record use of API key %d: %v\n", apiKey.ID, err)
+	}
+	return apiKey, nil
+}
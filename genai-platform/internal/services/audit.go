@@ -0,0 +1,86 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"genai-platform/internal/models"
+)
+
+// Events of auth_events
+const (
+	AuthEventLoginSucceeded = "login_succeeded"
+	AuthEventLoginFailed    = "login_failed"
+	AuthEventLoginBlocked   = "login_blocked"
+	AuthEventLocked         = "locked"
+	AuthEventMFAFailed      = "mfa_failed"
+)
+
+// AuthEvents lists the events of the audit log
+var AuthEvents = []string{
+	AuthEventLoginSucceeded, AuthEventLoginFailed, AuthEventLoginBlocked, AuthEventLocked, AuthEventMFAFailed,
+}
+
+// AuditService keeps the audit log of sign-in attempts and lockouts
+type AuditService struct {
+	db *sql.DB
+}
+
+func NewAuditService(db *sql.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Record adds an event to the audit log. userID is 0 when the email has
+// no account. Failing to record is logged rather than failing the sign-in.
+func (s *AuditService) Record(event string, userID int, email, detail, ipAddress, userAgent string) {
+	var user interface{}
+	if userID != 0 {
+		user = userID
+	}
+	// Attempts may be made with anything as the email
+	if len(email) > 255 {
+		email = strings.ToValidUTF8(email[:255], "")
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO auth_events (user_id, email, event, detail, ip_address, user_agent)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		user, email, event, detail, ipAddress, userAgent,
+	); err != nil {
+		fmt.Printf("Failed to record %s event: %v\n", event, err)
+	}
+}
+
+// Events lists audit events, newest first, optionally only those of a
+// user, an email or an event, with the total number matching
+func (s *AuditService) Events(userID int, email, event string, limit, offset int) ([]models.AuthEvent, int, error) {
+	const filter = `($1::int = 0 OR user_id = $1::int) AND ($2::text = '' OR lower(email) = lower($2::text))
+		AND ($3::text = '' OR event = $3::text)`
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM auth_events WHERE "+filter, userID, email, event).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(
+		`SELECT id, user_id, email, event, detail, ip_address, user_agent, created_at
+		 FROM auth_events WHERE `+filter+`
+		 ORDER BY created_at DESC, id DESC LIMIT $4 OFFSET $5`,
+		userID, email, event, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []models.AuthEvent{}
+	for rows.Next() {
+		var e models.AuthEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Email, &e.Event, &e.Detail, &e.IPAddress, &e.UserAgent,
+			&e.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, e)
+	}
+	return events, total, rows.Err()
+}
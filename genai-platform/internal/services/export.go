@@ -0,0 +1,401 @@
+package services
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// exportBatchSize is how many rows are fetched from the cursor at a time
+// while streaming an export
+const exportBatchSize = 500
+
+// xlsxMaxRows is the row limit of a single Excel worksheet, header included
+const xlsxMaxRows = 1048576
+
+// ResultWriter streams a query result in one export format
+type ResultWriter interface {
+	WriteHeader(columns []ResultColumn) error
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// ExportFormat describes how an export is served over HTTP
+type ExportFormat struct {
+	ContentType string
+	Extension   string
+}
+
+var exportFormats = map[string]ExportFormat{
+	"csv":     {ContentType: "text/csv", Extension: "csv"},
+	"ndjson":  {ContentType: "application/x-ndjson", Extension: "ndjson"},
+	"xlsx":    {ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", Extension: "xlsx"},
+	"parquet": {ContentType: "application/vnd.apache.parquet", Extension: "parquet"},
+}
+
+// LookupExportFormat returns the format registered under name
+func LookupExportFormat(name string) (ExportFormat, bool) {
+	format, ok := exportFormats[name]
+	return format, ok
+}
+
+// NewResultWriter creates a writer for the named export format
+func NewResultWriter(name string, out io.Writer) (ResultWriter, error) {
+	switch name {
+	case "csv":
+		return &csvResultWriter{w: csv.NewWriter(out)}, nil
+	case "ndjson":
+		return &ndjsonResultWriter{w: bufio.NewWriter(out)}, nil
+	case "xlsx":
+		return &xlsxResultWriter{zw: zip.NewWriter(out)}, nil
+	case "parquet":
+		return &parquetResultWriter{out: out}, nil
+	}
+	return nil, fmt.Errorf("unsupported export format: %s", name)
+}
+
+// Export streams the full result of query into writer, reading it from a
+// server-side cursor in batches so large results are never held in memory.
+func (s *SQLService) Export(query string, writer ResultWriter) error {
+	cursor, err := s.OpenCursor(query)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	for first := true; ; first = false {
+		rows, err := cursor.Fetch(exportBatchSize)
+		if err != nil {
+			return err
+		}
+		if first {
+			if err := writer.WriteHeader(cursor.Columns); err != nil {
+				return err
+			}
+		}
+		for _, row := range rows {
+			if err := writer.WriteRow(row); err != nil {
+				return err
+			}
+		}
+		if len(rows) < exportBatchSize {
+			break
+		}
+	}
+
+	return writer.Close()
+}
+
+// formatExportValue renders a scanned value as text
+func formatExportValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case string:
+		return value
+	}
+	return fmt.Sprint(v)
+}
+
+// uniqueColumnNames suffixes repeated column names, which SQL allows but
+// keyed formats like JSON and Parquet do not
+func uniqueColumnNames(columns []ResultColumn) []string {
+	names := make([]string, len(columns))
+	seen := map[string]int{}
+	for i, column := range columns {
+		name := column.Name
+		seen[name]++
+		if seen[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, seen[name])
+		}
+		names[i] = name
+	}
+	return names
+}
+
+type csvResultWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvResultWriter) WriteHeader(columns []ResultColumn) error {
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	return c.w.Write(header)
+}
+
+func (c *csvResultWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = formatExportValue(v)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvResultWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonResultWriter struct {
+	w     *bufio.Writer
+	names [][]byte
+}
+
+func (n *ndjsonResultWriter) WriteHeader(columns []ResultColumn) error {
+	for _, name := range uniqueColumnNames(columns) {
+		encoded, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		n.names = append(n.names, encoded)
+	}
+	return nil
+}
+
+// WriteRow writes one JSON object per line, keeping the column order of
+// the result rather than the sorted order of a marshalled map
+func (n *ndjsonResultWriter) WriteRow(values []interface{}) error {
+	n.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			n.w.WriteByte(',')
+		}
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		n.w.Write(n.names[i])
+		n.w.WriteByte(':')
+		n.w.Write(encoded)
+	}
+	n.w.WriteString("}\n")
+	return nil
+}
+
+func (n *ndjsonResultWriter) Close() error {
+	return n.w.Flush()
+}
+
+// xlsxResultWriter writes a single-sheet workbook. The static parts of the
+// package are written up front so the worksheet can be streamed last.
+type xlsxResultWriter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	rows  int
+}
+
+var xlsxStaticParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Results" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+func (x *xlsxResultWriter) WriteHeader(columns []ResultColumn) error {
+	for _, part := range xlsxStaticParts {
+		f, err := x.zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+
+	f, err := x.zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	x.sheet = bufio.NewWriter(f)
+	x.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]interface{}, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	return x.WriteRow(header)
+}
+
+// WriteRow appends a row to the worksheet. Rows past the Excel sheet limit
+// are dropped; CSV, NDJSON or Parquet should be used for larger results.
+func (x *xlsxResultWriter) WriteRow(values []interface{}) error {
+	if x.rows >= xlsxMaxRows {
+		return nil
+	}
+	x.rows++
+
+	fmt.Fprintf(x.sheet, `<row r="%d">`, x.rows)
+	for _, v := range values {
+		switch value := v.(type) {
+		case nil:
+			x.sheet.WriteString(`<c/>`)
+		case int64, float64:
+			fmt.Fprintf(x.sheet, `<c><v>%v</v></c>`, value)
+		case bool:
+			b := 0
+			if value {
+				b = 1
+			}
+			fmt.Fprintf(x.sheet, `<c t="b"><v>%d</v></c>`, b)
+		default:
+			x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			if err := xml.EscapeText(x.sheet, []byte(formatExportValue(value))); err != nil {
+				return err
+			}
+			x.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (x *xlsxResultWriter) Close() error {
+	x.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// parquetResultWriter maps Postgres column types onto optional Parquet
+// columns; anything without a natural counterpart is written as a string.
+type parquetResultWriter struct {
+	out     io.Writer
+	w       *parquet.Writer
+	kinds   []string
+	indexes []int
+}
+
+func parquetColumnKind(databaseType string) string {
+	switch databaseType {
+	case "INT2", "INT4", "INT8", "OID":
+		return "int"
+	case "FLOAT4", "FLOAT8", "NUMERIC":
+		return "double"
+	case "BOOL":
+		return "bool"
+	case "TIMESTAMP", "TIMESTAMPTZ", "DATE":
+		return "timestamp"
+	}
+	return "string"
+}
+
+func (p *parquetResultWriter) WriteHeader(columns []ResultColumn) error {
+	names := uniqueColumnNames(columns)
+	group := parquet.Group{}
+	p.kinds = make([]string, len(columns))
+	for i, column := range columns {
+		p.kinds[i] = parquetColumnKind(column.Type)
+		var node parquet.Node
+		switch p.kinds[i] {
+		case "int":
+			node = parquet.Int(64)
+		case "double":
+			node = parquet.Leaf(parquet.DoubleType)
+		case "bool":
+			node = parquet.Leaf(parquet.BooleanType)
+		case "timestamp":
+			node = parquet.Timestamp(parquet.Microsecond)
+		default:
+			node = parquet.String()
+		}
+		group[names[i]] = parquet.Optional(node)
+	}
+
+	// Groups order their fields by name, so map each result column onto
+	// its leaf index in the schema
+	schema := parquet.NewSchema("result", group)
+	leaf := map[string]int{}
+	for i, field := range schema.Fields() {
+		leaf[field.Name()] = i
+	}
+	p.indexes = make([]int, len(columns))
+	for i, name := range names {
+		p.indexes[i] = leaf[name]
+	}
+
+	config, err := parquet.NewWriterConfig(schema, parquet.MaxRowsPerRowGroup(int64(exportBatchSize*20)))
+	if err != nil {
+		return err
+	}
+	p.w = parquet.NewWriter(p.out, config)
+	return nil
+}
+
+func (p *parquetResultWriter) WriteRow(values []interface{}) error {
+	row := make(parquet.Row, len(values))
+	for i, v := range values {
+		index := p.indexes[i]
+		value, ok := p.convert(p.kinds[i], v)
+		if !ok {
+			row[index] = parquet.NullValue().Level(0, 0, index)
+			continue
+		}
+		row[index] = value.Level(0, 1, index)
+	}
+	_, err := p.w.WriteRows([]parquet.Row{row})
+	return err
+}
+
+// convert coerces a scanned value to the physical type of its column,
+// reporting false for NULL or values that cannot be represented
+func (p *parquetResultWriter) convert(kind string, v interface{}) (parquet.Value, bool) {
+	if v == nil {
+		return parquet.Value{}, false
+	}
+
+	switch kind {
+	case "int":
+		if i, ok := v.(int64); ok {
+			return parquet.Int64Value(i), true
+		}
+		if i, err := strconv.ParseInt(formatExportValue(v), 10, 64); err == nil {
+			return parquet.Int64Value(i), true
+		}
+		return parquet.Value{}, false
+	case "double":
+		if f, ok := v.(float64); ok {
+			return parquet.DoubleValue(f), true
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(formatExportValue(v)), 64); err == nil {
+			return parquet.DoubleValue(f), true
+		}
+		return parquet.Value{}, false
+	case "bool":
+		if b, ok := v.(bool); ok {
+			return parquet.BooleanValue(b), true
+		}
+		return parquet.Value{}, false
+	case "timestamp":
+		if t, ok := v.(time.Time); ok {
+			return parquet.Int64Value(t.UnixMicro()), true
+		}
+		return parquet.Value{}, false
+	}
+	return parquet.ByteArrayValue([]byte(formatExportValue(v))), true
+}
+
+func (p *parquetResultWriter) Close() error {
+	return p.w.Close()
+}
@@ -30,12 +30,6 @@ func (s *FileService) ProcessPDF(docID int, filePath string) error {
 	return nil
 }
 
-func (s *FileService) GetRelevantContext(documentIDs []int, query string) (string, error) {
-	// Use the LLM service to get relevant context
-	llmService := NewLLMService()
-	return llmService.GetRelevantContext(documentIDs, query)
-}
-
 func (s *FileService) ExtractTextFromPDF(filePath string) (string, error) {
 	// Placeholder for PDF text extraction
 	// In a real implementation, this would use a PDF library
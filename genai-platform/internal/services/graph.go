@@ -0,0 +1,335 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"genai-platform/internal/models"
+	"github.com/lib/pq"
+)
+
+// graphExtractionWorkers caps how many chunks are sent to the model for
+// entity extraction at the same time
+const graphExtractionWorkers = 4
+
+// defaultEntityType is used when the model leaves an entity untyped
+const defaultEntityType = "CONCEPT"
+
+type GraphService struct {
+	db    *sql.DB
+	llm   *LLMService
+	store GraphStore
+}
+
+func NewGraphService(db *sql.DB, llm *LLMService, store GraphStore) *GraphService {
+	return &GraphService{
+		db:    db,
+		llm:   llm,
+		store: store,
+	}
+}
+
+// ForUser returns a copy of the service whose model calls are made on
+// behalf of userID, so that user's redaction setting applies, and metered
+// against workspaceID. Graphs belong to workspaces; the user is whoever
+// asked for the work.
+func (s *GraphService) ForUser(userID, workspaceID int) *GraphService {
+	scoped := *s
+	scoped.llm = s.llm.ForUser(userID).ForFeature(UsageFeatureGraph, workspaceID)
+	return &scoped
+}
+
+// Ingest chunks each document, extracts entities and relationships from
+// every chunk and merges them into the workspace's knowledge graph.
+// Progress is recorded on the graph_ingestions row as chunks are processed.
+func (s *GraphService) Ingest(ingestionID, workspaceID int, documents []models.Document) {
+	if err := s.ingest(ingestionID, workspaceID, documents); err != nil {
+		fmt.Printf("Failed graph ingestion %d: %v\n", ingestionID, err)
+		if _, err := s.db.Exec(
+			"UPDATE graph_ingestions SET status = $1, error = $2, completed_at = $3 WHERE id = $4",
+			"failed", err.Error(), time.Now(), ingestionID,
+		); err != nil {
+			fmt.Printf("Failed to update graph ingestion %d: %v\n", ingestionID, err)
+		}
+		return
+	}
+
+	if _, err := s.db.Exec(
+		"UPDATE graph_ingestions SET status = $1, completed_at = $2 WHERE id = $3",
+		"completed", time.Now(), ingestionID,
+	); err != nil {
+		fmt.Printf("Failed to update graph ingestion %d: %v\n", ingestionID, err)
+	}
+
+	// New entities change the communities global search summarizes
+	refresh, err := s.BuildCommunities(workspaceID)
+	if err != nil {
+		fmt.Printf("Failed to build communities for workspace %d: %v\n", workspaceID, err)
+		return
+	}
+	fmt.Printf("Refreshed communities for workspace %d: %d created, %d reused, %d removed\n",
+		workspaceID, refresh.Created, refresh.Reused, refresh.Removed)
+}
+
+func (s *GraphService) ingest(ingestionID, workspaceID int, documents []models.Document) error {
+	if _, err := s.db.Exec(
+		"UPDATE graph_ingestions SET status = $1 WHERE id = $2",
+		"processing", ingestionID,
+	); err != nil {
+		return err
+	}
+
+	// Chunk every document first so the total is known up front
+	chunksByDocument := make([][]string, len(documents))
+	total := 0
+	for i, doc := range documents {
+		chunks, err := s.llm.ChunkDocument(doc.FilePath)
+		if err != nil {
+			return fmt.Errorf("failed to chunk document %d: %w", doc.ID, err)
+		}
+		chunksByDocument[i] = chunks
+		total += len(chunks)
+	}
+
+	if _, err := s.db.Exec(
+		"UPDATE graph_ingestions SET total_chunks = $1 WHERE id = $2",
+		total, ingestionID,
+	); err != nil {
+		return err
+	}
+
+	for i, doc := range documents {
+		chunkIDs, err := s.replaceChunks(workspaceID, doc.ID, chunksByDocument[i])
+		if err != nil {
+			return fmt.Errorf("failed to store chunks of document %d: %w", doc.ID, err)
+		}
+		if err := s.extractChunks(ingestionID, workspaceID, chunkIDs, chunksByDocument[i]); err != nil {
+			return fmt.Errorf("failed to extract graph from document %d: %w", doc.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// replaceChunks stores the chunks of a document, dropping any chunks (and
+// with them, mentions) left from an earlier ingestion of the same document
+func (s *GraphService) replaceChunks(workspaceID, documentID int, chunks []string) ([]int, error) {
+	var staleIDs []int64
+	if err := s.db.QueryRow(
+		"SELECT COALESCE(array_agg(id), '{}') FROM graph_chunks WHERE document_id = $1",
+		documentID,
+	).Scan(pq.Array(&staleIDs)); err != nil {
+		return nil, err
+	}
+	if len(staleIDs) > 0 {
+		ids := make([]int, len(staleIDs))
+		for i, id := range staleIDs {
+			ids[i] = int(id)
+		}
+		if err := s.store.RemoveMentions(workspaceID, ids); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM graph_chunks WHERE document_id = $1", documentID); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(chunks))
+	for i, content := range chunks {
+		if err := tx.QueryRow(
+			`INSERT INTO graph_chunks (workspace_id, document_id, chunk_index, content)
+			 VALUES ($1, $2, $3, $4) RETURNING id`,
+			workspaceID, documentID, i, content,
+		).Scan(&ids[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, tx.Commit()
+}
+
+// extractChunks runs entity extraction over chunks with a bounded number of
+// workers. The first failure stops the remaining work.
+func (s *GraphService) extractChunks(ingestionID, workspaceID int, chunkIDs []int, chunks []string) error {
+	jobs := make(chan int)
+	errs := make(chan error, len(chunks))
+	done := make(chan struct{})
+	var once sync.Once
+
+	var wg sync.WaitGroup
+	for w := 0; w < graphExtractionWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := s.extractChunk(workspaceID, chunkIDs[i], chunks[i]); err != nil {
+					errs <- err
+					once.Do(func() { close(done) })
+					continue
+				}
+				if _, err := s.db.Exec(
+					"UPDATE graph_ingestions SET processed_chunks = processed_chunks + 1 WHERE id = $1",
+					ingestionID,
+				); err != nil {
+					fmt.Printf("Failed to update progress of graph ingestion %d: %v\n", ingestionID, err)
+				}
+			}
+		}()
+	}
+
+send:
+	for i := range chunks {
+		select {
+		case jobs <- i:
+		case <-done:
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+func (s *GraphService) extractChunk(workspaceID, chunkID int, content string) error {
+	extraction, err := s.llm.ExtractGraph(content)
+	if err != nil {
+		return err
+	}
+
+	entities, relations := NormalizeExtraction(extraction)
+	return s.store.MergeExtraction(workspaceID, chunkID, entities, relations)
+}
+
+// NormalizeExtraction cleans up a model extraction: names and types are
+// normalized, duplicate entities are merged, and relations are rewritten to
+// refer to entities by normalized name. Relation endpoints the model did not
+// also list as entities are added as untyped entities; self-loops and
+// duplicate relations are dropped. Both are returned sorted by key.
+func NormalizeExtraction(extraction *GraphExtraction) ([]ExtractedEntity, []ExtractedRelation) {
+	byName := map[string]*ExtractedEntity{}
+	add := func(name, entityType, description string) string {
+		key := NormalizeEntityName(name)
+		if key == "" {
+			return ""
+		}
+		entityType = NormalizeEntityType(entityType)
+		description = strings.TrimSpace(description)
+
+		existing, ok := byName[key]
+		if !ok {
+			byName[key] = &ExtractedEntity{Name: cleanEntityName(name), Type: entityType, Description: description}
+			return key
+		}
+		// Prefer a specific type over the default and the longest description
+		if existing.Type == defaultEntityType && entityType != defaultEntityType {
+			existing.Type = entityType
+		}
+		if len(description) > len(existing.Description) {
+			existing.Description = description
+		}
+		return key
+	}
+
+	for _, entity := range extraction.Entities {
+		add(entity.Name, entity.Type, entity.Description)
+	}
+
+	var relations []ExtractedRelation
+	seen := map[string]bool{}
+	for _, relation := range extraction.Relations {
+		source := add(relation.Source, "", "")
+		target := add(relation.Target, "", "")
+		if source == "" || target == "" || source == target {
+			continue
+		}
+
+		relationType := NormalizeEntityType(relation.Type)
+		if relation.Type == "" {
+			relationType = "RELATED_TO"
+		}
+		key := source + "\x00" + target + "\x00" + relationType
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		relations = append(relations, ExtractedRelation{
+			Source:      source,
+			Target:      target,
+			Type:        relationType,
+			Description: strings.TrimSpace(relation.Description),
+		})
+	}
+
+	sort.Slice(relations, func(i, j int) bool {
+		a, b := relations[i], relations[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		return a.Type < b.Type
+	})
+
+	keys := make([]string, 0, len(byName))
+	for key := range byName {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entities := make([]ExtractedEntity, len(keys))
+	for i, key := range keys {
+		entities[i] = *byName[key]
+	}
+
+	return entities, relations
+}
+
+// NormalizeEntityName builds the key entities are deduplicated on: case and
+// surrounding punctuation are dropped, inner whitespace is collapsed and a
+// leading article is removed, so "The  Acme Corp." and "acme corp" match.
+func NormalizeEntityName(name string) string {
+	name = strings.ToLower(cleanEntityName(name))
+	name = strings.TrimRight(name, ".")
+	for _, article := range []string{"the ", "a ", "an "} {
+		if strings.HasPrefix(name, article) && len(name) > len(article) {
+			name = name[len(article):]
+			break
+		}
+	}
+	return name
+}
+
+// NormalizeEntityType upper-cases a type and joins its words with
+// underscores, falling back to the default type when empty
+func NormalizeEntityType(entityType string) string {
+	entityType = strings.Join(strings.Fields(strings.ToUpper(entityType)), "_")
+	if entityType == "" {
+		return defaultEntityType
+	}
+	return entityType
+}
+
+// cleanEntityName trims quotes and punctuation around a name and collapses
+// its whitespace, keeping the original casing for display
+func cleanEntityName(name string) string {
+	name = strings.TrimFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || (unicode.IsPunct(r) && r != '.' && r != ')' && r != '&')
+	})
+	return strings.Join(strings.Fields(name), " ")
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"genai-platform/internal/models"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxSummaryNodes caps how many of a community's best connected
+	// entities are shown to the model when summarizing it
+	maxSummaryNodes = 50
+
+	// maxCommunityLevels is how many levels of the Louvain hierarchy are
+	// kept, counting down from the coarsest
+	maxCommunityLevels = 3
+
+	// communityLockClass namespaces the advisory lock taken while a workspace's
+	// communities are rewritten
+	communityLockClass = 7301
+)
+
+// CommunityRefresh reports what a rebuild changed
+type CommunityRefresh struct {
+	Levels      int `json:"levels"`
+	Created     int `json:"created"`
+	Reused      int `json:"reused"`
+	Removed     int `json:"removed"`
+	Communities int `json:"communities"`
+}
+
+// plannedCommunity is a community found by clustering, before it is stored
+type plannedCommunity struct {
+	level   int
+	nodeIDs []int
+	hash    string
+	parent  int // index into the previous level's plans, or -1
+	id      int
+	title   string
+	summary string
+}
+
+// BuildCommunities clusters the workspace's entity graph with the Louvain
+// method into a hierarchy of up to maxCommunityLevels levels, level 0 being
+// the coarsest, and stores an LLM summary for each community. Rebuilding is
+// incremental: a community whose membership did not change keeps its
+// stored summary, so only communities touched by new documents are sent to
+// the model again.
+func (s *GraphService) BuildCommunities(workspaceID int) (*CommunityRefresh, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Serialize rebuilds of the same workspace's graph, e.g. from two
+	// ingestions finishing together. The lock is taken before anything is
+	// read, so a waiting rebuild sees the communities the first one stored
+	// and reuses their summaries instead of creating duplicates.
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1, $2)", communityLockClass, workspaceID); err != nil {
+		return nil, err
+	}
+
+	graph, err := s.FullGraph(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	nodes, edges := graph.Nodes, graph.Edges
+
+	levels := planCommunities(nodes, edges)
+
+	existing, err := s.communities(workspaceID, -1)
+	if err != nil {
+		return nil, err
+	}
+	stored := map[string]models.GraphCommunity{}
+	for _, c := range existing {
+		stored[communityKey(c.Level, communityHash(c.NodeIDs))] = c
+	}
+
+	refresh := &CommunityRefresh{Levels: len(levels)}
+	kept := map[int]bool{}
+	for _, plans := range levels {
+		for i := range plans {
+			plan := &plans[i]
+			if c, ok := stored[communityKey(plan.level, plan.hash)]; ok {
+				plan.id, plan.title, plan.summary = c.ID, c.Title, c.Summary
+				kept[c.ID] = true
+				refresh.Reused++
+				continue
+			}
+
+			summary, err := s.summarizeCommunity(workspaceID, nodes, edges, plan.nodeIDs)
+			if err != nil {
+				return nil, err
+			}
+			plan.title, plan.summary = summary.Title, summary.Summary
+			refresh.Created++
+		}
+		refresh.Communities += len(plans)
+	}
+
+	var stale []int
+	for _, c := range existing {
+		if !kept[c.ID] {
+			stale = append(stale, c.ID)
+		}
+	}
+	refresh.Removed = len(stale)
+
+	if len(stale) > 0 {
+		if _, err := tx.Exec("DELETE FROM graph_communities WHERE id = ANY($1)", pq.Array(stale)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Store coarse levels first so every child can point at its parent
+	for l, plans := range levels {
+		for i := range plans {
+			plan := &plans[i]
+			var parentID *int
+			if plan.parent >= 0 {
+				parentID = &levels[l-1][plan.parent].id
+			}
+
+			if plan.id != 0 {
+				if _, err := tx.Exec(
+					"UPDATE graph_communities SET parent_id = $1 WHERE id = $2",
+					parentID, plan.id,
+				); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			if err := tx.QueryRow(
+				`INSERT INTO graph_communities (workspace_id, level, parent_id, node_ids, member_hash, title, summary)
+				 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+				workspaceID, plan.level, parentID, pq.Array(plan.nodeIDs), plan.hash, plan.title, plan.summary,
+			).Scan(&plan.id); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return refresh, tx.Commit()
+}
+
+// planCommunities runs Louvain over the entity graph and turns each pass
+// into a level of communities, coarsest first. Edges are weighted by how
+// many chunks mention them. Singleton communities are left out since an
+// isolated entity has no theme worth summarizing.
+func planCommunities(nodes []models.GraphNode, edges []models.GraphEdge) [][]plannedCommunity {
+	index := map[int]int{}
+	for i, node := range nodes {
+		index[node.ID] = i
+	}
+
+	g := newLouvainGraph(len(nodes))
+	for _, edge := range edges {
+		a, okA := index[edge.SourceID]
+		b, okB := index[edge.TargetID]
+		if !okA || !okB || a == b {
+			continue
+		}
+		weight := float64(edge.Weight)
+		if weight < 1 {
+			weight = 1
+		}
+		g.addEdge(a, b, weight)
+	}
+
+	passes := louvain(g)
+	if len(passes) > maxCommunityLevels {
+		passes = passes[len(passes)-maxCommunityLevels:]
+	}
+
+	levels := make([][]plannedCommunity, len(passes))
+	// position maps a community index of a pass to its plan index
+	var parentPosition map[int]int
+	var parentPartition []int
+	for level := range passes {
+		partition := passes[len(passes)-1-level]
+
+		members := map[int][]int{}
+		var order []int
+		for i, c := range partition {
+			if _, ok := members[c]; !ok {
+				order = append(order, c)
+			}
+			members[c] = append(members[c], nodes[i].ID)
+		}
+
+		position := map[int]int{}
+		for _, c := range order {
+			ids := members[c]
+			if len(ids) < 2 {
+				continue
+			}
+			sort.Ints(ids)
+
+			parent := -1
+			if parentPartition != nil {
+				// Louvain levels nest, so any member identifies the parent
+				parent = parentPosition[parentPartition[index[ids[0]]]]
+			}
+
+			position[c] = len(levels[level])
+			levels[level] = append(levels[level], plannedCommunity{
+				level:   level,
+				nodeIDs: ids,
+				hash:    communityHash(ids),
+				parent:  parent,
+			})
+		}
+
+		parentPosition = position
+		parentPartition = partition
+	}
+
+	return levels
+}
+
+// summarizeCommunity asks the model to summarize the entities in group and
+// the relations between them, keeping only the best connected entities of
+// large groups
+func (s *GraphService) summarizeCommunity(workspaceID int, nodes []models.GraphNode, edges []models.GraphEdge, group []int) (*CommunitySummary, error) {
+	members := map[int]bool{}
+	for _, id := range group {
+		members[id] = true
+	}
+
+	degree := map[int]int{}
+	for _, edge := range edges {
+		if members[edge.SourceID] && members[edge.TargetID] {
+			degree[edge.SourceID] += edge.Weight
+			degree[edge.TargetID] += edge.Weight
+		}
+	}
+
+	ranked := append([]int(nil), group...)
+	sort.SliceStable(ranked, func(i, j int) bool { return degree[ranked[i]] > degree[ranked[j]] })
+	if len(ranked) > maxSummaryNodes {
+		ranked = ranked[:maxSummaryNodes]
+	}
+	shown := map[int]bool{}
+	for _, id := range ranked {
+		shown[id] = true
+	}
+
+	byID := map[int]models.GraphNode{}
+	for _, node := range nodes {
+		byID[node.ID] = node
+	}
+
+	var entities []ExtractedEntity
+	for _, id := range ranked {
+		node := byID[id]
+		entities = append(entities, ExtractedEntity{Name: node.Name, Type: node.Type, Description: node.Description})
+	}
+	var relations []ExtractedRelation
+	for _, edge := range edges {
+		if shown[edge.SourceID] && shown[edge.TargetID] {
+			relations = append(relations, ExtractedRelation{
+				Source:      byID[edge.SourceID].Name,
+				Target:      byID[edge.TargetID].Name,
+				Type:        edge.Type,
+				Description: edge.Description,
+			})
+		}
+	}
+
+	return s.llm.SummarizeCommunity(entities, relations)
+}
+
+// communityHash fingerprints a community's membership so an unchanged
+// community can be recognized across rebuilds
+func communityHash(nodeIDs []int) string {
+	ids := append([]int(nil), nodeIDs...)
+	sort.Ints(ids)
+
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+func communityKey(level int, hash string) string {
+	return fmt.Sprintf("%d:%s", level, hash)
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"database/sql"
+
+	"genai-platform/internal/models"
+)
+
+// maxNeighborhoodNodes caps how many nodes a neighborhood lookup returns
+const maxNeighborhoodNodes = 200
+
+// EntityNeighborhood is an entity together with the part of the graph
+// within a number of hops of it
+type EntityNeighborhood struct {
+	Entity   models.GraphNode `json:"entity"`
+	Depth    int              `json:"depth"`
+	Subgraph Subgraph         `json:"subgraph"`
+}
+
+// GraphPath is a shortest path between two entities. Nodes are listed from
+// source to target and Edges[i] joins Nodes[i] and Nodes[i+1].
+type GraphPath struct {
+	Length int                `json:"length"`
+	Nodes  []models.GraphNode `json:"nodes"`
+	Edges  []models.GraphEdge `json:"edges"`
+}
+
+// SearchEntities finds the workspace's entities whose name contains query,
+// optionally restricted to one type. Exact matches come first, then names
+// starting with the query, then the best connected entities.
+func (s *GraphService) SearchEntities(workspaceID int, query, entityType string, limit, offset int) ([]models.GraphNode, error) {
+	query = NormalizeEntityName(query)
+	if entityType != "" {
+		entityType = NormalizeEntityType(entityType)
+	}
+
+	return s.store.SearchNodes(workspaceID, query, entityType, limit, offset)
+}
+
+// Neighborhood returns an entity and its neighbors up to depth hops away.
+// sql.ErrNoRows is returned when the workspace has no such entity.
+func (s *GraphService) Neighborhood(workspaceID, entityID, depth int) (*EntityNeighborhood, error) {
+	entity, err := s.node(workspaceID, entityID)
+	if err != nil {
+		return nil, err
+	}
+
+	subgraph, err := s.expand(workspaceID, []int{entityID}, depth, maxNeighborhoodNodes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EntityNeighborhood{Entity: *entity, Depth: depth, Subgraph: *subgraph}, nil
+}
+
+// ShortestPath finds a shortest path between two entities, following edges
+// in either direction, with a breadth-first search that gives up after
+// maxDepth hops. A nil path means the entities are not connected within
+// that distance; sql.ErrNoRows means one of them does not exist.
+func (s *GraphService) ShortestPath(workspaceID, fromID, toID, maxDepth int) (*GraphPath, error) {
+	from, err := s.node(workspaceID, fromID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.node(workspaceID, toID); err != nil {
+		return nil, err
+	}
+	if fromID == toID {
+		return &GraphPath{Nodes: []models.GraphNode{*from}, Edges: []models.GraphEdge{}}, nil
+	}
+
+	// via maps each reached node to the edge it was first reached through
+	via := map[int]models.GraphEdge{}
+	visited := map[int]bool{fromID: true}
+	frontier := []int{fromID}
+	found := false
+	for hop := 0; hop < maxDepth && len(frontier) > 0 && !found; hop++ {
+		edges, err := s.store.EdgesTouching(workspaceID, frontier)
+		if err != nil {
+			return nil, err
+		}
+
+		inFrontier := map[int]bool{}
+		for _, id := range frontier {
+			inFrontier[id] = true
+		}
+
+		var next []int
+		for _, edge := range edges {
+			for _, step := range [][2]int{{edge.SourceID, edge.TargetID}, {edge.TargetID, edge.SourceID}} {
+				if !inFrontier[step[0]] || visited[step[1]] {
+					continue
+				}
+				visited[step[1]] = true
+				via[step[1]] = edge
+				next = append(next, step[1])
+				if step[1] == toID {
+					found = true
+				}
+			}
+		}
+		frontier = next
+	}
+	if !found {
+		return nil, nil
+	}
+
+	// Walk back from the target to recover the path
+	var edges []models.GraphEdge
+	ids := []int{toID}
+	for id := toID; id != fromID; {
+		edge := via[id]
+		edges = append(edges, edge)
+		if edge.SourceID == id {
+			id = edge.TargetID
+		} else {
+			id = edge.SourceID
+		}
+		ids = append(ids, id)
+	}
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	for i, j := 0, len(edges)-1; i < j; i, j = i+1, j-1 {
+		edges[i], edges[j] = edges[j], edges[i]
+	}
+
+	nodes, err := s.store.Nodes(workspaceID, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int]models.GraphNode{}
+	for _, node := range nodes {
+		byID[node.ID] = node
+	}
+	path := &GraphPath{Length: len(edges), Edges: edges}
+	for _, id := range ids {
+		path.Nodes = append(path.Nodes, byID[id])
+	}
+
+	return path, nil
+}
+
+// FullGraph loads every entity and relation of the workspace's graph
+func (s *GraphService) FullGraph(workspaceID int) (*Subgraph, error) {
+	return s.store.Graph(workspaceID)
+}
+
+// node loads one of the workspace's entities, returning sql.ErrNoRows when
+// it does not exist
+func (s *GraphService) node(workspaceID, nodeID int) (*models.GraphNode, error) {
+	nodes, err := s.store.Nodes(workspaceID, []int{nodeID})
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &nodes[0], nil
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var graphExportFormats = map[string]ExportFormat{
+	"graphml":   {ContentType: "application/graphml+xml", Extension: "graphml"},
+	"gexf":      {ContentType: "application/gexf+xml", Extension: "gexf"},
+	"cytoscape": {ContentType: "application/json", Extension: "cyjs"},
+}
+
+// LookupGraphExportFormat returns the graph export format registered under
+// name
+func LookupGraphExportFormat(name string) (ExportFormat, bool) {
+	format, ok := graphExportFormats[name]
+	return format, ok
+}
+
+// WriteGraph serializes a graph in the named export format. Relations are
+// exported as directed edges carrying their type, description and weight,
+// the number of chunks that mention them.
+func WriteGraph(name string, out io.Writer, graph *Subgraph) error {
+	switch name {
+	case "graphml":
+		return writeGraphML(out, graph)
+	case "gexf":
+		return writeGEXF(out, graph)
+	case "cytoscape":
+		return writeCytoscape(out, graph)
+	}
+	return fmt.Errorf("unsupported graph export format: %s", name)
+}
+
+func writeGraphML(out io.Writer, graph *Subgraph) error {
+	w := bufio.NewWriter(out)
+
+	w.WriteString(xml.Header)
+	w.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	w.WriteString(`  <key id="name" for="node" attr.name="name" attr.type="string"/>` + "\n")
+	w.WriteString(`  <key id="type" for="node" attr.name="type" attr.type="string"/>` + "\n")
+	w.WriteString(`  <key id="description" for="node" attr.name="description" attr.type="string"/>` + "\n")
+	w.WriteString(`  <key id="relation" for="edge" attr.name="type" attr.type="string"/>` + "\n")
+	w.WriteString(`  <key id="relation_description" for="edge" attr.name="description" attr.type="string"/>` + "\n")
+	w.WriteString(`  <key id="weight" for="edge" attr.name="weight" attr.type="int"/>` + "\n")
+	w.WriteString(`  <graph id="knowledge_graph" edgedefault="directed">` + "\n")
+
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(w, `    <node id="n%d">`+"\n", node.ID)
+		writeGraphMLData(w, "name", node.Name)
+		writeGraphMLData(w, "type", node.Type)
+		writeGraphMLData(w, "description", node.Description)
+		w.WriteString("    </node>\n")
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(w, `    <edge id="e%d" source="n%d" target="n%d">`+"\n", edge.ID, edge.SourceID, edge.TargetID)
+		writeGraphMLData(w, "relation", edge.Type)
+		writeGraphMLData(w, "relation_description", edge.Description)
+		writeGraphMLData(w, "weight", strconv.Itoa(edge.Weight))
+		w.WriteString("    </edge>\n")
+	}
+
+	w.WriteString("  </graph>\n</graphml>\n")
+	return w.Flush()
+}
+
+func writeGEXF(out io.Writer, graph *Subgraph) error {
+	w := bufio.NewWriter(out)
+
+	w.WriteString(xml.Header)
+	w.WriteString(`<gexf xmlns="http://gexf.net/1.3" version="1.3">` + "\n")
+	w.WriteString(`  <graph mode="static" defaultedgetype="directed">` + "\n")
+	w.WriteString(`    <attributes class="node">` + "\n")
+	w.WriteString(`      <attribute id="type" title="type" type="string"/>` + "\n")
+	w.WriteString(`      <attribute id="description" title="description" type="string"/>` + "\n")
+	w.WriteString("    </attributes>\n")
+	w.WriteString(`    <attributes class="edge">` + "\n")
+	w.WriteString(`      <attribute id="description" title="description" type="string"/>` + "\n")
+	w.WriteString("    </attributes>\n")
+
+	w.WriteString("    <nodes>\n")
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(w, `      <node id="n%d" label="%s">`+"\n", node.ID, escapeXML(node.Name))
+		w.WriteString("        <attvalues>\n")
+		fmt.Fprintf(w, `          <attvalue for="type" value="%s"/>`+"\n", escapeXML(node.Type))
+		fmt.Fprintf(w, `          <attvalue for="description" value="%s"/>`+"\n", escapeXML(node.Description))
+		w.WriteString("        </attvalues>\n")
+		w.WriteString("      </node>\n")
+	}
+	w.WriteString("    </nodes>\n")
+
+	w.WriteString("    <edges>\n")
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(w, `      <edge id="e%d" source="n%d" target="n%d" label="%s" weight="%d">`+"\n",
+			edge.ID, edge.SourceID, edge.TargetID, escapeXML(edge.Type), edge.Weight)
+		w.WriteString("        <attvalues>\n")
+		fmt.Fprintf(w, `          <attvalue for="description" value="%s"/>`+"\n", escapeXML(edge.Description))
+		w.WriteString("        </attvalues>\n")
+		w.WriteString("      </edge>\n")
+	}
+	w.WriteString("    </edges>\n")
+
+	w.WriteString("  </graph>\n</gexf>\n")
+	return w.Flush()
+}
+
+// writeCytoscape writes the elements JSON format read by Cytoscape and
+// cytoscape.js
+func writeCytoscape(out io.Writer, graph *Subgraph) error {
+	type element struct {
+		Data map[string]interface{} `json:"data"`
+	}
+
+	nodes := make([]element, len(graph.Nodes))
+	for i, node := range graph.Nodes {
+		nodes[i] = element{Data: map[string]interface{}{
+			"id":          "n" + strconv.Itoa(node.ID),
+			"label":       node.Name,
+			"type":        node.Type,
+			"description": node.Description,
+		}}
+	}
+	edges := make([]element, len(graph.Edges))
+	for i, edge := range graph.Edges {
+		edges[i] = element{Data: map[string]interface{}{
+			"id":          "e" + strconv.Itoa(edge.ID),
+			"source":      "n" + strconv.Itoa(edge.SourceID),
+			"target":      "n" + strconv.Itoa(edge.TargetID),
+			"label":       edge.Type,
+			"description": edge.Description,
+			"weight":      edge.Weight,
+		}}
+	}
+
+	return json.NewEncoder(out).Encode(map[string]interface{}{
+		"elements": map[string]interface{}{"nodes": nodes, "edges": edges},
+	})
+}
+
+func writeGraphMLData(w *bufio.Writer, key, value string) {
+	fmt.Fprintf(w, `      <data key="%s">%s</data>`+"\n", key, escapeXML(value))
+}
+
+// escapeXML escapes text for use in both element content and attribute
+// values
+func escapeXML(value string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
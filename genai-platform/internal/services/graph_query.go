@@ -0,0 +1,397 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"genai-platform/internal/models"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxSeedEntities caps how many entities matched in a question start a
+	// local search
+	maxSeedEntities = 10
+
+	// maxLocalNodes caps the neighborhood a local search expands to
+	maxLocalNodes = 50
+
+	// maxLocalChunks is how many source chunks are added to local context
+	maxLocalChunks = 5
+
+	// maxGlobalPartials is how many community answers are combined by the
+	// reduce step of a global search
+	maxGlobalPartials = 10
+
+	// maxGlobalSubgraphCommunities is how many of the most relevant
+	// communities are drawn in the subgraph of a global answer
+	maxGlobalSubgraphCommunities = 3
+)
+
+// Search modes accepted by /graph/query
+const (
+	GraphSearchLocal  = "local"
+	GraphSearchGlobal = "global"
+)
+
+// Subgraph is the part of the knowledge graph an answer was built from
+type Subgraph struct {
+	Nodes []models.GraphNode `json:"nodes"`
+	Edges []models.GraphEdge `json:"edges"`
+}
+
+// CommunityReference is a community used by a global answer and how
+// relevant the model rated it
+type CommunityReference struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Score int    `json:"score"`
+}
+
+type GraphAnswer struct {
+	Mode        string               `json:"mode"`
+	Answer      string               `json:"answer"`
+	Subgraph    Subgraph             `json:"subgraph"`
+	Chunks      []models.GraphChunk  `json:"chunks,omitempty"`
+	Communities []CommunityReference `json:"communities,omitempty"`
+}
+
+var questionStopwords = map[string]bool{
+	"what": true, "which": true, "who": true, "whom": true, "where": true, "when": true,
+	"does": true, "about": true, "with": true, "from": true, "that": true, "this": true,
+	"there": true, "their": true, "have": true, "were": true, "they": true, "into": true,
+	"between": true, "these": true, "those": true, "tell": true, "how": true, "many": true,
+}
+
+// LocalSearch answers a question about specific entities. Entities named in
+// the question seed the search, which expands to their neighborhood up to
+// depth hops and pulls in the chunks the seeds were extracted from.
+func (s *GraphService) LocalSearch(workspaceID int, query string, depth int) (*GraphAnswer, error) {
+	seeds, err := s.matchNodes(workspaceID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	answer := &GraphAnswer{Mode: GraphSearchLocal, Subgraph: Subgraph{Nodes: []models.GraphNode{}, Edges: []models.GraphEdge{}}}
+	if len(seeds) == 0 {
+		answer.Answer = "I could not find any entities from your question in the knowledge graph."
+		return answer, nil
+	}
+
+	seedIDs := make([]int, len(seeds))
+	for i, node := range seeds {
+		seedIDs[i] = node.ID
+	}
+
+	subgraph, err := s.expand(workspaceID, seedIDs, depth, maxLocalNodes)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := s.chunksMentioning(workspaceID, seedIDs, maxLocalChunks)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.llm.GenerateResponse(query, localContext(subgraph, chunks))
+	if err != nil {
+		return nil, err
+	}
+
+	answer.Answer = response
+	answer.Subgraph = *subgraph
+	answer.Chunks = chunks
+	return answer, nil
+}
+
+// GlobalSearch answers a question about the corpus as a whole by
+// map-reducing over community summaries: each summary produces a scored
+// partial answer and the most relevant ones are combined. Level picks how
+// coarse the communities are, 0 being the broadest themes.
+func (s *GraphService) GlobalSearch(workspaceID int, query string, level int) (*GraphAnswer, error) {
+	communities, err := s.communities(workspaceID, level)
+	if err != nil {
+		return nil, err
+	}
+	if len(communities) == 0 && level == 0 {
+		if _, err := s.BuildCommunities(workspaceID); err != nil {
+			return nil, err
+		}
+		if communities, err = s.communities(workspaceID, level); err != nil {
+			return nil, err
+		}
+	}
+
+	answer := &GraphAnswer{Mode: GraphSearchGlobal, Subgraph: Subgraph{Nodes: []models.GraphNode{}, Edges: []models.GraphEdge{}}}
+	if len(communities) == 0 {
+		answer.Answer = "The knowledge graph is empty. Upload documents to build it first."
+		return answer, nil
+	}
+
+	type partial struct {
+		community models.GraphCommunity
+		answer    string
+		score     int
+	}
+
+	// Map each community summary to a partial answer
+	partials := make([]partial, len(communities))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < graphExtractionWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				partials[i].community = communities[i]
+				result, err := s.llm.GraphMapAnswer(query, communities[i].Summary)
+				if err != nil {
+					fmt.Printf("Failed to map community %d: %v\n", communities[i].ID, err)
+					continue
+				}
+				partials[i].answer = result.Answer
+				partials[i].score = result.Score
+			}
+		}()
+	}
+	for i := range communities {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.SliceStable(partials, func(i, j int) bool { return partials[i].score > partials[j].score })
+
+	// Reduce the most relevant partial answers into the final answer
+	var answers []string
+	var nodeIDs []int
+	for i, p := range partials {
+		if p.score <= 0 || len(answers) >= maxGlobalPartials {
+			break
+		}
+		answers = append(answers, p.answer)
+		answer.Communities = append(answer.Communities, CommunityReference{ID: p.community.ID, Title: p.community.Title, Score: p.score})
+		if i < maxGlobalSubgraphCommunities {
+			nodeIDs = append(nodeIDs, p.community.NodeIDs...)
+		}
+	}
+	if len(answers) == 0 {
+		answer.Answer = "None of the topics in the knowledge graph are relevant to your question."
+		return answer, nil
+	}
+
+	response, err := s.llm.GraphReduceAnswer(query, answers)
+	if err != nil {
+		return nil, err
+	}
+	answer.Answer = response
+
+	if len(nodeIDs) > maxLocalNodes {
+		nodeIDs = nodeIDs[:maxLocalNodes]
+	}
+	subgraph, err := s.subgraph(workspaceID, nodeIDs)
+	if err != nil {
+		return nil, err
+	}
+	answer.Subgraph = *subgraph
+
+	return answer, nil
+}
+
+// matchNodes finds entities whose name appears in the question, falling
+// back to entities whose name contains one of the question's keywords
+func (s *GraphService) matchNodes(workspaceID int, query string) ([]models.GraphNode, error) {
+	normalized := strings.Join(strings.Fields(strings.ToLower(query)), " ")
+
+	nodes, err := s.store.NodesInText(workspaceID, normalized, maxSeedEntities)
+	if err != nil || len(nodes) > 0 {
+		return nodes, err
+	}
+
+	var keywords []string
+	for _, word := range strings.FieldsFunc(normalized, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9')
+	}) {
+		if len(word) >= 4 && !questionStopwords[word] {
+			keywords = append(keywords, word)
+		}
+	}
+	if len(keywords) == 0 {
+		return nil, nil
+	}
+
+	return s.store.NodesMatching(workspaceID, keywords, maxSeedEntities)
+}
+
+// expand walks outward from the seed nodes one hop at a time, following
+// the most frequently mentioned edges first, until depth is reached or the
+// neighborhood holds maxNodes nodes
+func (s *GraphService) expand(workspaceID int, seedIDs []int, depth, maxNodes int) (*Subgraph, error) {
+	included := map[int]bool{}
+	for _, id := range seedIDs {
+		included[id] = true
+	}
+
+	edges := []models.GraphEdge{}
+	seenEdges := map[int]bool{}
+	frontier := seedIDs
+	for hop := 0; hop < depth && len(frontier) > 0 && len(included) < maxNodes; hop++ {
+		hopEdges, err := s.store.EdgesTouching(workspaceID, frontier)
+		if err != nil {
+			return nil, err
+		}
+
+		var next []int
+		for _, edge := range hopEdges {
+			if seenEdges[edge.ID] {
+				continue
+			}
+			for _, id := range []int{edge.SourceID, edge.TargetID} {
+				if !included[id] && len(included) < maxNodes {
+					included[id] = true
+					next = append(next, id)
+				}
+			}
+			if included[edge.SourceID] && included[edge.TargetID] {
+				seenEdges[edge.ID] = true
+				edges = append(edges, edge)
+			}
+		}
+		frontier = next
+	}
+
+	ids := make([]int, 0, len(included))
+	for id := range included {
+		ids = append(ids, id)
+	}
+	nodes, err := s.store.Nodes(workspaceID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Subgraph{Nodes: nodes, Edges: edges}, nil
+}
+
+// subgraph loads the given nodes and the edges between them
+func (s *GraphService) subgraph(workspaceID int, nodeIDs []int) (*Subgraph, error) {
+	nodes, err := s.store.Nodes(workspaceID, nodeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	edges, err := s.store.EdgesBetween(workspaceID, nodeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Subgraph{Nodes: nodes, Edges: edges}, nil
+}
+
+// chunksMentioning returns the chunks that mention the most of the given
+// nodes
+func (s *GraphService) chunksMentioning(workspaceID int, nodeIDs []int, limit int) ([]models.GraphChunk, error) {
+	ids, err := s.store.MentioningChunks(workspaceID, nodeIDs, limit)
+	if err != nil || len(ids) == 0 {
+		return []models.GraphChunk{}, err
+	}
+
+	rows, err := s.db.Query(
+		`SELECT id, document_id, chunk_index, content FROM graph_chunks
+		 WHERE workspace_id = $1 AND id = ANY($2)
+		 ORDER BY array_position($2, id)`,
+		workspaceID, pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := []models.GraphChunk{}
+	for rows.Next() {
+		var chunk models.GraphChunk
+		if err := rows.Scan(&chunk.ID, &chunk.DocumentID, &chunk.ChunkIndex, &chunk.Content); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+// Communities lists the workspace's communities at a level of the
+// hierarchy, or at every level when level is negative
+func (s *GraphService) Communities(workspaceID, level int) ([]models.GraphCommunity, error) {
+	return s.communities(workspaceID, level)
+}
+
+func (s *GraphService) communities(workspaceID, level int) ([]models.GraphCommunity, error) {
+	rows, err := s.db.Query(
+		`SELECT id, level, parent_id, node_ids, title, summary, updated_at
+		 FROM graph_communities WHERE workspace_id = $1 AND ($2 < 0 OR level = $2)
+		 ORDER BY level, id`,
+		workspaceID, level,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	communities := []models.GraphCommunity{}
+	for rows.Next() {
+		var c models.GraphCommunity
+		var parentID sql.NullInt64
+		var nodeIDs []int64
+		if err := rows.Scan(&c.ID, &c.Level, &parentID, pq.Array(&nodeIDs), &c.Title, &c.Summary, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			c.ParentID = &id
+		}
+		c.WorkspaceID = workspaceID
+		c.NodeIDs = make([]int, len(nodeIDs))
+		for i, id := range nodeIDs {
+			c.NodeIDs[i] = int(id)
+		}
+		communities = append(communities, c)
+	}
+	return communities, rows.Err()
+}
+
+// localContext renders a neighborhood and its source chunks as the context
+// passed to the model
+func localContext(subgraph *Subgraph, chunks []models.GraphChunk) string {
+	names := map[int]string{}
+	var b strings.Builder
+
+	b.WriteString("Entities:\n")
+	for _, node := range subgraph.Nodes {
+		names[node.ID] = node.Name
+		fmt.Fprintf(&b, "- %s (%s)", node.Name, node.Type)
+		if node.Description != "" {
+			fmt.Fprintf(&b, ": %s", node.Description)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(subgraph.Edges) > 0 {
+		b.WriteString("\nRelationships:\n")
+		for _, edge := range subgraph.Edges {
+			fmt.Fprintf(&b, "- %s %s %s", names[edge.SourceID], edge.Type, names[edge.TargetID])
+			if edge.Description != "" {
+				fmt.Fprintf(&b, ": %s", edge.Description)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	if len(chunks) > 0 {
+		b.WriteString("\nSources:\n")
+		for _, chunk := range chunks {
+			fmt.Fprintf(&b, "[document %d, chunk %d]\n%s\n\n", chunk.DocumentID, chunk.ChunkIndex, chunk.Content)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+
+	"genai-platform/internal/models"
+)
+
+// Graph store backends selectable with GRAPH_STORE
+const (
+	GraphStorePostgres = "postgres"
+	GraphStoreNeo4j    = "neo4j"
+)
+
+// GraphStore persists the entity graph extracted from documents: entities,
+// the relations between them and which chunks mention each. Chunks,
+// ingestions and community summaries stay in Postgres whichever store is
+// used; they refer to entities by the integer ids a store assigns.
+//
+// Entities are unique per workspace by normalized name and type. A
+// relation's weight is the number of chunks that mention it.
+type GraphStore interface {
+	// MergeExtraction merges a chunk's normalized entities and relations,
+	// as returned by NormalizeExtraction, into the workspace's graph and
+	// records that the chunk mentions them. An untyped entity attaches to an
+	// existing entity of the same name, preferring a typed one. Merging the
+	// same chunk twice has no further effect.
+	MergeExtraction(workspaceID, chunkID int, entities []ExtractedEntity, relations []ExtractedRelation) error
+
+	// RemoveMentions forgets every mention made by the given chunks, e.g.
+	// before their document is ingested again. Entities and relations
+	// themselves are kept.
+	RemoveMentions(workspaceID int, chunkIDs []int) error
+
+	// Nodes loads the workspace's entities with the given ids, ordered by id
+	Nodes(workspaceID int, ids []int) ([]models.GraphNode, error)
+
+	// NodesInText finds entities whose normalized name, when longer than
+	// two characters, occurs in text, longest names first
+	NodesInText(workspaceID int, text string, limit int) ([]models.GraphNode, error)
+
+	// NodesMatching finds entities whose normalized name contains any of
+	// keywords, ordered by id
+	NodesMatching(workspaceID int, keywords []string, limit int) ([]models.GraphNode, error)
+
+	// SearchNodes finds entities whose normalized name contains query and,
+	// when entityType is set, of that type. Exact matches come first, then
+	// prefix matches, then the most mentioned entities.
+	SearchNodes(workspaceID int, query, entityType string, limit, offset int) ([]models.GraphNode, error)
+
+	// EdgesTouching returns the relations with either endpoint in nodeIDs,
+	// heaviest first
+	EdgesTouching(workspaceID int, nodeIDs []int) ([]models.GraphEdge, error)
+
+	// EdgesBetween returns the relations with both endpoints in nodeIDs,
+	// ordered by id
+	EdgesBetween(workspaceID int, nodeIDs []int) ([]models.GraphEdge, error)
+
+	// MentioningChunks ranks the ids of chunks by how many of nodeIDs they
+	// mention, most first
+	MentioningChunks(workspaceID int, nodeIDs []int, limit int) ([]int, error)
+
+	// Graph loads all of the workspace's entities and relations, ordered by id
+	Graph(workspaceID int) (*Subgraph, error)
+
+	// Clear deletes the workspace's whole graph
+	Clear(workspaceID int) error
+
+	Close() error
+}
+
+// Neo4jConfig holds the connection settings of the Neo4j graph store
+type Neo4jConfig struct {
+	URL      string
+	Username string
+	Password string
+	Database string
+}
+
+// OpenGraphStore creates the graph store named by backend. The Postgres
+// store keeps the graph in the application database; the Neo4j store
+// connects over Bolt and fails when the server cannot be reached. Graphs of
+// the Postgres store were moved into workspaces by a migration; the Neo4j
+// store's are moved here, into each user's personal workspace.
+func OpenGraphStore(db *sql.DB, backend string, neo4j Neo4jConfig) (GraphStore, error) {
+	switch backend {
+	case "", GraphStorePostgres:
+		return NewPostgresGraphStore(db), nil
+	case GraphStoreNeo4j:
+		store, err := NewNeo4jGraphStore(neo4j)
+		if err != nil {
+			return nil, err
+		}
+		if err := adoptUserGraphs(db, store); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to move neo4j graphs into workspaces: %w", err)
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("unsupported graph store: %s", backend)
+}
+
+// adoptUserGraphs gives the Neo4j store each user's personal workspace
+func adoptUserGraphs(db *sql.DB, store *Neo4jGraphStore) error {
+	rows, err := db.Query("SELECT created_by, id FROM workspaces WHERE personal")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	workspaceByUser := map[int]int{}
+	for rows.Next() {
+		var userID, workspaceID int
+		if err := rows.Scan(&userID, &workspaceID); err != nil {
+			return err
+		}
+		workspaceByUser[userID] = workspaceID
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return store.AdoptUserGraphs(workspaceByUser)
+}
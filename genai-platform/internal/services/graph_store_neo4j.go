@@ -0,0 +1,415 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"genai-platform/internal/models"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// neo4jSchema creates the constraint entities are merged on and the index
+// they are looked up by. Both statements are idempotent. Entities were
+// keyed by user before workspaces; those keys are left behind unused.
+var neo4jSchema = []string{
+	`CREATE CONSTRAINT graph_entity_workspace_key IF NOT EXISTS
+	 FOR (e:Entity) REQUIRE (e.workspace_id, e.normalized_name, e.type) IS UNIQUE`,
+	`CREATE INDEX graph_entity_workspace_id IF NOT EXISTS FOR (e:Entity) ON (e.workspace_id, e.id)`,
+	`CREATE CONSTRAINT graph_sequence_name IF NOT EXISTS
+	 FOR (s:GraphSequence) REQUIRE s.name IS UNIQUE`,
+}
+
+// neo4jNextID assigns x.id from a named counter node when x has none yet.
+// Integer ids keep the API, community membership and exports identical to
+// the Postgres store.
+const neo4jNextID = `
+	FOREACH (_ IN CASE WHEN x.id IS NULL THEN [1] ELSE [] END |
+		MERGE (s:GraphSequence {name: $sequence})
+		SET s.value = coalesce(s.value, 0) + 1
+		SET x.id = s.value)`
+
+const (
+	neo4jNodeFields = `e.id AS id, e.name AS name, e.normalized_name AS normalized_name,
+		e.type AS type, e.description AS description`
+	neo4jEdgeFields = `r.id AS id, a.id AS source_id, b.id AS target_id, r.type AS type,
+		r.description AS description, size(r.chunk_ids) AS weight`
+)
+
+// Neo4jGraphStore keeps the graph in Neo4j over Bolt. Entities are
+// (:Entity) nodes and relations are [:RELATES] relationships carrying the
+// relation type as a property; each records the ids of the chunks that
+// mention it in chunk_ids.
+type Neo4jGraphStore struct {
+	driver   neo4j.DriverWithContext
+	database string
+}
+
+// NewNeo4jGraphStore connects to Neo4j and makes sure the constraints the
+// store relies on exist
+func NewNeo4jGraphStore(cfg Neo4jConfig) (*Neo4jGraphStore, error) {
+	driver, err := neo4j.NewDriverWithContext(cfg.URL, neo4j.BasicAuth(cfg.Username, cfg.Password, ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create neo4j driver: %w", err)
+	}
+
+	ctx := context.Background()
+	if err := driver.VerifyConnectivity(ctx); err != nil {
+		driver.Close(ctx)
+		return nil, fmt.Errorf("failed to connect to neo4j: %w", err)
+	}
+
+	store := &Neo4jGraphStore{driver: driver, database: cfg.Database}
+	for _, statement := range neo4jSchema {
+		if _, err := store.query(statement, nil); err != nil {
+			driver.Close(ctx)
+			return nil, fmt.Errorf("failed to create neo4j schema: %w", err)
+		}
+	}
+
+	return store, nil
+}
+
+// MergeExtraction merges the chunk's entities and relations in one write
+// transaction, which the driver retries on transient errors such as
+// deadlocks between concurrent chunks
+func (n *Neo4jGraphStore) MergeExtraction(workspaceID, chunkID int, entities []ExtractedEntity, relations []ExtractedRelation) error {
+	ctx := context.Background()
+	session := n.driver.NewSession(ctx, neo4j.SessionConfig{DatabaseName: n.database, AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	_, err := session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		nodeIDs := map[string]int64{}
+		for _, entity := range entities {
+			key := NormalizeEntityName(entity.Name)
+			params := map[string]any{
+				"workspace_id": int64(workspaceID),
+				"chunk_id":     int64(chunkID),
+				"key":          key,
+				"name":         entity.Name,
+				"type":         entity.Type,
+				"description":  entity.Description,
+				"default":      defaultEntityType,
+				"sequence":     "entity",
+			}
+
+			// An untyped entity is most likely a reference to a typed one
+			// seen in another chunk, so attach to that before creating one
+			var record *neo4j.Record
+			if entity.Type == defaultEntityType {
+				result, err := tx.Run(ctx,
+					`MATCH (x:Entity {workspace_id: $workspace_id, normalized_name: $key})
+					 WITH x ORDER BY x.type = $default, x.id LIMIT 1
+					 SET x.chunk_ids = CASE WHEN $chunk_id IN x.chunk_ids THEN x.chunk_ids ELSE x.chunk_ids + $chunk_id END
+					 RETURN x.id AS id`,
+					params,
+				)
+				if err != nil {
+					return nil, err
+				}
+				records, err := result.Collect(ctx)
+				if err != nil {
+					return nil, err
+				}
+				if len(records) > 0 {
+					record = records[0]
+				}
+			}
+
+			if record == nil {
+				result, err := tx.Run(ctx,
+					`MERGE (x:Entity {workspace_id: $workspace_id, normalized_name: $key, type: $type})
+					 ON CREATE SET x.name = $name, x.description = '', x.chunk_ids = []
+					 WITH x`+neo4jNextID+`
+					 SET x.description = CASE WHEN x.description = '' THEN $description ELSE x.description END,
+					     x.chunk_ids = CASE WHEN $chunk_id IN x.chunk_ids THEN x.chunk_ids ELSE x.chunk_ids + $chunk_id END
+					 RETURN x.id AS id`,
+					params,
+				)
+				if err != nil {
+					return nil, err
+				}
+				if record, err = result.Single(ctx); err != nil {
+					return nil, err
+				}
+			}
+
+			id, _, err := neo4j.GetRecordValue[int64](record, "id")
+			if err != nil {
+				return nil, err
+			}
+			nodeIDs[key] = id
+		}
+
+		for _, relation := range relations {
+			result, err := tx.Run(ctx,
+				`MATCH (a:Entity {workspace_id: $workspace_id, id: $source_id}), (b:Entity {workspace_id: $workspace_id, id: $target_id})
+				 MERGE (a)-[x:RELATES {type: $type}]->(b)
+				 ON CREATE SET x.description = '', x.chunk_ids = []
+				 WITH x`+neo4jNextID+`
+				 SET x.description = CASE WHEN x.description = '' THEN $description ELSE x.description END,
+				     x.chunk_ids = CASE WHEN $chunk_id IN x.chunk_ids THEN x.chunk_ids ELSE x.chunk_ids + $chunk_id END`,
+				map[string]any{
+					"workspace_id": int64(workspaceID),
+					"chunk_id":     int64(chunkID),
+					"source_id":    nodeIDs[relation.Source],
+					"target_id":    nodeIDs[relation.Target],
+					"type":         relation.Type,
+					"description":  relation.Description,
+					"sequence":     "relation",
+				},
+			)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := result.Consume(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+	return err
+}
+
+func (n *Neo4jGraphStore) RemoveMentions(workspaceID int, chunkIDs []int) error {
+	params := map[string]any{"workspace_id": int64(workspaceID), "chunk_ids": int64s(chunkIDs)}
+
+	if _, err := n.query(
+		`MATCH (e:Entity {workspace_id: $workspace_id})
+		 WHERE any(c IN e.chunk_ids WHERE c IN $chunk_ids)
+		 SET e.chunk_ids = [c IN e.chunk_ids WHERE NOT c IN $chunk_ids]`,
+		params,
+	); err != nil {
+		return err
+	}
+	_, err := n.query(
+		`MATCH (:Entity {workspace_id: $workspace_id})-[r:RELATES]->()
+		 WHERE any(c IN r.chunk_ids WHERE c IN $chunk_ids)
+		 SET r.chunk_ids = [c IN r.chunk_ids WHERE NOT c IN $chunk_ids]`,
+		params,
+	)
+	return err
+}
+
+func (n *Neo4jGraphStore) Nodes(workspaceID int, ids []int) ([]models.GraphNode, error) {
+	return n.queryNodes(
+		`MATCH (e:Entity {workspace_id: $workspace_id}) WHERE e.id IN $ids
+		 RETURN `+neo4jNodeFields+` ORDER BY e.id`,
+		map[string]any{"workspace_id": int64(workspaceID), "ids": int64s(ids)},
+	)
+}
+
+func (n *Neo4jGraphStore) NodesInText(workspaceID int, text string, limit int) ([]models.GraphNode, error) {
+	return n.queryNodes(
+		`MATCH (e:Entity {workspace_id: $workspace_id})
+		 WHERE size(e.normalized_name) > 2 AND $text CONTAINS e.normalized_name
+		 RETURN `+neo4jNodeFields+`
+		 ORDER BY size(e.normalized_name) DESC, e.id
+		 LIMIT $limit`,
+		map[string]any{"workspace_id": int64(workspaceID), "text": text, "limit": int64(limit)},
+	)
+}
+
+func (n *Neo4jGraphStore) NodesMatching(workspaceID int, keywords []string, limit int) ([]models.GraphNode, error) {
+	return n.queryNodes(
+		`MATCH (e:Entity {workspace_id: $workspace_id})
+		 WHERE any(k IN $keywords WHERE e.normalized_name CONTAINS k)
+		 RETURN `+neo4jNodeFields+`
+		 ORDER BY e.id
+		 LIMIT $limit`,
+		map[string]any{"workspace_id": int64(workspaceID), "keywords": keywords, "limit": int64(limit)},
+	)
+}
+
+func (n *Neo4jGraphStore) SearchNodes(workspaceID int, query, entityType string, limit, offset int) ([]models.GraphNode, error) {
+	return n.queryNodes(
+		`MATCH (e:Entity {workspace_id: $workspace_id})
+		 WHERE ($query = '' OR e.normalized_name CONTAINS $query)
+		   AND ($type = '' OR e.type = $type)
+		 RETURN `+neo4jNodeFields+`
+		 ORDER BY e.normalized_name = $query DESC,
+		          e.normalized_name STARTS WITH $query DESC,
+		          size(e.chunk_ids) DESC,
+		          e.id
+		 SKIP $offset LIMIT $limit`,
+		map[string]any{
+			"workspace_id": int64(workspaceID),
+			"query":        query,
+			"type":         entityType,
+			"limit":        int64(limit),
+			"offset":       int64(offset),
+		},
+	)
+}
+
+func (n *Neo4jGraphStore) EdgesTouching(workspaceID int, nodeIDs []int) ([]models.GraphEdge, error) {
+	return n.queryEdges(
+		`MATCH (a:Entity {workspace_id: $workspace_id})-[r:RELATES]->(b:Entity)
+		 WHERE a.id IN $ids OR b.id IN $ids
+		 RETURN `+neo4jEdgeFields+`
+		 ORDER BY weight DESC, r.id`,
+		map[string]any{"workspace_id": int64(workspaceID), "ids": int64s(nodeIDs)},
+	)
+}
+
+func (n *Neo4jGraphStore) EdgesBetween(workspaceID int, nodeIDs []int) ([]models.GraphEdge, error) {
+	return n.queryEdges(
+		`MATCH (a:Entity {workspace_id: $workspace_id})-[r:RELATES]->(b:Entity)
+		 WHERE a.id IN $ids AND b.id IN $ids
+		 RETURN `+neo4jEdgeFields+`
+		 ORDER BY r.id`,
+		map[string]any{"workspace_id": int64(workspaceID), "ids": int64s(nodeIDs)},
+	)
+}
+
+func (n *Neo4jGraphStore) MentioningChunks(workspaceID int, nodeIDs []int, limit int) ([]int, error) {
+	records, err := n.query(
+		`MATCH (e:Entity {workspace_id: $workspace_id}) WHERE e.id IN $ids
+		 UNWIND e.chunk_ids AS chunk_id
+		 RETURN chunk_id, count(*) AS mentions
+		 ORDER BY mentions DESC, chunk_id
+		 LIMIT $limit`,
+		map[string]any{"workspace_id": int64(workspaceID), "ids": int64s(nodeIDs), "limit": int64(limit)},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int{}
+	for _, record := range records {
+		id, _, err := neo4j.GetRecordValue[int64](record, "chunk_id")
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, int(id))
+	}
+	return ids, nil
+}
+
+func (n *Neo4jGraphStore) Graph(workspaceID int) (*Subgraph, error) {
+	params := map[string]any{"workspace_id": int64(workspaceID)}
+
+	nodes, err := n.queryNodes(
+		`MATCH (e:Entity {workspace_id: $workspace_id}) RETURN `+neo4jNodeFields+` ORDER BY e.id`,
+		params,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	edges, err := n.queryEdges(
+		`MATCH (a:Entity {workspace_id: $workspace_id})-[r:RELATES]->(b:Entity)
+		 RETURN `+neo4jEdgeFields+` ORDER BY r.id`,
+		params,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Subgraph{Nodes: nodes, Edges: edges}, nil
+}
+
+func (n *Neo4jGraphStore) Clear(workspaceID int) error {
+	_, err := n.query(
+		"MATCH (e:Entity {workspace_id: $workspace_id}) DETACH DELETE e",
+		map[string]any{"workspace_id": int64(workspaceID)},
+	)
+	return err
+}
+
+// AdoptUserGraphs moves entities stored before workspaces, which are keyed
+// by user, into the workspace given for their user. Entities already in a
+// workspace are left alone, so running it again has no effect.
+func (n *Neo4jGraphStore) AdoptUserGraphs(workspaceByUser map[int]int) error {
+	pairs := make([]map[string]any, 0, len(workspaceByUser))
+	for userID, workspaceID := range workspaceByUser {
+		pairs = append(pairs, map[string]any{"user_id": int64(userID), "workspace_id": int64(workspaceID)})
+	}
+	_, err := n.query(
+		`UNWIND $pairs AS p
+		 MATCH (e:Entity {user_id: p.user_id}) WHERE e.workspace_id IS NULL
+		 SET e.workspace_id = p.workspace_id`,
+		map[string]any{"pairs": pairs},
+	)
+	return err
+}
+
+func (n *Neo4jGraphStore) Close() error {
+	return n.driver.Close(context.Background())
+}
+
+// query runs a single auto-retried statement and collects its records
+func (n *Neo4jGraphStore) query(cypher string, params map[string]any) ([]*neo4j.Record, error) {
+	result, err := neo4j.ExecuteQuery(context.Background(), n.driver, cypher, params,
+		neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase(n.database))
+	if err != nil {
+		return nil, err
+	}
+	return result.Records, nil
+}
+
+func (n *Neo4jGraphStore) queryNodes(cypher string, params map[string]any) ([]models.GraphNode, error) {
+	records, err := n.query(cypher, params)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []models.GraphNode{}
+	for _, record := range records {
+		var node models.GraphNode
+		id, _, err := neo4j.GetRecordValue[int64](record, "id")
+		if err != nil {
+			return nil, err
+		}
+		node.ID = int(id)
+		node.Name = recordString(record, "name")
+		node.NormalizedName = recordString(record, "normalized_name")
+		node.Type = recordString(record, "type")
+		node.Description = recordString(record, "description")
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (n *Neo4jGraphStore) queryEdges(cypher string, params map[string]any) ([]models.GraphEdge, error) {
+	records, err := n.query(cypher, params)
+	if err != nil {
+		return nil, err
+	}
+
+	edges := []models.GraphEdge{}
+	for _, record := range records {
+		var edge models.GraphEdge
+		for key, target := range map[string]*int{
+			"id":        &edge.ID,
+			"source_id": &edge.SourceID,
+			"target_id": &edge.TargetID,
+			"weight":    &edge.Weight,
+		} {
+			value, _, err := neo4j.GetRecordValue[int64](record, key)
+			if err != nil {
+				return nil, err
+			}
+			*target = int(value)
+		}
+		edge.Type = recordString(record, "type")
+		edge.Description = recordString(record, "description")
+		edges = append(edges, edge)
+	}
+	return edges, nil
+}
+
+// recordString reads an optional string property, treating null as empty
+func recordString(record *neo4j.Record, key string) string {
+	value, _ := record.Get(key)
+	s, _ := value.(string)
+	return s
+}
+
+func int64s(values []int) []int64 {
+	out := make([]int64, len(values))
+	for i, v := range values {
+		out[i] = int64(v)
+	}
+	return out
+}
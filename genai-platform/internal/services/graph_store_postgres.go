@@ -0,0 +1,283 @@
+package services
+
+import (
+	"database/sql"
+
+	"genai-platform/internal/models"
+	"github.com/lib/pq"
+)
+
+const (
+	postgresNodeColumns = `n.id, n.name, n.normalized_name, n.type, n.description`
+	postgresEdgeColumns = `e.id, e.source_id, e.target_id, e.type, e.description,
+		(SELECT COUNT(*) FROM graph_edge_mentions m WHERE m.edge_id = e.id) AS weight`
+)
+
+// PostgresGraphStore keeps the graph in the graph_nodes, graph_edges and
+// mention tables of the application database. It is the default store.
+type PostgresGraphStore struct {
+	db *sql.DB
+}
+
+func NewPostgresGraphStore(db *sql.DB) *PostgresGraphStore {
+	return &PostgresGraphStore{db: db}
+}
+
+// MergeExtraction upserts the chunk's entities and relations in one
+// transaction. Both arrive sorted by key, so concurrent chunks that share
+// entities always lock the same rows in the same order.
+func (p *PostgresGraphStore) MergeExtraction(workspaceID, chunkID int, entities []ExtractedEntity, relations []ExtractedRelation) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	nodeIDs := map[string]int{}
+	for _, entity := range entities {
+		key := NormalizeEntityName(entity.Name)
+
+		// An untyped entity is most likely a reference to a typed one seen
+		// in another chunk, so attach to that before creating a new node
+		var id int
+		if entity.Type == defaultEntityType {
+			err := tx.QueryRow(
+				`SELECT id FROM graph_nodes WHERE workspace_id = $1 AND normalized_name = $2
+				 ORDER BY (type = $3), id LIMIT 1`,
+				workspaceID, key, defaultEntityType,
+			).Scan(&id)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+		}
+
+		if id == 0 {
+			if err := tx.QueryRow(
+				`INSERT INTO graph_nodes (workspace_id, name, normalized_name, type, description)
+				 VALUES ($1, $2, $3, $4, $5)
+				 ON CONFLICT (workspace_id, normalized_name, type) DO UPDATE SET
+				   description = CASE WHEN graph_nodes.description = '' THEN EXCLUDED.description ELSE graph_nodes.description END,
+				   updated_at = CURRENT_TIMESTAMP
+				 RETURNING id`,
+				workspaceID, entity.Name, key, entity.Type, entity.Description,
+			).Scan(&id); err != nil {
+				return err
+			}
+		}
+		nodeIDs[key] = id
+
+		if _, err := tx.Exec(
+			"INSERT INTO graph_mentions (node_id, chunk_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			id, chunkID,
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, relation := range relations {
+		sourceID, targetID := nodeIDs[relation.Source], nodeIDs[relation.Target]
+
+		var id int
+		if err := tx.QueryRow(
+			`INSERT INTO graph_edges (workspace_id, source_id, target_id, type, description)
+			 VALUES ($1, $2, $3, $4, $5)
+			 ON CONFLICT (workspace_id, source_id, target_id, type) DO UPDATE SET
+			   description = CASE WHEN graph_edges.description = '' THEN EXCLUDED.description ELSE graph_edges.description END,
+			   updated_at = CURRENT_TIMESTAMP
+			 RETURNING id`,
+			workspaceID, sourceID, targetID, relation.Type, relation.Description,
+		).Scan(&id); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			"INSERT INTO graph_edge_mentions (edge_id, chunk_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			id, chunkID,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMentions deletes the chunks' mentions. Deleting a graph_chunks row
+// already cascades to its mentions; this covers callers that keep the row.
+func (p *PostgresGraphStore) RemoveMentions(workspaceID int, chunkIDs []int) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`DELETE FROM graph_mentions m USING graph_nodes n
+		 WHERE n.id = m.node_id AND n.workspace_id = $1 AND m.chunk_id = ANY($2)`,
+		workspaceID, pq.Array(chunkIDs),
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`DELETE FROM graph_edge_mentions m USING graph_edges e
+		 WHERE e.id = m.edge_id AND e.workspace_id = $1 AND m.chunk_id = ANY($2)`,
+		workspaceID, pq.Array(chunkIDs),
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (p *PostgresGraphStore) Nodes(workspaceID int, ids []int) ([]models.GraphNode, error) {
+	return p.queryNodes(
+		`SELECT `+postgresNodeColumns+` FROM graph_nodes n
+		 WHERE n.workspace_id = $1 AND n.id = ANY($2) ORDER BY n.id`,
+		workspaceID, pq.Array(ids),
+	)
+}
+
+func (p *PostgresGraphStore) NodesInText(workspaceID int, text string, limit int) ([]models.GraphNode, error) {
+	return p.queryNodes(
+		`SELECT `+postgresNodeColumns+` FROM graph_nodes n
+		 WHERE n.workspace_id = $1 AND length(n.normalized_name) > 2 AND strpos($2, n.normalized_name) > 0
+		 ORDER BY length(n.normalized_name) DESC, n.id
+		 LIMIT $3`,
+		workspaceID, text, limit,
+	)
+}
+
+func (p *PostgresGraphStore) NodesMatching(workspaceID int, keywords []string, limit int) ([]models.GraphNode, error) {
+	return p.queryNodes(
+		`SELECT `+postgresNodeColumns+` FROM graph_nodes n
+		 WHERE n.workspace_id = $1
+		   AND EXISTS (SELECT 1 FROM unnest($2::text[]) k WHERE strpos(n.normalized_name, k) > 0)
+		 ORDER BY n.id
+		 LIMIT $3`,
+		workspaceID, pq.Array(keywords), limit,
+	)
+}
+
+func (p *PostgresGraphStore) SearchNodes(workspaceID int, query, entityType string, limit, offset int) ([]models.GraphNode, error) {
+	return p.queryNodes(
+		`SELECT `+postgresNodeColumns+` FROM graph_nodes n
+		 WHERE n.workspace_id = $1
+		   AND ($2 = '' OR strpos(n.normalized_name, $2) > 0)
+		   AND ($3 = '' OR n.type = $3)
+		 ORDER BY n.normalized_name = $2 DESC,
+		          left(n.normalized_name, length($2)) = $2 DESC,
+		          (SELECT COUNT(*) FROM graph_mentions m WHERE m.node_id = n.id) DESC,
+		          n.id
+		 LIMIT $4 OFFSET $5`,
+		workspaceID, query, entityType, limit, offset,
+	)
+}
+
+func (p *PostgresGraphStore) EdgesTouching(workspaceID int, nodeIDs []int) ([]models.GraphEdge, error) {
+	return p.queryEdges(
+		`SELECT `+postgresEdgeColumns+` FROM graph_edges e
+		 WHERE e.workspace_id = $1 AND (e.source_id = ANY($2) OR e.target_id = ANY($2))
+		 ORDER BY weight DESC, e.id`,
+		workspaceID, pq.Array(nodeIDs),
+	)
+}
+
+func (p *PostgresGraphStore) EdgesBetween(workspaceID int, nodeIDs []int) ([]models.GraphEdge, error) {
+	return p.queryEdges(
+		`SELECT `+postgresEdgeColumns+` FROM graph_edges e
+		 WHERE e.workspace_id = $1 AND e.source_id = ANY($2) AND e.target_id = ANY($2)
+		 ORDER BY e.id`,
+		workspaceID, pq.Array(nodeIDs),
+	)
+}
+
+func (p *PostgresGraphStore) MentioningChunks(workspaceID int, nodeIDs []int, limit int) ([]int, error) {
+	rows, err := p.db.Query(
+		`SELECT m.chunk_id FROM graph_mentions m JOIN graph_nodes n ON n.id = m.node_id
+		 WHERE n.workspace_id = $1 AND m.node_id = ANY($2)
+		 GROUP BY m.chunk_id
+		 ORDER BY COUNT(*) DESC, m.chunk_id
+		 LIMIT $3`,
+		workspaceID, pq.Array(nodeIDs), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (p *PostgresGraphStore) Graph(workspaceID int) (*Subgraph, error) {
+	nodes, err := p.queryNodes(
+		`SELECT `+postgresNodeColumns+` FROM graph_nodes n WHERE n.workspace_id = $1 ORDER BY n.id`,
+		workspaceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	edges, err := p.queryEdges(
+		`SELECT `+postgresEdgeColumns+` FROM graph_edges e WHERE e.workspace_id = $1 ORDER BY e.id`,
+		workspaceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Subgraph{Nodes: nodes, Edges: edges}, nil
+}
+
+// Clear deletes the workspace's entities; edges and mentions cascade with them
+func (p *PostgresGraphStore) Clear(workspaceID int) error {
+	_, err := p.db.Exec("DELETE FROM graph_nodes WHERE workspace_id = $1", workspaceID)
+	return err
+}
+
+// Close is a no-op; the database handle is owned by the caller
+func (p *PostgresGraphStore) Close() error {
+	return nil
+}
+
+func (p *PostgresGraphStore) queryNodes(query string, args ...interface{}) ([]models.GraphNode, error) {
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := []models.GraphNode{}
+	for rows.Next() {
+		var node models.GraphNode
+		if err := rows.Scan(&node.ID, &node.Name, &node.NormalizedName, &node.Type, &node.Description); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+func (p *PostgresGraphStore) queryEdges(query string, args ...interface{}) ([]models.GraphEdge, error) {
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edges := []models.GraphEdge{}
+	for rows.Next() {
+		var edge models.GraphEdge
+		if err := rows.Scan(&edge.ID, &edge.SourceID, &edge.TargetID, &edge.Type, &edge.Description, &edge.Weight); err != nil {
+			return nil, err
+		}
+		edges = append(edges, edge)
+	}
+	return edges, rows.Err()
+}
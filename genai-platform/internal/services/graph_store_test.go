@@ -0,0 +1,421 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"genai-platform/internal/database"
+	"genai-platform/internal/models"
+)
+
+// The GraphStore conformance suite runs against the Postgres store when
+// DATABASE_URL is set and against Neo4j when NEO4J_URL is too, so the two
+// stay interchangeable behind the GraphRAG upload and query paths. A local
+// Neo4j can be started with:
+//
+//	docker run --rm -p 7687:7687 -e NEO4J_AUTH=neo4j/password neo4j:5
+
+// graphStoreFixture names the workspaces and chunks the suite writes to.
+// Both workspaces' graphs are cleared before and after each store's run.
+// Chunks always live in Postgres, whichever store is tested.
+type graphStoreFixture struct {
+	WorkspaceID      int
+	OtherWorkspaceID int
+	ChunkIDs         [3]int
+}
+
+func TestGraphStoreConformance(t *testing.T) {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	db, err := database.Initialize(databaseURL)
+	if err != nil {
+		t.Fatalf("failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	fixture := createGraphStoreFixture(t, db)
+
+	t.Run("postgres", func(t *testing.T) {
+		store, err := OpenGraphStore(db, "postgres", Neo4jConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		runGraphStoreConformance(t, store, fixture)
+	})
+
+	t.Run("neo4j", func(t *testing.T) {
+		neo4jURL := os.Getenv("NEO4J_URL")
+		if neo4jURL == "" {
+			t.Skip("NEO4J_URL is not set")
+		}
+		store, err := OpenGraphStore(db, "neo4j", Neo4jConfig{
+			URL:      neo4jURL,
+			Username: envOr("NEO4J_USERNAME", "neo4j"),
+			Password: os.Getenv("NEO4J_PASSWORD"),
+			Database: envOr("NEO4J_DATABASE", "neo4j"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		runGraphStoreConformance(t, store, fixture)
+	})
+}
+
+// runGraphStoreConformance builds the same small graph in store that every
+// store must agree on, then checks what the store returns for it
+func runGraphStoreConformance(t *testing.T, store GraphStore, fixture graphStoreFixture) {
+	workspaceID, chunks := fixture.WorkspaceID, fixture.ChunkIDs
+	for _, id := range []int{workspaceID, fixture.OtherWorkspaceID} {
+		if err := store.Clear(id); err != nil {
+			t.Fatalf("failed to clear graph: %v", err)
+		}
+		defer func(id int) {
+			if err := store.Clear(id); err != nil {
+				t.Errorf("failed to clear graph: %v", err)
+			}
+		}(id)
+	}
+
+	merge := func(chunk int, entities []ExtractedEntity, relations []ExtractedRelation) {
+		t.Helper()
+		normalizedEntities, normalizedRelations := NormalizeExtraction(&GraphExtraction{Entities: entities, Relations: relations})
+		if err := store.MergeExtraction(workspaceID, chunks[chunk], normalizedEntities, normalizedRelations); err != nil {
+			t.Fatalf("MergeExtraction() error = %v", err)
+		}
+	}
+
+	// Chunks 0 and 1 relate Jane to Acme, chunk 1 twice, which must count
+	// once. Chunk 2 names Acme without a type, so it attaches to the
+	// organization, adds a product, repeats Jane with a description that
+	// must not replace hers, and adds names that only look like Acme.
+	merge(0,
+		[]ExtractedEntity{
+			{Name: "Acme Corp", Type: "organization"},
+			{Name: "Jane Doe", Type: "person", Description: "Chief engineer"},
+		},
+		[]ExtractedRelation{{Source: "Jane Doe", Target: "Acme Corp", Type: "works for"}},
+	)
+	for i := 0; i < 2; i++ {
+		merge(1,
+			[]ExtractedEntity{{Name: "Acme Corp", Type: "organization"}, {Name: "Jane Doe", Type: "person"}},
+			[]ExtractedRelation{{Source: "Jane Doe", Target: "Acme Corp", Type: "works for"}},
+		)
+	}
+	merge(2,
+		[]ExtractedEntity{{Name: "the Acme Corp."}, {Name: "Widget", Type: "product"}},
+		[]ExtractedRelation{{Source: "Acme Corp", Target: "Widget", Type: "makes"}},
+	)
+	merge(2,
+		[]ExtractedEntity{
+			{Name: "Jane Doe", Type: "person", Description: "Someone else"},
+			{Name: "Widget", Type: "product", Description: "A gadget"},
+		},
+		nil,
+	)
+	merge(2, []ExtractedEntity{{Name: "Acme", Type: "brand"}, {Name: "Big Acme", Type: "organization"}}, nil)
+
+	graph, err := store.Graph(workspaceID)
+	if err != nil {
+		t.Fatalf("Graph() error = %v", err)
+	}
+	nodeIDs := map[string]int{}
+	for _, node := range graph.Nodes {
+		nodeIDs[node.NormalizedName+"/"+node.Type] = node.ID
+	}
+	acme, jane, widget := nodeIDs["acme corp/ORGANIZATION"], nodeIDs["jane doe/PERSON"], nodeIDs["widget/PRODUCT"]
+	if acme == 0 || jane == 0 || widget == 0 {
+		t.Fatalf("Graph() nodes = %v, want Acme Corp, Jane Doe and Widget", graphNodeNames(graph.Nodes))
+	}
+
+	t.Run("merge creates entities and relations", func(t *testing.T) {
+		if len(graph.Nodes) != 5 || len(graph.Edges) != 2 {
+			t.Fatalf("got %d nodes and %d edges, want 5 and 2", len(graph.Nodes), len(graph.Edges))
+		}
+		for i := 1; i < len(graph.Nodes); i++ {
+			if graph.Nodes[i-1].ID >= graph.Nodes[i].ID {
+				t.Errorf("nodes not ordered by id: %d, %d", graph.Nodes[i-1].ID, graph.Nodes[i].ID)
+			}
+		}
+	})
+
+	t.Run("merge counts a chunk once", func(t *testing.T) {
+		for _, edge := range graph.Edges {
+			if edge.Type == "WORKS_FOR" && (edge.SourceID != jane || edge.TargetID != acme || edge.Weight != 2) {
+				t.Errorf("WORKS_FOR edge = %+v, want Jane to Acme Corp with weight 2", edge)
+			}
+		}
+	})
+
+	t.Run("untyped entities attach to typed ones", func(t *testing.T) {
+		found := false
+		for _, edge := range graph.Edges {
+			if edge.Type == "MAKES" {
+				found = true
+				if edge.SourceID != acme || edge.TargetID != widget {
+					t.Errorf("MAKES edge joins %d and %d, want %d and %d", edge.SourceID, edge.TargetID, acme, widget)
+				}
+			}
+		}
+		if !found {
+			t.Error("MAKES edge not found")
+		}
+	})
+
+	t.Run("descriptions keep the first non-empty value", func(t *testing.T) {
+		descriptions := map[string]string{}
+		for _, node := range graph.Nodes {
+			descriptions[node.NormalizedName] = node.Description
+		}
+		if descriptions["jane doe"] != "Chief engineer" {
+			t.Errorf("jane doe description is %q, want the first one", descriptions["jane doe"])
+		}
+		if descriptions["widget"] != "A gadget" {
+			t.Errorf("widget description is %q, want it filled in", descriptions["widget"])
+		}
+	})
+
+	t.Run("nodes are looked up by id", func(t *testing.T) {
+		nodes, err := store.Nodes(workspaceID, []int{widget, acme, -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := graphNodeNames(nodes); !reflect.DeepEqual(got, []string{"Acme Corp", "Widget"}) {
+			t.Fatalf("got %v", got)
+		}
+		if nodes[0].ID != acme || nodes[0].Type != "ORGANIZATION" || nodes[0].NormalizedName != "acme corp" {
+			t.Errorf("unexpected node %+v", nodes[0])
+		}
+	})
+
+	t.Run("nodes are found in text", func(t *testing.T) {
+		nodes, err := store.NodesInText(workspaceID, "does jane doe use the widget?", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := graphNodeNames(nodes); !reflect.DeepEqual(got, []string{"Jane Doe", "Widget"}) {
+			t.Errorf("got %v", got)
+		}
+
+		nodes, err = store.NodesInText(workspaceID, "does jane doe use the widget?", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 1 {
+			t.Errorf("limit ignored, got %d nodes", len(nodes))
+		}
+	})
+
+	t.Run("nodes match keywords", func(t *testing.T) {
+		nodes, err := store.NodesMatching(workspaceID, []string{"widg", "jane"}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := graphNodeNames(nodes); !reflect.DeepEqual(got, []string{"Jane Doe", "Widget"}) {
+			t.Errorf("got %v", got)
+		}
+	})
+
+	t.Run("search ranks exact and prefix matches first", func(t *testing.T) {
+		tests := []struct {
+			entityType    string
+			limit, offset int
+			want          []string
+		}{
+			{"", 10, 0, []string{"Acme", "Acme Corp", "Big Acme"}},
+			{"ORGANIZATION", 10, 0, []string{"Acme Corp", "Big Acme"}},
+			{"", 1, 1, []string{"Acme Corp"}},
+		}
+		for _, tt := range tests {
+			nodes, err := store.SearchNodes(workspaceID, "acme", tt.entityType, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := graphNodeNames(nodes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchNodes(%q, %d, %d) = %v, want %v", tt.entityType, tt.limit, tt.offset, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("edges touching and between nodes", func(t *testing.T) {
+		edges, err := store.EdgesTouching(workspaceID, []int{acme})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := graphEdgeTypes(edges); !reflect.DeepEqual(got, []string{"WORKS_FOR", "MAKES"}) {
+			t.Errorf("touching: got %v, want heaviest first", got)
+		}
+
+		edges, err = store.EdgesBetween(workspaceID, []int{jane, widget})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(edges) != 0 {
+			t.Errorf("between unconnected nodes: got %v", graphEdgeTypes(edges))
+		}
+
+		edges, err = store.EdgesBetween(workspaceID, []int{acme, widget})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := graphEdgeTypes(edges); !reflect.DeepEqual(got, []string{"MAKES"}) {
+			t.Errorf("between: got %v", got)
+		}
+	})
+
+	t.Run("chunks are ranked by mentions", func(t *testing.T) {
+		first, second := chunks[0], chunks[1]
+		if second < first {
+			first, second = second, first
+		}
+
+		// Chunk 2 mentions all three; chunks 0 and 1 mention Acme and Jane
+		ids, err := store.MentioningChunks(workspaceID, []int{acme, jane, widget}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int{chunks[2], first, second}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("got %v, want %v", ids, want)
+		}
+
+		ids, err = store.MentioningChunks(workspaceID, []int{widget}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int{chunks[2]}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("got %v, want %v", ids, want)
+		}
+	})
+
+	t.Run("graphs are isolated per workspace", func(t *testing.T) {
+		other := fixture.OtherWorkspaceID
+		if err := store.MergeExtraction(other, chunks[0],
+			[]ExtractedEntity{{Name: "Acme Corp", Type: "ORGANIZATION"}}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		otherGraph, err := store.Graph(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(otherGraph.Nodes) != 1 || len(otherGraph.Edges) != 0 {
+			t.Fatalf("other workspace sees %d nodes and %d edges", len(otherGraph.Nodes), len(otherGraph.Edges))
+		}
+		if otherGraph.Nodes[0].ID == acme {
+			t.Errorf("other workspace shares entity %d", acme)
+		}
+
+		nodes, err := store.Nodes(other, []int{acme})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 0 {
+			t.Errorf("other workspace can load entity %d", acme)
+		}
+
+		nodes, err = store.SearchNodes(workspaceID, "acme corp", "", 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 1 || nodes[0].ID != acme {
+			t.Errorf("workspace sees other workspace's entities: %v", graphNodeNames(nodes))
+		}
+	})
+
+	// Runs last, since it changes the graph the checks above read
+	t.Run("removing mentions lowers weights", func(t *testing.T) {
+		if err := store.RemoveMentions(workspaceID, []int{chunks[1]}); err != nil {
+			t.Fatal(err)
+		}
+
+		graph, err := store.Graph(workspaceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, edge := range graph.Edges {
+			if edge.Type == "WORKS_FOR" && edge.Weight != 1 {
+				t.Errorf("WORKS_FOR weight is %d, want 1", edge.Weight)
+			}
+		}
+
+		ids, err := store.MentioningChunks(workspaceID, []int{jane}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []int{chunks[0], chunks[2]}
+		sort.Ints(want)
+		if !reflect.DeepEqual(ids, want) {
+			t.Errorf("mentions of jane doe: got %v, want %v", ids, want)
+		}
+	})
+}
+
+// createGraphStoreFixture adds two throwaway workspaces and three chunks,
+// removed again when the test ends
+func createGraphStoreFixture(t *testing.T, db *sql.DB) graphStoreFixture {
+	var fixture graphStoreFixture
+	var workspaceIDs []int
+
+	// Chunks and the Postgres graph cascade with their workspace
+	t.Cleanup(func() {
+		for _, workspaceID := range workspaceIDs {
+			db.Exec("DELETE FROM workspaces WHERE id = $1", workspaceID)
+		}
+	})
+
+	suffix := time.Now().UnixNano()
+	for i := 0; i < 2; i++ {
+		var id int
+		if err := db.QueryRow(
+			"INSERT INTO workspaces (name) VALUES ($1) RETURNING id",
+			fmt.Sprintf("graphstore-test-%d-%d", suffix, i),
+		).Scan(&id); err != nil {
+			t.Fatalf("failed to create workspace: %v", err)
+		}
+		workspaceIDs = append(workspaceIDs, id)
+	}
+	fixture.WorkspaceID, fixture.OtherWorkspaceID = workspaceIDs[0], workspaceIDs[1]
+
+	for i := range fixture.ChunkIDs {
+		if err := db.QueryRow(
+			`INSERT INTO graph_chunks (workspace_id, chunk_index, content)
+			 VALUES ($1, $2, $3) RETURNING id`,
+			fixture.WorkspaceID, i, fmt.Sprintf("conformance chunk %d", i),
+		).Scan(&fixture.ChunkIDs[i]); err != nil {
+			t.Fatalf("failed to create chunk: %v", err)
+		}
+	}
+
+	return fixture
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func graphNodeNames(nodes []models.GraphNode) []string {
+	names := make([]string, len(nodes))
+	for i, node := range nodes {
+		names[i] = node.Name
+	}
+	return names
+}
+
+func graphEdgeTypes(edges []models.GraphEdge) []string {
+	types := make([]string, len(edges))
+	for i, edge := range edges {
+		types[i] = edge.Type
+	}
+	return types
+}
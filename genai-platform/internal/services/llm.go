@@ -7,28 +7,157 @@ import (
 	"fmt"
 	"os/exec"
 	"time"
+
+	"genai-platform/internal/models"
 )
 
 type LLMService struct {
 	// Add LLM client configurations here
+	redaction *RedactionService
+	usage     *UsageService
+
+	// userID and knownNames are set on copies made by ForUser, feature
+	// and workspaceID on those made by ForFeature
+	userID      int
+	knownNames  []string
+	feature     string
+	workspaceID int
 }
 
 func NewLLMService() *LLMService {
 	return &LLMService{}
 }
 
+// SetRedaction redacts personal data from model input, for the operations
+// and tenants the redaction service enables it for
+func (s *LLMService) SetRedaction(redaction *RedactionService) {
+	s.redaction = redaction
+}
+
+// ForUser returns a copy of the service whose calls are made on behalf of
+// userID, so that tenant's redaction setting applies. knownNames are
+// redacted on top of the names the patterns find, e.g. a candidate's name.
+func (s *LLMService) ForUser(userID int, knownNames ...string) *LLMService {
+	scoped := *s
+	scoped.userID = userID
+	scoped.knownNames = knownNames
+	return &scoped
+}
+
+// SetUsage meters the tokens of every model call and refuses calls of
+// users over their monthly quota
+func (s *LLMService) SetUsage(usage *UsageService) {
+	s.usage = usage
+}
+
+// ForFeature returns a copy of the service whose calls are metered against
+// feature of workspaceID
+func (s *LLMService) ForFeature(feature string, workspaceID int) *LLMService {
+	scoped := *s
+	scoped.feature = feature
+	scoped.workspaceID = workspaceID
+	return &scoped
+}
+
+// bridgeOutput is what the bridge prints: the operation's result and the
+// tokens its model calls used
+type bridgeOutput struct {
+	Result json.RawMessage `json:"result"`
+	Usage  *struct {
+		Model            string `json:"model"`
+		Calls            int    `json:"calls"`
+		PromptTokens     int    `json:"prompt_tokens"`
+		CompletionTokens int    `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
 type AIResponse struct {
 	Response string `json:"response"`
 	Error    string `json:"error,omitempty"`
 }
 
+type DocumentChunks struct {
+	Chunks []string `json:"chunks"`
+	Error  string   `json:"error,omitempty"`
+}
+
+type ExtractedEntity struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+type ExtractedRelation struct {
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+type GraphExtraction struct {
+	Entities  []ExtractedEntity   `json:"entities"`
+	Relations []ExtractedRelation `json:"relations"`
+	Error     string              `json:"error,omitempty"`
+}
+
+type CommunitySummary struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+	Error   string `json:"error,omitempty"`
+}
+
+type PartialAnswer struct {
+	Answer string `json:"answer"`
+	Score  int    `json:"score"`
+	Error  string `json:"error,omitempty"`
+}
+
+type SQLExplanation struct {
+	Explanation string `json:"explanation"`
+	Summary     string `json:"summary"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ResumeAnalysis is the model's review of a resume. The overall score is
+// not trusted to the model; it is computed from the dimension scores.
 type ResumeAnalysis struct {
-	Feedback string `json:"feedback"`
-	Score    int    `json:"score"`
-	Error    string `json:"error,omitempty"`
+	Feedback        string                            `json:"feedback"`
+	Dimensions      map[string]models.ResumeDimension `json:"dimensions"`
+	Strengths       []string                          `json:"strengths"`
+	Gaps            []string                          `json:"gaps"`
+	MissingKeywords []string                          `json:"missing_keywords"`
+	BulletRewrites  []models.ResumeBulletRewrite      `json:"bullet_rewrites"`
+	Error           string                            `json:"error,omitempty"`
 }
 
 func (s *LLMService) callPythonAI(method string, args map[string]interface{}) ([]byte, error) {
+	// Users over their quota are refused before anything is spent
+	if s.usage != nil && s.userID != 0 {
+		if err := s.usage.CheckQuota(s.userID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Replace personal data with placeholders before it reaches the
+	// provider. Without the setting there is no telling whether that is
+	// required, so the call is refused rather than sent as is.
+	var redaction *Redaction
+	if s.redaction != nil {
+		enabled, err := s.redaction.Enabled(s.userID, method)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redaction setting: %w", err)
+		}
+		if enabled {
+			redaction = NewRedaction(s.knownNames...)
+			if args, err = redaction.RedactArgs(args); err != nil {
+				return nil, fmt.Errorf("failed to redact %s input: %w", method, err)
+			}
+			if err := s.redaction.Record(s.userID, method, redaction.Counts()); err != nil {
+				fmt.Printf("Failed to record redaction of %s: %v\n", method, err)
+			}
+		}
+	}
+
 	// Prepare the Python script call
 	argsJSON, _ := json.Marshal(args)
 	
@@ -40,12 +169,35 @@ func (s *LLMService) callPythonAI(method string, args map[string]interface{}) ([
 	cmd.Stdout = &out
 	cmd.Stderr = &stderr
 	
+	start := time.Now()
 	err := cmd.Run()
 	if err != nil {
 		return nil, fmt.Errorf("python script error: %v, stderr: %s", err, stderr.String())
 	}
+	latency := time.Since(start)
 	
-	return out.Bytes(), nil
+	output := out.Bytes()
+	var bridged bridgeOutput
+	if json.Unmarshal(output, &bridged) == nil && bridged.Result != nil {
+		output = bridged.Result
+		if s.usage != nil && bridged.Usage != nil && bridged.Usage.Calls > 0 {
+			s.usage.Record(UsageCall{
+				UserID:           s.userID,
+				WorkspaceID:      s.workspaceID,
+				Feature:          s.feature,
+				Operation:        method,
+				Model:            bridged.Usage.Model,
+				PromptTokens:     bridged.Usage.PromptTokens,
+				CompletionTokens: bridged.Usage.CompletionTokens,
+				Latency:          latency,
+			})
+		}
+	}
+	
+	if redaction != nil {
+		return redaction.RestoreJSON(output), nil
+	}
+	return output, nil
 }
 
 func (s *LLMService) ProcessPDF(docID int, filePath string) error {
@@ -91,6 +243,119 @@ func (s *LLMService) GetRelevantContext(documentIDs []int, query string) (string
 	return context, nil
 }
 
+func (s *LLMService) ChunkDocument(filePath string) ([]string, error) {
+	args := map[string]interface{}{
+		"file_path": filePath,
+	}
+	
+	result, err := s.callPythonAI("chunk_document", args)
+	if err != nil {
+		return nil, err
+	}
+	
+	var chunks DocumentChunks
+	if err := json.Unmarshal(result, &chunks); err != nil {
+		return nil, err
+	}
+	
+	if chunks.Error != "" {
+		return nil, fmt.Errorf(chunks.Error)
+	}
+	
+	return chunks.Chunks, nil
+}
+
+func (s *LLMService) ExtractGraph(text string) (*GraphExtraction, error) {
+	args := map[string]interface{}{
+		"text": text,
+	}
+	
+	result, err := s.callPythonAI("extract_graph", args)
+	if err != nil {
+		return nil, err
+	}
+	
+	var extraction GraphExtraction
+	if err := json.Unmarshal(result, &extraction); err != nil {
+		return nil, err
+	}
+	
+	if extraction.Error != "" {
+		return nil, fmt.Errorf(extraction.Error)
+	}
+	
+	return &extraction, nil
+}
+
+func (s *LLMService) SummarizeCommunity(entities []ExtractedEntity, relations []ExtractedRelation) (*CommunitySummary, error) {
+	args := map[string]interface{}{
+		"entities":  entities,
+		"relations": relations,
+	}
+	
+	result, err := s.callPythonAI("summarize_community", args)
+	if err != nil {
+		return nil, err
+	}
+	
+	var summary CommunitySummary
+	if err := json.Unmarshal(result, &summary); err != nil {
+		return nil, err
+	}
+	
+	if summary.Error != "" {
+		return nil, fmt.Errorf(summary.Error)
+	}
+	
+	return &summary, nil
+}
+
+func (s *LLMService) GraphMapAnswer(query, summary string) (*PartialAnswer, error) {
+	args := map[string]interface{}{
+		"query":   query,
+		"summary": summary,
+	}
+	
+	result, err := s.callPythonAI("graph_map_answer", args)
+	if err != nil {
+		return nil, err
+	}
+	
+	var answer PartialAnswer
+	if err := json.Unmarshal(result, &answer); err != nil {
+		return nil, err
+	}
+	
+	if answer.Error != "" {
+		return nil, fmt.Errorf(answer.Error)
+	}
+	
+	return &answer, nil
+}
+
+func (s *LLMService) GraphReduceAnswer(query string, partialAnswers []string) (string, error) {
+	args := map[string]interface{}{
+		"query":           query,
+		"partial_answers": partialAnswers,
+	}
+	
+	result, err := s.callPythonAI("graph_reduce_answer", args)
+	if err != nil {
+		return "", err
+	}
+	
+	var response AIResponse
+	if err := json.Unmarshal(result, &response); err != nil {
+		return "", err
+	}
+	
+	if response.Error != "" {
+		return "", fmt.Errorf(response.Error)
+	}
+	
+	return response.Response, nil
+}
+
 func (s *LLMService) GenerateResponse(query, context string) (string, error) {
 	args := map[string]interface{}{
 		"query":   query,
@@ -114,9 +379,12 @@ func (s *LLMService) GenerateResponse(query, context string) (string, error) {
 	return response.Response, nil
 }
 
-func (s *LLMService) GenerateSQL(naturalQuery string) (string, error) {
+// GenerateSQL writes SQL for naturalQuery against the tables described in
+// schema, which are all the model is told about
+func (s *LLMService) GenerateSQL(naturalQuery, schema string) (string, error) {
 	args := map[string]interface{}{
 		"natural_query": naturalQuery,
+		"schema":        schema,
 	}
 	
 	result, err := s.callPythonAI("generate_sql_from_natural_language", args)
@@ -132,6 +400,70 @@ func (s *LLMService) GenerateSQL(naturalQuery string) (string, error) {
 	return response.Response, nil
 }
 
+func (s *LLMService) RepairSQL(naturalQuery, failedSQL, dbError, schema string) (string, error) {
+	args := map[string]interface{}{
+		"natural_query": naturalQuery,
+		"failed_sql":    failedSQL,
+		"error":         dbError,
+		"schema":        schema,
+	}
+	
+	result, err := s.callPythonAI("repair_sql", args)
+	if err != nil {
+		return "", err
+	}
+	
+	var response AIResponse
+	if err := json.Unmarshal(result, &response); err != nil {
+		return "", err
+	}
+	
+	if response.Error != "" {
+		return "", fmt.Errorf(response.Error)
+	}
+	
+	return response.Response, nil
+}
+
+// explainSampleRows is how many result rows are shown to the model when
+// summarizing a result
+const explainSampleRows = 20
+
+func (s *LLMService) ExplainSQLResult(naturalQuery, query string, result *SQLResult) (*SQLExplanation, error) {
+	columns := make([]string, len(result.Columns))
+	for i, column := range result.Columns {
+		columns[i] = column.Name
+	}
+	rows := result.Rows
+	if len(rows) > explainSampleRows {
+		rows = rows[:explainSampleRows]
+	}
+	
+	args := map[string]interface{}{
+		"natural_query": naturalQuery,
+		"sql":           query,
+		"columns":       columns,
+		"rows":          rows,
+		"row_count":     result.RowCount,
+	}
+	
+	output, err := s.callPythonAI("explain_sql_result", args)
+	if err != nil {
+		return nil, err
+	}
+	
+	var explanation SQLExplanation
+	if err := json.Unmarshal(output, &explanation); err != nil {
+		return nil, err
+	}
+	
+	if explanation.Error != "" {
+		return nil, fmt.Errorf(explanation.Error)
+	}
+	
+	return &explanation, nil
+}
+
 func (s *LLMService) ProcessResearchTask(taskID int, query string, db *sql.DB) {
 	// Simulate research processing
 	time.Sleep(2 * time.Second)
@@ -162,39 +494,29 @@ func (s *LLMService) ProcessResearchTask(taskID int, query string, db *sql.DB) {
 	}
 }
 
-func (s *LLMService) ProcessResume(analysisID int, resumePath, jobDescription string, db *sql.DB) {
-	// Simulate resume processing
-	time.Sleep(2 * time.Second)
-	
+// AnalyzeResume reviews resume text extracted from the file at resumePath.
+// The text is sent rather than the path so it can be redacted.
+func (s *LLMService) AnalyzeResume(resumePath, resumeText, jobDescription string) (*ResumeAnalysis, error) {
 	args := map[string]interface{}{
 		"resume_path":     resumePath,
+		"resume_text":     resumeText,
 		"job_description": jobDescription,
 	}
 	
 	result, err := s.callPythonAI("analyze_resume", args)
 	if err != nil {
-		fmt.Printf("Failed to analyze resume for analysis %d: %v\n", analysisID, err)
-		return
+		return nil, err
 	}
 	
 	var analysis ResumeAnalysis
 	if err := json.Unmarshal(result, &analysis); err != nil {
-		fmt.Printf("Failed to parse resume analysis result for analysis %d: %v\n", analysisID, err)
-		return
+		return nil, err
 	}
 	
 	if analysis.Error != "" {
-		fmt.Printf("Resume analysis error for analysis %d: %s\n", analysisID, analysis.Error)
-		return
+		return nil, fmt.Errorf(analysis.Error)
 	}
 	
-	// Update analysis with result
-	_, err = db.Exec(
-		"UPDATE resume_analyses SET status = $1, feedback = $2, score = $3, completed_at = $4 WHERE id = $5",
-		"completed", analysis.Feedback, analysis.Score, time.Now(), analysisID,
-	)
-	if err != nil {
-		fmt.Printf("Failed to update resume analysis %d: %v\n", analysisID, err)
-	}
+	return &analysis, nil
 }
 
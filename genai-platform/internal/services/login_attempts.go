@@ -0,0 +1,207 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Scopes of login_failures
+const (
+	loginScopeAccount = "account"
+	loginScopeIP      = "ip"
+)
+
+const (
+	// loginBackoffBase is the wait after the first failure past the free
+	// attempts; it doubles with every failure after that
+	loginBackoffBase = time.Second
+
+	// loginFailureWindow is how long failures are remembered after the
+	// last one
+	loginFailureWindow = 24 * time.Hour
+
+	// loginLockClass namespaces the advisory locks that serialize sign-in
+	// attempts per email and per address
+	loginLockClass = 7302
+)
+
+// LoginAttemptOptions configures how failed sign-ins slow down guessing
+type LoginAttemptOptions struct {
+	// FreeAttempts is how many failures an account takes before each
+	// further one makes it wait; IPFreeAttempts the same for a client
+	// address, across every account it tries
+	FreeAttempts   int
+	IPFreeAttempts int
+
+	// MaxLockout caps the wait. A failure that reaches it locks the
+	// account or address out for that long.
+	MaxLockout time.Duration
+}
+
+// LoginFailure is what a failed sign-in led to
+type LoginFailure struct {
+	// Wait is how long until the next attempt is accepted
+	Wait time.Duration
+
+	// AccountLocked and IPLocked are set when the failure locked the
+	// account or the address out for the longest wait
+	AccountLocked bool
+	IPLocked      bool
+}
+
+// LoginAttemptService counts failed sign-ins per account and per client
+// address. Past the free attempts every failure makes the next attempt
+// wait, twice as long as the one before, up to a lockout. Accounts are
+// counted by email, whether or not it has an account, so a lockout does
+// not tell anyone which emails are registered.
+type LoginAttemptService struct {
+	db      *sql.DB
+	options LoginAttemptOptions
+}
+
+func NewLoginAttemptService(db *sql.DB, options LoginAttemptOptions) *LoginAttemptService {
+	return &LoginAttemptService{db: db, options: options}
+}
+
+// LoginAttempt holds the locks of a sign-in attempt's email and address
+// until Done, so attempts for either are checked one at a time
+type LoginAttempt struct {
+	tx *sql.Tx
+}
+
+// Begin starts a sign-in attempt. It waits for any other attempt on the
+// same email or address to finish, so a failure recorded by that attempt
+// is seen, then returns how long the email or address must wait before
+// trying, 0 when it may sign in now. Without these locks parallel guesses
+// would all pass the check before any of them was counted. When the wait
+// is 0 the caller checks the password, records a failure or a success,
+// and then calls Done.
+func (s *LoginAttemptService) Begin(email, ipAddress string) (*LoginAttempt, time.Duration, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The email is always locked before the address, so two attempts can
+	// never wait for each other
+	subjects := []string{loginScopeAccount + ":" + hashToken(email)}
+	if ipAddress != "" {
+		subjects = append(subjects, loginScopeIP+":"+ipAddress)
+	}
+	for _, subject := range subjects {
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1, hashtext($2))", loginLockClass, subject); err != nil {
+			tx.Rollback()
+			return nil, 0, err
+		}
+	}
+
+	var lockedUntil sql.NullTime
+	err = tx.QueryRow(
+		`SELECT MAX(locked_until) FROM login_failures
+		 WHERE (scope = $1 AND subject = $2) OR (scope = $3 AND subject = $4)`,
+		loginScopeAccount, hashToken(email), loginScopeIP, ipAddress,
+	).Scan(&lockedUntil)
+	if err != nil {
+		tx.Rollback()
+		return nil, 0, err
+	}
+	if lockedUntil.Valid {
+		if wait := time.Until(lockedUntil.Time); wait > 0 {
+			tx.Rollback()
+			return nil, wait, nil
+		}
+	}
+	return &LoginAttempt{tx: tx}, 0, nil
+}
+
+// Done releases the attempt's locks. Failures and resets are written
+// outside its transaction, so they are already visible to the next
+// attempt.
+func (a *LoginAttempt) Done() {
+	a.tx.Rollback()
+}
+
+// RecordFailure counts a wrong password or code against the email and
+// the address
+func (s *LoginAttemptService) RecordFailure(email, ipAddress string) (*LoginFailure, error) {
+	if _, err := s.db.Exec(
+		"DELETE FROM login_failures WHERE last_failure_at < $1", time.Now().Add(-loginFailureWindow),
+	); err != nil {
+		fmt.Printf("Failed to purge old login failures: %v\n", err)
+	}
+
+	failure := &LoginFailure{}
+	wait, locked, err := s.fail(loginScopeAccount, hashToken(email), s.options.FreeAttempts)
+	if err != nil {
+		return nil, err
+	}
+	failure.Wait, failure.AccountLocked = wait, locked
+
+	if ipAddress != "" {
+		wait, locked, err := s.fail(loginScopeIP, ipAddress, s.options.IPFreeAttempts)
+		if err != nil {
+			return nil, err
+		}
+		if wait > failure.Wait {
+			failure.Wait = wait
+		}
+		failure.IPLocked = locked
+	}
+	return failure, nil
+}
+
+// Reset forgets the failures of an email once it signed in. Those of the
+// address stay, so signing in to one account does not buy more guesses
+// at others.
+func (s *LoginAttemptService) Reset(email string) error {
+	_, err := s.db.Exec(
+		"DELETE FROM login_failures WHERE scope = $1 AND subject = $2", loginScopeAccount, hashToken(email),
+	)
+	return err
+}
+
+// fail counts one failure of a subject and makes it wait, returning the
+// wait and whether it reached the lockout
+func (s *LoginAttemptService) fail(scope, subject string, freeAttempts int) (time.Duration, bool, error) {
+	now := time.Now()
+	var failures int
+	err := s.db.QueryRow(
+		`INSERT INTO login_failures (scope, subject, failures, last_failure_at) VALUES ($1, $2, 1, $3)
+		 ON CONFLICT (scope, subject) DO UPDATE
+		 SET failures = CASE WHEN login_failures.last_failure_at < $4 THEN 1 ELSE login_failures.failures + 1 END,
+		     last_failure_at = $3
+		 RETURNING failures`,
+		scope, subject, now, now.Add(-loginFailureWindow),
+	).Scan(&failures)
+	if err != nil {
+		return 0, false, err
+	}
+
+	wait := loginBackoff(failures-freeAttempts, s.options.MaxLockout)
+	if wait == 0 {
+		return 0, false, nil
+	}
+	if _, err := s.db.Exec(
+		"UPDATE login_failures SET locked_until = $1 WHERE scope = $2 AND subject = $3",
+		now.Add(wait), scope, subject,
+	); err != nil {
+		return 0, false, err
+	}
+	return wait, wait == s.options.MaxLockout, nil
+}
+
+// loginBackoff is the wait after the nth failure past the free attempts
+func loginBackoff(n int, max time.Duration) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	wait := loginBackoffBase
+	for i := 1; i < n && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		return max
+	}
+	return wait
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"genai-platform/internal/database"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		n    int
+		max  time.Duration
+		want time.Duration
+	}{
+		{-1, time.Hour, 0},
+		{0, time.Hour, 0},
+		{1, time.Hour, time.Second},
+		{2, time.Hour, 2 * time.Second},
+		{4, time.Hour, 8 * time.Second},
+		{3, 3 * time.Second, 3 * time.Second},
+		{1000, 15 * time.Minute, 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := loginBackoff(tt.n, tt.max); got != tt.want {
+			t.Errorf("loginBackoff(%d, %v) = %v, want %v", tt.n, tt.max, got, tt.want)
+		}
+	}
+}
+
+// TestLoginAttemptsTakeTurns runs against the database in DATABASE_URL
+func TestLoginAttemptsTakeTurns(t *testing.T) {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	db, err := database.Initialize(databaseURL)
+	if err != nil {
+		t.Fatalf("failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	email := fmt.Sprintf("login%d@example.com", time.Now().UnixNano())
+	ip := "run-" + email
+	t.Cleanup(func() {
+		if _, err := db.Exec(
+			"DELETE FROM login_failures WHERE subject = ANY(ARRAY[$1, $2])", hashToken(email), ip,
+		); err != nil {
+			t.Errorf("cleanup: %v", err)
+		}
+	})
+
+	s := NewLoginAttemptService(db, LoginAttemptOptions{MaxLockout: time.Minute})
+	first, wait, err := s.Begin(email, ip)
+	if err != nil || wait != 0 {
+		t.Fatalf("Begin() = %v, %v, want no wait", wait, err)
+	}
+
+	// A parallel guess waits until the first one is counted, then sees
+	// the lockout it caused
+	waits := make(chan time.Duration, 1)
+	go func() {
+		attempt, wait, err := s.Begin(email, ip)
+		if err != nil {
+			t.Errorf("parallel Begin() error = %v", err)
+		}
+		if attempt != nil {
+			attempt.Done()
+		}
+		waits <- wait
+	}()
+
+	select {
+	case <-waits:
+		t.Fatal("parallel Begin() returned while the first attempt was open")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if _, err := s.RecordFailure(email, ip); err != nil {
+		t.Fatalf("RecordFailure() error = %v", err)
+	}
+	first.Done()
+
+	if wait := <-waits; wait <= 0 {
+		t.Errorf("parallel Begin() wait = %v, want the lockout of the first failure", wait)
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import "sort"
+
+// maxLouvainSweeps bounds the local moving phase in case floating point
+// noise keeps nodes oscillating between equally good communities
+const maxLouvainSweeps = 100
+
+// louvainGraph is an undirected weighted graph over dense node indexes.
+// adj[i][j] holds the weight between i and j in both directions; after
+// aggregation adj[i][i] holds twice the weight internal to node i, so a
+// node's degree is always the sum of its row.
+type louvainGraph struct {
+	adj    []map[int]float64
+	degree []float64
+	total  float64
+}
+
+func newLouvainGraph(n int) *louvainGraph {
+	g := &louvainGraph{adj: make([]map[int]float64, n), degree: make([]float64, n)}
+	for i := range g.adj {
+		g.adj[i] = map[int]float64{}
+	}
+	return g
+}
+
+func (g *louvainGraph) addEdge(a, b int, weight float64) {
+	if a == b {
+		g.adj[a][a] += 2 * weight
+		g.degree[a] += 2 * weight
+	} else {
+		g.adj[a][b] += weight
+		g.adj[b][a] += weight
+		g.degree[a] += weight
+		g.degree[b] += weight
+	}
+	g.total += 2 * weight
+}
+
+// louvain clusters g with the Louvain method and returns one partition per
+// pass, finest first. Each partition maps every original node index to a
+// community index. Nodes are visited in index order, so the result is
+// deterministic for a given graph.
+func louvain(g *louvainGraph) [][]int {
+	n := len(g.adj)
+
+	// membership maps each original node to its node in the current graph
+	membership := make([]int, n)
+	for i := range membership {
+		membership[i] = i
+	}
+
+	var levels [][]int
+	for {
+		community, moved := louvainLocalMoving(g)
+		if !moved {
+			break
+		}
+
+		// Renumber communities densely, in order of first appearance
+		renumber := map[int]int{}
+		for _, c := range community {
+			if _, ok := renumber[c]; !ok {
+				renumber[c] = len(renumber)
+			}
+		}
+
+		partition := make([]int, n)
+		for i, node := range membership {
+			partition[i] = renumber[community[node]]
+		}
+		levels = append(levels, partition)
+		membership = partition
+
+		if len(renumber) == len(g.adj) {
+			break
+		}
+		g = louvainAggregate(g, community, renumber)
+	}
+
+	return levels
+}
+
+// louvainLocalMoving repeatedly moves each node to the neighboring
+// community with the largest modularity gain until no move improves it
+func louvainLocalMoving(g *louvainGraph) ([]int, bool) {
+	n := len(g.adj)
+	community := make([]int, n)
+	tot := make([]float64, n)
+	for i := range community {
+		community[i] = i
+		tot[i] = g.degree[i]
+	}
+	if g.total == 0 {
+		return community, false
+	}
+
+	moved := false
+	for sweep, improved := 0, true; improved && sweep < maxLouvainSweeps; sweep++ {
+		improved = false
+		for i := 0; i < n; i++ {
+			current := community[i]
+
+			// Weight from i to each neighboring community
+			links := map[int]float64{}
+			for j, w := range g.adj[i] {
+				if j != i {
+					links[community[j]] += w
+				}
+			}
+			neighbors := make([]int, 0, len(links))
+			for c := range links {
+				neighbors = append(neighbors, c)
+			}
+			sort.Ints(neighbors)
+
+			tot[current] -= g.degree[i]
+			best := current
+			bestGain := links[current] - tot[current]*g.degree[i]/g.total
+			for _, c := range neighbors {
+				gain := links[c] - tot[c]*g.degree[i]/g.total
+				if gain > bestGain+1e-12 {
+					best, bestGain = c, gain
+				}
+			}
+			tot[best] += g.degree[i]
+			community[i] = best
+
+			if best != current {
+				improved = true
+				moved = true
+			}
+		}
+	}
+
+	return community, moved
+}
+
+// louvainAggregate builds the graph whose nodes are the communities of g
+func louvainAggregate(g *louvainGraph, community []int, renumber map[int]int) *louvainGraph {
+	aggregated := &louvainGraph{
+		adj:    make([]map[int]float64, len(renumber)),
+		degree: make([]float64, len(renumber)),
+		total:  g.total,
+	}
+	for i := range aggregated.adj {
+		aggregated.adj[i] = map[int]float64{}
+	}
+
+	for i, neighbors := range g.adj {
+		a := renumber[community[i]]
+		for j, w := range neighbors {
+			b := renumber[community[j]]
+			aggregated.adj[a][b] += w
+			aggregated.degree[a] += w
+		}
+	}
+
+	return aggregated
+}
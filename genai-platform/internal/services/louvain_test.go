@@ -0,0 +1,140 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"genai-platform/internal/models"
+)
+
+// twoCliques builds two four-node cliques joined by a single light edge
+func twoCliques() *louvainGraph {
+	g := newLouvainGraph(8)
+	for _, clique := range [][]int{{0, 1, 2, 3}, {4, 5, 6, 7}} {
+		for i, a := range clique {
+			for _, b := range clique[i+1:] {
+				g.addEdge(a, b, 1)
+			}
+		}
+	}
+	g.addEdge(3, 4, 1)
+	return g
+}
+
+func TestLouvainSplitsCliques(t *testing.T) {
+	passes := louvain(twoCliques())
+	if len(passes) == 0 {
+		t.Fatal("louvain() found no communities")
+	}
+
+	final := passes[len(passes)-1]
+	for _, i := range []int{1, 2, 3} {
+		if final[i] != final[0] {
+			t.Errorf("node %d in community %d, want %d with node 0", i, final[i], final[0])
+		}
+	}
+	for _, i := range []int{5, 6, 7} {
+		if final[i] != final[4] {
+			t.Errorf("node %d in community %d, want %d with node 4", i, final[i], final[4])
+		}
+	}
+	if final[0] == final[4] {
+		t.Errorf("louvain() merged both cliques: %v", final)
+	}
+}
+
+func TestLouvainDeterministic(t *testing.T) {
+	want := louvain(twoCliques())
+	for i := 0; i < 10; i++ {
+		if got := louvain(twoCliques()); !reflect.DeepEqual(got, want) {
+			t.Fatalf("louvain() = %v, then %v", want, got)
+		}
+	}
+}
+
+func TestLouvainWithoutEdges(t *testing.T) {
+	for _, n := range []int{0, 1, 5} {
+		if passes := louvain(newLouvainGraph(n)); len(passes) != 0 {
+			t.Errorf("louvain() of %d isolated nodes = %v, want no passes", n, passes)
+		}
+	}
+}
+
+func TestLouvainPassesNest(t *testing.T) {
+	// A ring of triangles merges in more than one pass
+	const triangles = 12
+	g := newLouvainGraph(3 * triangles)
+	for k := 0; k < triangles; k++ {
+		a, b, c := 3*k, 3*k+1, 3*k+2
+		g.addEdge(a, b, 1)
+		g.addEdge(b, c, 1)
+		g.addEdge(a, c, 1)
+		g.addEdge(c, (3*k+3)%(3*triangles), 1)
+	}
+
+	passes := louvain(g)
+	if len(passes) < 2 {
+		t.Fatalf("louvain() = %d passes, want at least 2", len(passes))
+	}
+	for p := 1; p < len(passes); p++ {
+		parent := map[int]int{}
+		for i, c := range passes[p-1] {
+			if got, ok := parent[c]; ok && got != passes[p][i] {
+				t.Errorf("pass %d splits community %d of pass %d", p, c, p-1)
+			}
+			parent[c] = passes[p][i]
+		}
+	}
+}
+
+func TestPlanCommunities(t *testing.T) {
+	var nodes []models.GraphNode
+	for id := 10; id < 19; id++ {
+		nodes = append(nodes, models.GraphNode{ID: id})
+	}
+	edge := func(a, b int) models.GraphEdge { return models.GraphEdge{SourceID: a, TargetID: b, Weight: 1} }
+	edges := []models.GraphEdge{
+		edge(10, 11), edge(11, 12), edge(10, 12),
+		edge(13, 14), edge(14, 15), edge(13, 15),
+		edge(12, 13),
+		edge(17, 17), // self loops are ignored
+		edge(17, 99), // so are edges to unknown nodes
+	}
+
+	levels := planCommunities(nodes, edges)
+	if len(levels) == 0 {
+		t.Fatal("planCommunities() returned no levels")
+	}
+
+	finest := levels[len(levels)-1]
+	var groups [][]int
+	for _, plan := range finest {
+		groups = append(groups, plan.nodeIDs)
+		if plan.hash != communityHash(plan.nodeIDs) {
+			t.Errorf("plan %v hash = %s, want communityHash of its members", plan.nodeIDs, plan.hash)
+		}
+		if plan.level != len(levels)-1 {
+			t.Errorf("plan %v level = %d, want %d", plan.nodeIDs, plan.level, len(levels)-1)
+		}
+	}
+	if want := [][]int{{10, 11, 12}, {13, 14, 15}}; !reflect.DeepEqual(groups, want) {
+		t.Errorf("finest communities = %v, want %v without singletons", groups, want)
+	}
+
+	for l := 1; l < len(levels); l++ {
+		for _, plan := range levels[l] {
+			if plan.parent < 0 || plan.parent >= len(levels[l-1]) {
+				t.Errorf("level %d plan %v has no parent", l, plan.nodeIDs)
+			}
+		}
+	}
+}
+
+func TestCommunityHashIgnoresOrder(t *testing.T) {
+	if communityHash([]int{3, 1, 2}) != communityHash([]int{1, 2, 3}) {
+		t.Error("communityHash() depends on member order")
+	}
+	if communityHash([]int{1, 2}) == communityHash([]int{12}) {
+		t.Error("communityHash() confuses [1 2] with [12]")
+	}
+}
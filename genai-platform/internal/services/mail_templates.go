@@ -0,0 +1,158 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Notification emails. Each template defines a subject, a text body and an
+// HTML body from the same data.
+const (
+	EmailVerifyAddress       = "verify_email"
+	EmailPasswordReset       = "password_reset"
+	EmailPasswordChanged     = "password_changed"
+	EmailWorkspaceInvitation = "workspace_invitation"
+)
+
+type emailTemplate struct {
+	subject string
+	text    string
+	html    string
+}
+
+var emailTemplates = map[string]emailTemplate{
+	EmailVerifyAddress: {
+		subject: `Confirm your email address`,
+		text: `Welcome to GenAI Platform.
+
+Confirm your email address by opening this link:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}}. If you did not sign up, ignore this email.`,
+		html: `<p>Welcome to GenAI Platform.</p>
+<p><a href="{{.Link}}">Confirm your email address</a></p>
+<p>The link expires in {{.ExpiresIn}}. If you did not sign up, ignore this email.</p>`,
+	},
+	EmailPasswordReset: {
+		subject: `Reset your password`,
+		text: `Someone asked to reset the password of your GenAI Platform account.
+
+Choose a new password by opening this link:
+
+{{.Link}}
+
+The link works once and expires in {{.ExpiresIn}}. If you did not ask for it, ignore this email; your password stays the same.`,
+		html: `<p>Someone asked to reset the password of your GenAI Platform account.</p>
+<p><a href="{{.Link}}">Choose a new password</a></p>
+<p>The link works once and expires in {{.ExpiresIn}}. If you did not ask for it, ignore this email; your password stays the same.</p>`,
+	},
+	EmailPasswordChanged: {
+		subject: `Your password was changed`,
+		text: `The password of your GenAI Platform account was changed and every device was signed out.
+
+If this was not you, reset your password at {{.AppURL}} and contact your administrator.`,
+		html: `<p>The password of your GenAI Platform account was changed and every device was signed out.</p>
+<p>If this was not you, <a href="{{.AppURL}}">reset your password</a> and contact your administrator.</p>`,
+	},
+	EmailWorkspaceInvitation: {
+		subject: `{{.InvitedBy}} invited you to {{.Workspace}}`,
+		text: `{{.InvitedBy}} invited you to join the workspace "{{.Workspace}}" on GenAI Platform as {{.Role}}.
+
+Accept the invitation by opening this link:
+
+{{.Link}}
+
+The invitation expires in {{.ExpiresIn}}.`,
+		html: `<p>{{.InvitedBy}} invited you to join the workspace <strong>{{.Workspace}}</strong> on GenAI Platform as {{.Role}}.</p>
+<p><a href="{{.Link}}">Accept the invitation</a></p>
+<p>The invitation expires in {{.ExpiresIn}}.</p>`,
+	},
+}
+
+// parsedEmailTemplate keeps the subject and text apart from the HTML, which
+// is escaped
+type parsedEmailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+var parsedEmailTemplates = func() map[string]parsedEmailTemplate {
+	parsed := map[string]parsedEmailTemplate{}
+	for name, t := range emailTemplates {
+		parsed[name] = parsedEmailTemplate{
+			subject: texttemplate.Must(texttemplate.New(name).Option("missingkey=error").Parse(t.subject)),
+			text:    texttemplate.Must(texttemplate.New(name).Option("missingkey=error").Parse(t.text)),
+			html:    htmltemplate.Must(htmltemplate.New(name).Option("missingkey=error").Parse(t.html)),
+		}
+	}
+	return parsed
+}()
+
+// RenderEmail fills in a notification template for one recipient
+func RenderEmail(name, to string, data map[string]interface{}) (*Email, error) {
+	t, ok := parsedEmailTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	return &Email{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// MailService sends notification emails in the background, so a slow or
+// failing mail provider never holds up a request
+type MailService struct {
+	mailer Mailer
+	appURL string
+}
+
+// NewMailService creates the service. Links in emails point into the web
+// app at appURL.
+func NewMailService(mailer Mailer, appURL string) *MailService {
+	return &MailService{mailer: mailer, appURL: strings.TrimRight(appURL, "/")}
+}
+
+// Link returns the web app address of path with a token query parameter
+func (s *MailService) Link(path, token string) string {
+	return s.appURL + path + "?token=" + token
+}
+
+// Notify renders a notification template and sends it. The app URL is
+// available to every template as AppURL. Failures are only logged.
+func (s *MailService) Notify(to, name string, data map[string]interface{}) {
+	values := map[string]interface{}{"AppURL": s.appURL}
+	for k, v := range data {
+		values[k] = v
+	}
+
+	email, err := RenderEmail(name, to, values)
+	if err != nil {
+		fmt.Printf("Failed to render %s email: %v\n", name, err)
+		return
+	}
+	go func() {
+		if err := s.mailer.Send(*email); err != nil {
+			fmt.Printf("Failed to send %s email to %s: %v\n", name, to, err)
+		}
+	}()
+}
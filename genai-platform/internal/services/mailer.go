@@ -0,0 +1,242 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Email is one message to one recipient, with a plain text and an HTML
+// body
+type Email struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers email
+type Mailer interface {
+	Send(email Email) error
+}
+
+// Mailer names
+const (
+	MailerSendGrid = "sendgrid"
+	MailerSMTP     = "smtp"
+	MailerFile     = "file"
+)
+
+// MailerConfig configures NewMailer
+type MailerConfig struct {
+	// Mailer is MailerSendGrid, MailerSMTP or MailerFile. Empty uses
+	// SendGrid when SendGridAPIKey is set and the file mailer otherwise.
+	Mailer string
+	From   string
+
+	SendGridAPIKey string
+
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+
+	// Dir is where the file mailer writes messages; empty logs them
+	Dir string
+}
+
+// NewMailer builds the configured mailer
+func NewMailer(cfg MailerConfig) (Mailer, error) {
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", cfg.From, err)
+	}
+
+	name := cfg.Mailer
+	if name == "" {
+		name = MailerFile
+		if cfg.SendGridAPIKey != "" {
+			name = MailerSendGrid
+		}
+	}
+
+	switch name {
+	case MailerSendGrid:
+		if cfg.SendGridAPIKey == "" {
+			return nil, fmt.Errorf("the sendgrid mailer needs SENDGRID_API_KEY")
+		}
+		return &SendGridMailer{apiKey: cfg.SendGridAPIKey, from: cfg.From, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	case MailerSMTP:
+		if cfg.SMTPHost == "" {
+			return nil, fmt.Errorf("the smtp mailer needs SMTP_HOST")
+		}
+		return &SMTPMailer{
+			addr:     net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+			host:     cfg.SMTPHost,
+			username: cfg.SMTPUsername,
+			password: cfg.SMTPPassword,
+			from:     cfg.From,
+		}, nil
+	case MailerFile:
+		if cfg.Dir != "" {
+			if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+				return nil, err
+			}
+		}
+		return &FileMailer{dir: cfg.Dir, from: cfg.From}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q, expected sendgrid, smtp or file", name)
+	}
+}
+
+// SendGridMailer sends email through the SendGrid v3 API
+type SendGridMailer struct {
+	apiKey string
+	from   string
+	client *http.Client
+}
+
+const sendGridURL = "https://api.sendgrid.com/v3/mail/send"
+
+func (m *SendGridMailer) Send(email Email) error {
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return err
+	}
+
+	type address struct {
+		Email string `json:"email"`
+		Name  string `json:"name,omitempty"`
+	}
+	type content struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"personalizations": []map[string]interface{}{{"to": []address{{Email: email.To}}}},
+		"from":             address{Email: from.Address, Name: from.Name},
+		"subject":          email.Subject,
+		"content":          []content{{"text/plain", email.Text}, {"text/html", email.HTML}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sendGridURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var detail bytes.Buffer
+		detail.ReadFrom(resp.Body)
+		return fmt.Errorf("sendgrid returned %s: %s", resp.Status, strings.TrimSpace(detail.String()))
+	}
+	return nil
+}
+
+// SMTPMailer sends email through an SMTP server, with STARTTLS when the
+// server offers it and PLAIN authentication when a username is set
+type SMTPMailer struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+func (m *SMTPMailer) Send(email Email) error {
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return err
+	}
+	message, err := buildMessage(m.from, email)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+	return smtp.SendMail(m.addr, auth, from.Address, []string{email.To}, message)
+}
+
+// FileMailer writes each email as an .eml file to a directory, or logs it
+// when no directory is set. It is meant for development and tests.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func (m *FileMailer) Send(email Email) error {
+	if m.dir == "" {
+		fmt.Printf("Email to %s: %s\n%s\n", email.To, email.Subject, email.Text)
+		return nil
+	}
+
+	message, err := buildMessage(m.from, email)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s_%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(b))
+	return os.WriteFile(filepath.Join(m.dir, name), message, 0644)
+}
+
+// buildMessage formats email as a multipart/alternative MIME message
+func buildMessage(from string, email Email) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", email.To)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
@@ -0,0 +1,433 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"genai-platform/internal/auth"
+	"genai-platform/internal/models"
+)
+
+const (
+	// recoveryCodeCount is how many recovery codes a user gets at a time
+	recoveryCodeCount = 10
+
+	// maxMFAAttempts is how many wrong codes a sign-in challenge takes
+	// before the user must enter their password again
+	maxMFAAttempts = 5
+)
+
+var (
+	// ErrMFANotEnabled is returned for a user without two-factor
+	// authentication
+	ErrMFANotEnabled = errors.New("two-factor authentication is not turned on")
+
+	// ErrMFAAlreadyEnabled is returned when enrolling a user who has
+	// two-factor authentication already
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already turned on")
+
+	// ErrMFANotEnrolled is returned when activating before enrolling
+	ErrMFANotEnrolled = errors.New("start enrollment before turning on two-factor authentication")
+
+	// ErrInvalidMFACode is returned for a wrong, reused or malformed
+	// authentication or recovery code
+	ErrInvalidMFACode = errors.New("invalid authentication code")
+
+	// ErrMFAChallenge is returned for an unknown, expired or exhausted
+	// sign-in challenge
+	ErrMFAChallenge = errors.New("sign-in has expired, please sign in again")
+
+	// ErrMFARequiredByWorkspace is returned when turning off two-factor
+	// authentication that a workspace of the user requires
+	ErrMFARequiredByWorkspace = errors.New("a workspace you belong to requires two-factor authentication")
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFAService manages TOTP two-factor authentication: enrolling an
+// authenticator app, one-time recovery codes, and the challenge that
+// stands between a correct password and a session. Recovery codes and
+// challenge tokens are stored hashed; an authenticator code is accepted
+// once.
+type MFAService struct {
+	db           *sql.DB
+	issuer       string
+	challengeTTL time.Duration
+}
+
+func NewMFAService(db *sql.DB, issuer string, challengeTTL time.Duration) *MFAService {
+	return &MFAService{db: db, issuer: issuer, challengeTTL: challengeTTL}
+}
+
+// ChallengeTTL is how long a sign-in challenge lasts
+func (s *MFAService) ChallengeTTL() time.Duration {
+	return s.challengeTTL
+}
+
+// Status returns whether a user has two-factor authentication, how many
+// recovery codes they have left and which of their workspaces require it
+func (s *MFAService) Status(userID int) (*models.MFAStatus, error) {
+	status := &models.MFAStatus{RequiredBy: []string{}}
+	var enabledAt sql.NullTime
+	err := s.db.QueryRow(
+		`SELECT enabled_at,
+		        (SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL)
+		 FROM user_mfa WHERE user_id = $1`,
+		userID,
+	).Scan(&enabledAt, &status.RecoveryCodesLeft)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if enabledAt.Valid {
+		status.Enabled = true
+		status.EnabledAt = &enabledAt.Time
+	} else {
+		status.RecoveryCodesLeft = 0
+	}
+
+	rows, err := s.db.Query(
+		`SELECT w.name FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id
+		 WHERE m.user_id = $1 AND w.require_mfa ORDER BY w.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		status.RequiredBy = append(status.RequiredBy, name)
+	}
+	return status, rows.Err()
+}
+
+// Enabled reports whether a user signs in with a second factor
+func (s *MFAService) Enabled(userID int) (bool, error) {
+	var enabled bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM user_mfa WHERE user_id = $1 AND enabled_at IS NOT NULL)", userID,
+	).Scan(&enabled)
+	return enabled, err
+}
+
+// Enroll makes a new secret for a user to add to their authenticator app,
+// returning it and the otpauth:// URI to show as a QR code, labelled with
+// their email. It replaces the secret of an enrollment that was never
+// activated and returns ErrMFAAlreadyEnabled when two-factor
+// authentication is on.
+func (s *MFAService) Enroll(userID int) (string, string, error) {
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	var account string
+	err = s.db.QueryRow(
+		`INSERT INTO user_mfa (user_id, totp_secret) VALUES ($1, $2)
+		 ON CONFLICT (user_id) DO UPDATE
+		 SET totp_secret = EXCLUDED.totp_secret, last_used_step = 0, created_at = NOW()
+		 WHERE user_mfa.enabled_at IS NULL
+		 RETURNING (SELECT email FROM users WHERE id = $1)`,
+		userID, secret,
+	).Scan(&account)
+	if err == sql.ErrNoRows {
+		return "", "", ErrMFAAlreadyEnabled
+	} else if err != nil {
+		return "", "", err
+	}
+	return secret, auth.TOTPURI(s.issuer, account, secret), nil
+}
+
+// Activate turns on two-factor authentication once the user proves their
+// app has the secret by entering a code from it, and returns their
+// recovery codes. They are shown this once.
+func (s *MFAService) Activate(userID int, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var secret string
+	var enabled bool
+	err = tx.QueryRow(
+		"SELECT totp_secret, enabled_at IS NOT NULL FROM user_mfa WHERE user_id = $1 FOR UPDATE", userID,
+	).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return nil, ErrMFANotEnrolled
+	} else if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := auth.VerifyTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+	if _, err := tx.Exec(
+		"UPDATE user_mfa SET enabled_at = NOW(), last_used_step = $1 WHERE user_id = $2", step, userID,
+	); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns off two-factor authentication after checking a code from
+// the user's app or a recovery code. It returns ErrMFARequiredByWorkspace
+// while a workspace of the user requires it.
+func (s *MFAService) Disable(userID int, code string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkMFACode(tx, userID, code); err != nil {
+		return err
+	}
+	var required bool
+	if err := tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id
+		                WHERE m.user_id = $1 AND w.require_mfa)`,
+		userID,
+	).Scan(&required); err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequiredByWorkspace
+	}
+	if err := removeMFA(tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes after checking
+// a code, returning the new ones
+func (s *MFAService) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkMFACode(tx, userID, code); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Reset turns off a user's two-factor authentication for an administrator,
+// as when they lost their phone and recovery codes. It returns
+// sql.ErrNoRows for an unknown user.
+func (s *MFAService) Reset(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	if err := removeMFA(tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Challenge starts the second step of signing in for a user whose password
+// or identity provider checked out, returning the token to send back with
+// their code
+func (s *MFAService) Challenge(userID int) (string, error) {
+	if _, err := s.db.Exec("DELETE FROM mfa_challenges WHERE expires_at < NOW()"); err != nil {
+		fmt.Printf("Failed to purge expired MFA challenges: %v\n", err)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.db.Exec(
+		"INSERT INTO mfa_challenges (token_hash, user_id, expires_at) VALUES ($1, $2, $3)",
+		hashToken(token), userID, time.Now().Add(s.challengeTTL),
+	); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// VerifyChallenge finishes signing in with a challenge token and a code
+// from the user's app or a recovery code, returning the user's id and
+// email, which are returned with ErrInvalidMFACode too. A challenge works
+// once and takes a few wrong codes, after which it returns
+// ErrMFAChallenge.
+func (s *MFAService) VerifyChallenge(token, code string) (int, string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	var userID, attempts int
+	var email string
+	var expiresAt time.Time
+	err = tx.QueryRow(
+		`SELECT c.user_id, u.email, c.attempts, c.expires_at
+		 FROM mfa_challenges c JOIN users u ON u.id = c.user_id
+		 WHERE c.token_hash = $1 AND u.disabled_at IS NULL
+		 FOR UPDATE OF c`,
+		hashToken(token),
+	).Scan(&userID, &email, &attempts, &expiresAt)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrMFAChallenge
+	} else if err != nil {
+		return 0, "", err
+	}
+	if time.Now().After(expiresAt) || attempts >= maxMFAAttempts {
+		return 0, "", ErrMFAChallenge
+	}
+
+	err = checkMFACode(tx, userID, code)
+	if err == ErrInvalidMFACode {
+		if _, err := tx.Exec(
+			"UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = $1", hashToken(token),
+		); err != nil {
+			return 0, "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, "", err
+		}
+		return userID, email, ErrInvalidMFACode
+	} else if err == ErrMFANotEnabled {
+		// Turned off by an administrator since the password was checked;
+		// signing in again skips the second step
+		return 0, "", ErrMFAChallenge
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	if _, err := tx.Exec("DELETE FROM mfa_challenges WHERE token_hash = $1", hashToken(token)); err != nil {
+		return 0, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, "", err
+	}
+	return userID, email, nil
+}
+
+// checkMFACode accepts a code from the user's app, unless its period was
+// already used, or an unused recovery code, which is then used up
+func checkMFACode(tx *sql.Tx, userID int, code string) error {
+	var secret string
+	var lastStep int64
+	err := tx.QueryRow(
+		"SELECT totp_secret, last_used_step FROM user_mfa WHERE user_id = $1 AND enabled_at IS NOT NULL FOR UPDATE",
+		userID,
+	).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return ErrMFANotEnabled
+	} else if err != nil {
+		return err
+	}
+
+	if step, ok := auth.VerifyTOTP(secret, code, time.Now()); ok {
+		if step <= lastStep {
+			return ErrInvalidMFACode
+		}
+		_, err := tx.Exec("UPDATE user_mfa SET last_used_step = $1 WHERE user_id = $2", step, userID)
+		return err
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return ErrInvalidMFACode
+	}
+	result, err := tx.Exec(
+		`UPDATE mfa_recovery_codes SET used_at = NOW()
+		 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, hashToken(normalized),
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// replaceRecoveryCodes gives a user a new set of recovery codes in place of
+// any they had
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+		code := encoded[:5] + "-" + encoded[5:]
+		if _, err := tx.Exec(
+			"INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, hashToken(normalizeRecoveryCode(code)),
+		); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode drops the case, dashes and spaces people type
+// recovery codes with, returning "" for anything that can not be one
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 10 {
+		return ""
+	}
+	return code
+}
+
+func removeMFA(tx *sql.Tx, userID int) error {
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM mfa_challenges WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM user_mfa WHERE user_id = $1", userID)
+	return err
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"genai-platform/internal/auth"
+	"genai-platform/internal/database"
+)
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"abcde-fghij", "abcdefghij"},
+		{"ABCDE-FGHIJ", "abcdefghij"},
+		{" abcde fghij ", "abcdefghij"},
+		{"abcdefghij", "abcdefghij"},
+		{"abcde-fghi", ""},
+		{"abcde-fghijk", ""},
+		{"123456", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestRecoveryCodeHash(t *testing.T) {
+	// However a code is typed it hashes to what was stored, and what was
+	// stored is not the code
+	stored := hashToken(normalizeRecoveryCode("abcde-fghij"))
+	if stored == "abcdefghij" || len(stored) != 64 {
+		t.Errorf("stored recovery code = %q, want a SHA-256 hex digest", stored)
+	}
+	for _, typed := range []string{"ABCDE-FGHIJ", "abcde fghij", "abcdefghij"} {
+		if got := hashToken(normalizeRecoveryCode(typed)); got != stored {
+			t.Errorf("hash of %q = %s, want %s", typed, got, stored)
+		}
+	}
+	if hashToken(normalizeRecoveryCode("abcde-fghik")) == stored {
+		t.Error("different recovery codes hash the same")
+	}
+}
+
+// TestMFA runs enrollment, codes and sign-in challenges against the
+// database in DATABASE_URL, with a throwaway user
+func TestMFA(t *testing.T) {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	db, err := database.Initialize(databaseURL)
+	if err != nil {
+		t.Fatalf("failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	email := fmt.Sprintf("mfa%d@example.com", time.Now().UnixNano())
+	var userID int
+	if err := db.QueryRow(
+		"INSERT INTO users (email, password_hash) VALUES ($1, 'unused') RETURNING id", email,
+	).Scan(&userID); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec("DELETE FROM users WHERE id = $1", userID); err != nil {
+			t.Errorf("cleanup: %v", err)
+		}
+	})
+
+	mfa := NewMFAService(db, "GenAI Platform", 5*time.Minute)
+
+	secret, uri, err := mfa.Enroll(userID)
+	if err != nil {
+		t.Fatalf("Enroll() error = %v", err)
+	}
+	if uri != auth.TOTPURI("GenAI Platform", email, secret) {
+		t.Errorf("Enroll() URI = %q", uri)
+	}
+	step := auth.TOTPStep(time.Now())
+	code := func(step int64) string {
+		c, err := auth.TOTPCode(secret, step)
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		return c
+	}
+
+	if _, err := mfa.Activate(userID, "000000x"); err != ErrInvalidMFACode {
+		t.Fatalf("Activate() with a wrong code error = %v, want ErrInvalidMFACode", err)
+	}
+	recoveryCodes, err := mfa.Activate(userID, code(step))
+	if err != nil {
+		t.Fatalf("Activate() error = %v", err)
+	}
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("Activate() = %d recovery codes, want %d", len(recoveryCodes), recoveryCodeCount)
+	}
+	if _, _, err := mfa.Enroll(userID); err != ErrMFAAlreadyEnabled {
+		t.Errorf("Enroll() when enabled error = %v, want ErrMFAAlreadyEnabled", err)
+	}
+
+	check := func(code string) error {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("failed to begin: %v", err)
+		}
+		defer tx.Rollback()
+		if err := checkMFACode(tx, userID, code); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	t.Run("recovery codes are stored hashed", func(t *testing.T) {
+		rows, err := db.Query("SELECT code_hash FROM mfa_recovery_codes WHERE user_id = $1", userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		stored := map[string]bool{}
+		for rows.Next() {
+			var hash string
+			if err := rows.Scan(&hash); err != nil {
+				t.Fatal(err)
+			}
+			stored[hash] = true
+		}
+		for _, code := range recoveryCodes {
+			if stored[code] || stored[normalizeRecoveryCode(code)] {
+				t.Errorf("recovery code %s is stored in the clear", code)
+			}
+			if !stored[hashToken(normalizeRecoveryCode(code))] {
+				t.Errorf("recovery code %s is not stored hashed", code)
+			}
+		}
+	})
+
+	t.Run("authenticator codes work once", func(t *testing.T) {
+		if err := check(code(step)); err != ErrInvalidMFACode {
+			t.Errorf("code used to activate error = %v, want ErrInvalidMFACode", err)
+		}
+		if err := check(code(step + 1)); err != nil {
+			t.Errorf("next code error = %v", err)
+		}
+		if err := check(code(step + 1)); err != ErrInvalidMFACode {
+			t.Errorf("replayed code error = %v, want ErrInvalidMFACode", err)
+		}
+		if err := check(code(step)); err != ErrInvalidMFACode {
+			t.Errorf("earlier code error = %v, want ErrInvalidMFACode", err)
+		}
+	})
+
+	t.Run("recovery codes work once", func(t *testing.T) {
+		if err := check(" " + recoveryCodes[0] + " "); err != nil {
+			t.Errorf("recovery code error = %v", err)
+		}
+		if err := check(recoveryCodes[0]); err != ErrInvalidMFACode {
+			t.Errorf("used recovery code error = %v, want ErrInvalidMFACode", err)
+		}
+	})
+
+	t.Run("challenges take a few wrong codes", func(t *testing.T) {
+		token, err := mfa.Challenge(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < maxMFAAttempts; i++ {
+			if id, _, err := mfa.VerifyChallenge(token, "wrong"); err != ErrInvalidMFACode || id != userID {
+				t.Fatalf("wrong code #%d = %d, %v, want ErrInvalidMFACode", i+1, id, err)
+			}
+		}
+		if _, _, err := mfa.VerifyChallenge(token, recoveryCodes[1]); err != ErrMFAChallenge {
+			t.Errorf("right code after %d wrong ones error = %v, want ErrMFAChallenge", maxMFAAttempts, err)
+		}
+
+		// The exhausted challenge did not use up the recovery code
+		token, err = mfa.Challenge(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, gotEmail, err := mfa.VerifyChallenge(token, recoveryCodes[1]); err != nil || id != userID || gotEmail != email {
+			t.Errorf("VerifyChallenge() = %d, %s, %v, want %d, %s", id, gotEmail, err, userID, email)
+		}
+		if _, _, err := mfa.VerifyChallenge(token, recoveryCodes[2]); err != ErrMFAChallenge {
+			t.Errorf("reused challenge error = %v, want ErrMFAChallenge", err)
+		}
+	})
+
+	t.Run("challenges expire", func(t *testing.T) {
+		token, err := NewMFAService(db, "GenAI Platform", -time.Second).Challenge(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := mfa.VerifyChallenge(token, recoveryCodes[3]); err != ErrMFAChallenge {
+			t.Errorf("expired challenge error = %v, want ErrMFAChallenge", err)
+		}
+		if _, _, err := mfa.VerifyChallenge("unknown", recoveryCodes[3]); err != ErrMFAChallenge {
+			t.Errorf("unknown challenge error = %v, want ErrMFAChallenge", err)
+		}
+	})
+
+	t.Run("disabling needs a code", func(t *testing.T) {
+		if err := mfa.Disable(userID, "wrong"); err != ErrInvalidMFACode {
+			t.Errorf("Disable() with a wrong code error = %v, want ErrInvalidMFACode", err)
+		}
+		if err := mfa.Disable(userID, recoveryCodes[4]); err != nil {
+			t.Errorf("Disable() error = %v", err)
+		}
+		if enabled, err := mfa.Enabled(userID); err != nil || enabled {
+			t.Errorf("Enabled() after Disable() = %v, %v", enabled, err)
+		}
+	})
+}
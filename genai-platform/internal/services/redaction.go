@@ -0,0 +1,243 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of personal data replaced by redaction. Each names the placeholders
+// that stand in for it, e.g. [EMAIL_1].
+const (
+	PIIEmail      = "EMAIL"
+	PIIPhone      = "PHONE"
+	PIIAddress    = "ADDRESS"
+	PIINationalID = "NATIONAL_ID"
+	PIIName       = "NAME"
+)
+
+// piiPattern finds one kind of personal data. When group is set only that
+// submatch is replaced, leaving context such as an honorific in place.
+type piiPattern struct {
+	kind  string
+	re    *regexp.Regexp
+	group int
+	valid func(string) bool
+}
+
+// piiPatterns run in order, so emails are replaced before the names and
+// numbers inside them and national ids before the looser phone pattern
+var piiPatterns = []piiPattern{
+	{kind: PIIEmail, re: resumeEmailRe},
+	// US social security, Canadian social insurance, UK national insurance,
+	// Indian Aadhaar and PAN numbers
+	{kind: PIINationalID, re: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+	{kind: PIINationalID, re: regexp.MustCompile(`\b\d{3}[ -]\d{3}[ -]\d{3}\b`)},
+	{kind: PIINationalID, re: regexp.MustCompile(`\b[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z] ?\d{2} ?\d{2} ?\d{2} ?[A-D]\b`)},
+	{kind: PIINationalID, re: regexp.MustCompile(`\b\d{4} \d{4} \d{4}\b`), valid: notYears},
+	{kind: PIINationalID, re: regexp.MustCompile(`\b[A-Z]{5}\d{4}[A-Z]\b`)},
+	{kind: PIIAddress, re: regexp.MustCompile(`\b\d{1,6}\s+(?:[A-Z0-9][A-Za-z0-9.'\-]*\s+){1,4}(?:Street|St|Avenue|Ave|Road|Rd|Boulevard|Blvd|Lane|Ln|Drive|Dr|Court|Ct|Way|Place|Pl|Terrace|Circle|Cir|Parkway|Pkwy|Highway|Hwy|Square|Sq)\b\.?(?:,?\s*(?:Apt|Apartment|Suite|Ste|Unit|#)\.?\s*[A-Za-z0-9\-]+)?`)},
+	{kind: PIIPhone, re: resumePhoneRe, valid: func(s string) bool {
+		digits := countDigits(s)
+		return digits >= 7 && digits <= 15 && notYears(s)
+	}},
+	// Names stay on one line, so a label on the next is not taken for one
+	{kind: PIIName, re: regexp.MustCompile(`\b(?:Mr|Mrs|Ms|Miss|Mx|Dr|Prof)\.?[ \t]+([A-Z][\p{L}'\-]+(?:[ \t]+[A-Z][\p{L}'\-]+){0,2})`), group: 1},
+	{kind: PIIName, re: regexp.MustCompile(`(?im)^[ \t]*(?:name|full name|candidate|applicant|contact)[ \t]*:[ \t]*([A-Z][\p{L}'\-]+(?:[ \t]+[A-Z][\p{L}'\-]+){1,3})`), group: 1},
+}
+
+var piiYearsRe = regexp.MustCompile(`^(?:(?:19|20)\d{2}\D*)+$`)
+
+// notYears rejects runs of years, as in "2019 2020 2021", which are
+// neither phone nor national id numbers
+func notYears(s string) bool {
+	return !piiYearsRe.MatchString(s)
+}
+
+// Redaction replaces personal data in text sent to a model with numbered
+// placeholders and puts the originals back in what the model returns. The
+// same value always gets the same placeholder within one redaction, so the
+// model can still tell people apart.
+type Redaction struct {
+	names        []string
+	placeholders map[string]string
+	originals    map[string]string
+	numbers      map[string]int
+	counts       map[string]int
+}
+
+// NewRedaction starts a redaction. Names that patterns cannot find, such as
+// the candidate named at the top of a resume, are passed in knownNames.
+func NewRedaction(knownNames ...string) *Redaction {
+	r := &Redaction{
+		placeholders: map[string]string{},
+		originals:    map[string]string{},
+		numbers:      map[string]int{},
+		counts:       map[string]int{},
+	}
+
+	// A full name is also redacted where it appears only in part, e.g. by
+	// surname. Longer forms go first so they are not split.
+	seen := map[string]bool{}
+	for _, name := range knownNames {
+		name = strings.Join(strings.Fields(name), " ")
+		if name == "" {
+			continue
+		}
+		forms := []string{name}
+		for _, part := range strings.Fields(name) {
+			if len([]rune(strings.Trim(part, "."))) > 2 {
+				forms = append(forms, part)
+			}
+		}
+		for _, form := range forms {
+			if !seen[form] {
+				seen[form] = true
+				r.names = append(r.names, form)
+			}
+		}
+	}
+	sort.SliceStable(r.names, func(i, j int) bool { return len(r.names[i]) > len(r.names[j]) })
+
+	return r
+}
+
+// Redact replaces the personal data in text with placeholders
+func (r *Redaction) Redact(text string) string {
+	for _, pattern := range piiPatterns {
+		text = r.replace(text, pattern)
+	}
+	for _, name := range r.names {
+		re := regexp.MustCompile(`\b` + regexp.QuoteMeta(name) + `\b`)
+		text = r.replace(text, piiPattern{kind: PIIName, re: re})
+	}
+	return text
+}
+
+func (r *Redaction) replace(text string, pattern piiPattern) string {
+	matches := pattern.re.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return text
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := match[2*pattern.group], match[2*pattern.group+1]
+		if start < 0 {
+			continue
+		}
+		value := text[start:end]
+		if pattern.valid != nil && !pattern.valid(value) {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(r.placeholder(pattern.kind, value))
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func (r *Redaction) placeholder(kind, value string) string {
+	r.counts[kind]++
+	key := kind + "\x00" + value
+	if placeholder, ok := r.placeholders[key]; ok {
+		return placeholder
+	}
+	r.numbers[kind]++
+	placeholder := fmt.Sprintf("[%s_%d]", kind, r.numbers[kind])
+	r.placeholders[key] = placeholder
+	r.originals[placeholder] = value
+	return placeholder
+}
+
+// Restore puts the original values back in place of placeholders
+func (r *Redaction) Restore(text string) string {
+	if len(r.originals) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, 2*len(r.originals))
+	for placeholder, original := range r.originals {
+		pairs = append(pairs, placeholder, original)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// RestoreJSON restores placeholders inside the strings of a JSON document,
+// escaping the originals so the document stays valid
+func (r *Redaction) RestoreJSON(data []byte) []byte {
+	if len(r.originals) == 0 {
+		return data
+	}
+	pairs := make([]string, 0, 2*len(r.originals))
+	for placeholder, original := range r.originals {
+		encoded, _ := json.Marshal(original)
+		pairs = append(pairs, placeholder, string(encoded[1:len(encoded)-1]))
+	}
+	return []byte(strings.NewReplacer(pairs...).Replace(string(data)))
+}
+
+// RedactArgs redacts every string in a model call's arguments. File paths
+// are dropped rather than redacted, since the other side would read the
+// file as it is: a call naming a file in a *_path argument has to send its
+// text in the matching *_text argument, or it is refused.
+func (r *Redaction) RedactArgs(args map[string]interface{}) (map[string]interface{}, error) {
+	// Round-trip through JSON so structured arguments become plain maps,
+	// slices and strings that can be walked
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var generic map[string]interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	for key, value := range generic {
+		if !strings.HasSuffix(key, "_path") {
+			continue
+		}
+		if path, _ := value.(string); path != "" {
+			text, _ := generic[strings.TrimSuffix(key, "_path")+"_text"].(string)
+			if strings.TrimSpace(text) == "" {
+				return nil, fmt.Errorf("%s can not be redacted without its text", key)
+			}
+		}
+		delete(generic, key)
+	}
+	for key, value := range generic {
+		generic[key] = r.redactValue(value)
+	}
+	return generic, nil
+}
+
+func (r *Redaction) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.Redact(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = r.redactValue(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = r.redactValue(v[key])
+		}
+	}
+	return value
+}
+
+// Counts reports how many values of each kind were replaced
+func (r *Redaction) Counts() map[string]int {
+	counts := make(map[string]int, len(r.counts))
+	for kind, n := range r.counts {
+		counts[kind] = n
+	}
+	return counts
+}
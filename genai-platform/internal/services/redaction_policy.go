@@ -0,0 +1,215 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"genai-platform/internal/models"
+	"github.com/lib/pq"
+)
+
+// RedactableOperations are the model calls that send user content to the
+// provider, by the name the Python bridge knows them under. Redaction is
+// switched on per operation.
+var RedactableOperations = []string{
+	"analyze_resume",
+	"conduct_research",
+	"explain_sql_result",
+	"extract_graph",
+	"generate_chat_response",
+	"generate_sql_from_natural_language",
+	"graph_map_answer",
+	"graph_reduce_answer",
+	"repair_sql",
+	"search_similar_chunks",
+	"summarize_community",
+}
+
+// RedactionService decides which model calls are redacted and keeps the
+// audit log of redactions. Operations listed in PII_REDACTION are redacted
+// for everyone unless a tenant has its own setting.
+type RedactionService struct {
+	db       *sql.DB
+	defaults []string
+}
+
+// NewRedactionService creates the service with the operations redacted by
+// default, as returned by ParseRedactionOperations
+func NewRedactionService(db *sql.DB, defaults []string) *RedactionService {
+	return &RedactionService{db: db, defaults: defaults}
+}
+
+// ParseRedactionOperations reads a comma separated list of operations, or
+// "all". An empty spec, or "off", redacts nothing.
+func ParseRedactionOperations(spec string) ([]string, error) {
+	spec = strings.TrimSpace(spec)
+	switch strings.ToLower(spec) {
+	case "", "off", "none":
+		return []string{}, nil
+	case "all", "on":
+		return append([]string{}, RedactableOperations...), nil
+	}
+
+	var operations []string
+	for _, part := range strings.Split(spec, ",") {
+		operation := strings.TrimSpace(part)
+		if !isRedactableOperation(operation) {
+			return nil, fmt.Errorf("unknown redaction operation %q: expected all or some of %s", operation, strings.Join(RedactableOperations, ", "))
+		}
+		operations = append(operations, operation)
+	}
+	return cleanList(operations), nil
+}
+
+// ValidateRedactionOperations checks that every operation can be redacted
+func ValidateRedactionOperations(operations []string) error {
+	for _, operation := range operations {
+		if !isRedactableOperation(operation) {
+			return fmt.Errorf("unknown redaction operation %q", operation)
+		}
+	}
+	return nil
+}
+
+func isRedactableOperation(operation string) bool {
+	for _, known := range RedactableOperations {
+		if operation == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Setting returns the redaction setting in effect for a tenant: its own
+// when it has saved one, the server default otherwise
+func (s *RedactionService) Setting(userID int) (*models.RedactionSetting, error) {
+	setting := &models.RedactionSetting{Source: "tenant"}
+	var operations []string
+	err := s.db.QueryRow(
+		"SELECT enabled, operations, updated_at FROM pii_redaction_settings WHERE user_id = $1",
+		userID,
+	).Scan(&setting.Enabled, pq.Array(&operations), &setting.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return &models.RedactionSetting{
+			Enabled:    len(s.defaults) > 0,
+			Operations: append([]string{}, s.defaults...),
+			Source:     "default",
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A tenant that enables redaction without naming operations gets all
+	if setting.Enabled && len(operations) == 0 {
+		operations = append([]string{}, RedactableOperations...)
+	}
+	if !setting.Enabled {
+		operations = []string{}
+	}
+	setting.Operations = operations
+	return setting, nil
+}
+
+// SaveSetting stores a tenant's own setting, overriding the default.
+// Enabling with no operations redacts every operation.
+func (s *RedactionService) SaveSetting(userID int, enabled bool, operations []string) error {
+	if err := ValidateRedactionOperations(operations); err != nil {
+		return err
+	}
+	operations = cleanList(operations)
+	sort.Strings(operations)
+
+	_, err := s.db.Exec(
+		`INSERT INTO pii_redaction_settings (user_id, enabled, operations, updated_at)
+		 VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		 ON CONFLICT (user_id) DO UPDATE SET
+		   enabled = EXCLUDED.enabled, operations = EXCLUDED.operations, updated_at = CURRENT_TIMESTAMP`,
+		userID, enabled, pq.Array(operations),
+	)
+	return err
+}
+
+// ResetSetting drops a tenant's own setting so the default applies again
+func (s *RedactionService) ResetSetting(userID int) error {
+	_, err := s.db.Exec("DELETE FROM pii_redaction_settings WHERE user_id = $1", userID)
+	return err
+}
+
+// Enabled reports whether an operation run for userID is redacted. Calls
+// made outside any tenant, with userID 0, follow the default.
+func (s *RedactionService) Enabled(userID int, operation string) (bool, error) {
+	operations := s.defaults
+	if userID != 0 {
+		setting, err := s.Setting(userID)
+		if err != nil {
+			return false, err
+		}
+		operations = setting.Operations
+	}
+
+	for _, enabled := range operations {
+		if enabled == operation {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Record adds a redaction to the audit log. Only the number of values of
+// each kind is kept; the values themselves never leave the request.
+func (s *RedactionService) Record(userID int, operation string, counts map[string]int) error {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	if total == 0 {
+		return nil
+	}
+
+	countsJSON, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+
+	var user interface{}
+	if userID != 0 {
+		user = userID
+	}
+	_, err = s.db.Exec(
+		"INSERT INTO pii_redaction_events (user_id, operation, counts, total) VALUES ($1, $2, $3, $4)",
+		user, operation, countsJSON, total,
+	)
+	return err
+}
+
+// Events lists a tenant's redactions, newest first
+func (s *RedactionService) Events(userID, limit, offset int) ([]models.RedactionEvent, error) {
+	rows, err := s.db.Query(
+		`SELECT id, operation, counts, total, created_at FROM pii_redaction_events
+		 WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3`,
+		userID, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.RedactionEvent{}
+	for rows.Next() {
+		var event models.RedactionEvent
+		var countsJSON []byte
+		if err := rows.Scan(&event.ID, &event.Operation, &countsJSON, &event.Total, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(countsJSON, &event.Counts); err != nil {
+			return nil, err
+		}
+		event.UserID = userID
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
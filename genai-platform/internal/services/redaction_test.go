@@ -0,0 +1,141 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name       string
+		knownNames []string
+		text       string
+		want       string
+	}{
+		{
+			name: "email",
+			text: "Reach me at jane.doe@example.com.",
+			want: "Reach me at [EMAIL_1].",
+		},
+		{
+			name: "phone",
+			text: "Call +1 (555) 123-4567 after six",
+			want: "Call [PHONE_1] after six",
+		},
+		{
+			name: "years are not phone numbers",
+			text: "Engineer 2019 2020 2021",
+			want: "Engineer 2019 2020 2021",
+		},
+		{
+			name: "social security number",
+			text: "SSN 123-45-6789",
+			want: "SSN [NATIONAL_ID_1]",
+		},
+		{
+			name: "UK national insurance number",
+			text: "NI number AB 12 34 56 C",
+			want: "NI number [NATIONAL_ID_1]",
+		},
+		{
+			name: "street address",
+			text: "Lives at 221 Baker Street, Apt 2",
+			want: "Lives at [ADDRESS_1]",
+		},
+		{
+			name: "honorific keeps its title",
+			text: "Referred by Dr. Gregory House",
+			want: "Referred by Dr. [NAME_1]",
+		},
+		{
+			name: "labelled name",
+			text: "Name: Jane Doe\nRole: Engineer",
+			want: "Name: [NAME_1]\nRole: Engineer",
+		},
+		{
+			name:       "known name and its parts",
+			knownNames: []string{"Jane  Doe"},
+			text:       "Jane Doe led the team. Doe shipped it.",
+			want:       "[NAME_1] led the team. [NAME_2] shipped it.",
+		},
+		{
+			name: "same value same placeholder",
+			text: "a@example.com, b@example.com, a@example.com",
+			want: "[EMAIL_1], [EMAIL_2], [EMAIL_1]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRedaction(tt.knownNames...)
+			got := r.Redact(tt.text)
+			if got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if restored := r.Restore(got); restored != tt.text {
+				t.Errorf("Restore(%q) = %q, want %q", got, restored, tt.text)
+			}
+		})
+	}
+}
+
+func TestRedactionCounts(t *testing.T) {
+	r := NewRedaction()
+	r.Redact("a@example.com, a@example.com, SSN 123-45-6789")
+
+	counts := r.Counts()
+	if counts[PIIEmail] != 2 || counts[PIINationalID] != 1 {
+		t.Errorf("Counts() = %v, want 2 EMAIL and 1 NATIONAL_ID", counts)
+	}
+}
+
+func TestRestoreJSON(t *testing.T) {
+	r := NewRedaction(`Jo "JJ" O'Neil`)
+	redacted := r.Redact(`Jo "JJ" O'Neil`)
+
+	output, _ := json.Marshal(map[string]string{"summary": redacted + " is strong"})
+	var restored map[string]string
+	if err := json.Unmarshal(r.RestoreJSON(output), &restored); err != nil {
+		t.Fatalf("RestoreJSON() gave invalid JSON: %v", err)
+	}
+	if want := `Jo "JJ" O'Neil is strong`; restored["summary"] != want {
+		t.Errorf("RestoreJSON() summary = %q, want %q", restored["summary"], want)
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	r := NewRedaction()
+	args, err := r.RedactArgs(map[string]interface{}{
+		"resume_path":  "/uploads/resume.pdf",
+		"resume_text":  "jane@example.com",
+		"document_ids": []int{1, 2},
+		"sample":       [][]interface{}{{"bob@example.com", 3}},
+	})
+	if err != nil {
+		t.Fatalf("RedactArgs() error = %v", err)
+	}
+
+	if _, ok := args["resume_path"]; ok {
+		t.Errorf("RedactArgs() kept resume_path, which would be read unredacted")
+	}
+	if text, _ := args["resume_text"].(string); !strings.HasPrefix(text, "[EMAIL_") {
+		t.Errorf("RedactArgs() resume_text = %v", args["resume_text"])
+	}
+	encoded, _ := json.Marshal(args)
+	if strings.Contains(string(encoded), "bob@example.com") || !strings.Contains(string(encoded), `"document_ids":[1,2]`) {
+		t.Errorf("RedactArgs() = %s", encoded)
+	}
+}
+
+func TestRedactArgsRefusesPathWithoutText(t *testing.T) {
+	for _, args := range []map[string]interface{}{
+		{"resume_path": "/uploads/resume.pdf", "resume_text": ""},
+		{"resume_path": "/uploads/resume.pdf"},
+		{"file_path": "/uploads/doc.pdf"},
+	} {
+		if _, err := NewRedaction().RedactArgs(args); err == nil {
+			t.Errorf("RedactArgs(%v) = nil error, want the call refused", args)
+		}
+	}
+}
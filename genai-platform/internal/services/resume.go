@@ -0,0 +1,279 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"genai-platform/internal/models"
+)
+
+// Resume rubric dimensions, each scored from 0 to 100 by the model
+const (
+	DimensionSkillsMatch         = "skills_match"
+	DimensionExperienceRelevance = "experience_relevance"
+	DimensionImpact              = "impact"
+	DimensionFormatting          = "formatting"
+	DimensionATSReadability      = "ats_readability"
+)
+
+// ResumeDimensions lists the rubric dimensions in display order
+var ResumeDimensions = []string{
+	DimensionSkillsMatch,
+	DimensionExperienceRelevance,
+	DimensionImpact,
+	DimensionFormatting,
+	DimensionATSReadability,
+}
+
+// RubricWeights gives the relative weight of each rubric dimension in the
+// overall score. Weights need not sum to one.
+type RubricWeights map[string]float64
+
+// DefaultRubricWeights favors how well the candidate fits the job over how
+// the resume is presented
+func DefaultRubricWeights() RubricWeights {
+	return RubricWeights{
+		DimensionSkillsMatch:         0.30,
+		DimensionExperienceRelevance: 0.25,
+		DimensionImpact:              0.20,
+		DimensionFormatting:          0.10,
+		DimensionATSReadability:      0.15,
+	}
+}
+
+// ParseRubricWeights reads weights written as
+// "skills_match=0.4,impact=0.3,...". Dimensions left out weigh nothing; an
+// empty spec gives the defaults.
+func ParseRubricWeights(spec string) (RubricWeights, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return DefaultRubricWeights(), nil
+	}
+
+	known := map[string]bool{}
+	for _, dimension := range ResumeDimensions {
+		known[dimension] = true
+	}
+
+	weights := RubricWeights{}
+	total := 0.0
+	for _, part := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		name = strings.TrimSpace(name)
+		if !ok || !known[name] {
+			return nil, fmt.Errorf("invalid rubric weight %q: expected one of %s followed by =weight", part, strings.Join(ResumeDimensions, ", "))
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 || math.IsInf(weight, 0) || math.IsNaN(weight) {
+			return nil, fmt.Errorf("invalid weight for %s: %q", name, value)
+		}
+		weights[name] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("at least one rubric weight must be positive")
+	}
+
+	return weights, nil
+}
+
+// Normalized scales the weights to sum to one, listing every dimension
+func (w RubricWeights) Normalized() map[string]float64 {
+	total := 0.0
+	for _, dimension := range ResumeDimensions {
+		total += w[dimension]
+	}
+
+	normalized := make(map[string]float64, len(ResumeDimensions))
+	for _, dimension := range ResumeDimensions {
+		if total > 0 {
+			normalized[dimension] = math.Round(w[dimension]/total*1000) / 1000
+		} else {
+			normalized[dimension] = 0
+		}
+	}
+	return normalized
+}
+
+// Score is the weighted average of the dimension scores, rounded to an
+// integer. Dimensions the model did not score are left out and the
+// remaining weights rescaled.
+func (w RubricWeights) Score(dimensions map[string]models.ResumeDimension) int {
+	sum, total := 0.0, 0.0
+	for _, dimension := range ResumeDimensions {
+		d, ok := dimensions[dimension]
+		if !ok || w[dimension] == 0 {
+			continue
+		}
+		sum += float64(d.Score) * w[dimension]
+		total += w[dimension]
+	}
+	if total == 0 {
+		return 0
+	}
+	return int(math.Round(sum / total))
+}
+
+// ScoredResume is a resume analysis ready to be stored
+type ScoredResume struct {
+	Feedback string
+	Score    int
+	Rubric   *models.ResumeRubric
+}
+
+type ResumeService struct {
+	db      *sql.DB
+	llm     *LLMService
+	weights RubricWeights
+	workers int
+}
+
+// NewResumeService creates the service. workers caps how many resumes of a
+// batch are analyzed at once.
+func NewResumeService(db *sql.DB, llm *LLMService, weights RubricWeights, workers int) *ResumeService {
+	if workers < 1 {
+		workers = 1
+	}
+	return &ResumeService{
+		db:      db,
+		llm:     llm,
+		weights: weights,
+		workers: workers,
+	}
+}
+
+// Weights returns the service's default rubric weights
+func (s *ResumeService) Weights() RubricWeights {
+	return s.weights
+}
+
+// Process analyzes a stored resume against a job description, scores it
+// with weights (the service defaults when nil) and records the structured
+// result on the resume_analyses row
+func (s *ResumeService) Process(analysisID, userID, workspaceID int, resumePath, jobDescription string, weights RubricWeights) {
+	if weights == nil {
+		weights = s.weights
+	}
+
+	scored, err := s.process(analysisID, userID, workspaceID, resumePath, jobDescription, weights)
+	if err != nil {
+		fmt.Printf("Failed to analyze resume for analysis %d: %v\n", analysisID, err)
+		if _, err := s.db.Exec(
+			"UPDATE resume_analyses SET status = $1, error = $2, completed_at = $3 WHERE id = $4",
+			"failed", err.Error(), time.Now(), analysisID,
+		); err != nil {
+			fmt.Printf("Failed to update resume analysis %d: %v\n", analysisID, err)
+		}
+		return
+	}
+
+	analysisJSON, _ := json.Marshal(scored.Rubric)
+	if _, err := s.db.Exec(
+		`UPDATE resume_analyses SET status = $1, feedback = $2, score = $3, analysis = $4, completed_at = $5
+		 WHERE id = $6`,
+		"completed", scored.Feedback, scored.Score, analysisJSON, time.Now(), analysisID,
+	); err != nil {
+		fmt.Printf("Failed to update resume analysis %d: %v\n", analysisID, err)
+	}
+}
+
+// process reads the resume, stores the profile parsed from it and has the
+// model review it
+func (s *ResumeService) process(analysisID, userID, workspaceID int, resumePath, jobDescription string, weights RubricWeights) (*ScoredResume, error) {
+	text, err := ExtractResumeText(resumePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The text and profile come from the file alone, so they are kept even
+	// when the model's review fails. The text is what versions are diffed on.
+	profile := ParseResumeProfile(text)
+	if err := s.storeProfile(analysisID, text, profile); err != nil {
+		fmt.Printf("Failed to store resume profile for analysis %d: %v\n", analysisID, err)
+	}
+
+	return s.Analyze(userID, workspaceID, resumePath, text, profile.Contact.Name, jobDescription, weights)
+}
+
+func (s *ResumeService) storeProfile(analysisID int, text string, profile *models.ResumeProfile) error {
+	profileJSON, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
+		"UPDATE resume_analyses SET resume_text = $1, profile = $2 WHERE id = $3",
+		text, profileJSON, analysisID,
+	)
+	return err
+}
+
+// Analyze runs the model's review of a resume's text and turns it into a
+// rubric and an overall score. The candidate's name is redacted along with
+// the personal data the patterns find when userID has redaction enabled.
+// The model's tokens are metered against workspaceID, where the upload was
+// made.
+func (s *ResumeService) Analyze(userID, workspaceID int, resumePath, resumeText, candidateName, jobDescription string, weights RubricWeights) (*ScoredResume, error) {
+	analysis, err := s.llm.ForUser(userID, candidateName).ForFeature(UsageFeatureResume, workspaceID).AnalyzeResume(resumePath, resumeText, jobDescription)
+	if err != nil {
+		return nil, err
+	}
+
+	rubric := &models.ResumeRubric{
+		Dimensions:      map[string]models.ResumeDimension{},
+		Weights:         weights.Normalized(),
+		Strengths:       cleanList(analysis.Strengths),
+		Gaps:            cleanList(analysis.Gaps),
+		MissingKeywords: cleanList(analysis.MissingKeywords),
+		BulletRewrites:  []models.ResumeBulletRewrite{},
+	}
+	for _, dimension := range ResumeDimensions {
+		d, ok := analysis.Dimensions[dimension]
+		if !ok {
+			continue
+		}
+		if d.Score < 0 {
+			d.Score = 0
+		} else if d.Score > 100 {
+			d.Score = 100
+		}
+		d.Comment = strings.TrimSpace(d.Comment)
+		rubric.Dimensions[dimension] = d
+	}
+	for _, rewrite := range analysis.BulletRewrites {
+		if strings.TrimSpace(rewrite.Original) == "" || strings.TrimSpace(rewrite.Rewrite) == "" {
+			continue
+		}
+		rubric.BulletRewrites = append(rubric.BulletRewrites, rewrite)
+	}
+	if len(rubric.Dimensions) == 0 {
+		return nil, fmt.Errorf("model returned no rubric scores")
+	}
+
+	return &ScoredResume{
+		Feedback: strings.TrimSpace(analysis.Feedback),
+		Score:    weights.Score(rubric.Dimensions),
+		Rubric:   rubric,
+	}, nil
+}
+
+// cleanList trims entries, dropping empty and duplicate ones while keeping
+// the model's order
+func cleanList(items []string) []string {
+	seen := map[string]bool{}
+	cleaned := []string{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		key := strings.ToLower(item)
+		if item == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, item)
+	}
+	return cleaned
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"genai-platform/internal/models"
+)
+
+// resumeDimensionLabels names the rubric dimensions in explanations
+var resumeDimensionLabels = map[string]string{
+	DimensionSkillsMatch:         "Skills match",
+	DimensionExperienceRelevance: "Experience relevance",
+	DimensionImpact:              "Impact",
+	DimensionFormatting:          "Formatting",
+	DimensionATSReadability:      "ATS readability",
+}
+
+// explanationKeywords caps how many missing keywords an explanation lists
+const explanationKeywords = 5
+
+// BatchResume is a stored resume of a batch and the analysis row it is
+// scored into
+type BatchResume struct {
+	AnalysisID int
+	Path       string
+}
+
+// BatchCandidate is a ranked resume together with its full rubric, which
+// exports flatten into columns
+type BatchCandidate struct {
+	models.RankedResume
+	Rubric *models.ResumeRubric
+}
+
+// ProcessBatch scores every resume of a batch against the job description,
+// analyzing at most the service's worker count at a time. A resume that
+// fails is marked failed on its own row and does not stop the others;
+// progress is recorded on the resume_batches row.
+func (s *ResumeService) ProcessBatch(batchID, userID, workspaceID int, resumes []BatchResume, jobDescription string, weights RubricWeights) {
+	if weights == nil {
+		weights = s.weights
+	}
+
+	if _, err := s.db.Exec("UPDATE resume_batches SET status = $1 WHERE id = $2", "processing", batchID); err != nil {
+		fmt.Printf("Failed to update resume batch %d: %v\n", batchID, err)
+	}
+
+	jobs := make(chan BatchResume)
+	var wg sync.WaitGroup
+	for w := 0; w < s.workers && w < len(resumes); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for resume := range jobs {
+				s.Process(resume.AnalysisID, userID, workspaceID, resume.Path, jobDescription, weights)
+				if _, err := s.db.Exec(
+					"UPDATE resume_batches SET processed_resumes = processed_resumes + 1 WHERE id = $1",
+					batchID,
+				); err != nil {
+					fmt.Printf("Failed to update progress of resume batch %d: %v\n", batchID, err)
+				}
+			}
+		}()
+	}
+
+	for _, resume := range resumes {
+		jobs <- resume
+	}
+	close(jobs)
+	wg.Wait()
+
+	if _, err := s.db.Exec(
+		"UPDATE resume_batches SET status = $1, completed_at = $2 WHERE id = $3",
+		"completed", time.Now(), batchID,
+	); err != nil {
+		fmt.Printf("Failed to update resume batch %d: %v\n", batchID, err)
+	}
+}
+
+// BatchCandidates lists the resumes of a batch best first. Scored resumes
+// are ranked by score, ties going to the earlier upload; failed and
+// pending resumes follow unranked.
+func (s *ResumeService) BatchCandidates(batchID int) ([]BatchCandidate, error) {
+	rows, err := s.db.Query(
+		`SELECT id, COALESCE(filename, ''), status, score, analysis, COALESCE(error, '')
+		 FROM resume_analyses WHERE batch_id = $1
+		 ORDER BY (status = 'completed') DESC, score DESC NULLS LAST, id`,
+		batchID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []BatchCandidate{}
+	for rows.Next() {
+		var candidate BatchCandidate
+		var score sql.NullInt64
+		var rubricJSON []byte
+		if err := rows.Scan(&candidate.AnalysisID, &candidate.Filename, &candidate.Status,
+			&score, &rubricJSON, &candidate.Error); err != nil {
+			return nil, err
+		}
+
+		if candidate.Status == "completed" {
+			candidate.Rank = len(candidates) + 1
+			if score.Valid {
+				value := int(score.Int64)
+				candidate.Score = &value
+			}
+		}
+		if rubricJSON != nil {
+			candidate.Rubric = &models.ResumeRubric{}
+			if err := json.Unmarshal(rubricJSON, candidate.Rubric); err != nil {
+				return nil, err
+			}
+			candidate.DimensionScores = map[string]int{}
+			for dimension, d := range candidate.Rubric.Dimensions {
+				candidate.DimensionScores[dimension] = d.Score
+			}
+			candidate.Explanation = ExplainRubric(candidate.Rubric)
+			candidate.MissingKeywords = candidate.Rubric.MissingKeywords
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+// ExplainRubric summarizes why a resume ranked where it did in a few short
+// snippets: its strongest and weakest weighted dimensions, its top
+// strength and gap, and the job keywords it lacks
+func ExplainRubric(rubric *models.ResumeRubric) []string {
+	var best, worst string
+	for _, dimension := range ResumeDimensions {
+		d, ok := rubric.Dimensions[dimension]
+		if !ok || rubric.Weights[dimension] == 0 {
+			continue
+		}
+		if best == "" || d.Score > rubric.Dimensions[best].Score {
+			best = dimension
+		}
+		if worst == "" || d.Score < rubric.Dimensions[worst].Score {
+			worst = dimension
+		}
+	}
+
+	snippets := []string{}
+	if best != "" {
+		snippets = append(snippets, dimensionSnippet("Strongest", best, rubric.Dimensions[best]))
+	}
+	if worst != "" && worst != best {
+		snippets = append(snippets, dimensionSnippet("Weakest", worst, rubric.Dimensions[worst]))
+	}
+	if len(rubric.Strengths) > 0 {
+		snippets = append(snippets, "Strength: "+rubric.Strengths[0])
+	}
+	if len(rubric.Gaps) > 0 {
+		snippets = append(snippets, "Gap: "+rubric.Gaps[0])
+	}
+	if keywords := rubric.MissingKeywords; len(keywords) > 0 {
+		if len(keywords) > explanationKeywords {
+			keywords = keywords[:explanationKeywords]
+		}
+		snippets = append(snippets, "Missing keywords: "+strings.Join(keywords, ", "))
+	}
+	return snippets
+}
+
+func dimensionSnippet(prefix, dimension string, d models.ResumeDimension) string {
+	snippet := fmt.Sprintf("%s: %s %d/100", prefix, resumeDimensionLabels[dimension], d.Score)
+	if d.Comment != "" {
+		snippet += " - " + d.Comment
+	}
+	return snippet
+}
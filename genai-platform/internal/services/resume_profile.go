@@ -0,0 +1,538 @@
+package services
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"genai-platform/internal/models"
+)
+
+// Resume sections recognized by their headings
+const (
+	resumeSectionHeader         = "header"
+	resumeSectionSummary        = "summary"
+	resumeSectionExperience     = "experience"
+	resumeSectionEducation      = "education"
+	resumeSectionSkills         = "skills"
+	resumeSectionCertifications = "certifications"
+	resumeSectionOther          = "other"
+)
+
+// resumeHeadings maps normalized section headings to the section they start.
+// Sections the profile does not use still need a heading so their lines are
+// not read as part of the section before them.
+var resumeHeadings = map[string]string{
+	"summary":                     resumeSectionSummary,
+	"professional summary":        resumeSectionSummary,
+	"career summary":              resumeSectionSummary,
+	"profile":                     resumeSectionSummary,
+	"professional profile":        resumeSectionSummary,
+	"objective":                   resumeSectionSummary,
+	"career objective":            resumeSectionSummary,
+	"about me":                    resumeSectionSummary,
+	"experience":                  resumeSectionExperience,
+	"work experience":             resumeSectionExperience,
+	"professional experience":     resumeSectionExperience,
+	"relevant experience":         resumeSectionExperience,
+	"employment":                  resumeSectionExperience,
+	"employment history":          resumeSectionExperience,
+	"work history":                resumeSectionExperience,
+	"career history":              resumeSectionExperience,
+	"education":                   resumeSectionEducation,
+	"education and training":      resumeSectionEducation,
+	"academic background":         resumeSectionEducation,
+	"academic qualifications":     resumeSectionEducation,
+	"skills":                      resumeSectionSkills,
+	"technical skills":            resumeSectionSkills,
+	"key skills":                  resumeSectionSkills,
+	"core skills":                 resumeSectionSkills,
+	"core competencies":           resumeSectionSkills,
+	"skills and tools":            resumeSectionSkills,
+	"skills and technologies":     resumeSectionSkills,
+	"technologies":                resumeSectionSkills,
+	"certifications":              resumeSectionCertifications,
+	"certificates":                resumeSectionCertifications,
+	"licenses and certifications": resumeSectionCertifications,
+	"certifications and licenses": resumeSectionCertifications,
+	"professional certifications": resumeSectionCertifications,
+	"projects":                    resumeSectionOther,
+	"personal projects":           resumeSectionOther,
+	"awards":                      resumeSectionOther,
+	"honors and awards":           resumeSectionOther,
+	"publications":                resumeSectionOther,
+	"volunteer experience":        resumeSectionOther,
+	"volunteering":                resumeSectionOther,
+	"languages":                   resumeSectionOther,
+	"interests":                   resumeSectionOther,
+	"hobbies":                     resumeSectionOther,
+	"references":                  resumeSectionOther,
+	"additional information":      resumeSectionOther,
+	"activities":                  resumeSectionOther,
+	"leadership":                  resumeSectionOther,
+	"leadership and activities":   resumeSectionOther,
+	"extracurricular activities":  resumeSectionOther,
+	"courses":                     resumeSectionOther,
+	"relevant coursework":         resumeSectionOther,
+	"training":                    resumeSectionOther,
+	"achievements":                resumeSectionOther,
+	"key achievements":            resumeSectionOther,
+	"affiliations":                resumeSectionOther,
+	"professional affiliations":   resumeSectionOther,
+	"memberships":                 resumeSectionOther,
+	"professional memberships":    resumeSectionOther,
+	"accomplishments":             resumeSectionOther,
+	"contact":                     resumeSectionHeader,
+	"contact information":         resumeSectionHeader,
+	"personal information":        resumeSectionHeader,
+	"personal details":            resumeSectionHeader,
+	"contact details":             resumeSectionHeader,
+}
+
+const resumeMonth = `(?:jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sep(?:t(?:ember)?)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?)\.?`
+
+const resumeDate = `(?:` + resumeMonth + `\s*,?\s*(?:19|20)\d{2}|\d{1,2}/(?:19|20)\d{2}|(?:19|20)\d{2})`
+
+var (
+	resumeDateRangeRe = regexp.MustCompile(`(?i)\(?\b(` + resumeDate + `)\s*(?:-|–|—|to|until)\s*(` + resumeDate + `|present|current|now|today)\b\)?`)
+	resumeDateRe      = regexp.MustCompile(`(?i)\(?\b` + resumeDate + `\b\)?`)
+	resumeMonthRe     = regexp.MustCompile(`(?i)^` + resumeMonth)
+	resumeYearRe      = regexp.MustCompile(`(?:19|20)\d{2}`)
+
+	resumeEmailRe        = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	resumePhoneRe        = regexp.MustCompile(`(?:\+\d{1,3}[\s.\-]?)?(?:\(\d{2,4}\)|\d{2,4})[\s.\-]?\d{3,4}[\s.\-]?\d{3,4}`)
+	resumeLinkRe         = regexp.MustCompile(`(?i)(?:https?://\S+|\b(?:www\.)?(?:linkedin\.com|github\.com|gitlab\.com)/\S+)`)
+	resumeLocationRe     = regexp.MustCompile(`^[A-Z][A-Za-z.\-' ]+,\s*[A-Z][A-Za-z.\- ]*$`)
+	resumeWorkLocationRe = regexp.MustCompile(`^(?:[A-Z][A-Za-z.\-' ]+,\s*[A-Z]{2}|(?i:remote|hybrid|on-?site))$`)
+	resumeNameRe         = regexp.MustCompile(`^[\p{L}][\p{L}.'\-]*(?:\s+[\p{L}][\p{L}.'\-]*){1,3}$`)
+
+	resumeBulletRe    = regexp.MustCompile(`^(?:[•●▪◦○■□‣∙·*\-–—>]|\d{1,2}[.)])\s*`)
+	resumeSeparatorRe = regexp.MustCompile(`\s*(?:\t+|\s[|•·–—-]\s|\s{3,}|\s+at\s+)\s*`)
+	resumeSkillSepRe  = regexp.MustCompile(`\s*(?:[,;|•·]|\t|\s{3,}|\s-\s)\s*`)
+
+	resumeInstitutionRe = regexp.MustCompile(`(?i)\b(?:university|college|institute|school|academy|polytechnic|universidad|universit[éä]t)\b`)
+	resumeDegreeRe      = regexp.MustCompile(`(?i)\b(?:bachelor|master|associate|diploma|doctor(?:ate)?|high school|ged)\b|\b(?:B\.S\.|B\.A\.|M\.S\.|M\.A\.|BSc|MSc|BS|MS|BA|MA|BEng|MEng|BTech|MTech|PhD|Ph\.D\.?|MBA)(?:\s|,|$)`)
+	resumeTitleRe       = regexp.MustCompile(`(?i)\b(?:engineer|developer|programmer|manager|analyst|designer|director|lead|intern|consultant|specialist|scientist|architect|officer|coordinator|assistant|associate|administrator|head|president|founder|teacher|researcher|technician|accountant|representative|executive|supervisor|recruiter|writer|editor|nurse|advisor|owner|partner|vp)\b`)
+)
+
+// ParseResumeProfile reads a structured profile from resume text: contact
+// details from the top of the resume, and work history, education, skills
+// and certifications from their sections. It relies on headings and dates
+// rather than the model, so it is fast and repeatable but misses what a
+// resume does not mark up conventionally.
+func ParseResumeProfile(text string) *models.ResumeProfile {
+	sections := splitResumeSections(text)
+
+	profile := &models.ResumeProfile{
+		Contact:        parseResumeContact(sections[resumeSectionHeader], text),
+		Summary:        strings.Join(sections[resumeSectionSummary], " "),
+		WorkHistory:    parseResumeWork(sections[resumeSectionExperience]),
+		Education:      parseResumeEducation(sections[resumeSectionEducation]),
+		Skills:         parseResumeSkills(sections[resumeSectionSkills]),
+		Certifications: parseResumeCertifications(sections[resumeSectionCertifications]),
+	}
+	profile.TotalExperienceMonths = experienceMonths(profile.WorkHistory, time.Now())
+	return profile
+}
+
+// splitResumeSections groups non-empty lines under the section of the
+// heading above them. Lines before the first heading form the header.
+func splitResumeSections(text string) map[string][]string {
+	sections := map[string][]string{}
+	section := resumeSectionHeader
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if heading, ok := resumeHeading(line); ok {
+			section = heading
+			continue
+		}
+		sections[section] = append(sections[section], line)
+	}
+	return sections
+}
+
+// resumeHeading recognizes a line that is only a section heading, in any
+// case and with or without a trailing colon or markdown marker
+func resumeHeading(line string) (string, bool) {
+	if len(line) > 50 {
+		return "", false
+	}
+	key := strings.ToLower(strings.Trim(line, "#*_=:- \t"))
+	key = strings.ReplaceAll(key, "&", "and")
+	key = strings.Join(strings.Fields(key), " ")
+	section, ok := resumeHeadings[key]
+	return section, ok
+}
+
+func parseResumeContact(header []string, text string) models.ResumeContact {
+	contact := models.ResumeContact{Links: []string{}}
+
+	// Contact details are normally at the top, but some layouts put them
+	// in a sidebar that ends up elsewhere in the text
+	top := strings.Join(header, "\n")
+	for _, source := range []string{top, text} {
+		if contact.Email == "" {
+			contact.Email = resumeEmailRe.FindString(source)
+		}
+		if contact.Phone == "" {
+			for _, candidate := range resumePhoneRe.FindAllString(resumeLinkRe.ReplaceAllString(source, " "), -1) {
+				if digits := countDigits(candidate); digits >= 7 && digits <= 15 {
+					contact.Phone = strings.TrimSpace(candidate)
+					break
+				}
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, link := range resumeLinkRe.FindAllString(text, -1) {
+		link = strings.TrimRight(link, ".,;)|")
+		if strings.Contains(link, "@") || seen[strings.ToLower(link)] {
+			continue
+		}
+		seen[strings.ToLower(link)] = true
+		contact.Links = append(contact.Links, link)
+	}
+
+	for _, line := range header {
+		line = resumeEmailRe.ReplaceAllString(line, " ")
+		line = resumeLinkRe.ReplaceAllString(line, " ")
+		line = resumePhoneRe.ReplaceAllString(line, " ")
+		for _, piece := range splitResumeLine(line) {
+			switch {
+			case contact.Name == "" && resumeNameRe.MatchString(piece) && !resumeTitleRe.MatchString(piece):
+				contact.Name = piece
+			case contact.Location == "" && resumeLocationRe.MatchString(piece) && len(strings.Fields(piece)) <= 5:
+				contact.Location = piece
+			}
+		}
+	}
+
+	return contact
+}
+
+// parseResumeWork reads experience entries, each anchored on the line that
+// holds its date range. The title and company come from that line and the
+// plain lines just above or below it; bullets and other lines are the
+// entry's highlights.
+func parseResumeWork(lines []string) []models.ResumeWorkEntry {
+	entries := []models.ResumeWorkEntry{}
+	var headers [][]string
+	var pending []string
+
+	for _, line := range lines {
+		bullet := resumeBulletRe.MatchString(line)
+		text := strings.TrimSpace(resumeBulletRe.ReplaceAllString(line, ""))
+		if text == "" {
+			continue
+		}
+
+		if match := resumeDateRangeRe.FindStringSubmatchIndex(text); match != nil && !bullet {
+			entry := models.ResumeWorkEntry{
+				StartDate:  normalizeResumeDate(text[match[2]:match[3]]),
+				Highlights: []string{},
+			}
+			end := strings.ToLower(text[match[4]:match[5]])
+			switch end {
+			case "present", "current", "now", "today":
+				entry.Current = true
+			default:
+				entry.EndDate = normalizeResumeDate(end)
+			}
+
+			// Up to two plain lines right above the dates name the role;
+			// anything before them continues the previous entry
+			if len(pending) > 2 {
+				if len(entries) > 0 {
+					last := &entries[len(entries)-1]
+					last.Highlights = append(last.Highlights, pending[:len(pending)-2]...)
+				}
+				pending = pending[len(pending)-2:]
+			}
+			header := append(append([]string{}, pending...), text[:match[0]]+" "+text[match[1]:])
+			pending = nil
+
+			entries = append(entries, entry)
+			headers = append(headers, header)
+			continue
+		}
+
+		if len(entries) == 0 {
+			pending = append(pending, text)
+			continue
+		}
+
+		last := len(entries) - 1
+		switch {
+		case bullet:
+			entries[last].Highlights = append(entries[last].Highlights, pending...)
+			entries[last].Highlights = append(entries[last].Highlights, text)
+			pending = nil
+		case len(entries[last].Highlights) == 0 && len(headers[last]) < 3:
+			headers[last] = append(headers[last], text)
+		default:
+			pending = append(pending, text)
+		}
+	}
+	if len(entries) > 0 {
+		last := &entries[len(entries)-1]
+		last.Highlights = append(last.Highlights, pending...)
+	}
+
+	for i := range entries {
+		entries[i].Title, entries[i].Company = resumeRoleAndCompany(headers[i])
+	}
+	return entries
+}
+
+// resumeRoleAndCompany picks the title and company out of an entry's header
+// lines. Resumes list them in either order, so the piece that reads like a
+// job title is taken as the title.
+func resumeRoleAndCompany(header []string) (string, string) {
+	var pieces []string
+	for _, line := range header {
+		for _, piece := range splitResumeLine(line) {
+			if resumeWorkLocationRe.MatchString(piece) {
+				continue
+			}
+			pieces = append(pieces, piece)
+		}
+	}
+
+	switch len(pieces) {
+	case 0:
+		return "", ""
+	case 1:
+		// "Data Analyst, Initech"
+		title, company, ok := strings.Cut(pieces[0], ",")
+		if !ok {
+			return pieces[0], ""
+		}
+		pieces = []string{strings.TrimSpace(title), strings.TrimSpace(company)}
+	}
+	if !resumeTitleRe.MatchString(pieces[0]) && resumeTitleRe.MatchString(pieces[1]) {
+		return pieces[1], pieces[0]
+	}
+	return pieces[0], pieces[1]
+}
+
+// parseResumeEducation reads one entry per institution or degree. A degree
+// and the institution it was earned at share an entry whichever comes
+// first.
+func parseResumeEducation(lines []string) []models.ResumeEducation {
+	entries := []models.ResumeEducation{}
+	var current *models.ResumeEducation
+
+	for _, line := range lines {
+		text := strings.TrimSpace(resumeBulletRe.ReplaceAllString(line, ""))
+
+		var start, end string
+		if match := resumeDateRangeRe.FindStringSubmatchIndex(text); match != nil {
+			start = normalizeResumeDate(text[match[2]:match[3]])
+			if value := strings.ToLower(text[match[4]:match[5]]); resumeYearRe.MatchString(value) {
+				end = normalizeResumeDate(value)
+			}
+			text = text[:match[0]] + " " + text[match[1]:]
+		} else if match := resumeDateRe.FindStringIndex(text); match != nil {
+			end = normalizeResumeDate(strings.Trim(text[match[0]:match[1]], "()"))
+			text = text[:match[0]] + " " + text[match[1]:]
+		}
+
+		var institution, degree string
+		for _, piece := range splitResumeLine(text) {
+			switch {
+			case institution == "" && resumeInstitutionRe.MatchString(piece):
+				institution = piece
+			case degree == "" && resumeDegreeRe.MatchString(piece):
+				degree = piece
+			}
+		}
+
+		if institution == "" && degree == "" {
+			if current != nil && (start != "" || end != "") && current.EndDate == "" {
+				current.StartDate, current.EndDate = start, end
+			}
+			continue
+		}
+
+		if current == nil || (institution != "" && current.Institution != "") || (degree != "" && current.Degree != "") {
+			entries = append(entries, models.ResumeEducation{})
+			current = &entries[len(entries)-1]
+		}
+		if institution != "" {
+			current.Institution = institution
+		}
+		if degree != "" {
+			current.Degree = degree
+		}
+		if current.StartDate == "" && current.EndDate == "" {
+			current.StartDate, current.EndDate = start, end
+		}
+	}
+	return entries
+}
+
+// parseResumeSkills splits the skills section into single skills. Category
+// labels such as "Languages:" are dropped.
+func parseResumeSkills(lines []string) []string {
+	var skills []string
+	for _, line := range lines {
+		text := strings.TrimSpace(resumeBulletRe.ReplaceAllString(line, ""))
+		if label, rest, ok := strings.Cut(text, ":"); ok && len(label) <= 40 {
+			text = rest
+		}
+		for _, skill := range resumeSkillSepRe.Split(text, -1) {
+			skill = strings.Trim(skill, " .")
+			if skill == "" || len(skill) > 50 || len(strings.Fields(skill)) > 5 {
+				continue
+			}
+			skills = append(skills, skill)
+		}
+	}
+	return cleanList(skills)
+}
+
+// parseResumeCertifications takes one certification per line, with its date
+// when the line gives one
+func parseResumeCertifications(lines []string) []models.ResumeCertification {
+	certifications := []models.ResumeCertification{}
+	for _, line := range lines {
+		text := strings.TrimSpace(resumeBulletRe.ReplaceAllString(line, ""))
+
+		var date string
+		if match := resumeDateRe.FindStringIndex(text); match != nil {
+			date = normalizeResumeDate(strings.Trim(text[match[0]:match[1]], "()"))
+			text = text[:match[0]] + " " + text[match[1]:]
+		}
+
+		name := strings.Trim(strings.Join(strings.Fields(text), " "), " ,-–—|()")
+		name = strings.ReplaceAll(name, "()", "")
+		if name == "" {
+			continue
+		}
+		certifications = append(certifications, models.ResumeCertification{Name: strings.TrimSpace(name), Date: date})
+	}
+	return certifications
+}
+
+// splitResumeLine splits a line on the separators resumes use between
+// fields on one line: tabs, wide gaps, bars, bullets, dashes and " at "
+func splitResumeLine(line string) []string {
+	var pieces []string
+	for _, piece := range resumeSeparatorRe.Split(line, -1) {
+		piece = strings.Trim(piece, " ,;:|()")
+		if piece != "" {
+			pieces = append(pieces, piece)
+		}
+	}
+	return pieces
+}
+
+// normalizeResumeDate turns "Mar 2021", "03/2021" or "2021" into "2021-03"
+// or "2021"
+func normalizeResumeDate(value string) string {
+	value = strings.TrimSpace(value)
+	year := resumeYearRe.FindString(value)
+	if year == "" {
+		return ""
+	}
+
+	month := 0
+	if m := resumeMonthRe.FindString(value); m != "" {
+		month = monthNumber(strings.ToLower(m[:3]))
+	} else if slash := strings.Index(value, "/"); slash > 0 {
+		month, _ = strconv.Atoi(value[:slash])
+	}
+	if month < 1 || month > 12 {
+		return year
+	}
+	return year + "-" + twoDigits(month)
+}
+
+func monthNumber(abbreviation string) int {
+	for i, name := range []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"} {
+		if abbreviation == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func twoDigits(n int) string {
+	if n < 10 {
+		return "0" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
+
+// experienceMonths totals the months covered by work entries, counting
+// overlapping roles once. A year without a month counts from January.
+func experienceMonths(entries []models.ResumeWorkEntry, now time.Time) int {
+	type span struct{ start, end int }
+	var spans []span
+	for _, entry := range entries {
+		start, ok := resumeMonthIndex(entry.StartDate)
+		if !ok {
+			continue
+		}
+		end := now.Year()*12 + int(now.Month()) - 1
+		if !entry.Current {
+			if end, ok = resumeMonthIndex(entry.EndDate); !ok {
+				continue
+			}
+		}
+		// Both months are worked, so the span runs to the end of the last
+		if len(entry.EndDate) > 4 || entry.Current {
+			end++
+		}
+		if end > start {
+			spans = append(spans, span{start, end})
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	total, coveredUntil := 0, 0
+	for _, s := range spans {
+		if s.start < coveredUntil {
+			s.start = coveredUntil
+		}
+		if s.end > s.start {
+			total += s.end - s.start
+			coveredUntil = s.end
+		}
+	}
+	return total
+}
+
+// resumeMonthIndex counts months since year zero for a normalized date
+func resumeMonthIndex(date string) (int, bool) {
+	if len(date) < 4 {
+		return 0, false
+	}
+	year, err := strconv.Atoi(date[:4])
+	if err != nil {
+		return 0, false
+	}
+	month := 1
+	if len(date) == 7 {
+		if month, err = strconv.Atoi(date[5:]); err != nil {
+			return 0, false
+		}
+	}
+	return year*12 + month - 1, true
+}
+
+func countDigits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
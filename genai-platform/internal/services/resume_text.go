@@ -0,0 +1,194 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// ResumeFileTypes are the extensions ExtractResumeText can read
+var ResumeFileTypes = map[string]bool{
+	".pdf":  true,
+	".docx": true,
+	".txt":  true,
+	".md":   true,
+}
+
+// IsResumeFile reports whether a resume can be read from filename
+func IsResumeFile(filename string) bool {
+	return ResumeFileTypes[strings.ToLower(filepath.Ext(filename))]
+}
+
+// ExtractResumeText reads the text of a PDF, DOCX or plain-text resume, one
+// line per line of the document
+func ExtractResumeText(filePath string) (string, error) {
+	var text string
+	var err error
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".pdf":
+		text, err = extractPDFText(filePath)
+	case ".docx":
+		text, err = extractDOCXText(filePath)
+	case ".txt", ".md":
+		var content []byte
+		content, err = os.ReadFile(filePath)
+		text = string(content)
+	default:
+		return "", fmt.Errorf("unsupported resume file type: %s", filepath.Ext(filePath))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\u00a0", " ")
+	// Postgres text columns cannot hold NUL or invalid UTF-8
+	text = strings.ToValidUTF8(strings.ReplaceAll(text, "\x00", ""), "")
+	if strings.TrimSpace(text) == "" {
+		return "", fmt.Errorf("no text found in resume")
+	}
+	return text, nil
+}
+
+// extractPDFText lays out each page's glyphs in rows, top to bottom.
+// Glyphs carry no spaces of their own in many PDFs, so a space is inserted
+// where the gap between two glyphs is wider than letter spacing and a tab
+// where it is wide enough to separate columns.
+func extractPDFText(filePath string) (text string, err error) {
+	f, reader, err := pdf.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// The reader panics on content streams it cannot interpret
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to read PDF: %v", r)
+		}
+	}()
+
+	var b strings.Builder
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+
+		glyphs := page.Content().Text
+		sort.SliceStable(glyphs, func(a, b int) bool { return glyphs[a].Y > glyphs[b].Y })
+
+		for start := 0; start < len(glyphs); {
+			end := start + 1
+			for end < len(glyphs) && glyphs[start].Y-glyphs[end].Y <= pdfRowTolerance(glyphs[start], glyphs[end]) {
+				end++
+			}
+			row := glyphs[start:end]
+			sort.SliceStable(row, func(a, b int) bool { return row[a].X < row[b].X })
+
+			for j, glyph := range row {
+				if j > 0 {
+					prev := row[j-1]
+					gap := glyph.X - (prev.X + prev.W)
+					switch {
+					case gap > 2*glyph.FontSize:
+						b.WriteByte('\t')
+					case gap > 0.15*glyph.FontSize && prev.S != " " && glyph.S != " ":
+						b.WriteByte(' ')
+					}
+				}
+				b.WriteString(glyph.S)
+			}
+			b.WriteByte('\n')
+			start = end
+		}
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// pdfRowTolerance is how far apart vertically two glyphs may be and still
+// share a row, allowing for sub- and superscripts
+func pdfRowTolerance(a, b pdf.Text) float64 {
+	return math.Max(a.FontSize, b.FontSize) / 3
+}
+
+// extractDOCXText reads the paragraphs of word/document.xml. Tabs and
+// breaks inside a paragraph are kept so dates set off by a tab stay
+// separate from the text before them.
+func extractDOCXText(filePath string) (string, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	var document *zip.File
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			document = f
+			break
+		}
+	}
+	if document == nil {
+		return "", fmt.Errorf("not a Word document: word/document.xml is missing")
+	}
+
+	rc, err := document.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var b strings.Builder
+	decoder := xml.NewDecoder(rc)
+	inText, inTabStops := false, false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tabs":
+				// Tab stop definitions in paragraph properties, not tabs
+				inTabStops = true
+			case "tab":
+				if !inTabStops {
+					b.WriteByte('\t')
+				}
+			case "br", "cr":
+				b.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "tabs":
+				inTabStops = false
+			case "p":
+				b.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				b.Write(t)
+			}
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,441 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"genai-platform/internal/models"
+)
+
+// diffContext is how many unchanged lines surround each change in a diff
+const diffContext = 3
+
+// maxDiffCells caps the lines of one text times the lines of the other
+// that are compared once the shared head and tail are skipped, keeping the
+// table of a diff to 16 MB
+const maxDiffCells = 4 << 20
+
+// ErrDiffTooLarge is returned when two texts differ in too many lines to diff
+var ErrDiffTooLarge = errors.New("too large to diff")
+
+// Suggestion statuses of a version comparison
+const (
+	SuggestionAddressed          = "addressed"
+	SuggestionPartiallyAddressed = "partially_addressed"
+	SuggestionOpen               = "open"
+)
+
+// ResumeVersionDetail is a stored version of a resume with the text and
+// rubric a comparison needs. Extracted is false until the analysis has
+// read the resume's text.
+type ResumeVersionDetail struct {
+	models.ResumeVersion
+	Text      string
+	Extracted bool
+	Rubric    *models.ResumeRubric
+}
+
+// Resume loads a resume owned by userID with its versions, oldest first.
+// It returns sql.ErrNoRows when the resume does not exist or belongs to
+// someone else.
+func (s *ResumeService) Resume(resumeID, userID int) (*models.Resume, error) {
+	resume := &models.Resume{ID: resumeID, UserID: userID}
+	if err := s.db.QueryRow(
+		"SELECT name, created_at, updated_at FROM resumes WHERE id = $1 AND user_id = $2",
+		resumeID, userID,
+	).Scan(&resume.Name, &resume.CreatedAt, &resume.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(
+		`SELECT id, version, COALESCE(filename, ''), status, score, created_at
+		 FROM resume_analyses WHERE resume_id = $1 ORDER BY version`,
+		resumeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resume.Versions = []models.ResumeVersion{}
+	for rows.Next() {
+		var version models.ResumeVersion
+		var score sql.NullInt64
+		if err := rows.Scan(&version.AnalysisID, &version.Version, &version.Filename, &version.Status,
+			&score, &version.CreatedAt); err != nil {
+			return nil, err
+		}
+		if score.Valid {
+			value := int(score.Int64)
+			version.Score = &value
+		}
+		resume.Versions = append(resume.Versions, version)
+		resume.LatestVersion = version.Version
+	}
+	return resume, rows.Err()
+}
+
+// ResumeVersion loads one version of a resume. It returns sql.ErrNoRows
+// when the resume has no such version.
+func (s *ResumeService) ResumeVersion(resumeID, version int) (*ResumeVersionDetail, error) {
+	detail := &ResumeVersionDetail{}
+	detail.Version = version
+	var score sql.NullInt64
+	var rubricJSON []byte
+	var text sql.NullString
+	if err := s.db.QueryRow(
+		`SELECT id, COALESCE(filename, ''), status, score, analysis, resume_text, created_at
+		 FROM resume_analyses WHERE resume_id = $1 AND version = $2`,
+		resumeID, version,
+	).Scan(&detail.AnalysisID, &detail.Filename, &detail.Status, &score, &rubricJSON, &text,
+		&detail.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if score.Valid {
+		value := int(score.Int64)
+		detail.Score = &value
+	}
+	detail.Text, detail.Extracted = text.String, text.Valid
+	if rubricJSON != nil {
+		detail.Rubric = &models.ResumeRubric{}
+		if err := json.Unmarshal(rubricJSON, detail.Rubric); err != nil {
+			return nil, err
+		}
+	}
+	return detail, nil
+}
+
+// CompareResumeVersions diffs two versions of a resume, reports how each
+// rubric dimension moved and checks which suggestions made on the from
+// version were taken up in the to version
+func CompareResumeVersions(resumeID int, from, to *ResumeVersionDetail) *models.ResumeComparison {
+	comparison := &models.ResumeComparison{
+		ResumeID:    resumeID,
+		From:        from.ResumeVersion,
+		To:          to.ResumeVersion,
+		Dimensions:  map[string]models.DimensionChange{},
+		Suggestions: []models.ResumeSuggestion{},
+	}
+
+	var err error
+	comparison.Diff, comparison.LinesAdded, comparison.LinesRemoved, err = UnifiedDiff(
+		strings.TrimSpace(fmt.Sprintf("v%d %s", from.Version, from.Filename)),
+		strings.TrimSpace(fmt.Sprintf("v%d %s", to.Version, to.Filename)),
+		from.Text, to.Text,
+	)
+	// The scores and suggestions are still compared without the diff
+	comparison.DiffTooLarge = err == ErrDiffTooLarge
+
+	if from.Score != nil && to.Score != nil {
+		change := *to.Score - *from.Score
+		comparison.ScoreChange = &change
+	}
+	for _, dimension := range ResumeDimensions {
+		var change models.DimensionChange
+		if from.Rubric != nil {
+			if d, ok := from.Rubric.Dimensions[dimension]; ok {
+				score := d.Score
+				change.From = &score
+			}
+		}
+		if to.Rubric != nil {
+			if d, ok := to.Rubric.Dimensions[dimension]; ok {
+				score := d.Score
+				change.To = &score
+			}
+		}
+		if change.From == nil && change.To == nil {
+			continue
+		}
+		if change.From != nil && change.To != nil {
+			delta := *change.To - *change.From
+			change.Change = &delta
+		}
+		comparison.Dimensions[dimension] = change
+	}
+
+	if from.Rubric != nil {
+		comparison.Suggestions = resumeSuggestions(from.Rubric, to)
+	}
+	for _, suggestion := range comparison.Suggestions {
+		if suggestion.Status == SuggestionAddressed {
+			comparison.Addressed++
+		}
+	}
+	return comparison
+}
+
+// resumeSuggestions checks the missing keywords, gaps and bullet rewrites
+// of an analysis against a later version. Keywords and rewrites are looked
+// for in the later text; a gap counts as addressed when the later analysis
+// no longer raises a similar one.
+func resumeSuggestions(rubric *models.ResumeRubric, to *ResumeVersionDetail) []models.ResumeSuggestion {
+	text := " " + matchText(to.Text) + " "
+	suggestions := []models.ResumeSuggestion{}
+
+	for _, keyword := range rubric.MissingKeywords {
+		suggestion := models.ResumeSuggestion{Kind: "missing_keyword", Suggestion: keyword, Status: SuggestionOpen}
+		switch {
+		case containsPhrase(text, keyword):
+			suggestion.Status = SuggestionAddressed
+			suggestion.Detail = "Now mentioned in the resume"
+		case to.Rubric != nil && !containsFold(to.Rubric.MissingKeywords, keyword):
+			suggestion.Status = SuggestionAddressed
+			suggestion.Detail = "No longer flagged as missing"
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	for _, gap := range rubric.Gaps {
+		suggestion := models.ResumeSuggestion{Kind: "gap", Suggestion: gap, Status: SuggestionOpen}
+		switch {
+		case to.Rubric == nil:
+			suggestion.Detail = "The later version has not been scored"
+		case similarGap(gap, to.Rubric.Gaps) != "":
+			suggestion.Detail = "Still raised: " + similarGap(gap, to.Rubric.Gaps)
+		default:
+			suggestion.Status = SuggestionAddressed
+			suggestion.Detail = "No longer raised as a gap"
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	for _, rewrite := range rubric.BulletRewrites {
+		suggestion := models.ResumeSuggestion{Kind: "bullet_rewrite", Suggestion: rewrite.Rewrite, Status: SuggestionOpen}
+		switch {
+		case containsPhrase(text, rewrite.Rewrite):
+			suggestion.Status = SuggestionAddressed
+			suggestion.Detail = "Rewrite applied"
+		case !containsPhrase(text, rewrite.Original):
+			suggestion.Status = SuggestionPartiallyAddressed
+			suggestion.Detail = "Original bullet was changed in another way"
+		default:
+			suggestion.Detail = "Original bullet is unchanged"
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	return suggestions
+}
+
+// matchText lowercases text and reduces everything but letters and digits
+// to single spaces, so phrases match across punctuation, bullets and line
+// breaks
+func matchText(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '+' && r != '#'
+	}), " ")
+}
+
+// containsPhrase reports whether padded, a matchText result wrapped in
+// spaces, holds phrase as whole words
+func containsPhrase(padded, phrase string) bool {
+	phrase = matchText(phrase)
+	return phrase != "" && strings.Contains(padded, " "+phrase+" ")
+}
+
+func containsFold(items []string, item string) bool {
+	for _, candidate := range items {
+		if strings.EqualFold(strings.TrimSpace(candidate), strings.TrimSpace(item)) {
+			return true
+		}
+	}
+	return false
+}
+
+// similarGap returns the first of gaps sharing at least half its words with
+// gap, ignoring short words, or "" when none does
+func similarGap(gap string, gaps []string) string {
+	words := gapWords(gap)
+	if len(words) == 0 {
+		return ""
+	}
+	for _, candidate := range gaps {
+		other := gapWords(candidate)
+		shared := 0
+		for word := range words {
+			if other[word] {
+				shared++
+			}
+		}
+		union := len(words) + len(other) - shared
+		if union > 0 && shared*2 >= union {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func gapWords(text string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.Fields(matchText(text)) {
+		if len(word) > 3 {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+// diffOp is one line of a diff: kept (' '), removed ('-') or added ('+').
+// from and to are the 0-based line numbers in each text before the line.
+type diffOp struct {
+	kind     byte
+	text     string
+	from, to int
+}
+
+// UnifiedDiff compares two texts line by line and renders the result in
+// unified diff format, returning it with the number of lines added and
+// removed. Trailing whitespace is ignored. Identical texts give "". Texts
+// that differ in too many lines give ErrDiffTooLarge.
+func UnifiedDiff(fromName, toName, from, to string) (string, int, int, error) {
+	ops, err := diffLines(diffSplit(from), diffSplit(to))
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	var changes []int
+	added, removed := 0, 0
+	for i, op := range ops {
+		switch op.kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		default:
+			continue
+		}
+		changes = append(changes, i)
+	}
+	if len(changes) == 0 {
+		return "", 0, 0, nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+	for i := 0; i < len(changes); {
+		start := changes[i] - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := changes[i] + diffContext + 1
+		// Changes close enough to share context go in one hunk
+		for i++; i < len(changes) && changes[i]-diffContext <= end; i++ {
+			end = changes[i] + diffContext + 1
+		}
+		if end > len(ops) {
+			end = len(ops)
+		}
+		writeHunk(&b, ops[start:end])
+	}
+	return b.String(), added, removed, nil
+}
+
+func writeHunk(b *strings.Builder, ops []diffOp) {
+	fromCount, toCount := 0, 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			fromCount++
+		}
+		if op.kind != '-' {
+			toCount++
+		}
+	}
+	// Unified diffs number from 1, and an empty range is placed after the
+	// line it follows
+	fromStart, toStart := ops[0].from, ops[0].to
+	if fromCount > 0 {
+		fromStart++
+	}
+	if toCount > 0 {
+		toStart++
+	}
+
+	fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+	for _, op := range ops {
+		b.WriteByte(op.kind)
+		b.WriteString(op.text)
+		b.WriteByte('\n')
+	}
+}
+
+func diffSplit(text string) []string {
+	text = strings.TrimRight(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return lines
+}
+
+// diffLines finds a shortest edit turning a into b from their longest
+// common subsequence of lines. The shared head and tail are skipped before
+// the quadratic table is built; resumes rarely run past a few hundred
+// lines, and ErrDiffTooLarge is returned rather than build a table of more
+// than maxDiffCells.
+func diffLines(a, b []string) ([]diffOp, error) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(am) > 0 && len(bm) > maxDiffCells/len(am) {
+		return nil, ErrDiffTooLarge
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of am[i:]
+	// and bm[j:]
+	lcs := make([][]int32, len(am)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(bm)+1)
+	}
+	for i := len(am) - 1; i >= 0; i-- {
+		for j := len(bm) - 1; j >= 0; j-- {
+			switch {
+			case am[i] == bm[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(bm))
+	for k := 0; k < prefix; k++ {
+		ops = append(ops, diffOp{kind: ' ', text: a[k], from: k, to: k})
+	}
+	i, j := 0, 0
+	for i < len(am) || j < len(bm) {
+		from, to := prefix+i, prefix+j
+		switch {
+		case i < len(am) && j < len(bm) && am[i] == bm[j]:
+			ops = append(ops, diffOp{kind: ' ', text: am[i], from: from, to: to})
+			i++
+			j++
+		case j == len(bm) || (i < len(am) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', text: am[i], from: from, to: to})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', text: bm[j], from: from, to: to})
+			j++
+		}
+	}
+	for k := 0; k < suffix; k++ {
+		ops = append(ops, diffOp{kind: ' ', text: a[len(a)-suffix+k], from: len(a) - suffix + k, to: len(b) - suffix + k})
+	}
+	return ops, nil
+}
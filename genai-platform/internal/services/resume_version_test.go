@@ -0,0 +1,103 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		want    string
+		added   int
+		removed int
+	}{
+		{
+			name: "identical",
+			from: "a\nb\n",
+			to:   "a\nb",
+			want: "",
+		},
+		{
+			name: "trailing whitespace and line endings are ignored",
+			from: "a  \r\nb\r\n",
+			to:   "a\nb\t\n",
+			want: "",
+		},
+		{
+			name:  "from empty",
+			from:  "",
+			to:    "a\nb",
+			want:  "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+			added: 2,
+		},
+		{
+			name:    "to empty",
+			from:    "a\nb",
+			to:      "",
+			want:    "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+			removed: 2,
+		},
+		{
+			name:    "changed line",
+			from:    "Jane Doe\nEngineer\nGo, SQL",
+			to:      "Jane Doe\nSenior Engineer\nGo, SQL",
+			want:    "--- old\n+++ new\n@@ -1,3 +1,3 @@\n Jane Doe\n-Engineer\n+Senior Engineer\n Go, SQL\n",
+			added:   1,
+			removed: 1,
+		},
+		{
+			name:  "added line in the middle",
+			from:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10",
+			to:    "1\n2\n3\n4\n5\nnew\n6\n7\n8\n9\n10",
+			want:  "--- old\n+++ new\n@@ -3,6 +3,7 @@\n 3\n 4\n 5\n+new\n 6\n 7\n 8\n",
+			added: 1,
+		},
+		{
+			name:    "distant changes get separate hunks",
+			from:    "a\n1\n2\n3\n4\n5\n6\n7\n8\nb",
+			to:      "A\n1\n2\n3\n4\n5\n6\n7\n8\nB",
+			want:    "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+B\n",
+			added:   2,
+			removed: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, added, removed, err := UnifiedDiff("old", "new", tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("UnifiedDiff() error = %v", err)
+			}
+			if diff != tt.want {
+				t.Errorf("UnifiedDiff() diff =\n%s\nwant\n%s", diff, tt.want)
+			}
+			if added != tt.added || removed != tt.removed {
+				t.Errorf("UnifiedDiff() = +%d -%d, want +%d -%d", added, removed, tt.added, tt.removed)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffTooLarge(t *testing.T) {
+	lines := func(prefix string, n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "%s %d\n", prefix, i)
+		}
+		return b.String()
+	}
+
+	// Long texts sharing a head and tail only diff what lies between
+	shared := lines("same", 5000)
+	if _, added, removed, err := UnifiedDiff("old", "new", shared+"a\n"+shared, shared+"b\n"+shared); err != nil || added != 1 || removed != 1 {
+		t.Errorf("UnifiedDiff() = +%d -%d, %v, want +1 -1", added, removed, err)
+	}
+
+	if _, _, _, err := UnifiedDiff("old", "new", lines("from", 3000), lines("to", 3000)); err != ErrDiffTooLarge {
+		t.Errorf("UnifiedDiff() error = %v, want ErrDiffTooLarge", err)
+	}
+}
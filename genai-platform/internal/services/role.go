@@ -0,0 +1,282 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"genai-platform/internal/auth"
+	"genai-platform/internal/models"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrBuiltinRole is returned when changing or deleting a built-in role
+	ErrBuiltinRole = errors.New("built-in roles can not be changed")
+
+	// ErrRoleExists is returned when creating a role whose name is taken
+	ErrRoleExists = errors.New("role already exists")
+
+	// ErrRoleInUse is returned when deleting a role users still have
+	ErrRoleInUse = errors.New("role is assigned to users")
+
+	// ErrUnknownRole is returned when assigning a role that does not exist
+	ErrUnknownRole = errors.New("unknown role")
+
+	// ErrLastAdmin is returned when a change would leave no active user
+	// able to administer users
+	ErrLastAdmin = errors.New("at least one active administrator is required")
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+
+// adminGrants are the permissions that make a role's users administrators
+var adminGrants = []string{auth.ScopeAll, "admin:*", auth.PermissionAdminUsers}
+
+// RoleService keeps roles and the role of each user. It is the account
+// store of the auth middleware.
+type RoleService struct {
+	db          *sql.DB
+	adminEmails map[string]bool
+}
+
+// NewRoleService creates the service. Users signing in with one of
+// adminEmails are made administrators, which is how the first
+// administrator of a deployment is made.
+func NewRoleService(db *sql.DB, adminEmails []string) *RoleService {
+	emails := map[string]bool{}
+	for _, email := range adminEmails {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			emails[email] = true
+		}
+	}
+	return &RoleService{db: db, adminEmails: emails}
+}
+
+// Account returns the role and permissions of a user and whether the
+// account is disabled. It is called on every authenticated request.
+func (s *RoleService) Account(userID int) (*auth.Account, error) {
+	var account auth.Account
+	err := s.db.QueryRow(
+		`SELECT u.role, r.permissions, u.disabled_at IS NOT NULL
+		 FROM users u JOIN roles r ON r.name = u.role
+		 WHERE u.id = $1`,
+		userID,
+	).Scan(&account.Role, pq.Array(&account.Permissions), &account.Disabled)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// PromoteConfiguredAdmin makes a user an administrator when their email is
+// one of the configured admin emails
+func (s *RoleService) PromoteConfiguredAdmin(userID int, email string) {
+	if !s.adminEmails[strings.ToLower(strings.TrimSpace(email))] {
+		return
+	}
+	if _, err := s.db.Exec(
+		"UPDATE users SET role = $1, updated_at = NOW() WHERE id = $2 AND role <> $1",
+		auth.RoleAdmin, userID,
+	); err != nil {
+		fmt.Printf("Failed to make user %d an administrator: %v\n", userID, err)
+	}
+}
+
+// Roles lists every role with how many users have it, built-in roles first
+func (s *RoleService) Roles() ([]models.Role, error) {
+	rows, err := s.db.Query(
+		`SELECT r.name, COALESCE(r.description, ''), r.permissions, r.builtin, r.created_at, r.updated_at,
+		        (SELECT COUNT(*) FROM users u WHERE u.role = r.name)
+		 FROM roles r
+		 ORDER BY r.builtin DESC, r.name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.Name, &role.Description, pq.Array(&role.Permissions), &role.Builtin,
+			&role.CreatedAt, &role.UpdatedAt, &role.Users); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// ValidateRoleName checks the name of a new custom role
+func ValidateRoleName(name string) error {
+	if !roleNamePattern.MatchString(name) {
+		return fmt.Errorf("role name must be 2 to 50 lowercase letters, digits, '-' or '_', starting with a letter")
+	}
+	return nil
+}
+
+// CreateRole defines a custom role
+func (s *RoleService) CreateRole(name, description string, permissions []string) (*models.Role, error) {
+	if err := ValidateRoleName(name); err != nil {
+		return nil, err
+	}
+	permissions, err := cleanPermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	role := &models.Role{Name: name, Description: description, Permissions: permissions}
+	err = s.db.QueryRow(
+		`INSERT INTO roles (name, description, permissions) VALUES ($1, $2, $3)
+		 ON CONFLICT (name) DO NOTHING
+		 RETURNING created_at, updated_at`,
+		name, description, pq.Array(permissions),
+	).Scan(&role.CreatedAt, &role.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrRoleExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// UpdateRole replaces the description and permissions of a custom role.
+// It returns sql.ErrNoRows for an unknown role.
+func (s *RoleService) UpdateRole(name, description string, permissions []string) (*models.Role, error) {
+	permissions, err := cleanPermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockCustomRole(tx, name); err != nil {
+		return nil, err
+	}
+	role := &models.Role{Name: name, Description: description, Permissions: permissions}
+	if err := tx.QueryRow(
+		`UPDATE roles SET description = $1, permissions = $2, updated_at = NOW()
+		 WHERE name = $3
+		 RETURNING created_at, updated_at, (SELECT COUNT(*) FROM users WHERE role = $3)`,
+		description, pq.Array(permissions), name,
+	).Scan(&role.CreatedAt, &role.UpdatedAt, &role.Users); err != nil {
+		return nil, err
+	}
+	// Taking away admin rights from a role can leave nobody to give them back
+	if err := requireActiveAdmin(tx); err != nil {
+		return nil, err
+	}
+	return role, tx.Commit()
+}
+
+// DeleteRole deletes a custom role no user has. It returns sql.ErrNoRows
+// for an unknown role.
+func (s *RoleService) DeleteRole(name string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockCustomRole(tx, name); err != nil {
+		return err
+	}
+	var users int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE role = $1", name).Scan(&users); err != nil {
+		return err
+	}
+	if users > 0 {
+		return ErrRoleInUse
+	}
+	if _, err := tx.Exec("DELETE FROM roles WHERE name = $1", name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetUserRole gives a user a role. It returns sql.ErrNoRows for an unknown
+// user and ErrLastAdmin when it would demote the last administrator.
+func (s *RoleService) SetUserRole(userID int, role string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)", role).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownRole
+	}
+
+	result, err := tx.Exec("UPDATE users SET role = $1, updated_at = NOW() WHERE id = $2", role, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := requireActiveAdmin(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockCustomRole locks a role for a change, refusing built-in roles
+func lockCustomRole(tx *sql.Tx, name string) error {
+	var builtin bool
+	if err := tx.QueryRow("SELECT builtin FROM roles WHERE name = $1 FOR UPDATE", name).Scan(&builtin); err != nil {
+		return err
+	}
+	if builtin {
+		return ErrBuiltinRole
+	}
+	return nil
+}
+
+// requireActiveAdmin fails with ErrLastAdmin unless some enabled user can
+// still administer users once tx commits. The administrators are locked so
+// two admins demoting each other at once can not both succeed.
+func requireActiveAdmin(tx *sql.Tx) error {
+	rows, err := tx.Query(
+		`SELECT u.id FROM users u JOIN roles r ON r.name = u.role
+		 WHERE u.disabled_at IS NULL AND r.permissions && $1
+		 FOR UPDATE OF u`,
+		pq.Array(adminGrants),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// cleanPermissions validates, deduplicates and sorts role permissions
+func cleanPermissions(permissions []string) ([]string, error) {
+	permissions = cleanList(permissions)
+	if err := auth.ValidatePermissions(permissions); err != nil {
+		return nil, err
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"genai-platform/internal/auth"
+	"genai-platform/internal/models"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for refresh tokens that are
+	// unknown, expired or belong to a revoked session or disabled account
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+	// ErrRefreshTokenReused is returned when a refresh token that was
+	// already exchanged is presented again. Either the client or a thief
+	// holds a stale copy, so the whole session has been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// refreshReuseGrace is how long after a refresh token was exchanged a
+// second use is rejected without revoking the session
+const refreshReuseGrace = 10 * time.Second
+
+// Reasons a session was revoked, recorded on auth_sessions
+const (
+	RevokedLogout       = "logout"
+	RevokedByUser       = "revoked"
+	RevokedTokenReuse   = "refresh_token_reuse"
+	RevokedOtherDevices = "revoked_others"
+)
+
+// SessionService keeps signed-in sessions. Each session holds a family of
+// rotating refresh tokens, stored as SHA-256 hashes, and records the access
+// tokens issued to it so they can be revoked with it.
+type SessionService struct {
+	db         *sql.DB
+	auth       *auth.Service
+	refreshTTL time.Duration
+
+	// revoked caches access token ids known to be revoked until they
+	// expire; ids not found are looked up again every time
+	revoked sync.Map
+}
+
+// NewSessionService creates the service. Refresh tokens are valid for
+// refreshTTL after the session last refreshed.
+func NewSessionService(db *sql.DB, authService *auth.Service, refreshTTL time.Duration) *SessionService {
+	return &SessionService{db: db, auth: authService, refreshTTL: refreshTTL}
+}
+
+// Start signs a user in on a new session
+func (s *SessionService) Start(userID int, email, userAgent, ipAddress string) (*models.AuthTokens, error) {
+	s.purge()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var sessionID int
+	if err := tx.QueryRow(
+		`INSERT INTO auth_sessions (user_id, user_agent, ip_address, expires_at)
+		 VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second') RETURNING id`,
+		userID, userAgent, ipAddress, int64(s.refreshTTL.Seconds()),
+	).Scan(&sessionID); err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.issue(tx, sessionID, userID, email)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, tx.Commit()
+}
+
+// Refresh exchanges a refresh token for a new access token and a new
+// refresh token, extending the session. The token presented can not be used
+// again; presenting it again revokes the session and returns
+// ErrRefreshTokenReused.
+func (s *SessionService) Refresh(refreshToken, userAgent, ipAddress string) (*models.AuthTokens, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var tokenID, sessionID, userID int
+	var email string
+	var used, justUsed, revoked, expired bool
+	err = tx.QueryRow(
+		`SELECT rt.id, rt.used_at IS NOT NULL, COALESCE(rt.used_at > NOW() - $2 * INTERVAL '1 second', false),
+		        s.id, s.user_id, u.email,
+		        s.revoked_at IS NOT NULL OR u.disabled_at IS NOT NULL, s.expires_at < NOW()
+		 FROM refresh_tokens rt
+		 JOIN auth_sessions s ON s.id = rt.session_id
+		 JOIN users u ON u.id = s.user_id
+		 WHERE rt.token_hash = $1
+		 FOR UPDATE OF rt, s`,
+		hashToken(refreshToken), int64(refreshReuseGrace.Seconds()),
+	).Scan(&tokenID, &used, &justUsed, &sessionID, &userID, &email, &revoked, &expired)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case revoked || expired:
+		return nil, ErrInvalidRefreshToken
+	case justUsed:
+		// Two tabs or a retried request refreshing at once is not theft
+		return nil, ErrInvalidRefreshToken
+	case used:
+		if err := s.revokeSession(tx, sessionID, RevokedTokenReuse); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Revoked session %d of user %d: refresh token reused\n", sessionID, userID)
+		return nil, ErrRefreshTokenReused
+	}
+
+	if _, err := tx.Exec("UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1", tokenID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(
+		`UPDATE auth_sessions SET last_used_at = NOW(), expires_at = NOW() + $1 * INTERVAL '1 second',
+		   user_agent = $2, ip_address = $3
+		 WHERE id = $4`,
+		int64(s.refreshTTL.Seconds()), userAgent, ipAddress, sessionID,
+	); err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.issue(tx, sessionID, userID, email)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, tx.Commit()
+}
+
+// issue signs an access token and creates a refresh token for a session
+func (s *SessionService) issue(tx *sql.Tx, sessionID, userID int, email string) (*models.AuthTokens, error) {
+	access, err := s.auth.GenerateToken(userID, email, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(b)
+
+	if _, err := tx.Exec(
+		`INSERT INTO refresh_tokens (session_id, token_hash, access_token_id, access_expires_at)
+		 VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second')`,
+		sessionID, hashToken(refreshToken), access.ID, int64(s.auth.TTL().Seconds())+1,
+	); err != nil {
+		return nil, err
+	}
+
+	return &models.AuthTokens{
+		Token:        access.Token,
+		AccessToken:  access.Token,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.auth.TTL().Seconds()),
+		SessionID:    sessionID,
+		UserID:       userID,
+		Email:        email,
+	}, nil
+}
+
+// Sessions lists a user's active sessions, most recently used first
+func (s *SessionService) Sessions(userID, currentSessionID int) ([]models.AuthSession, error) {
+	rows, err := s.db.Query(
+		`SELECT id, user_agent, ip_address, created_at, last_used_at, expires_at
+		 FROM auth_sessions
+		 WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		 ORDER BY last_used_at DESC, id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.AuthSession{}
+	for rows.Next() {
+		session := models.AuthSession{UserID: userID}
+		if err := rows.Scan(&session.ID, &session.UserAgent, &session.IPAddress,
+			&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke ends one of a user's sessions: its refresh tokens stop working
+// and its access tokens are rejected. It returns sql.ErrNoRows when the
+// session is not the user's or is already revoked.
+func (s *SessionService) Revoke(userID, sessionID int, reason string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRow(
+		"SELECT id FROM auth_sessions WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL FOR UPDATE",
+		sessionID, userID,
+	).Scan(&id); err != nil {
+		return err
+	}
+	if err := s.revokeSession(tx, sessionID, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeAll ends every session of a user except keepSessionID, returning
+// how many were revoked. Pass 0 to revoke them all.
+func (s *SessionService) RevokeAll(userID, keepSessionID int, reason string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		"SELECT id FROM auth_sessions WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL FOR UPDATE",
+		userID, keepSessionID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := s.revokeSession(tx, id, reason); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), tx.Commit()
+}
+
+// revokeSession marks a session revoked and puts the access tokens issued
+// to it that have not expired yet on the revocation list
+func (s *SessionService) revokeSession(tx *sql.Tx, sessionID int, reason string) error {
+	if _, err := tx.Exec(
+		"UPDATE auth_sessions SET revoked_at = NOW(), revoked_reason = $1 WHERE id = $2 AND revoked_at IS NULL",
+		reason, sessionID,
+	); err != nil {
+		return err
+	}
+	_, err := tx.Exec(
+		`INSERT INTO revoked_tokens (token_id, expires_at)
+		 SELECT access_token_id, access_expires_at FROM refresh_tokens
+		 WHERE session_id = $1 AND access_token_id IS NOT NULL AND access_expires_at > NOW()
+		 ON CONFLICT (token_id) DO NOTHING`,
+		sessionID,
+	)
+	return err
+}
+
+// IsRevoked reports whether an access token has been revoked. It is called
+// on every authenticated request.
+func (s *SessionService) IsRevoked(tokenID string) (bool, error) {
+	if expiresAt, ok := s.revoked.Load(tokenID); ok {
+		if time.Now().Before(expiresAt.(time.Time)) {
+			return true, nil
+		}
+		s.revoked.Delete(tokenID)
+	}
+
+	var ttl float64
+	err := s.db.QueryRow(
+		"SELECT EXTRACT(EPOCH FROM expires_at - NOW()) FROM revoked_tokens WHERE token_id = $1",
+		tokenID,
+	).Scan(&ttl)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.revoked.Store(tokenID, time.Now().Add(time.Duration(ttl*float64(time.Second))))
+	return true, nil
+}
+
+// purge drops revocations of tokens that have expired anyway and sessions
+// that ended long enough ago that their refresh tokens no longer matter
+func (s *SessionService) purge() {
+	if _, err := s.db.Exec("DELETE FROM revoked_tokens WHERE expires_at < NOW()"); err != nil {
+		fmt.Printf("Failed to purge revoked tokens: %v\n", err)
+	}
+	if _, err := s.db.Exec("DELETE FROM auth_sessions WHERE expires_at < NOW() - INTERVAL '7 days'"); err != nil {
+		fmt.Printf("Failed to purge expired sessions: %v\n", err)
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,430 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"genai-platform/internal/models"
+	"github.com/lib/pq"
+)
+
+// previewRows is how many rows of a result are kept on the sql_queries row;
+// the full result is re-read through a cursor when paging or exporting
+const previewRows = 50
+
+// repairableSQLStates lists the Postgres error codes that the model can
+// plausibly fix by rewriting the query: syntax errors, unknown columns,
+// tables and functions, type mismatches and ambiguous references.
+var repairableSQLStates = map[pq.ErrorCode]bool{
+	"42601": true, // syntax_error
+	"42703": true, // undefined_column
+	"42P01": true, // undefined_table
+	"42883": true, // undefined_function
+	"42804": true, // datatype_mismatch
+	"42702": true, // ambiguous_column
+	"42803": true, // grouping_error
+	"42P10": true, // invalid_column_reference
+	"22P02": true, // invalid_text_representation
+}
+
+// ErrTextToSQLDisabled is returned when no query database is configured
+var ErrTextToSQLDisabled = errors.New("text-to-SQL is not configured")
+
+// readableStatements are the keywords a query may start with
+var readableStatements = map[string]bool{
+	"select": true,
+	"with":   true,
+	"values": true,
+	"table":  true,
+}
+
+// SQLService runs generated and saved SQL on its own connection, whose
+// role can only read the tables of one schema, rather than on the
+// application database
+type SQLService struct {
+	db         *sql.DB
+	schema     string
+	llm        *LLMService
+	maxRepairs int
+
+	cursorsMu sync.Mutex
+	cursors   map[string]*heldCursor
+}
+
+// NewSQLService runs queries on db, limited to schema. A nil db leaves
+// text-to-SQL disabled.
+func NewSQLService(db *sql.DB, schema string, llm *LLMService, maxRepairs int) *SQLService {
+	return &SQLService{
+		db:         db,
+		schema:     schema,
+		llm:        llm,
+		maxRepairs: maxRepairs,
+		cursors:    map[string]*heldCursor{},
+	}
+}
+
+// Enabled reports whether a query database is configured
+func (s *SQLService) Enabled() bool {
+	return s.db != nil
+}
+
+// ResultColumn describes one column of a query result
+type ResultColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// SQLResult is one page of the tabular output of an executed query
+type SQLResult struct {
+	Columns  []ResultColumn  `json:"columns"`
+	Rows     [][]interface{} `json:"rows"`
+	RowCount int             `json:"row_count"`
+	HasMore  bool            `json:"has_more"`
+}
+
+// ResultCursor walks the rows of a query through a server-side cursor held
+// open in a read-only transaction
+type ResultCursor struct {
+	tx      *sql.Tx
+	Columns []ResultColumn
+
+	// next is a row read ahead by page
+	next []interface{}
+}
+
+// GenerateAndExecute turns a natural language query into SQL and runs it.
+// The model only sees the tables of the query schema. When execution fails
+// with an error the model can fix, the error and the schema are sent back
+// for a rewrite, up to maxRepairs times. Every attempt is returned so the
+// caller can persist how the query converged.
+func (s *SQLService) GenerateAndExecute(userID, workspaceID int, naturalQuery string) (*SQLResult, []models.SQLAttempt, error) {
+	schema, err := s.DescribeSchema()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to describe schema: %w", err)
+	}
+
+	llm := s.llm.ForUser(userID).ForFeature(UsageFeatureSQL, workspaceID)
+	generated, err := llm.GenerateSQL(naturalQuery, schema)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate SQL: %w", err)
+	}
+	query := cleanSQL(generated)
+
+	var attempts []models.SQLAttempt
+	for i := 0; ; i++ {
+		result, attempt, execErr := s.runAttempt(i+1, query)
+		attempts = append(attempts, attempt)
+		if execErr == nil {
+			return result, attempts, nil
+		}
+
+		if i >= s.maxRepairs || !isRepairable(execErr) {
+			return nil, attempts, execErr
+		}
+
+		repaired, err := llm.RepairSQL(naturalQuery, query, describeSQLError(execErr), schema)
+		if err != nil {
+			return nil, attempts, fmt.Errorf("failed to repair SQL: %w", err)
+		}
+		repaired = cleanSQL(repaired)
+		if repaired == "" || repaired == query {
+			// The model gave up or returned the same query, so retrying
+			// would only fail the same way
+			return nil, attempts, execErr
+		}
+		query = repaired
+	}
+}
+
+// Run executes already written SQL once, without involving the model
+func (s *SQLService) Run(query string) (*SQLResult, models.SQLAttempt, error) {
+	return s.runAttempt(1, query)
+}
+
+func (s *SQLService) runAttempt(number int, query string) (*SQLResult, models.SQLAttempt, error) {
+	start := time.Now()
+	result, err := s.Execute(query)
+	attempt := models.SQLAttempt{
+		Attempt:    number,
+		SQL:        query,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		if pqErr, ok := err.(*pq.Error); ok {
+			attempt.ErrorCode = string(pqErr.Code)
+		}
+		return nil, attempt, err
+	}
+
+	attempt.RowCount = result.RowCount
+	return result, attempt, nil
+}
+
+// Execute runs a query and returns its first previewRows rows
+func (s *SQLService) Execute(query string) (*SQLResult, error) {
+	cursor, err := s.OpenCursor(query)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	return cursor.page(previewRows)
+}
+
+// OpenCursor declares a cursor for query inside a read-only transaction on
+// the query database, resolving table names in the query schema. The
+// query must be a single statement that reads; the cursor is declared
+// through a prepared statement, which Postgres refuses to give more than
+// one command. The transaction is rolled back when the cursor is closed.
+func (s *SQLService) OpenCursor(query string) (*ResultCursor, error) {
+	if !s.Enabled() {
+		return nil, ErrTextToSQLDisabled
+	}
+	query = cleanSQL(query)
+	if err := ValidateSQL(query); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []string{
+		"SET TRANSACTION READ ONLY",
+		"SET LOCAL statement_timeout = '30s'",
+		"SET LOCAL standard_conforming_strings = on",
+		"SET LOCAL search_path = " + pq.QuoteIdentifier(s.schema),
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	stmt, err := tx.Prepare("DECLARE result_cursor NO SCROLL CURSOR FOR " + query)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer stmt.Close()
+	if _, err := stmt.Exec(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return &ResultCursor{tx: tx}, nil
+}
+
+// page fetches up to limit rows, reading one more to learn whether
+// another page exists. The extra row is kept for the next page.
+func (c *ResultCursor) page(limit int) (*SQLResult, error) {
+	rows := [][]interface{}{}
+	if c.next != nil {
+		rows = append(rows, c.next)
+		c.next = nil
+	}
+	more, err := c.Fetch(limit + 1 - len(rows))
+	if err != nil {
+		return nil, err
+	}
+	rows = append(rows, more...)
+
+	result := &SQLResult{Columns: c.Columns, Rows: rows}
+	if len(rows) > limit {
+		c.next = rows[limit]
+		result.Rows = rows[:limit]
+		result.HasMore = true
+	}
+	result.RowCount = len(result.Rows)
+	return result, nil
+}
+
+// Fetch returns up to n rows from the cursor. An empty slice means the
+// cursor is exhausted. Columns is populated after the first call.
+func (c *ResultCursor) Fetch(n int) ([][]interface{}, error) {
+	rows, err := c.tx.Query(fmt.Sprintf("FETCH FORWARD %d FROM result_cursor", n))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if c.Columns == nil {
+		types, err := rows.ColumnTypes()
+		if err != nil {
+			return nil, err
+		}
+		c.Columns = make([]ResultColumn, len(types))
+		for i, t := range types {
+			c.Columns[i] = ResultColumn{Name: t.Name(), Type: t.DatabaseTypeName()}
+		}
+	}
+
+	result := [][]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(c.Columns))
+		pointers := make([]interface{}, len(c.Columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result = append(result, values)
+	}
+
+	return result, rows.Err()
+}
+
+func (c *ResultCursor) Close() error {
+	return c.tx.Rollback()
+}
+
+// DescribeSchema lists the tables and columns of the query schema that
+// the query role can read, in the format the SQL prompts use. Nothing
+// outside the schema is described to the model.
+func (s *SQLService) DescribeSchema() (string, error) {
+	if !s.Enabled() {
+		return "", ErrTextToSQLDisabled
+	}
+	rows, err := s.db.Query(
+		`SELECT table_name, column_name, data_type
+		 FROM information_schema.columns
+		 WHERE table_schema = $1
+		 ORDER BY table_name, ordinal_position`,
+		s.schema,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var b strings.Builder
+	b.WriteString("Database Schema:\n")
+	current := ""
+	for rows.Next() {
+		var table, column, dataType string
+		if err := rows.Scan(&table, &column, &dataType); err != nil {
+			return "", err
+		}
+		if table != current {
+			if current != "" {
+				b.WriteString(")\n")
+			}
+			fmt.Fprintf(&b, "- %s (", table)
+			current = table
+		} else {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s %s", column, dataType)
+	}
+	if current != "" {
+		b.WriteString(")\n")
+	}
+
+	return b.String(), rows.Err()
+}
+
+func isRepairable(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && repairableSQLStates[pqErr.Code]
+}
+
+func describeSQLError(err error) string {
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return err.Error()
+	}
+
+	msg := fmt.Sprintf("ERROR: %s (SQLSTATE %s)", pqErr.Message, pqErr.Code)
+	if pqErr.Hint != "" {
+		msg += "\nHINT: " + pqErr.Hint
+	}
+	if pqErr.Position != "" {
+		msg += "\nPOSITION: " + pqErr.Position
+	}
+	return msg
+}
+
+// ValidateSQL checks that query is a single statement that reads, such as
+// a SELECT or a WITH query. String constants, quoted identifiers and
+// comments are skipped, so a semicolon or keyword inside them is ignored.
+func ValidateSQL(query string) error {
+	first := ""
+	for i := 0; i < len(query); {
+		if end := skipSQLToken(query, i); end > i {
+			if first == "" && !isSQLComment(query, i) {
+				return fmt.Errorf("only SELECT queries can be run")
+			}
+			i = end
+			continue
+		}
+		c := query[i]
+		switch {
+		case c == ';':
+			// Only whitespace and comments may follow the statement
+			for j := i + 1; j < len(query); {
+				if isSQLComment(query, j) {
+					j = skipSQLToken(query, j)
+					continue
+				}
+				if !isSQLSpace(query[j]) {
+					return fmt.Errorf("only a single SQL statement can be run")
+				}
+				j++
+			}
+			i = len(query)
+		case first == "" && isParameterStart(c):
+			end := i + 1
+			for end < len(query) && isParameterPart(query[end]) {
+				end++
+			}
+			first = strings.ToLower(query[i:end])
+			i = end
+		case first == "" && !isSQLSpace(c) && c != '(':
+			return fmt.Errorf("only SELECT queries can be run")
+		default:
+			i++
+		}
+	}
+
+	if first == "" {
+		return fmt.Errorf("the SQL query is empty")
+	}
+	if !readableStatements[first] {
+		return fmt.Errorf("only SELECT queries can be run, not %s", strings.ToUpper(first))
+	}
+	return nil
+}
+
+func isSQLComment(query string, i int) bool {
+	return i+1 < len(query) && (query[i:i+2] == "--" || query[i:i+2] == "/*")
+}
+
+func isSQLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// cleanSQL strips markdown fences and trailing semicolons that models
+// commonly wrap around generated queries.
+func cleanSQL(query string) string {
+	query = strings.TrimSpace(query)
+	if strings.HasPrefix(query, "```") {
+		query = strings.TrimPrefix(query, "```sql")
+		query = strings.TrimPrefix(query, "```")
+		query = strings.TrimSuffix(query, "```")
+	}
+	query = strings.TrimSpace(query)
+	return strings.TrimRight(query, "; \n\t")
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// resultCursorTTL is how long a result cursor stays open after its
+	// last page was read
+	resultCursorTTL = 2 * time.Minute
+
+	// maxResultCursors caps the cursors open at once, each of which holds
+	// a connection to the query database; maxUserResultCursors caps them
+	// per user, whose oldest cursor is closed to make room for a new one
+	maxResultCursors     = 32
+	maxUserResultCursors = 4
+)
+
+var (
+	// ErrResultCursorExpired is returned for a cursor token that is
+	// unknown, used up, timed out or someone else's
+	ErrResultCursorExpired = errors.New("the result cursor has expired, start again from the first page")
+
+	// ErrTooManyResultCursors is returned when every cursor is in use
+	ErrTooManyResultCursors = errors.New("too many results are being paged through, try again shortly")
+)
+
+// ResultPage is one page of a result read through a held cursor
+type ResultPage struct {
+	*SQLResult
+
+	// Page counts from 1; Cursor fetches the next page and is empty on
+	// the last one
+	Page   int
+	Cursor string
+}
+
+// heldCursor is a result cursor kept open between page requests
+type heldCursor struct {
+	mu      sync.Mutex
+	cursor  *ResultCursor
+	userID  int
+	queryID int
+	page    int
+	opened  time.Time
+	expires time.Time
+	timer   *time.Timer
+	closed  bool
+}
+
+// PageResults reads the next page of a stored query's result. Without a
+// token it runs the query and returns the first page; the cursor is then
+// held open, so later pages come from the same snapshot and cost only
+// the rows they return. A cursor belongs to the user and query it was
+// opened for, and closes after the last page or resultCursorTTL idle.
+func (s *SQLService) PageResults(userID, queryID int, query, token string, limit int) (*ResultPage, error) {
+	var held *heldCursor
+	if token == "" {
+		var err error
+		if held, token, err = s.openCursor(userID, queryID, query); err != nil {
+			return nil, err
+		}
+	} else {
+		s.cursorsMu.Lock()
+		held = s.cursors[token]
+		s.cursorsMu.Unlock()
+		if held == nil || held.userID != userID || held.queryID != queryID {
+			return nil, ErrResultCursorExpired
+		}
+	}
+
+	held.mu.Lock()
+	defer held.mu.Unlock()
+	if held.closed {
+		return nil, ErrResultCursorExpired
+	}
+
+	result, err := held.cursor.page(limit)
+	if err != nil {
+		s.dropCursor(token, held)
+		return nil, err
+	}
+	held.page++
+	page := &ResultPage{SQLResult: result, Page: held.page}
+	if !result.HasMore {
+		s.dropCursor(token, held)
+		return page, nil
+	}
+	held.expires = time.Now().Add(resultCursorTTL)
+	page.Cursor = token
+	return page, nil
+}
+
+// openCursor opens and registers a cursor for query, first closing the
+// user's oldest cursor when they hold too many
+func (s *SQLService) openCursor(userID, queryID int, query string) (*heldCursor, string, error) {
+	s.cursorsMu.Lock()
+	var oldestToken string
+	var oldest *heldCursor
+	count := 0
+	for token, held := range s.cursors {
+		if held.userID != userID {
+			continue
+		}
+		count++
+		if oldest == nil || held.opened.Before(oldest.opened) {
+			oldestToken, oldest = token, held
+		}
+	}
+	full := len(s.cursors) >= maxResultCursors
+	s.cursorsMu.Unlock()
+
+	if oldest != nil && (count >= maxUserResultCursors || full) {
+		oldest.mu.Lock()
+		if !oldest.closed {
+			s.dropCursor(oldestToken, oldest)
+		}
+		oldest.mu.Unlock()
+	} else if full {
+		return nil, "", ErrTooManyResultCursors
+	}
+
+	cursor, err := s.OpenCursor(query)
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := randomToken()
+	if err != nil {
+		cursor.Close()
+		return nil, "", err
+	}
+
+	now := time.Now()
+	held := &heldCursor{cursor: cursor, userID: userID, queryID: queryID, opened: now, expires: now.Add(resultCursorTTL)}
+	s.cursorsMu.Lock()
+	defer s.cursorsMu.Unlock()
+	if len(s.cursors) >= maxResultCursors {
+		cursor.Close()
+		return nil, "", ErrTooManyResultCursors
+	}
+	s.cursors[token] = held
+	held.timer = time.AfterFunc(resultCursorTTL, func() { s.expireCursor(token, held) })
+	return held, token, nil
+}
+
+// expireCursor closes a cursor that has been idle for resultCursorTTL,
+// or checks again later when it was read since
+func (s *SQLService) expireCursor(token string, held *heldCursor) {
+	held.mu.Lock()
+	defer held.mu.Unlock()
+	if held.closed {
+		return
+	}
+	if wait := time.Until(held.expires); wait > 0 {
+		held.timer.Reset(wait)
+		return
+	}
+	s.dropCursor(token, held)
+}
+
+// dropCursor closes a cursor and forgets its token. The caller holds
+// held.mu.
+func (s *SQLService) dropCursor(token string, held *heldCursor) {
+	s.cursorsMu.Lock()
+	delete(s.cursors, token)
+	s.cursorsMu.Unlock()
+
+	held.closed = true
+	if held.timer != nil {
+		held.timer.Stop()
+	}
+	held.cursor.Close()
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+)
+
+func TestValidateSQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		ok    bool
+	}{
+		{"select", "SELECT id, name FROM orders", true},
+		{"lowercase", "select 1", true},
+		{"with", "WITH recent AS (SELECT * FROM orders) SELECT count(*) FROM recent", true},
+		{"values", "VALUES (1), (2)", true},
+		{"table", "TABLE orders", true},
+		{"parenthesized", "(SELECT 1) UNION (SELECT 2)", true},
+		{"leading comments", "-- monthly totals\n/* by region */ SELECT 1", true},
+		{"trailing semicolon", "SELECT 1;", true},
+		{"trailing comment", "SELECT 1; -- done", true},
+		{"semicolon in string", "SELECT 'a; DROP TABLE users' AS s", true},
+		{"semicolon in identifier", `SELECT 1 AS "a;b"`, true},
+		{"semicolon in comment", "SELECT 1 /* ; DELETE FROM users */", true},
+		{"nested comment", "SELECT 1 /* outer /* inner */ ; still comment */", true},
+		{"semicolon in dollar quote", "SELECT $$; DELETE FROM users$$", true},
+		{"semicolon in tagged dollar quote", "SELECT $q$ $$; $q$", true},
+		{"escaped quote in E string", `SELECT E'it\'s; fine'`, true},
+		{"doubled quote", "SELECT 'it''s; fine'", true},
+		{"positional parameter", "SELECT $1", true},
+
+		{"empty", "", false},
+		{"only comment", "-- nothing", false},
+		{"delete", "DELETE FROM users", false},
+		{"update", "UPDATE users SET email = 'x'", false},
+		{"set", "SET search_path = public", false},
+		{"second statement", "SELECT 1; DELETE FROM users", false},
+		{"second statement after comment", "SELECT 1 /* x */; SET ROLE postgres", false},
+		{"statement after E string", `SELECT E'\\'; DELETE FROM users`, false},
+		{"statement after plain string with backslash", `SELECT '\'; DELETE FROM users`, false},
+		{"statement after dollar quote", "SELECT $$x$$; DROP TABLE users", false},
+		{"leading string", "'x'; SELECT 1", false},
+		{"transaction", "COMMIT; SELECT 1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSQL(tt.query)
+			if tt.ok && err != nil {
+				t.Errorf("ValidateSQL(%q) = %v, want nil", tt.query, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("ValidateSQL(%q) = nil, want an error", tt.query)
+			}
+		})
+	}
+}
+
+// TestPageResults pages through a generated series on the database in
+// DATABASE_URL, which stands in for the query database
+func TestPageResults(t *testing.T) {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	s := NewSQLService(db, "public", nil, 0)
+	const query = "SELECT n FROM generate_series(1, 25) AS n"
+
+	t.Run("pages continue from the held cursor", func(t *testing.T) {
+		var seen []int64
+		token := ""
+		for want := 1; ; want++ {
+			page, err := s.PageResults(1, 1, query, token, 10)
+			if err != nil {
+				t.Fatalf("PageResults() page %d error = %v", want, err)
+			}
+			if page.Page != want {
+				t.Errorf("PageResults() page = %d, want %d", page.Page, want)
+			}
+			for _, row := range page.Rows {
+				seen = append(seen, row[0].(int64))
+			}
+			if page.HasMore != (page.Cursor != "") {
+				t.Errorf("PageResults() has_more %v with cursor %q", page.HasMore, page.Cursor)
+			}
+			if !page.HasMore {
+				break
+			}
+			token = page.Cursor
+		}
+		if len(seen) != 25 || seen[0] != 1 || seen[24] != 25 {
+			t.Errorf("paged rows = %v, want 1 to 25 once each", seen)
+		}
+		if _, err := s.PageResults(1, 1, query, token, 10); err != ErrResultCursorExpired {
+			t.Errorf("PageResults() after the last page error = %v, want ErrResultCursorExpired", err)
+		}
+	})
+
+	t.Run("cursors belong to their user and query", func(t *testing.T) {
+		page, err := s.PageResults(1, 1, query, "", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.PageResults(2, 1, query, page.Cursor, 10); err != ErrResultCursorExpired {
+			t.Errorf("PageResults() by another user error = %v, want ErrResultCursorExpired", err)
+		}
+		if _, err := s.PageResults(1, 2, query, page.Cursor, 10); err != ErrResultCursorExpired {
+			t.Errorf("PageResults() for another query error = %v, want ErrResultCursorExpired", err)
+		}
+		if next, err := s.PageResults(1, 1, query, page.Cursor, 10); err != nil || next.Page != 2 {
+			t.Errorf("PageResults() by its owner = %v, %v", next, err)
+		}
+	})
+
+	t.Run("a user's oldest cursor makes room", func(t *testing.T) {
+		var tokens []string
+		for i := 0; i <= maxUserResultCursors; i++ {
+			page, err := s.PageResults(3, 1, query, "", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens = append(tokens, page.Cursor)
+		}
+		if _, err := s.PageResults(3, 1, query, tokens[0], 10); err != ErrResultCursorExpired {
+			t.Errorf("PageResults() with the oldest cursor error = %v, want ErrResultCursorExpired", err)
+		}
+		if _, err := s.PageResults(3, 1, query, tokens[len(tokens)-1], 10); err != nil {
+			t.Errorf("PageResults() with the newest cursor error = %v", err)
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ParseSQLParameters returns the distinct :name placeholders in query in
+// the order they first appear
+func ParseSQLParameters(query string) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	scanSQLParameters(query, func(name string) string {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return ":" + name
+	})
+	return names
+}
+
+// BindSQLParameters replaces every :name placeholder in query with its
+// value quoted as a SQL literal. Postgres coerces the untyped literal to
+// whatever type the surrounding expression expects, so dates and numbers
+// can be passed as strings.
+func BindSQLParameters(query string, values map[string]interface{}) (string, error) {
+	var missing []string
+	bound := scanSQLParameters(query, func(name string) string {
+		value, ok := values[name]
+		if !ok {
+			missing = append(missing, name)
+			return ":" + name
+		}
+		if value == nil {
+			return "NULL"
+		}
+		return pq.QuoteLiteral(sqlParameterText(value))
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing values for parameters: %s", strings.Join(missing, ", "))
+	}
+	return bound, nil
+}
+
+// sqlParameterText is the text a value binds as. Numbers are written out
+// in full, since %v gives large floats an exponent such as 1e+06.
+func sqlParameterText(value interface{}) string {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	return fmt.Sprint(value)
+}
+
+// scanSQLParameters walks query and replaces each :name placeholder with
+// the result of replace. Quoted strings, quoted identifiers, comments and
+// :: casts are left untouched.
+func scanSQLParameters(query string, replace func(name string) string) string {
+	var b strings.Builder
+	for i := 0; i < len(query); {
+		c := query[i]
+		if end := skipSQLToken(query, i); end > i {
+			b.WriteString(query[i:end])
+			i = end
+			continue
+		}
+		switch {
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			b.WriteString("::")
+			i += 2
+		case c == ':' && i+1 < len(query) && isParameterStart(query[i+1]):
+			end := i + 2
+			for end < len(query) && isParameterPart(query[end]) {
+				end++
+			}
+			b.WriteString(replace(query[i+1 : end]))
+			i = end
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// skipSQLToken returns where the string constant, quoted identifier or
+// comment starting at query[i] ends, or i when none starts there. An
+// unterminated one runs to the end of query, as it does for Postgres.
+func skipSQLToken(query string, i int) int {
+	c := query[i]
+	switch {
+	case c == '\'':
+		// E'...' strings also escape with backslashes
+		escapes := i > 0 && (query[i-1] == 'e' || query[i-1] == 'E') && (i < 2 || !isParameterPart(query[i-2]))
+		return skipQuoted(query, i, escapes)
+	case c == '"':
+		return skipQuoted(query, i, false)
+	case c == '-' && i+1 < len(query) && query[i+1] == '-':
+		end := strings.IndexByte(query[i:], '\n')
+		if end < 0 {
+			return len(query)
+		}
+		return i + end
+	case c == '/' && i+1 < len(query) && query[i+1] == '*':
+		// Block comments nest
+		depth := 0
+		for j := i; j+1 < len(query); j++ {
+			switch {
+			case query[j] == '/' && query[j+1] == '*':
+				depth++
+				j++
+			case query[j] == '*' && query[j+1] == '/':
+				depth--
+				j++
+				if depth == 0 {
+					return j + 1
+				}
+			}
+		}
+		return len(query)
+	case c == '$' && (i == 0 || !isParameterPart(query[i-1])):
+		// $tag$...$tag$, where the tag may be empty; $1 is a positional
+		// parameter rather than a quote
+		tagEnd := i + 1
+		if tagEnd < len(query) && isParameterStart(query[tagEnd]) {
+			for tagEnd < len(query) && isParameterPart(query[tagEnd]) {
+				tagEnd++
+			}
+		}
+		if tagEnd >= len(query) || query[tagEnd] != '$' {
+			return i
+		}
+		tag := query[i : tagEnd+1]
+		end := strings.Index(query[tagEnd+1:], tag)
+		if end < 0 {
+			return len(query)
+		}
+		return tagEnd + 1 + end + len(tag)
+	}
+	return i
+}
+
+// skipQuoted returns where the quoted token starting at query[i] ends. A
+// doubled quote is an escaped quote, and so is a backslashed one when
+// escapes is set.
+func skipQuoted(query string, i int, escapes bool) int {
+	quote := query[i]
+	for end := i + 1; end < len(query); end++ {
+		switch {
+		case escapes && query[end] == '\\':
+			end++
+		case query[end] == quote:
+			if end+1 < len(query) && query[end+1] == quote {
+				end++
+				continue
+			}
+			return end + 1
+		}
+	}
+	return len(query)
+}
+
+func isParameterStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isParameterPart(c byte) bool {
+	return isParameterStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseSQLParameters(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"SELECT 1", []string{}},
+		{"SELECT * FROM orders WHERE placed_at >= :from AND placed_at < :to", []string{"from", "to"}},
+		{"SELECT :a, :b, :a", []string{"a", "b"}},
+		{"SELECT created_at::date FROM orders WHERE id = :id", []string{"id"}},
+		{"SELECT ':skipped', \":skipped\" -- :skipped\nFROM t WHERE x = :kept", []string{"kept"}},
+		{"SELECT /* :skipped /* nested */ :skipped */ $$ :skipped $$, $q$:skipped$q$, :kept", []string{"kept"}},
+		{"SELECT E'\\' :skipped', :kept", []string{"kept"}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseSQLParameters(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSQLParameters(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestBindSQLParameters(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		values  map[string]interface{}
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "string",
+			query:  "SELECT * FROM orders WHERE region = :region",
+			values: map[string]interface{}{"region": "EMEA"},
+			want:   "SELECT * FROM orders WHERE region = 'EMEA'",
+		},
+		{
+			name:   "number and repeat",
+			query:  "SELECT :n + :n",
+			values: map[string]interface{}{"n": 2},
+			want:   "SELECT '2' + '2'",
+		},
+		{
+			name:   "float without an exponent",
+			query:  "SELECT * FROM orders WHERE total >= :min AND placed_on = :day",
+			values: map[string]interface{}{"min": 1e6, "day": float64(20240101)},
+			want:   "SELECT * FROM orders WHERE total >= '1000000' AND placed_on = '20240101'",
+		},
+		{
+			name:   "fraction",
+			query:  "SELECT :rate",
+			values: map[string]interface{}{"rate": 0.075},
+			want:   "SELECT '0.075'",
+		},
+		{
+			name:   "large integer kept exact",
+			query:  "SELECT * FROM orders WHERE id = :id",
+			values: map[string]interface{}{"id": json.Number("9007199254740993")},
+			want:   "SELECT * FROM orders WHERE id = '9007199254740993'",
+		},
+		{
+			name:   "null",
+			query:  "SELECT * FROM orders WHERE region IS NOT DISTINCT FROM :region",
+			values: map[string]interface{}{"region": nil},
+			want:   "SELECT * FROM orders WHERE region IS NOT DISTINCT FROM NULL",
+		},
+		{
+			name:   "quote is escaped",
+			query:  "SELECT :name",
+			values: map[string]interface{}{"name": "O'Brien'; DROP TABLE orders; --"},
+			want:   "SELECT 'O''Brien''; DROP TABLE orders; --'",
+		},
+		{
+			name:   "backslash makes an escape string",
+			query:  "SELECT :path",
+			values: map[string]interface{}{"path": `C:\'`},
+			want:   `SELECT  E'C:\\'''`,
+		},
+		{
+			name:   "casts and quoted text are left alone",
+			query:  "SELECT ':id', created_at::date FROM orders WHERE id = :id",
+			values: map[string]interface{}{"id": 7},
+			want:   "SELECT ':id', created_at::date FROM orders WHERE id = '7'",
+		},
+		{
+			name:    "missing value",
+			query:   "SELECT :from, :to",
+			values:  map[string]interface{}{"from": "2024-01-01"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BindSQLParameters(tt.query, tt.values)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("BindSQLParameters() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BindSQLParameters() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("BindSQLParameters() = %q, want %q", got, tt.want)
+			}
+			// A bound value can never smuggle in a second statement
+			if err := ValidateSQL(got); err != nil {
+				t.Errorf("ValidateSQL(%q) = %v", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,448 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"genai-platform/internal/auth"
+	"genai-platform/internal/models"
+	"github.com/lib/pq"
+)
+
+// oidcLoginTTL is how long a user has to sign in at the identity provider
+const oidcLoginTTL = 10 * time.Minute
+
+var (
+	// ErrSSODisabled is returned when no identity provider is configured
+	ErrSSODisabled = errors.New("single sign-on is not configured")
+
+	// ErrSSOState is returned for an unknown, used or expired sign-in state
+	ErrSSOState = errors.New("sign-in request is invalid or has expired")
+
+	// ErrSSONoEmail is returned when the identity provider shares no email
+	// address for someone signing in for the first time
+	ErrSSONoEmail = errors.New("identity provider did not share an email address")
+
+	// ErrSSOEmailUnverified is returned when the identity provider has not
+	// verified the email address an account would be found or made by
+	ErrSSOEmailUnverified = errors.New("identity provider has not verified the email address")
+
+	// ErrSSOSignupDisabled is returned for an identity without an account
+	// when accounts are not made on first sign-in
+	ErrSSOSignupDisabled = errors.New("no account for this identity, ask an administrator for access")
+)
+
+var emailDomainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// SSOOptions configures how identities from the provider become accounts
+type SSOOptions struct {
+	// AllowSignup makes accounts for identities signing in the first time
+	AllowSignup bool
+
+	// TrustUnverifiedEmail finds and makes accounts by email addresses the
+	// provider does not say it verified
+	TrustUnverifiedEmail bool
+}
+
+// SSOLogin is the account someone signed in to with single sign-on
+type SSOLogin struct {
+	UserID   int
+	Email    string
+	Created  bool
+	Disabled bool
+}
+
+// SSOService signs users in through an OpenID Connect provider. The first
+// sign-in of an identity links it to the account with the same verified
+// email, or makes one and adds it to the workspaces mapped to its email
+// domain. Every sign-in gives the user the role mapped to their groups.
+type SSOService struct {
+	db      *sql.DB
+	client  *auth.OIDCClient
+	roles   *RoleService
+	options SSOOptions
+}
+
+// NewSSOService creates the service. A nil client leaves single sign-on
+// off while its mappings can still be managed.
+func NewSSOService(db *sql.DB, client *auth.OIDCClient, roles *RoleService, options SSOOptions) *SSOService {
+	return &SSOService{db: db, client: client, roles: roles, options: options}
+}
+
+// Enabled reports whether an identity provider is configured
+func (s *SSOService) Enabled() bool {
+	return s.client != nil
+}
+
+// Begin starts a sign-in, returning the provider page to send the user to
+// and the state the provider hands back with the code
+func (s *SSOService) Begin() (string, string, error) {
+	if s.client == nil {
+		return "", "", ErrSSODisabled
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := auth.NewPKCEVerifier()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := s.client.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expires_at)
+		 VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second')`,
+		hashToken(state), nonce, verifier, int64(oidcLoginTTL.Seconds()),
+	); err != nil {
+		return "", "", err
+	}
+	if _, err := s.db.Exec("DELETE FROM oidc_logins WHERE expires_at < NOW()"); err != nil {
+		fmt.Printf("Failed to purge expired OIDC logins: %v\n", err)
+	}
+	return authURL, state, nil
+}
+
+// Complete finishes a sign-in with the code and state the provider sent
+// the user back with. Each state works once. Errors caused by the provider
+// or the user wrap auth.ErrOIDCLogin or are one of the ErrSSO errors.
+func (s *SSOService) Complete(code, state string) (*SSOLogin, error) {
+	if s.client == nil {
+		return nil, ErrSSODisabled
+	}
+
+	var nonce, verifier string
+	err := s.db.QueryRow(
+		`DELETE FROM oidc_logins WHERE state_hash = $1 AND expires_at > NOW()
+		 RETURNING nonce, code_verifier`,
+		hashToken(state),
+	).Scan(&nonce, &verifier)
+	if err == sql.ErrNoRows {
+		return nil, ErrSSOState
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := s.client.Exchange(code, verifier, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	login, linked, err := s.provision(identity)
+	if err != nil {
+		return nil, err
+	}
+	if linked {
+		if err := s.joinDomainWorkspaces(login.UserID, login.Email); err != nil {
+			fmt.Printf("Failed to add user %d to the workspaces of their email domain: %v\n", login.UserID, err)
+		}
+	}
+	if err := s.applyGroupRole(login.UserID, identity.Groups); err != nil {
+		fmt.Printf("Failed to give user %d the role of their groups: %v\n", login.UserID, err)
+	}
+	return login, nil
+}
+
+// provision finds the account of an identity, linking or making one on its
+// first sign-in. linked reports whether the identity was new.
+func (s *SSOService) provision(identity *auth.OIDCIdentity) (login *SSOLogin, linked bool, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	login = &SSOLogin{}
+	err = tx.QueryRow(
+		`UPDATE user_identities i SET email = COALESCE(NULLIF($3, ''), i.email), last_login_at = NOW()
+		 FROM users u
+		 WHERE u.id = i.user_id AND i.issuer = $1 AND i.subject = $2
+		 RETURNING u.id, u.email, u.disabled_at IS NOT NULL`,
+		identity.Issuer, identity.Subject, identity.Email,
+	).Scan(&login.UserID, &login.Email, &login.Disabled)
+	if err == nil {
+		return login, false, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
+
+	// A new identity is matched by email, which must be one the provider
+	// vouches for
+	email := NormalizeEmail(identity.Email)
+	if email == "" {
+		return nil, false, ErrSSONoEmail
+	}
+	if !identity.EmailVerified && !s.options.TrustUnverifiedEmail {
+		return nil, false, ErrSSOEmailUnverified
+	}
+	if err := ValidateEmail(email); err != nil {
+		return nil, false, ErrSSONoEmail
+	}
+
+	err = tx.QueryRow(
+		`SELECT id, email, disabled_at IS NOT NULL FROM users WHERE lower(email) = $1
+		 ORDER BY email = $1 DESC LIMIT 1 FOR UPDATE`,
+		email,
+	).Scan(&login.UserID, &login.Email, &login.Disabled)
+	switch {
+	case err == sql.ErrNoRows:
+		if !s.options.AllowSignup {
+			return nil, false, ErrSSOSignupDisabled
+		}
+		// Accounts made here have no password; they sign in through the
+		// provider only
+		if err := tx.QueryRow(
+			`INSERT INTO users (email, password_hash, email_verified_at) VALUES ($1, '', NOW())
+			 RETURNING id, email`,
+			email,
+		).Scan(&login.UserID, &login.Email); err != nil {
+			return nil, false, err
+		}
+		login.Created = true
+	case err != nil:
+		return nil, false, err
+	default:
+		if _, err := tx.Exec(
+			"UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW() WHERE id = $1",
+			login.UserID,
+		); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at)
+		 VALUES ($1, $2, $3, $4, NOW())`,
+		login.UserID, identity.Issuer, identity.Subject, email,
+	); err != nil {
+		return nil, false, err
+	}
+	return login, true, tx.Commit()
+}
+
+// joinDomainWorkspaces adds a user to the workspaces mapped to the domain
+// of their email. Existing memberships keep their role.
+func (s *SSOService) joinDomainWorkspaces(userID int, email string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO workspace_members (workspace_id, user_id, role)
+		 SELECT d.workspace_id, $1, d.role FROM sso_domain_workspaces d
+		 WHERE d.domain = $2
+		 ON CONFLICT DO NOTHING`,
+		userID, emailDomain(email),
+	)
+	return err
+}
+
+// applyGroupRole gives a user the role of their highest priority mapped
+// group. Users in no mapped group keep their role.
+func (s *SSOService) applyGroupRole(userID int, groups []string) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	var role, current string
+	err := s.db.QueryRow(
+		`SELECT g.role, u.role FROM sso_group_roles g, users u
+		 WHERE u.id = $1 AND g.group_name = ANY($2)
+		 ORDER BY g.priority DESC, g.group_name
+		 LIMIT 1`,
+		userID, pq.Array(groups),
+	).Scan(&role, &current)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil || role == current {
+		return err
+	}
+	return s.roles.SetUserRole(userID, role)
+}
+
+// ValidateEmailDomain checks a domain for a domain mapping, such as
+// "example.com"
+func ValidateEmailDomain(domain string) error {
+	if len(domain) > 253 || !emailDomainPattern.MatchString(domain) {
+		return fmt.Errorf("a valid lowercase email domain such as example.com is required")
+	}
+	return nil
+}
+
+// ValidateSSOGroup checks the name of a group for a group mapping
+func ValidateSSOGroup(group string) error {
+	if strings.TrimSpace(group) == "" || len(group) > 255 {
+		return fmt.Errorf("group must be 1 to 255 characters")
+	}
+	return nil
+}
+
+// DomainMappings lists every email domain mapping
+func (s *SSOService) DomainMappings() ([]models.SSODomainMapping, error) {
+	rows, err := s.db.Query(
+		`SELECT d.domain, d.workspace_id, w.name, d.role, d.created_at, d.updated_at
+		 FROM sso_domain_workspaces d JOIN workspaces w ON w.id = d.workspace_id
+		 ORDER BY d.domain, w.name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mappings := []models.SSODomainMapping{}
+	for rows.Next() {
+		var m models.SSODomainMapping
+		if err := rows.Scan(&m.Domain, &m.WorkspaceID, &m.WorkspaceName, &m.Role, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, rows.Err()
+}
+
+// SetDomainMapping adds people of an email domain to a shared workspace
+// with role when they first sign in. It returns sql.ErrNoRows for an
+// unknown workspace and ErrPersonalWorkspace for a personal one.
+func (s *SSOService) SetDomainMapping(domain string, workspaceID int, role string) (*models.SSODomainMapping, error) {
+	if err := ValidateEmailDomain(domain); err != nil {
+		return nil, err
+	}
+	// Owners are made explicitly, never by domain
+	if !validWorkspaceRole(role) || role == WorkspaceRoleOwner {
+		return nil, ErrUnknownWorkspaceRole
+	}
+
+	var personal bool
+	if err := s.db.QueryRow("SELECT personal FROM workspaces WHERE id = $1", workspaceID).Scan(&personal); err != nil {
+		return nil, err
+	}
+	if personal {
+		return nil, ErrPersonalWorkspace
+	}
+
+	if _, err := s.db.Exec(
+		`INSERT INTO sso_domain_workspaces (domain, workspace_id, role) VALUES ($1, $2, $3)
+		 ON CONFLICT (domain, workspace_id) DO UPDATE SET role = EXCLUDED.role, updated_at = NOW()`,
+		domain, workspaceID, role,
+	); err != nil {
+		return nil, err
+	}
+
+	m := &models.SSODomainMapping{}
+	err := s.db.QueryRow(
+		`SELECT d.domain, d.workspace_id, w.name, d.role, d.created_at, d.updated_at
+		 FROM sso_domain_workspaces d JOIN workspaces w ON w.id = d.workspace_id
+		 WHERE d.domain = $1 AND d.workspace_id = $2`,
+		domain, workspaceID,
+	).Scan(&m.Domain, &m.WorkspaceID, &m.WorkspaceName, &m.Role, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DeleteDomainMapping stops adding people of a domain to a workspace. It
+// returns sql.ErrNoRows when there is no such mapping.
+func (s *SSOService) DeleteDomainMapping(domain string, workspaceID int) error {
+	result, err := s.db.Exec(
+		"DELETE FROM sso_domain_workspaces WHERE domain = $1 AND workspace_id = $2",
+		domain, workspaceID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GroupMappings lists every group mapping, highest priority first
+func (s *SSOService) GroupMappings() ([]models.SSOGroupMapping, error) {
+	rows, err := s.db.Query(
+		`SELECT group_name, role, priority, created_at, updated_at FROM sso_group_roles
+		 ORDER BY priority DESC, group_name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mappings := []models.SSOGroupMapping{}
+	for rows.Next() {
+		var m models.SSOGroupMapping
+		if err := rows.Scan(&m.Group, &m.Role, &m.Priority, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, rows.Err()
+}
+
+// SetGroupMapping gives the people of a group role when they sign in. It
+// returns ErrUnknownRole for a role that does not exist.
+func (s *SSOService) SetGroupMapping(group, role string, priority int) (*models.SSOGroupMapping, error) {
+	if err := ValidateSSOGroup(group); err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)", role).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrUnknownRole
+	}
+
+	m := &models.SSOGroupMapping{Group: group, Role: role, Priority: priority}
+	err := s.db.QueryRow(
+		`INSERT INTO sso_group_roles (group_name, role, priority) VALUES ($1, $2, $3)
+		 ON CONFLICT (group_name) DO UPDATE SET role = EXCLUDED.role, priority = EXCLUDED.priority, updated_at = NOW()
+		 RETURNING created_at, updated_at`,
+		group, role, priority,
+	).Scan(&m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DeleteGroupMapping stops giving a group's people a role. Users keep the
+// role they have. It returns sql.ErrNoRows when there is no such mapping.
+func (s *SSOService) DeleteGroupMapping(group string) error {
+	result, err := s.db.Exec("DELETE FROM sso_group_roles WHERE group_name = $1", group)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// emailDomain returns the lowercase domain of an email address
+func emailDomain(email string) string {
+	return strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+}
+
+// randomToken returns 32 random bytes, URL-safe encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,678 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"genai-platform/internal/auth"
+	"genai-platform/internal/database"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Single sign-on runs end to end against a stand-in OpenID Connect
+// provider: discovery, the authorization code flow with PKCE and the ID
+// token checks need nothing else, while accounts made and linked on first
+// sign-in and the domain and group mappings need the database from
+// DATABASE_URL, where throwaway users, a workspace and mappings are added
+// and removed again.
+
+func TestOIDCClient(t *testing.T) {
+	provider, err := newSSOProvider("sso-test-client", "sso-test-secret")
+	if err != nil {
+		t.Fatalf("failed to start provider: %v", err)
+	}
+	defer provider.Close()
+
+	client := auth.NewOIDCClient(auth.OIDCConfig{
+		Issuer:       provider.URL,
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  "http://localhost:3000/auth/oidc/callback",
+	})
+	alice := ssoIdentity{
+		Subject:       "alice-subject",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		Name:          "Alice",
+		Groups:        []string{"/engineers"},
+	}
+
+	// exchange signs in as identity and redeems the code with verifier
+	// against nonce, both as sent with the authorization request unless
+	// changed
+	exchange := func(verifier, nonce string) (*auth.OIDCIdentity, error) {
+		provider.SignIn(alice)
+		sent, err := auth.NewPKCEVerifier()
+		if err != nil {
+			return nil, err
+		}
+		authURL, err := client.AuthCodeURL("state", "nonce", sent)
+		if err != nil {
+			return nil, err
+		}
+		code, state, err := provider.Authorize(authURL)
+		if err != nil {
+			return nil, err
+		}
+		if state != "state" {
+			return nil, fmt.Errorf("provider returned state %q", state)
+		}
+		if verifier == "" {
+			verifier = sent
+		}
+		return client.Exchange(code, verifier, nonce)
+	}
+
+	t.Run("discovery and PKCE sign in", func(t *testing.T) {
+		identity, err := exchange("", "nonce")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity.Issuer != provider.URL || identity.Subject != alice.Subject || identity.Email != alice.Email ||
+			!identity.EmailVerified || identity.Name != alice.Name || len(identity.Groups) != 1 || identity.Groups[0] != "/engineers" {
+			t.Errorf("got identity %+v", identity)
+		}
+	})
+
+	t.Run("codes need the PKCE verifier", func(t *testing.T) {
+		if _, err := exchange("wrong-verifier", "nonce"); !errors.Is(err, auth.ErrOIDCLogin) {
+			t.Errorf("got %v, want an OIDC login error", err)
+		}
+	})
+
+	t.Run("ID tokens with another nonce are refused", func(t *testing.T) {
+		if _, err := exchange("", "another-nonce"); !errors.Is(err, auth.ErrOIDCLogin) {
+			t.Errorf("got %v, want an OIDC login error", err)
+		}
+	})
+
+	for _, tt := range []struct {
+		name   string
+		tamper func(claims jwt.MapClaims)
+	}{
+		{"ID tokens from another issuer are refused", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }},
+		{"ID tokens for another client are refused", func(claims jwt.MapClaims) { claims["aud"] = "another-client" }},
+		{"expired ID tokens are refused", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			provider.TamperNextToken(tt.tamper)
+			if _, err := exchange("", "nonce"); !errors.Is(err, auth.ErrOIDCLogin) {
+				t.Errorf("got %v, want an OIDC login error", err)
+			}
+		})
+	}
+
+	t.Run("ID tokens signed with unknown keys are refused", func(t *testing.T) {
+		provider.ForgeNextToken()
+		if _, err := exchange("", "nonce"); !errors.Is(err, auth.ErrOIDCLogin) {
+			t.Errorf("got %v, want an OIDC login error", err)
+		}
+	})
+}
+
+func TestSSO(t *testing.T) {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	db, err := database.Initialize(databaseURL)
+	if err != nil {
+		t.Fatalf("failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	provider, err := newSSOProvider("sso-test-client", "sso-test-secret")
+	if err != nil {
+		t.Fatalf("failed to start provider: %v", err)
+	}
+	defer provider.Close()
+
+	// The users, workspace and mappings are named after the run
+	f := &ssoFixture{
+		db:       db,
+		provider: provider,
+		domain:   fmt.Sprintf("run%d.example.com", time.Now().UnixNano()),
+		client: auth.NewOIDCClient(auth.OIDCConfig{
+			Issuer:       provider.URL,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  "http://localhost:3000/auth/oidc/callback",
+		}),
+	}
+	f.groups = [2]string{"/" + f.domain + "/analysts", "/" + f.domain + "/engineers"}
+	t.Cleanup(func() {
+		if err := f.cleanup(); err != nil {
+			t.Errorf("cleanup: %v", err)
+		}
+	})
+	sso := f.newService(true)
+
+	// An administrator so role changes never leave a deployment without
+	// one, and a workspace for the domain
+	if _, err := db.Exec(
+		"INSERT INTO users (email, password_hash, role) VALUES ($1, '', $2)",
+		f.email("admin"), auth.RoleAdmin,
+	); err != nil {
+		t.Fatalf("failed to add administrator: %v", err)
+	}
+	if err := db.QueryRow(
+		"INSERT INTO workspaces (name) VALUES ($1) RETURNING id", "sso-test "+f.domain,
+	).Scan(&f.workspaceID); err != nil {
+		t.Fatalf("failed to add workspace: %v", err)
+	}
+
+	t.Run("first sign-in makes an account", func(t *testing.T) {
+		login, err := f.signIn(sso, f.identity("alice"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !login.Created || login.Email != f.email("alice") {
+			t.Fatalf("got %+v, expected a new account for %s", login, f.email("alice"))
+		}
+
+		var verified bool
+		var passwordHash string
+		if err := db.QueryRow(
+			"SELECT email_verified_at IS NOT NULL, password_hash FROM users WHERE id = $1", login.UserID,
+		).Scan(&verified, &passwordHash); err != nil {
+			t.Fatal(err)
+		}
+		if !verified || passwordHash != "" {
+			t.Error("account should be verified and have no password")
+		}
+	})
+
+	t.Run("identities are found by subject", func(t *testing.T) {
+		first, err := f.signIn(sso, f.identity("alice"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The provider may change the email; the subject stays
+		renamed := f.identity("alice")
+		renamed.Email = f.email("alice.renamed")
+		again, err := f.signIn(sso, renamed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.Created || again.UserID != first.UserID {
+			t.Errorf("got user %d (created %v), expected user %d", again.UserID, again.Created, first.UserID)
+		}
+	})
+
+	t.Run("verified emails link existing accounts", func(t *testing.T) {
+		var userID int
+		if err := db.QueryRow(
+			"INSERT INTO users (email, password_hash) VALUES ($1, 'unused') RETURNING id", f.email("bob"),
+		).Scan(&userID); err != nil {
+			t.Fatal(err)
+		}
+
+		login, err := f.signIn(sso, f.identity("bob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if login.Created || login.UserID != userID {
+			t.Errorf("got user %d (created %v), expected the existing user %d", login.UserID, login.Created, userID)
+		}
+	})
+
+	t.Run("unverified emails are refused", func(t *testing.T) {
+		identity := f.identity("carol")
+		identity.EmailVerified = false
+		if _, err := f.signIn(sso, identity); err != ErrSSOEmailUnverified {
+			t.Errorf("got %v, expected %v", err, ErrSSOEmailUnverified)
+		}
+	})
+
+	t.Run("email domains add workspace members", func(t *testing.T) {
+		if _, err := sso.SetDomainMapping(f.domain, f.workspaceID, WorkspaceRoleViewer); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := sso.DeleteDomainMapping(f.domain, f.workspaceID); err != nil {
+				t.Errorf("failed to delete domain mapping: %v", err)
+			}
+		}()
+		login, err := f.signIn(sso, f.identity("dave"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var role string
+		if err := db.QueryRow(
+			"SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2", f.workspaceID, login.UserID,
+		).Scan(&role); err != nil {
+			t.Fatalf("membership: %v", err)
+		}
+		if role != WorkspaceRoleViewer {
+			t.Errorf("got workspace role %q, expected %q", role, WorkspaceRoleViewer)
+		}
+	})
+
+	t.Run("groups give roles", func(t *testing.T) {
+		if _, err := sso.SetGroupMapping(f.groups[0], auth.RoleViewer, 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sso.SetGroupMapping(f.groups[1], auth.RoleMember, 5); err != nil {
+			t.Fatal(err)
+		}
+
+		// Each sign-in starts from the role the one before it gave
+		for _, step := range []struct {
+			groups []string
+			role   string
+		}{
+			{[]string{f.groups[0], f.groups[1], "unmapped"}, auth.RoleMember},
+			{[]string{f.groups[0]}, auth.RoleViewer},
+			// No mapped group leaves the role alone
+			{[]string{"unmapped"}, auth.RoleViewer},
+		} {
+			identity := f.identity("erin")
+			identity.Groups = step.groups
+			login, err := f.signIn(sso, identity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var role string
+			if err := db.QueryRow("SELECT role FROM users WHERE id = $1", login.UserID).Scan(&role); err != nil {
+				t.Fatal(err)
+			}
+			if role != step.role {
+				t.Fatalf("groups %v gave role %q, expected %q", step.groups, role, step.role)
+			}
+		}
+	})
+
+	t.Run("states work once", func(t *testing.T) {
+		provider.SignIn(f.identity("alice"))
+		authURL, state, err := sso.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _, err := provider.Authorize(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sso.Complete(code, state); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sso.Complete(code, state); err != ErrSSOState {
+			t.Errorf("got %v reusing the state, expected %v", err, ErrSSOState)
+		}
+	})
+
+	t.Run("nonces are checked", func(t *testing.T) {
+		provider.TamperNextToken(func(claims jwt.MapClaims) { claims["nonce"] = "replayed" })
+		if _, err := f.signIn(sso, f.identity("alice")); !errors.Is(err, auth.ErrOIDCLogin) {
+			t.Errorf("got %v, expected an OIDC login error", err)
+		}
+	})
+
+	t.Run("sign-up can be turned off", func(t *testing.T) {
+		if _, err := f.signIn(sso, f.identity("grace")); err != nil {
+			t.Fatal(err)
+		}
+
+		closed := f.newService(false)
+		if _, err := f.signIn(closed, f.identity("frank")); err != ErrSSOSignupDisabled {
+			t.Errorf("got %v, expected %v", err, ErrSSOSignupDisabled)
+		}
+		// Known identities still sign in
+		if _, err := f.signIn(closed, f.identity("grace")); err != nil {
+			t.Errorf("known identity refused: %v", err)
+		}
+	})
+}
+
+// ssoIdentity is who signs in at the stand-in provider
+type ssoIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Groups        []string
+}
+
+// ssoProvider is a stand-in OpenID Connect provider serving discovery, an
+// authorization endpoint that signs in a preset identity without a login
+// page, a token endpoint checking PKCE, and its keys. It runs on an
+// httptest server until closed.
+type ssoProvider struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu       sync.Mutex
+	identity ssoIdentity
+	codes    map[string]ssoGrant
+	tamper   func(claims jwt.MapClaims)
+	forge    bool
+}
+
+// ssoGrant is an issued authorization code
+type ssoGrant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	identity    ssoIdentity
+	expiresAt   time.Time
+}
+
+const ssoProviderKeyID = "sso-test"
+
+// newSSOProvider starts a provider that accepts one client
+func newSSOProvider(clientID, clientSecret string) (*ssoProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ssoProvider{ClientID: clientID, ClientSecret: clientSecret, key: key, codes: map[string]ssoGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.server = httptest.NewServer(mux)
+	p.URL = p.server.URL
+	return p, nil
+}
+
+// Close stops the provider
+func (p *ssoProvider) Close() {
+	p.server.Close()
+}
+
+// SignIn sets the identity the next authorization signs in
+func (p *ssoProvider) SignIn(identity ssoIdentity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.identity = identity
+}
+
+// TamperNextToken changes the claims of the next ID token before it is
+// signed, to check that a bad token is refused
+func (p *ssoProvider) TamperNextToken(tamper func(claims jwt.MapClaims)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tamper = tamper
+}
+
+// ForgeNextToken signs the next ID token with a key the provider does not
+// publish
+func (p *ssoProvider) ForgeNextToken() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.forge = true
+}
+
+// Authorize visits an authorization URL like a browser whose user is
+// signed in at the provider, returning the code and state the provider
+// redirects back with
+func (p *ssoProvider) Authorize(authURL string) (string, string, error) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("authorization returned %s", resp.Status)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	query := location.Query()
+	if query.Get("error") != "" {
+		return "", "", fmt.Errorf("authorization failed: %s", query.Get("error"))
+	}
+	return query.Get("code"), query.Get("state"), nil
+}
+
+func (p *ssoProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+	})
+}
+
+func (p *ssoProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	switch {
+	case query.Get("client_id") != p.ClientID:
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	case redirectURI == "":
+		http.Error(w, "redirect_uri is required", http.StatusBadRequest)
+		return
+	case query.Get("response_type") != "code":
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		http.Error(w, "an S256 code_challenge is required", http.StatusBadRequest)
+		return
+	case !strings.Contains(" "+query.Get("scope")+" ", " openid "):
+		http.Error(w, "the openid scope is required", http.StatusBadRequest)
+		return
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.mu.Lock()
+	p.codes[code] = ssoGrant{
+		redirectURI: redirectURI,
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		identity:    p.identity,
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	back, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	values := back.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	back.RawQuery = values.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+func (p *ssoProvider) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		tokenError("invalid_request")
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || secret != p.ClientSecret {
+		tokenError("invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError("unsupported_grant_type")
+		return
+	}
+
+	// Codes work once, whether or not the exchange succeeds
+	p.mu.Lock()
+	code := r.PostForm.Get("code")
+	g, found := p.codes[code]
+	delete(p.codes, code)
+	tamper, forge := p.tamper, p.forge
+	p.tamper, p.forge = nil, false
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !found || time.Now().After(g.expiresAt):
+		tokenError("invalid_grant")
+		return
+	case r.PostForm.Get("redirect_uri") != g.redirectURI:
+		tokenError("invalid_grant")
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		tokenError("invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.URL,
+		"sub":            g.identity.Subject,
+		"aud":            p.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.identity.Email,
+		"email_verified": g.identity.EmailVerified,
+		"name":           g.identity.Name,
+	}
+	if g.identity.Groups != nil {
+		claims["groups"] = g.identity.Groups
+	}
+	if tamper != nil {
+		tamper(claims)
+	}
+
+	key := p.key
+	if forge {
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = ssoProviderKeyID
+	idToken, err := token.SignedString(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := randomToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (p *ssoProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	encode := base64.RawURLEncoding.EncodeToString
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []auth.JWK{{
+			Kty: "RSA", Kid: ssoProviderKeyID, Use: "sig", Alg: "RS256",
+			N: encode(p.key.N.Bytes()),
+			E: encode(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// ssoFixture holds what the single sign-on tests share: the database,
+// the stand-in provider and the names made for the run
+type ssoFixture struct {
+	db          *sql.DB
+	provider    *ssoProvider
+	client      *auth.OIDCClient
+	domain      string
+	groups      [2]string
+	workspaceID int
+}
+
+func (f *ssoFixture) cleanup() error {
+	if _, err := f.db.Exec("DELETE FROM sso_group_roles WHERE group_name = ANY(ARRAY[$1, $2])", f.groups[0], f.groups[1]); err != nil {
+		return err
+	}
+	if _, err := f.db.Exec("DELETE FROM workspaces WHERE id = $1", f.workspaceID); err != nil {
+		return err
+	}
+	_, err := f.db.Exec("DELETE FROM users WHERE email LIKE $1", "%@"+f.domain)
+	return err
+}
+
+func (f *ssoFixture) newService(allowSignup bool) *SSOService {
+	return NewSSOService(f.db, f.client, NewRoleService(f.db, nil), SSOOptions{
+		AllowSignup: allowSignup,
+	})
+}
+
+func (f *ssoFixture) email(name string) string {
+	return name + "@" + f.domain
+}
+
+func (f *ssoFixture) identity(name string) ssoIdentity {
+	return ssoIdentity{Subject: name + "-" + f.domain, Email: f.email(name), EmailVerified: true, Name: name}
+}
+
+// signIn goes through the whole flow as identity
+func (f *ssoFixture) signIn(sso *SSOService, identity ssoIdentity) (*SSOLogin, error) {
+	f.provider.SignIn(identity)
+	authURL, state, err := sso.Begin()
+	if err != nil {
+		return nil, err
+	}
+	code, returnedState, err := f.provider.Authorize(authURL)
+	if err != nil {
+		return nil, err
+	}
+	if returnedState != state {
+		return nil, fmt.Errorf("provider returned state %q, expected %q", returnedState, state)
+	}
+	return sso.Complete(code, state)
+}
@@ -0,0 +1,384 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"genai-platform/internal/models"
+)
+
+// Features of the platform model calls are metered against
+const (
+	UsageFeatureChat     = "chat"
+	UsageFeatureSQL      = "sql"
+	UsageFeatureResearch = "research"
+	UsageFeatureResume   = "resume"
+	UsageFeatureGraph    = "graph"
+)
+
+// UsageFeatures lists the features of llm_usage
+var UsageFeatures = []string{
+	UsageFeatureChat, UsageFeatureSQL, UsageFeatureResearch, UsageFeatureResume, UsageFeatureGraph,
+}
+
+// ModelPrice is what a model costs in US dollars per million prompt and
+// completion tokens
+type ModelPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// DefaultModelPrices are the list prices of the models the bridge uses,
+// looked up by the longest name prefix so dated versions such as
+// gpt-3.5-turbo-0125 match
+func DefaultModelPrices() map[string]ModelPrice {
+	return map[string]ModelPrice{
+		"gpt-3.5-turbo":    {Prompt: 0.50, Completion: 1.50},
+		"gpt-4":            {Prompt: 30, Completion: 60},
+		"gpt-4-turbo":      {Prompt: 10, Completion: 30},
+		"gpt-4o":           {Prompt: 2.50, Completion: 10},
+		"gpt-4o-mini":      {Prompt: 0.15, Completion: 0.60},
+		"gemini-pro":       {Prompt: 0.50, Completion: 1.50},
+		"gemini-1.5-flash": {Prompt: 0.075, Completion: 0.30},
+		"gemini-1.5-pro":   {Prompt: 1.25, Completion: 5},
+
+		// Embeddings are billed for their input only
+		"text-embedding-ada-002": {Prompt: 0.10},
+		"text-embedding-3-small": {Prompt: 0.02},
+		"text-embedding-3-large": {Prompt: 0.13},
+	}
+}
+
+// ParseModelPrices reads prices such as "gpt-4o=2.5/10,my-model=1/2",
+// dollars per million prompt/completion tokens, over the defaults
+func ParseModelPrices(spec string) (map[string]ModelPrice, error) {
+	prices := DefaultModelPrices()
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return prices, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		model, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		model = strings.TrimSpace(model)
+		promptValue, completionValue, hasCompletion := strings.Cut(value, "/")
+		if !ok || model == "" || !hasCompletion {
+			return nil, fmt.Errorf("invalid model price %q: expected model=prompt/completion", part)
+		}
+		prompt, err := strconv.ParseFloat(strings.TrimSpace(promptValue), 64)
+		if err != nil || prompt < 0 {
+			return nil, fmt.Errorf("invalid prompt price for %s: %q", model, promptValue)
+		}
+		completion, err := strconv.ParseFloat(strings.TrimSpace(completionValue), 64)
+		if err != nil || completion < 0 {
+			return nil, fmt.Errorf("invalid completion price for %s: %q", model, completionValue)
+		}
+		prices[model] = ModelPrice{Prompt: prompt, Completion: completion}
+	}
+	return prices, nil
+}
+
+// UsageQuota caps what a user's model calls may use in a calendar month
+// (UTC). Zero leaves that measure unlimited.
+type UsageQuota struct {
+	MonthlyTokens int64   `json:"monthly_tokens"`
+	MonthlyCost   float64 `json:"monthly_cost"`
+}
+
+// Quotas of QuotaExceededError
+const (
+	QuotaTokens = "token"
+	QuotaCost   = "cost"
+)
+
+// QuotaExceededError is returned for calls of a user who has used up a
+// monthly quota. Used and Limit are tokens or US dollars.
+type QuotaExceededError struct {
+	Quota    string
+	Used     float64
+	Limit    float64
+	ResetsAt time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("monthly %s quota exceeded", e.Quota)
+}
+
+// UsageCall is one operation of the bridge, which may have made several
+// model calls
+type UsageCall struct {
+	UserID           int
+	WorkspaceID      int
+	Feature          string
+	Operation        string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Latency          time.Duration
+}
+
+// UsageService meters the tokens and estimated cost of model calls per
+// user, workspace and feature, and enforces monthly quotas. Months and
+// days are UTC.
+type UsageService struct {
+	db     *sql.DB
+	prices map[string]ModelPrice
+	quota  UsageQuota
+}
+
+// NewUsageService prices calls with prices and gives users without a quota
+// of their own the default quota
+func NewUsageService(db *sql.DB, prices map[string]ModelPrice, quota UsageQuota) *UsageService {
+	return &UsageService{db: db, prices: prices, quota: quota}
+}
+
+// Cost estimates what a call cost in US dollars. Models without a price
+// cost nothing.
+func (s *UsageService) Cost(model string, promptTokens, completionTokens int) float64 {
+	price, ok := s.prices[model]
+	if !ok {
+		longest := 0
+		for name, p := range s.prices {
+			if len(name) > longest && strings.HasPrefix(model, name) {
+				price, longest = p, len(name)
+			}
+		}
+	}
+	return (float64(promptTokens)*price.Prompt + float64(completionTokens)*price.Completion) / 1e6
+}
+
+// Record adds a call to llm_usage. Failing to record is logged rather than
+// failing the call, whose tokens are spent either way.
+func (s *UsageService) Record(call UsageCall) {
+	var user, workspace interface{}
+	if call.UserID != 0 {
+		user = call.UserID
+	}
+	if call.WorkspaceID != 0 {
+		workspace = call.WorkspaceID
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO llm_usage (user_id, workspace_id, feature, operation, model, prompt_tokens, completion_tokens,
+		                        latency_ms, cost, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		user, workspace, call.Feature, call.Operation, call.Model, call.PromptTokens, call.CompletionTokens,
+		call.Latency.Milliseconds(), s.Cost(call.Model, call.PromptTokens, call.CompletionTokens), time.Now().UTC(),
+	); err != nil {
+		fmt.Printf("Failed to record usage of %s: %v\n", call.Operation, err)
+	}
+}
+
+// Quota returns the quota of a user: their own when an administrator set
+// one, the default otherwise
+func (s *UsageService) Quota(userID int) (UsageQuota, error) {
+	var quota UsageQuota
+	err := s.db.QueryRow(
+		"SELECT monthly_tokens, monthly_cost FROM usage_quotas WHERE user_id = $1", userID,
+	).Scan(&quota.MonthlyTokens, &quota.MonthlyCost)
+	if err == sql.ErrNoRows {
+		return s.quota, nil
+	}
+	return quota, err
+}
+
+// SetQuota gives a user a quota of their own, or the default again when
+// quota is nil. It returns sql.ErrNoRows for an unknown user.
+func (s *UsageService) SetQuota(userID int, quota *UsageQuota) error {
+	var exists bool
+	if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	if quota == nil {
+		_, err := s.db.Exec("DELETE FROM usage_quotas WHERE user_id = $1", userID)
+		return err
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO usage_quotas (user_id, monthly_tokens, monthly_cost, updated_at) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (user_id) DO UPDATE SET monthly_tokens = $2, monthly_cost = $3, updated_at = $4`,
+		userID, quota.MonthlyTokens, quota.MonthlyCost, time.Now(),
+	)
+	return err
+}
+
+// QuotaStatus returns a user's quota and what they used of it this month
+func (s *UsageService) QuotaStatus(userID int) (*models.UsageQuotaStatus, error) {
+	quota, err := s.Quota(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.quotaStatus(userID, quota)
+}
+
+func (s *UsageService) quotaStatus(userID int, quota UsageQuota) (*models.UsageQuotaStatus, error) {
+	start := MonthStart()
+	status := &models.UsageQuotaStatus{
+		MonthlyTokens: quota.MonthlyTokens,
+		MonthlyCost:   quota.MonthlyCost,
+		ResetsAt:      start.AddDate(0, 1, 0),
+	}
+	if err := s.db.QueryRow(
+		`SELECT COALESCE(SUM(prompt_tokens + completion_tokens), 0), COALESCE(SUM(cost), 0)
+		 FROM llm_usage WHERE user_id = $1 AND created_at >= $2`,
+		userID, start,
+	).Scan(&status.UsedTokens, &status.UsedCost); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// CheckQuota returns a *QuotaExceededError when the user has used up a
+// monthly quota
+func (s *UsageService) CheckQuota(userID int) error {
+	quota, err := s.Quota(userID)
+	if err != nil {
+		return err
+	}
+	if quota.MonthlyTokens == 0 && quota.MonthlyCost == 0 {
+		return nil
+	}
+
+	status, err := s.quotaStatus(userID, quota)
+	if err != nil {
+		return err
+	}
+	if quota.MonthlyTokens > 0 && status.UsedTokens >= quota.MonthlyTokens {
+		return &QuotaExceededError{
+			Quota: QuotaTokens, Used: float64(status.UsedTokens), Limit: float64(quota.MonthlyTokens), ResetsAt: status.ResetsAt,
+		}
+	}
+	if quota.MonthlyCost > 0 && status.UsedCost >= quota.MonthlyCost {
+		return &QuotaExceededError{Quota: QuotaCost, Used: status.UsedCost, Limit: quota.MonthlyCost, ResetsAt: status.ResetsAt}
+	}
+	return nil
+}
+
+// UsageFilter narrows a usage report. Zero IDs match everyone; the period
+// runs from From up to but not including To.
+type UsageFilter struct {
+	UserID      int
+	WorkspaceID int
+	From        time.Time
+	To          time.Time
+}
+
+// usageTotals sums the calls of llm_usage rows
+const usageTotals = `COUNT(*), COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0),
+	COALESCE(SUM(cost), 0), COALESCE(ROUND(AVG(latency_ms)), 0)`
+
+// maxUsageUsers caps the users listed in a report of everyone's usage
+const maxUsageUsers = 100
+
+// Report sums the usage matching filter in total, per day, per feature
+// and, when it is not of one user, per user from the largest spender down.
+// Days without calls are included with zeros.
+func (s *UsageService) Report(filter UsageFilter) (*models.UsageReport, error) {
+	const where = `($1::int = 0 OR u.user_id = $1::int) AND ($2::int = 0 OR u.workspace_id = $2::int)
+		AND u.created_at >= $3 AND u.created_at < $4`
+	args := []interface{}{filter.UserID, filter.WorkspaceID, filter.From.UTC(), filter.To.UTC()}
+
+	report := &models.UsageReport{
+		From:      filter.From.UTC(),
+		To:        filter.To.UTC(),
+		ByDay:     []models.UsageDay{},
+		ByFeature: []models.UsageFeature{},
+	}
+	if err := scanUsageTotals(s.db.QueryRow("SELECT "+usageTotals+" FROM llm_usage u WHERE "+where, args...),
+		&report.Totals); err != nil {
+		return nil, err
+	}
+
+	days := map[string]models.UsageTotals{}
+	rows, err := s.db.Query(
+		"SELECT u.created_at::date, "+usageTotals+" FROM llm_usage u WHERE "+where+" GROUP BY 1", args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day time.Time
+		var totals models.UsageTotals
+		if err := scanUsageTotals(rows, &totals, &day); err != nil {
+			return nil, err
+		}
+		days[day.Format("2006-01-02")] = totals
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for day := filter.From.UTC().Truncate(24 * time.Hour); day.Before(filter.To.UTC()); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		report.ByDay = append(report.ByDay, models.UsageDay{Date: date, UsageTotals: days[date]})
+	}
+
+	features, err := s.db.Query(
+		"SELECT u.feature, "+usageTotals+" FROM llm_usage u WHERE "+where+" GROUP BY 1", args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer features.Close()
+	for features.Next() {
+		var feature models.UsageFeature
+		if err := scanUsageTotals(features, &feature.UsageTotals, &feature.Feature); err != nil {
+			return nil, err
+		}
+		report.ByFeature = append(report.ByFeature, feature)
+	}
+	if err := features.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(report.ByFeature, func(i, j int) bool {
+		return report.ByFeature[i].Feature < report.ByFeature[j].Feature
+	})
+
+	if filter.UserID != 0 {
+		return report, nil
+	}
+	report.ByUser = []models.UsageUser{}
+	users, err := s.db.Query(
+		`SELECT u.user_id, COALESCE(users.email, ''), `+usageTotals+`
+		 FROM llm_usage u LEFT JOIN users ON users.id = u.user_id WHERE `+where+`
+		 GROUP BY 1, 2 ORDER BY SUM(u.cost) DESC, SUM(u.prompt_tokens + u.completion_tokens) DESC LIMIT $5`,
+		append(args, maxUsageUsers)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer users.Close()
+	for users.Next() {
+		var user models.UsageUser
+		if err := scanUsageTotals(users, &user.UsageTotals, &user.UserID, &user.Email); err != nil {
+			return nil, err
+		}
+		report.ByUser = append(report.ByUser, user)
+	}
+	return report, users.Err()
+}
+
+// scanUsageTotals scans the leading columns into keys and the
+// usageTotals columns after them into totals
+func scanUsageTotals(row interface{ Scan(...interface{}) error }, totals *models.UsageTotals, keys ...interface{}) error {
+	dest := append(keys, &totals.Calls, &totals.PromptTokens, &totals.CompletionTokens, &totals.Cost,
+		&totals.AvgLatencyMs)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	totals.TotalTokens = totals.PromptTokens + totals.CompletionTokens
+	return nil
+}
+
+// MonthStart is the first moment of the current month in UTC, when
+// quotas reset
+func MonthStart() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
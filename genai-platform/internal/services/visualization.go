@@ -0,0 +1,147 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+)
+
+const vegaLiteSchema = "https://vega.github.io/schema/vega-lite/v5.json"
+
+// maxPieSlices is the most categories a result may have to be suggested as
+// a pie chart; anything larger reads better as a bar chart
+const maxPieSlices = 6
+
+// Vega-Lite measurement types used to classify result columns
+const (
+	measureQuantitative = "quantitative"
+	measureTemporal     = "temporal"
+	measureNominal      = "nominal"
+)
+
+// SuggestVisualization picks a chart for a result from its column types and
+// returns it as a Vega-Lite spec with the preview rows inlined as data. A
+// temporal column with a measure becomes a line chart, a category with a
+// measure becomes a bar chart, or a pie chart when there are only a few
+// non-negative slices. Nil is returned when no chart fits the result.
+func SuggestVisualization(result *SQLResult) map[string]interface{} {
+	if result == nil || len(result.Rows) < 2 {
+		return nil
+	}
+
+	names := uniqueColumnNames(result.Columns)
+	var temporal, nominal, quantitative []int
+	for i, column := range result.Columns {
+		measure := columnMeasure(column.Type)
+		if measure == measureQuantitative && isIdentifierColumn(column.Name) {
+			// Keys are numbers but summing or plotting them means nothing
+			measure = measureNominal
+		}
+		switch measure {
+		case measureTemporal:
+			temporal = append(temporal, i)
+		case measureQuantitative:
+			quantitative = append(quantitative, i)
+		default:
+			nominal = append(nominal, i)
+		}
+	}
+
+	var mark string
+	var encoding map[string]interface{}
+	switch {
+	case len(temporal) > 0 && len(quantitative) > 0:
+		mark = "line"
+		encoding = map[string]interface{}{
+			"x": field(names[temporal[0]], measureTemporal),
+			"y": field(names[quantitative[0]], measureQuantitative),
+		}
+		if len(nominal) > 0 {
+			encoding["color"] = field(names[nominal[0]], measureNominal)
+		}
+	case len(nominal) > 0 && len(quantitative) > 0:
+		category, measure := nominal[0], quantitative[0]
+		if len(result.Rows) <= maxPieSlices && nonNegative(result.Rows, measure) {
+			mark = "arc"
+			encoding = map[string]interface{}{
+				"theta": field(names[measure], measureQuantitative),
+				"color": field(names[category], measureNominal),
+			}
+		} else {
+			mark = "bar"
+			x := field(names[category], measureNominal)
+			x["sort"] = "-y"
+			encoding = map[string]interface{}{
+				"x": x,
+				"y": field(names[measure], measureQuantitative),
+			}
+		}
+	default:
+		return nil
+	}
+
+	values := make([]map[string]interface{}, len(result.Rows))
+	for i, row := range result.Rows {
+		values[i] = make(map[string]interface{}, len(row))
+		for j, v := range row {
+			values[i][names[j]] = chartValue(result.Columns[j].Type, v)
+		}
+	}
+
+	return map[string]interface{}{
+		"$schema":  vegaLiteSchema,
+		"mark":     map[string]interface{}{"type": mark, "tooltip": true},
+		"encoding": encoding,
+		"data":     map[string]interface{}{"values": values},
+	}
+}
+
+// columnMeasure maps a Postgres type name onto a Vega-Lite measurement type
+func columnMeasure(databaseType string) string {
+	switch databaseType {
+	case "INT2", "INT4", "INT8", "FLOAT4", "FLOAT8", "NUMERIC":
+		return measureQuantitative
+	case "DATE", "TIMESTAMP", "TIMESTAMPTZ":
+		return measureTemporal
+	}
+	return measureNominal
+}
+
+func isIdentifierColumn(name string) bool {
+	name = strings.ToLower(name)
+	return name == "id" || strings.HasSuffix(name, "_id")
+}
+
+func field(name, measure string) map[string]interface{} {
+	return map[string]interface{}{"field": name, "type": measure}
+}
+
+// chartValue converts NUMERIC values, which are scanned as strings, to
+// numbers so the spec's data matches its encoding
+func chartValue(databaseType string, v interface{}) interface{} {
+	if s, ok := v.(string); ok && databaseType == "NUMERIC" {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+			return f
+		}
+	}
+	return v
+}
+
+func nonNegative(rows [][]interface{}, column int) bool {
+	for _, row := range rows {
+		switch value := row[column].(type) {
+		case int64:
+			if value < 0 {
+				return false
+			}
+		case float64:
+			if value < 0 {
+				return false
+			}
+		case string:
+			if strings.HasPrefix(strings.TrimSpace(value), "-") {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,669 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"genai-platform/internal/auth"
+	"genai-platform/internal/models"
+)
+
+// Workspace roles, from most to least privileged
+const (
+	WorkspaceRoleOwner  = "owner"
+	WorkspaceRoleAdmin  = "admin"
+	WorkspaceRoleMember = "member"
+	WorkspaceRoleViewer = "viewer"
+)
+
+// WorkspaceRoles lists the roles a workspace member can have
+var WorkspaceRoles = []string{WorkspaceRoleOwner, WorkspaceRoleAdmin, WorkspaceRoleMember, WorkspaceRoleViewer}
+
+// workspacePermissions is what each workspace role allows in the workspace,
+// on top of what the user's own role allows. Resumes, settings and
+// administration are not shared through workspaces, so no workspace role
+// restricts them.
+var workspacePermissions = map[string][]string{
+	WorkspaceRoleOwner:  {auth.ScopeAll},
+	WorkspaceRoleAdmin:  {auth.ScopeAll},
+	WorkspaceRoleMember: {auth.ScopeAll},
+	WorkspaceRoleViewer: {
+		auth.ScopeChatRead, auth.ScopeGraphRead, auth.ScopeResearchRead, auth.ScopeSQLRead,
+		"resume:*", "settings:*", "admin:*",
+	},
+}
+
+var (
+	// ErrPersonalWorkspace is returned when sharing, leaving or deleting a
+	// personal workspace
+	ErrPersonalWorkspace = errors.New("personal workspaces can not be shared, left or deleted")
+
+	// ErrLastOwner is returned when a change would leave a workspace
+	// without an owner
+	ErrLastOwner = errors.New("a workspace needs at least one owner")
+
+	// ErrOwnerOnly is returned when someone other than an owner grants or
+	// takes away the owner role
+	ErrOwnerOnly = errors.New("only an owner can grant or take away the owner role")
+
+	// ErrUnknownWorkspaceRole is returned for a role that is not one of
+	// WorkspaceRoles
+	ErrUnknownWorkspaceRole = errors.New("role must be one of owner, admin, member or viewer")
+
+	// ErrAlreadyMember is returned when inviting someone already in the
+	// workspace
+	ErrAlreadyMember = errors.New("user is already a member of this workspace")
+
+	// ErrInvalidInvitation is returned for an unknown, used, revoked or
+	// expired invitation token
+	ErrInvalidInvitation = errors.New("invitation is invalid or has expired")
+
+	// ErrInvitationEmail is returned when accepting an invitation sent to
+	// another email address
+	ErrInvitationEmail = errors.New("invitation was sent to a different email address")
+)
+
+// ValidateWorkspaceName checks the name of a workspace
+func ValidateWorkspaceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(name) > 255 {
+		return fmt.Errorf("name must be at most 255 characters")
+	}
+	return nil
+}
+
+// CanManageWorkspace reports whether a workspace role may rename the
+// workspace and manage its members and invitations
+func CanManageWorkspace(role string) bool {
+	return role == WorkspaceRoleOwner || role == WorkspaceRoleAdmin
+}
+
+func validWorkspaceRole(role string) bool {
+	_, ok := workspacePermissions[role]
+	return ok
+}
+
+// WorkspaceService keeps workspaces, their members and invitations. It is
+// the workspace store of the auth middleware.
+type WorkspaceService struct {
+	db            *sql.DB
+	store         GraphStore
+	mail          *MailService
+	invitationTTL time.Duration
+}
+
+// NewWorkspaceService creates the service. Deleting a workspace clears its
+// graph from store, and invitations are emailed through mail and can be
+// accepted for invitationTTL.
+func NewWorkspaceService(db *sql.DB, store GraphStore, mail *MailService, invitationTTL time.Duration) *WorkspaceService {
+	return &WorkspaceService{db: db, store: store, mail: mail, invitationTTL: invitationTTL}
+}
+
+// Membership returns a user's role in a workspace, or nil when they are not
+// a member. Workspace 0 is the user's personal workspace. It is called on
+// every authenticated request.
+func (s *WorkspaceService) Membership(userID, workspaceID int) (*auth.Membership, error) {
+	membership := &auth.Membership{WorkspaceID: workspaceID}
+	if workspaceID == 0 {
+		id, err := s.EnsurePersonal(userID)
+		if err != nil {
+			return nil, err
+		}
+		membership.WorkspaceID = id
+		membership.Role = WorkspaceRoleOwner
+	} else {
+		err := s.db.QueryRow(
+			`SELECT m.role, w.require_mfa AND NOT EXISTS (
+			        SELECT 1 FROM user_mfa f WHERE f.user_id = m.user_id AND f.enabled_at IS NOT NULL)
+			 FROM workspace_members m JOIN workspaces w ON w.id = m.workspace_id
+			 WHERE m.workspace_id = $1 AND m.user_id = $2`,
+			workspaceID, userID,
+		).Scan(&membership.Role, &membership.MFARequired)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	membership.Permissions = workspacePermissions[membership.Role]
+	return membership, nil
+}
+
+// EnsurePersonal returns the id of a user's personal workspace, creating it
+// when the user has none yet
+func (s *WorkspaceService) EnsurePersonal(userID int) (int, error) {
+	var id int
+	err := s.db.QueryRow("SELECT id FROM workspaces WHERE personal AND created_by = $1", userID).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		`INSERT INTO workspaces (name, personal, created_by) VALUES ('Personal', true, $1)
+		 ON CONFLICT (created_by) WHERE personal DO NOTHING
+		 RETURNING id`,
+		userID,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		// Created by a concurrent request
+		tx.Rollback()
+		err = s.db.QueryRow("SELECT id FROM workspaces WHERE personal AND created_by = $1", userID).Scan(&id)
+		return id, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3)",
+		id, userID, WorkspaceRoleOwner,
+	); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// Create makes a shared workspace owned by userID
+func (s *WorkspaceService) Create(userID int, name string) (*models.Workspace, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	workspace := &models.Workspace{Name: name, CreatedBy: userID, Role: WorkspaceRoleOwner, Members: 1}
+	if err := tx.QueryRow(
+		"INSERT INTO workspaces (name, created_by) VALUES ($1, $2) RETURNING id, created_at",
+		name, userID,
+	).Scan(&workspace.ID, &workspace.CreatedAt); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3)",
+		workspace.ID, userID, WorkspaceRoleOwner,
+	); err != nil {
+		return nil, err
+	}
+	return workspace, tx.Commit()
+}
+
+const workspaceColumns = `w.id, w.name, w.personal, COALESCE(w.created_by, 0), m.role,
+	(SELECT COUNT(*) FROM workspace_members c WHERE c.workspace_id = w.id), w.created_at, w.require_mfa`
+
+func scanWorkspace(row interface{ Scan(...interface{}) error }) (*models.Workspace, error) {
+	var workspace models.Workspace
+	if err := row.Scan(&workspace.ID, &workspace.Name, &workspace.Personal, &workspace.CreatedBy,
+		&workspace.Role, &workspace.Members, &workspace.CreatedAt, &workspace.RequireMFA); err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+// List returns the workspaces a user is a member of, personal first
+func (s *WorkspaceService) List(userID int) ([]models.Workspace, error) {
+	if _, err := s.EnsurePersonal(userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(
+		`SELECT `+workspaceColumns+`
+		 FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id AND m.user_id = $1
+		 ORDER BY w.personal DESC, w.name, w.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []models.Workspace{}
+	for rows.Next() {
+		workspace, err := scanWorkspace(rows)
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, *workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+// Get returns a workspace with the user's role in it, or sql.ErrNoRows when
+// they are not a member
+func (s *WorkspaceService) Get(workspaceID, userID int) (*models.Workspace, error) {
+	return scanWorkspace(s.db.QueryRow(
+		`SELECT `+workspaceColumns+`
+		 FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id AND m.user_id = $2
+		 WHERE w.id = $1`,
+		workspaceID, userID,
+	))
+}
+
+// Rename changes the name of a workspace. It returns sql.ErrNoRows for an
+// unknown workspace.
+func (s *WorkspaceService) Rename(workspaceID int, name string) error {
+	result, err := s.db.Exec("UPDATE workspaces SET name = $1, updated_at = NOW() WHERE id = $2", name, workspaceID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetRequireMFA turns on or off requiring two-factor authentication of the
+// members of a shared workspace. It returns sql.ErrNoRows for an unknown
+// workspace and ErrPersonalWorkspace for a personal one.
+func (s *WorkspaceService) SetRequireMFA(workspaceID int, required bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockSharedWorkspace(tx, workspaceID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"UPDATE workspaces SET require_mfa = $1, updated_at = NOW() WHERE id = $2",
+		required, workspaceID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete deletes a shared workspace with its documents, chats, research,
+// queries and graph. It returns sql.ErrNoRows for an unknown workspace and
+// ErrPersonalWorkspace for a personal one.
+func (s *WorkspaceService) Delete(workspaceID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockSharedWorkspace(tx, workspaceID); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT file_path FROM documents WHERE workspace_id = $1", workspaceID)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return err
+		}
+		files = append(files, path)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Chat messages are the only rows that do not cascade with the workspace
+	if _, err := tx.Exec(
+		"DELETE FROM chat_messages WHERE session_id IN (SELECT id FROM chat_sessions WHERE workspace_id = $1)",
+		workspaceID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM workspaces WHERE id = $1", workspaceID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The Postgres graph went with the workspace; other stores keep theirs
+	// apart from the database
+	if err := s.store.Clear(workspaceID); err != nil {
+		fmt.Printf("Failed to clear graph of deleted workspace %d: %v\n", workspaceID, err)
+	}
+	for _, path := range files {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Failed to remove file %s of deleted workspace %d: %v\n", path, workspaceID, err)
+		}
+	}
+	return nil
+}
+
+// Members lists the members of a workspace
+func (s *WorkspaceService) Members(workspaceID int) ([]models.WorkspaceMember, error) {
+	rows, err := s.db.Query(
+		`SELECT m.user_id, u.email, m.role,
+		        EXISTS (SELECT 1 FROM user_mfa f WHERE f.user_id = m.user_id AND f.enabled_at IS NOT NULL),
+		        m.created_at
+		 FROM workspace_members m JOIN users u ON u.id = m.user_id
+		 WHERE m.workspace_id = $1
+		 ORDER BY u.email`,
+		workspaceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.WorkspaceMember{}
+	for rows.Next() {
+		var member models.WorkspaceMember
+		if err := rows.Scan(&member.UserID, &member.Email, &member.Role, &member.MFAEnabled, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// SetMemberRole changes a member's role on behalf of someone with
+// actorRole. It returns sql.ErrNoRows for a user who is not a member.
+func (s *WorkspaceService) SetMemberRole(workspaceID, userID int, role, actorRole string) error {
+	if !validWorkspaceRole(role) {
+		return ErrUnknownWorkspaceRole
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockMember(tx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if (current == WorkspaceRoleOwner || role == WorkspaceRoleOwner) && actorRole != WorkspaceRoleOwner {
+		return ErrOwnerOnly
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE workspace_members SET role = $1 WHERE workspace_id = $2 AND user_id = $3",
+		role, workspaceID, userID,
+	); err != nil {
+		return err
+	}
+	if err := requireOwner(tx, workspaceID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveMember takes a user out of a workspace on behalf of someone with
+// actorRole. Their documents and queries stay in the workspace. It returns
+// sql.ErrNoRows for a user who is not a member.
+func (s *WorkspaceService) RemoveMember(workspaceID, userID int, actorRole string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockMember(tx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if current == WorkspaceRoleOwner && actorRole != WorkspaceRoleOwner {
+		return ErrOwnerOnly
+	}
+
+	if _, err := tx.Exec(
+		"DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2",
+		workspaceID, userID,
+	); err != nil {
+		return err
+	}
+	if err := requireOwner(tx, workspaceID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Invite invites an email address into a workspace with a role, replacing
+// any pending invitation of the same address, and emails it a link. The
+// returned token accepts the invitation and is not stored.
+func (s *WorkspaceService) Invite(workspaceID, invitedBy int, email, role, actorRole string) (*models.WorkspaceInvitation, string, error) {
+	if !validWorkspaceRole(role) {
+		return nil, "", ErrUnknownWorkspaceRole
+	}
+	if role == WorkspaceRoleOwner && actorRole != WorkspaceRoleOwner {
+		return nil, "", ErrOwnerOnly
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	if err := lockSharedWorkspace(tx, workspaceID); err != nil {
+		return nil, "", err
+	}
+
+	var member bool
+	if err := tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM workspace_members m JOIN users u ON u.id = m.user_id
+		                WHERE m.workspace_id = $1 AND lower(u.email) = lower($2))`,
+		workspaceID, email,
+	).Scan(&member); err != nil {
+		return nil, "", err
+	}
+	if member {
+		return nil, "", ErrAlreadyMember
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE workspace_invitations SET revoked_at = NOW()
+		 WHERE workspace_id = $1 AND lower(email) = lower($2) AND accepted_at IS NULL AND revoked_at IS NULL`,
+		workspaceID, email,
+	); err != nil {
+		return nil, "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	invitation := &models.WorkspaceInvitation{WorkspaceID: workspaceID, Email: email, Role: role, InvitedBy: invitedBy}
+	var inviterEmail string
+	if err := tx.QueryRow(
+		`INSERT INTO workspace_invitations (workspace_id, email, role, token_hash, invited_by, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, NOW() + $6 * INTERVAL '1 second')
+		 RETURNING id, expires_at, created_at, (SELECT name FROM workspaces WHERE id = $1),
+		           (SELECT email FROM users WHERE id = $5)`,
+		workspaceID, email, role, hashToken(token), invitedBy, int64(s.invitationTTL.Seconds()),
+	).Scan(&invitation.ID, &invitation.ExpiresAt, &invitation.CreatedAt, &invitation.WorkspaceName, &inviterEmail); err != nil {
+		return nil, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+
+	s.mail.Notify(email, EmailWorkspaceInvitation, map[string]interface{}{
+		"InvitedBy": inviterEmail,
+		"Workspace": invitation.WorkspaceName,
+		"Role":      role,
+		"Link":      s.mail.Link("/invitations", token),
+		"ExpiresIn": humanDuration(s.invitationTTL),
+	})
+	return invitation, token, nil
+}
+
+const invitationColumns = `i.id, i.workspace_id, w.name, i.email, i.role, COALESCE(i.invited_by, 0),
+	i.expires_at, i.accepted_at, i.created_at`
+
+const pendingInvitation = `i.accepted_at IS NULL AND i.revoked_at IS NULL AND i.expires_at > NOW()`
+
+// Invitations lists the pending invitations of a workspace
+func (s *WorkspaceService) Invitations(workspaceID int) ([]models.WorkspaceInvitation, error) {
+	return s.queryInvitations(
+		`SELECT `+invitationColumns+`
+		 FROM workspace_invitations i JOIN workspaces w ON w.id = i.workspace_id
+		 WHERE i.workspace_id = $1 AND `+pendingInvitation+`
+		 ORDER BY i.created_at DESC, i.id DESC`,
+		workspaceID,
+	)
+}
+
+// PendingInvitations lists the pending invitations sent to a user's email
+func (s *WorkspaceService) PendingInvitations(userID int) ([]models.WorkspaceInvitation, error) {
+	return s.queryInvitations(
+		`SELECT `+invitationColumns+`
+		 FROM workspace_invitations i JOIN workspaces w ON w.id = i.workspace_id
+		 WHERE lower(i.email) = (SELECT lower(email) FROM users WHERE id = $1) AND `+pendingInvitation+`
+		 ORDER BY i.created_at DESC, i.id DESC`,
+		userID,
+	)
+}
+
+// RevokeInvitation cancels a pending invitation. It returns sql.ErrNoRows
+// when the workspace has no such pending invitation.
+func (s *WorkspaceService) RevokeInvitation(workspaceID, invitationID int) error {
+	result, err := s.db.Exec(
+		`UPDATE workspace_invitations i SET revoked_at = NOW()
+		 WHERE i.id = $1 AND i.workspace_id = $2 AND `+pendingInvitation,
+		invitationID, workspaceID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AcceptInvitation makes a user a member of the workspace an invitation
+// token was issued for. The invitation must have been sent to the user's
+// email address.
+func (s *WorkspaceService) AcceptInvitation(userID int, token string) (*models.Workspace, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var invitationID, workspaceID int
+	var email, role string
+	err = tx.QueryRow(
+		`SELECT i.id, i.workspace_id, i.email, i.role FROM workspace_invitations i
+		 WHERE i.token_hash = $1 AND `+pendingInvitation+`
+		 FOR UPDATE`,
+		hashToken(token),
+	).Scan(&invitationID, &workspaceID, &email, &role)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var userEmail string
+	if err := tx.QueryRow("SELECT email FROM users WHERE id = $1", userID).Scan(&userEmail); err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(email, userEmail) {
+		return nil, ErrInvitationEmail
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3)
+		 ON CONFLICT DO NOTHING`,
+		workspaceID, userID, role,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(
+		"UPDATE workspace_invitations SET accepted_at = NOW(), accepted_by = $1 WHERE id = $2",
+		userID, invitationID,
+	); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.Get(workspaceID, userID)
+}
+
+func (s *WorkspaceService) queryInvitations(query string, args ...interface{}) ([]models.WorkspaceInvitation, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []models.WorkspaceInvitation{}
+	for rows.Next() {
+		var invitation models.WorkspaceInvitation
+		if err := rows.Scan(&invitation.ID, &invitation.WorkspaceID, &invitation.WorkspaceName, &invitation.Email,
+			&invitation.Role, &invitation.InvitedBy, &invitation.ExpiresAt, &invitation.AcceptedAt,
+			&invitation.CreatedAt); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
+
+// lockSharedWorkspace locks a workspace for a change to its membership,
+// refusing personal workspaces
+func lockSharedWorkspace(tx *sql.Tx, workspaceID int) error {
+	var personal bool
+	if err := tx.QueryRow("SELECT personal FROM workspaces WHERE id = $1 FOR UPDATE", workspaceID).Scan(&personal); err != nil {
+		return err
+	}
+	if personal {
+		return ErrPersonalWorkspace
+	}
+	return nil
+}
+
+// lockMember locks the workspace and returns a member's role in it
+func lockMember(tx *sql.Tx, workspaceID, userID int) (string, error) {
+	if err := lockSharedWorkspace(tx, workspaceID); err != nil {
+		return "", err
+	}
+	var role string
+	err := tx.QueryRow(
+		"SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2",
+		workspaceID, userID,
+	).Scan(&role)
+	return role, err
+}
+
+// requireOwner fails with ErrLastOwner unless the workspace still has an
+// owner once tx commits
+func requireOwner(tx *sql.Tx, workspaceID int) error {
+	var owners int
+	if err := tx.QueryRow(
+		"SELECT COUNT(*) FROM workspace_members WHERE workspace_id = $1 AND role = $2",
+		workspaceID, WorkspaceRoleOwner,
+	).Scan(&owners); err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}